@@ -0,0 +1,213 @@
+package cmd
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hrko/dltofu/internal/bundle"
+	"github.com/hrko/dltofu/internal/config"
+	"github.com/hrko/dltofu/internal/download"
+	"github.com/hrko/dltofu/internal/hash"
+	"github.com/hrko/dltofu/internal/model"
+	"github.com/hrko/dltofu/internal/template"
+)
+
+var (
+	bundleOutput string   // --output フラグ用
+	bundleOnly   []string // --only フラグ用
+	bundleFormat string   // --format フラグ用
+	bundleLevel  int      // --compression-level フラグ用
+)
+
+// bundleCmd represents the bundle command
+var bundleCmd = &cobra.Command{
+	Use:   "bundle",
+	Short: "Downloads all locked files and packages them into a single archive",
+	Long: `Downloads every file variant recorded in the lock file for the files in
+the configuration, verifies each against its locked hash, and packages them
+into a single .tar.gz/.tgz, .tar.zst/.tzst or .zip archive for transport to
+an offline environment. Files are streamed into the archive one at a time.
+
+The bundle contains a manifest.json mapping each bundle member to its
+file ID, URL and hash.`,
+	RunE: runBundle,
+}
+
+func init() {
+	rootCmd.AddCommand(bundleCmd)
+	bundleCmd.Flags().StringVarP(&bundleOutput, "output", "o", "", "Path of the bundle to create (.tar.gz, .tgz or .zip)")
+	bundleCmd.Flags().StringArrayVar(&bundleOnly, "only", nil, "Only bundle file IDs matching the glob pattern (repeatable)")
+	bundleCmd.Flags().StringVar(&bundleFormat, "format", "", "Bundle format (tar.gz, tar.zst, zip; default: inferred from the output extension)")
+	bundleCmd.Flags().IntVar(&bundleLevel, "compression-level", bundle.DefaultLevel, "Compression level (1-9 for tar.gz/zip, 1-22 for tar.zst; 0 for the format default)")
+	_ = bundleCmd.MarkFlagRequired("output")
+}
+
+func runBundle(cmd *cobra.Command, args []string) error {
+	logger.Info("Starting bundle command", "output", bundleOutput)
+
+	if cfgFile == "" {
+		return fmt.Errorf("configuration file must be specified using --config or exist as dltofu.yml/dltofu.yaml")
+	}
+
+	cfg, err := config.LoadConfig(cfgFile, logger, strict)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if err := cfg.SelectFiles(bundleOnly); err != nil {
+		return err
+	}
+
+	// Lock ファイルを読み込む (必須)
+	lockFile, err := loadLockFile(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to load lock file (required for bundle): %w", err)
+	}
+
+	// バンドル対象を決定 (設定ファイルに存在し、Lock ファイルに記録されている全URL)
+	var entries []bundle.ManifestEntry
+	headers := make(map[string]http.Header) // key: バンドル内のパス
+	for fileID, fileDef := range cfg.Files {
+		if len(fileDef.Parts) > 0 {
+			return fmt.Errorf("file ID %s: split files (parts) are not supported by bundle", fileID)
+		}
+		if fileDef.PatchFrom != nil {
+			return fmt.Errorf("file ID %s: patched files (patch_from) are not supported by bundle", fileID)
+		}
+		urls, ok := lockFile.Files[fileID]
+		if !ok || len(urls) == 0 {
+			return fmt.Errorf("file ID %s not found in lock file (run lock first)", fileID)
+		}
+		variants, err := variantsByURL(cfg, fileID)
+		if err != nil {
+			return err
+		}
+		for url, lockEntry := range urls {
+			if len(lockEntry.Hashes) == 0 {
+				return fmt.Errorf("no hash recorded for %s [%s] in lock file", fileID, url)
+			}
+			// 設定上のバリアントに対応する URL は、そのバリアントで有効なアルゴリズムで検証する
+			expectedHash := lockEntry.Hashes[0] // 対応するバリアントがなければいずれのアルゴリズムでも検証できる
+			variant, ok := variants[url]
+			if ok {
+				if expectedHash = lockEntry.Hash(variant.algorithm); expectedHash == nil {
+					return fmt.Errorf("%s hash not found for %s [%s] in lock file (run lock first)", variant.algorithm, fileID, url)
+				}
+			} else if variant.header, err = resolveHeaders(config.Target{FileID: fileID, Def: fileDef, Data: template.TemplateData{Version: fileDef.Version}}); err != nil {
+				return fmt.Errorf("failed to resolve headers for %s: %w", fileID, err)
+			}
+			entry := bundle.ManifestEntry{
+				Path:   bundleMemberPath(fileID, url, expectedHash.HashValue),
+				FileID: fileID,
+				URL:    url,
+				Hash:   expectedHash,
+			}
+			entries = append(entries, entry)
+			headers[entry.Path] = variant.header
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+
+	format := bundleFormat
+	if format == "" {
+		if format, err = bundle.FormatFromPath(bundleOutput); err != nil {
+			return err
+		}
+	}
+	opts, err := downloaderOptions(cfg, download.WithContext(cmd.Context()))
+	if err != nil {
+		return err
+	}
+	writer, err := bundle.NewWriter(bundleOutput, format, bundleLevel)
+	if err != nil {
+		return err
+	}
+	// 失敗時は中途半端なバンドルを残さない
+	success := false
+	defer func() {
+		if !success {
+			_ = os.Remove(bundleOutput)
+		}
+	}()
+
+	downloader := download.NewDownloader(timeout, logger, opts...)
+	for _, entry := range entries {
+		if err := addToBundle(writer, downloader.WithHeader(headers[entry.Path]), entry); err != nil {
+			writer.Close()
+			return fmt.Errorf("failed to bundle %s [%s]: %w", entry.FileID, entry.URL, err)
+		}
+		logger.Info("Added to bundle", "file_id", entry.FileID, "url", entry.URL, "path", entry.Path)
+	}
+
+	if err := bundle.WriteManifest(writer, &bundle.Manifest{Version: bundle.ManifestVersion, Files: entries}); err != nil {
+		writer.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	success = true
+
+	logger.Info("Bundle command finished successfully", "output", bundleOutput, "files", len(entries))
+	return nil
+}
+
+// addToBundle はファイルを一時ファイルにダウンロードしてハッシュ検証し、バンドルに追加する
+func addToBundle(writer bundle.Writer, downloader *download.Downloader, entry bundle.ManifestEntry) error {
+	tmpDir, err := os.MkdirTemp("", "dltofu-bundle-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tmpPath := filepath.Join(tmpDir, path.Base(entry.Path))
+	if err := downloader.FetchToFileWithHashCheck(entry.URL, tmpPath, entry.Hash); err != nil {
+		return err
+	}
+
+	f, err := os.Open(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to open downloaded file %s: %w", tmpPath, err)
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat downloaded file %s: %w", tmpPath, err)
+	}
+	return writer.Add(entry.Path, f, stat.Size(), 0644)
+}
+
+// bundleVariant は設定上のバリアントに対応する URL のダウンロード方法
+type bundleVariant struct {
+	algorithm hash.HashAlgorithm // 有効なハッシュアルゴリズム
+	header    http.Header        // リクエストに設定するヘッダ
+}
+
+// variantsByURL はファイルの全バリアントについて、解決済み URL とダウンロード方法の対応を返す
+func variantsByURL(cfg *config.Config, fileID model.FileID) (map[model.ResolvedURL]bundleVariant, error) {
+	targets, err := cfg.FileTargets(fileID)
+	if err != nil {
+		return nil, err
+	}
+	variants := make(map[model.ResolvedURL]bundleVariant, len(targets))
+	for _, target := range targets {
+		header, err := resolveHeaders(target)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve headers for %s: %w", target, err)
+		}
+		variants[target.URL] = bundleVariant{algorithm: target.HashAlgorithm, header: header}
+	}
+	return variants, nil
+}
+
+// bundleMemberPath はバンドル内のパスを決定する。
+// 同じファイルIDの異なるURLでファイル名が重複しないよう、ハッシュ値の先頭をディレクトリに含める。
+func bundleMemberPath(fileID model.FileID, url model.ResolvedURL, hashValue []byte) string {
+	return path.Join("files", string(fileID), hex.EncodeToString(hashValue)[:12], path.Base(string(url)))
+}
@@ -0,0 +1,207 @@
+package cmd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hrko/dltofu/internal/bundle"
+	"github.com/hrko/dltofu/internal/hash"
+	"github.com/hrko/dltofu/internal/model"
+)
+
+// contentServer は files (key: パス) の内容を返す httptest のサーバー。lock の後に内容を変更できる。
+type contentServer struct {
+	*httptest.Server
+	mu    sync.Mutex
+	files map[string]string
+}
+
+func newContentServer(t *testing.T, files map[string]string) *contentServer {
+	t.Helper()
+	s := &contentServer{files: files}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		content, ok := s.files[r.URL.Path]
+		s.mu.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(content))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *contentServer) set(p, content string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[p] = content
+}
+
+// remove は p を配信しないようにする (以降のリクエストは 404 となる)
+func (s *contentServer) remove(p string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.files, p)
+}
+
+// readBundle は tar.gz または zip のバンドルのメンバーの内容を返す (key: バンドル内のパス)
+func readBundle(t *testing.T, p string) map[string][]byte {
+	t.Helper()
+	members := make(map[string][]byte)
+	if strings.HasSuffix(p, ".zip") {
+		zr, err := zip.OpenReader(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			members[f.Name] = data
+		}
+		return members
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return members
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		members[hdr.Name] = data
+	}
+}
+
+func TestBundle(t *testing.T) {
+	files := map[model.FileID]string{"alpha": "alpha v1\n", "beta": "beta v1\n", "gamma": "gamma v1\n"}
+	tests := []struct {
+		name      string
+		output    string
+		args      []string
+		tamper    model.FileID // lock の後に内容を変更するファイル (空なら変更しない)
+		wantFiles []model.FileID
+		wantErr   bool
+	}{
+		{name: "tar.gz", output: "bundle.tar.gz", wantFiles: []model.FileID{"alpha", "beta", "gamma"}},
+		{name: "zip", output: "bundle.zip", wantFiles: []model.FileID{"alpha", "beta", "gamma"}},
+		{name: "tgz with only", output: "bundle.tgz", args: []string{"--only", "[ab]*"}, wantFiles: []model.FileID{"alpha", "beta"}},
+		{name: "explicit format", output: "bundle.out", args: []string{"--format", "zip"}, wantFiles: []model.FileID{"alpha", "beta", "gamma"}},
+		{name: "content changed after lock", output: "bundle.tar.gz", tamper: "beta", wantErr: true},
+		{name: "unknown extension", output: "bundle.rar", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			served := make(map[string]string)
+			for id, content := range files {
+				served["/"+string(id)+".bin"] = content
+			}
+			srv := newContentServer(t, served)
+
+			dir := t.TempDir()
+			cfgPath := filepath.Join(dir, "dltofu.yml")
+			cfg := "version: v1\nfiles:\n"
+			for _, id := range slices.Sorted(maps.Keys(files)) {
+				cfg += "  " + string(id) + ":\n    url: " + srv.URL + "/" + string(id) + ".bin\n    destination: " + string(id) + "\n"
+			}
+			if err := os.WriteFile(cfgPath, []byte(cfg), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := runCLI(t, "lock", "-c", cfgPath, "--no-progress"); err != nil {
+				t.Fatalf("lock: %v", err)
+			}
+			if tt.tamper != "" {
+				srv.set("/"+string(tt.tamper)+".bin", "tampered\n")
+			}
+
+			out := filepath.Join(dir, tt.output)
+			err := runCLI(t, append([]string{"bundle", "-c", cfgPath, "--no-progress", "-o", out}, tt.args...)...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("bundle error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if _, err := os.Stat(out); !os.IsNotExist(err) {
+					t.Errorf("a failed bundle left %s behind (error: %v)", out, err)
+				}
+				return
+			}
+
+			bundlePath := out
+			if filepath.Ext(out) == ".out" {
+				bundlePath = out + ".zip" // readBundle は拡張子で形式を判定する
+				if err := os.Rename(out, bundlePath); err != nil {
+					t.Fatal(err)
+				}
+			}
+			members := readBundle(t, bundlePath)
+			var manifest bundle.Manifest
+			if err := json.Unmarshal(members[bundle.ManifestFileName], &manifest); err != nil {
+				t.Fatalf("invalid manifest: %v\n%s", err, members[bundle.ManifestFileName])
+			}
+			if manifest.Version != bundle.ManifestVersion {
+				t.Errorf("manifest version = %d, want %d", manifest.Version, bundle.ManifestVersion)
+			}
+			var gotFiles []model.FileID
+			for _, entry := range manifest.Files {
+				gotFiles = append(gotFiles, entry.FileID)
+				data, ok := members[entry.Path]
+				if !ok {
+					t.Errorf("manifest entry %s is not in the bundle", entry.Path)
+					continue
+				}
+				if string(data) != files[entry.FileID] {
+					t.Errorf("%s content = %q, want %q", entry.Path, data, files[entry.FileID])
+				}
+				if got, err := hash.CalculateStream(bytes.NewReader(data), entry.Hash.Algorithm); err != nil || !got.Equal(entry.Hash) {
+					t.Errorf("%s hash = %v, manifest records %v", entry.Path, got, entry.Hash)
+				}
+				if want := srv.URL + "/" + string(entry.FileID) + ".bin"; string(entry.URL) != want {
+					t.Errorf("%s url = %s, want %s", entry.Path, entry.URL, want)
+				}
+			}
+			slices.Sort(gotFiles)
+			if !slices.Equal(gotFiles, tt.wantFiles) {
+				t.Errorf("manifest files = %v, want %v", gotFiles, tt.wantFiles)
+			}
+			if len(members) != len(manifest.Files)+1 {
+				t.Errorf("bundle has %d members, want %d files and the manifest", len(members), len(manifest.Files))
+			}
+		})
+	}
+}
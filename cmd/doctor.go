@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hrko/dltofu/internal/config"
+)
+
+var (
+	doctorFix bool // --fix フラグ用
+)
+
+// doctorCmd represents the doctor command
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Checks the configuration file for common issues",
+	Long: `Inspects the configuration file and reports problems such as a missing
+version, extract settings on files that are not archives, override keys that
+are not in the normalized "platform/arch" form and unsorted file IDs.
+
+With --fix, issues that can be corrected without changing the meaning of the
+configuration are fixed in place. The original file is kept as <config>.bak
+(or <config>.bak.1 and so on if a backup already exists). The fixed content is
+checked again before it is written, and nothing is changed if the check fails.
+Issues that need a decision are only reported.
+Exits with a non-zero status if any issue remains.`,
+	RunE: runDoctor,
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+	doctorCmd.Flags().BoolVar(&doctorFix, "fix", false, "Apply safe fixes to the configuration file (the original is kept as <config>.bak)")
+}
+
+func runDoctor(cmd *cobra.Command, args []string) error {
+	logger.Info("Starting doctor command", "fix", doctorFix)
+
+	if cfgFile == "" {
+		return fmt.Errorf("configuration file must be specified using --config or exist as dltofu.yml/dltofu.yaml")
+	}
+
+	data, err := os.ReadFile(cfgFile)
+	if err != nil {
+		return fmt.Errorf("failed to read config file %s: %w", cfgFile, err)
+	}
+	issues, fixed, err := config.Diagnose(data)
+	if err != nil {
+		return err
+	}
+
+	applied := false
+	if doctorFix && fixed != nil {
+		backupPath, err := config.ApplyFixes(cfgFile, data, fixed)
+		if err != nil {
+			return fmt.Errorf("failed to apply fixes to %s: %w", cfgFile, err)
+		}
+		applied = true
+		logger.Info("Applied fixes to configuration file", "path", cfgFile, "backup", backupPath)
+	}
+
+	// レポートを出力
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE ID\tSTATUS\tISSUE")
+	remaining := 0
+	for _, issue := range issues {
+		status := "MANUAL"
+		switch {
+		case issue.Fixable && applied:
+			status = "FIXED"
+		case issue.Fixable:
+			status = "FIXABLE"
+		}
+		if status != "FIXED" {
+			remaining++
+		}
+		fileID := string(issue.FileID)
+		if fileID == "" {
+			fileID = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", fileID, status, issue.Message)
+	}
+	w.Flush()
+
+	if remaining > 0 {
+		return fmt.Errorf("doctor found %d unresolved issue(s)", remaining)
+	}
+	logger.Info("Doctor command finished successfully")
+	return nil
+}
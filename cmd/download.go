@@ -1,22 +1,62 @@
 package cmd
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/hrko/dltofu/internal/archive"
+	"github.com/hrko/dltofu/internal/cache"
 	"github.com/hrko/dltofu/internal/config"
 	"github.com/hrko/dltofu/internal/download"
+	"github.com/hrko/dltofu/internal/hash"
 	"github.com/hrko/dltofu/internal/lock"
+	"github.com/hrko/dltofu/internal/metrics"
+	"github.com/hrko/dltofu/internal/model"
+	"github.com/hrko/dltofu/internal/patch"
 	"github.com/hrko/dltofu/internal/platform"
+	"github.com/hrko/dltofu/internal/progress"
+	"github.com/hrko/dltofu/internal/prompt"
+	"github.com/hrko/dltofu/internal/report"
+	"github.com/hrko/dltofu/internal/signature"
 	"github.com/hrko/dltofu/internal/template"
+	"github.com/hrko/dltofu/internal/verify"
 	"github.com/spf13/cobra"
+	"golang.org/x/sync/semaphore"
 )
 
-var forceDownload bool // --force フラグ用
+var (
+	forceDownload    bool     // --force フラグ用
+	downloadOnly     []string // --only フラグ用
+	downloadExclude  []string // --exclude フラグ用
+	strictPlatforms  bool     // --strict-platforms フラグ用
+	downloadJSON     bool     // --json フラグ用
+	downloadParallel int      // --parallelism フラグ用
+	downloadNoCache  bool     // --no-cache フラグ用
+	downloadCacheDir string   // --cache-dir フラグ用
+	downloadVersions []string // --set-version フラグ用
+
+	downloadPlatform    string // --platform フラグ用
+	downloadArch        string // --arch フラグ用
+	downloadArchVariant string // --arch-variant フラグ用
+)
+
+// downloadSession は download コマンドの1回の実行で全ファイルが共有する状態
+type downloadSession struct {
+	overwritePrompt *prompt.Overwrite // 既存ファイルの上書きを対話的に確認する (端末でない場合や --force の場合は nil)
+	cache           *cache.Cache      // ダウンロード済みファイルのディスクキャッシュ (--no-cache の場合は nil)
+}
 
 // downloadCmd represents the download command
 var downloadCmd = &cobra.Command{
@@ -27,240 +67,702 @@ for the current platform/architecture, downloads it, and verifies its hash
 against the lock file.
 
 If the file is an archive, it extracts it according to the configuration
-(strip_components, extract_paths). Use --force to overwrite existing files.`,
+(strip_components, extract_paths, extract_exclude, rename, flatten). Use --force to overwrite existing files.
+When run in a terminal without --force, asks before overwriting each
+existing file (yes/no/all/quit); otherwise existing files are skipped.
+
+Downloaded files are kept in an on-disk cache keyed by their locked hash
+($XDG_CACHE_HOME/dltofu by default, see --cache-dir). Later runs copy a file
+from the cache after re-verifying its hash instead of downloading it again.
+Use --no-cache to bypass the cache.
+
+Use --platform and --arch to download the variant of another platform or
+architecture instead of the detected one (e.g. to stage files for a
+container image).`,
 	RunE: runDownload,
 }
 
 func init() {
 	rootCmd.AddCommand(downloadCmd)
 	downloadCmd.Flags().BoolVarP(&forceDownload, "force", "f", false, "Overwrite existing files without asking")
+	downloadCmd.Flags().StringArrayVar(&downloadOnly, "only", nil, "Only process file IDs matching the glob pattern (repeatable)")
+	downloadCmd.Flags().StringArrayVar(&downloadVersions, "set-version", nil, "Override the version of a file for this run as <file-id>=<version> (repeatable)")
+	downloadCmd.Flags().StringArrayVar(&downloadExclude, "exclude", nil, "Skip file IDs matching the glob pattern (repeatable)")
+	addDeprecatedJSONFlag(downloadCmd, &downloadJSON)
+	downloadCmd.Flags().IntVarP(&downloadParallel, "parallelism", "p", runtime.NumCPU(), "Number of files to download in parallel")
+	downloadCmd.Flags().BoolVar(&downloadNoCache, "no-cache", false, "Do not read or populate the on-disk download cache")
+	downloadCmd.Flags().StringVar(&downloadCacheDir, "cache-dir", "", "Directory of the on-disk download cache (default: $XDG_CACHE_HOME/dltofu or the OS user cache directory)")
+	downloadCmd.Flags().BoolVar(&strictPlatforms, "strict-platforms", false, "Fail if a file declaring platforms has no variant for the current platform/architecture")
+	addTargetEnvironmentFlags(downloadCmd, &downloadPlatform, &downloadArch, &downloadArchVariant)
 }
 
-func runDownload(cmd *cobra.Command, args []string) error {
+func runDownload(cmd *cobra.Command, args []string) (err error) {
 	logger.Info("Starting download command", "force", forceDownload)
 
+	var results report.Collector
+	var runMetrics *metrics.Metrics
+	defer func() { writeResult("download", &results, runMetrics, err) }()
+
+	if downloadParallel < 1 {
+		return fmt.Errorf("--parallelism must be at least 1 (got %d)", downloadParallel)
+	}
+
 	if cfgFile == "" {
 		return fmt.Errorf("configuration file must be specified using --config or exist as dltofu.yml/dltofu.yaml")
 	}
 
-	cfg, err := config.LoadConfig(cfgFile, logger)
+	versions, err := parseSetVersions(downloadVersions)
+	if err != nil {
+		return err
+	}
+	cfg, err := config.LoadConfig(cfgFile, logger, strict, config.WithVersions(versions))
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
+	if err := cfg.SelectFiles(downloadOnly); err != nil {
+		return err
+	}
+	if err := cfg.ExcludeFiles(downloadExclude); err != nil {
+		return err
+	}
 
 	// Lock ファイルを読み込む (必須)
-	configDir := cfg.GetConfigDir()
-	lockFile, err := lock.LoadLockFile(configDir, logger)
+	lockFile, err := loadLockFile(cfg)
 	if err != nil {
 		// download では lock ファイルは必須
 		return fmt.Errorf("failed to load lock file (required for download): %w", err)
 	}
 
-	// 実行環境のプラットフォーム/アーキテクチャを取得
-	currentPlatform, err := platform.GetCurrentPlatform()
+	// 対象のプラットフォーム/アーキテクチャを取得 (フラグ未指定の場合は実行環境)
+	currentPlatform, currentArch, currentVariant, err := targetEnvironment(cfg, downloadPlatform, downloadArch, downloadArchVariant)
 	if err != nil {
-		return fmt.Errorf("failed to get current platform: %w", err)
+		return err
 	}
-	currentArch, err := platform.GetCurrentArch()
+	logger.Info("Target environment", "platform", currentPlatform, "architecture", currentArch, "variant", currentVariant)
+
+	// ダウンローダー準備
+	runMetrics = metrics.New()
+	defer printSummary(runMetrics)
+	// 複数のファイルが同じ URL を使う場合は1度だけダウンロードする
+	var targets []config.Target
+	for fileID := range cfg.Files {
+		target, applicable, err := cfg.SelectTarget(fileID, currentPlatform, currentArch, currentVariant)
+		if err != nil {
+			// このファイルは downloadFile で同じエラーにより失敗として記録される
+			logger.Warn("Failed to resolve file; not sharing its download with other files", "file_id", fileID, "error", err)
+			continue
+		}
+		if applicable {
+			targets = append(targets, target)
+		}
+	}
+	var session downloadSession
+	if !downloadNoCache {
+		session.cache, err = openDownloadCache(downloadCacheDir)
+		if err != nil {
+			logger.Warn("Download cache is disabled", "error", err) // キャッシュが使えなくてもダウンロードはできる
+		}
+	}
+	opts, err := downloaderOptions(cfg, download.WithMetrics(runMetrics), download.WithContext(cmd.Context()), sharedURLCache(targets, session.cache))
 	if err != nil {
-		return fmt.Errorf("failed to get current architecture: %w", err)
+		return err
 	}
-	logger.Info("Detected execution environment", "platform", currentPlatform, "architecture", currentArch)
+	downloader := download.NewDownloader(timeout, logger, opts...)
 
-	// ダウンローダー準備
-	downloader := download.NewDownloader(0, logger)
-
-	// 設定ファイルの各ファイルを処理
-	hasError := false // エラーが発生しても全ファイルの処理を試みるフラグ
-	for fileID, fileDef := range cfg.Files {
-		logger.Debug("Processing file definition", "file_id", fileID)
-
-		targetPlatformID := ""
-		targetArchID := ""
-		platformValue := ""
-		archValue := ""
-
-		// この環境向けのファイルか判定
-		if len(fileDef.Platforms) > 0 && len(fileDef.Architectures) > 0 {
-			validPlatform := false
-			if pVal, ok := fileDef.Platforms[currentPlatform]; ok {
-				validPlatform = true
-				targetPlatformID = currentPlatform
-				platformValue = pVal
-			}
-			validArch := false
-			if aVal, ok := fileDef.Architectures[currentArch]; ok {
-				validArch = true
-				targetArchID = currentArch
-				archValue = aVal
-			}
+	// 端末で実行されている場合のみ上書きを確認する (--output json では標準出力を結果の出力に使うため確認しない)
+	if !forceDownload && outputFormat == outputText && progress.IsTerminal(os.Stdin) && progress.IsTerminal(os.Stdout) {
+		session.overwritePrompt = prompt.NewOverwrite(os.Stdin, os.Stdout)
+	}
 
-			if !validPlatform || !validArch {
-				logger.Debug("Skipping file: not applicable for current platform/architecture", "file_id", fileID, "current_platform", currentPlatform, "current_arch", currentArch)
-				continue // このファイルは現在の環境向けではない
+	// 設定ファイルの各ファイルを並列に処理する
+	// 1ファイルの失敗で全体を中断せず、全ファイルの処理を試みる
+	var hasError atomic.Bool
+	var wg sync.WaitGroup
+	sem := semaphore.NewWeighted(int64(downloadParallel))
+	for fileID := range cfg.Files {
+		if err := sem.Acquire(cmd.Context(), 1); err != nil {
+			return err
+		}
+		if session.overwritePrompt != nil && session.overwritePrompt.Aborted() {
+			// quit が選択された場合は残りのファイルを処理しない
+			sem.Release(1)
+			results.Add(report.FileResult{FileID: fileID}.Fail(prompt.ErrQuit))
+			hasError.Store(true)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer sem.Release(1)
+			res := downloadFile(cfg, lockFile, downloader, &session, fileID, currentPlatform, currentArch, currentVariant)
+			results.Add(res)
+			if res.Failed() {
+				hasError.Store(true)
 			}
-			logger.Debug("File applicable for current environment", "file_id", fileID, "platform", targetPlatformID, "arch", targetArchID)
-		} else {
-			// プラットフォーム指定がない場合は常にダウンロード対象
-			logger.Debug("File does not have platform/architecture constraints", "file_id", fileID)
+		}()
+	}
+	wg.Wait()
+
+	if hasError.Load() {
+		return failedFilesError("download", results.Failures())
+	}
+
+	logger.Info("Download command finished successfully")
+	return nil
+}
+
+// downloadFile は1ファイルを現在の環境向けにダウンロードしてハッシュ検証し、必要なら展開する。
+// 失敗した場合はログを出力し、エラーを記録した結果を返す。
+func downloadFile(cfg *config.Config, lockFile *lock.LockFile, downloader *download.Downloader, session *downloadSession, fileID model.FileID, currentPlatform, currentArch, currentVariant string) report.FileResult {
+	logger.Debug("Processing file definition", "file_id", fileID)
+	res := report.FileResult{FileID: fileID}
+
+	// この環境向けのファイルか判定し、URL やダウンロード先を解決する
+	target, applicable, err := cfg.SelectTarget(fileID, currentPlatform, currentArch, currentVariant)
+	if err != nil {
+		logger.Error("Failed to resolve file", "file_id", fileID, "error", err)
+		return res.Fail(fmt.Errorf("failed to resolve file: %w", err))
+	}
+	if !applicable {
+		if strictPlatforms {
+			logger.Error("No variant defined for current platform/architecture", "file_id", fileID, "current_platform", currentPlatform, "current_arch", currentArch)
+			return res.Fail(fmt.Errorf("no variant defined for current platform/architecture (%s/%s)", currentPlatform, currentArch))
 		}
+		logger.Debug("Skipping file: not applicable for current platform/architecture", "file_id", fileID, "current_platform", currentPlatform, "current_arch", currentArch)
+		return res.Skip("not applicable for current platform/architecture") // このファイルは現在の環境向けではない
+	}
+	fileDef := target.Def
+	targetPlatformID, targetArchID, targetVariant, tmplData := target.PlatformID, target.ArchID, target.ArchVariant, target.Data
+	resolvedURL, hashAlgo, dest := target.URL, target.HashAlgorithm, target.Destination
+	res = targetResult(target)
+	logger.Debug("File applicable for current environment", "file_id", fileID, "platform", targetPlatformID, "arch", targetArchID, "variant", targetVariant)
+	logger.Debug("Resolved URL for download", "file_id", fileID, "url", resolvedURL)
+
+	// Lock ファイルから期待されるハッシュ値を取得 (設定されたアルゴリズムのもの)
+	expectedHash, err := lockFile.GetHash(fileID, resolvedURL, hashAlgo)
+	if err != nil {
+		// ハッシュが見つからないか、不正な形式の場合
+		logger.Error("Failed to get hash from lock file", "file_id", fileID, "url", resolvedURL, "error", err)
+		return res.Fail(fmt.Errorf("failed to get hash from lock file: %w", err))
+	}
+	logger.Debug("Found expected hash in lock file", "file_id", fileID, "url", resolvedURL, "hash", expectedHash)
+	res.Hash = expectedHash
+
+	logger.Debug("Resolved final destination path", "file_id", fileID, "path", dest)
 
-		// URL 解決
-		urlTemplate := fileDef.GetEffectiveURLTemplate(targetPlatformID, targetArchID)
-		tmplData := template.TemplateData{
-			Version:      fileDef.Version,
-			Platform:     platformValue,
-			Architecture: archValue,
+	// 既存ファイルのチェック (非アーカイブの場合のみ事前チェック)
+	if !fileDef.IsArchive {
+		if _, err := os.Stat(dest); err == nil {
+			// ファイルが存在する
+			if !forceDownload {
+				overwrite := false
+				if session.overwritePrompt != nil {
+					if overwrite, err = session.overwritePrompt.ConfirmOverwrite(dest); err != nil {
+						logger.Error("Download aborted", "file_id", fileID, "path", dest, "error", err)
+						return res.Fail(err)
+					}
+				}
+				if !overwrite {
+					logger.Warn("Destination file already exists. Skipping download.", "file_id", fileID, "path", dest, "hint", "Use --force to overwrite.")
+					return res.Skip("destination already exists (use --force to overwrite)")
+				}
+				logger.Debug("Destination file exists, proceeding with overwrite (confirmed)", "file_id", fileID, "path", dest)
+			} else {
+				logger.Debug("Destination file exists, proceeding with overwrite (--force)", "file_id", fileID, "path", dest)
+				// 上書き実行
+			}
+		} else if !os.IsNotExist(err) {
+			// Stat で予期せぬエラー
+			logger.Error("Failed to check destination file", "file_id", fileID, "path", dest, "error", err)
+			return res.Fail(fmt.Errorf("failed to check destination file: %w", err))
+		}
+		// ファイルが存在しない場合はそのまま進む
+	} else {
+		// アーカイブの場合、展開先ディレクトリが存在するかどうかだけ確認・作成
+		// 個々のファイルの上書きは展開処理内で行う
+		if err := os.MkdirAll(dest, 0755); err != nil { // dest はディレクトリパスのはず
+			logger.Error("Failed to create destination directory for archive", "file_id", fileID, "path", dest, "error", err)
+			return res.Fail(fmt.Errorf("failed to create destination directory for archive: %w", err))
 		}
-		resolvedURL, err := template.ResolveURL(urlTemplate, tmplData)
+		logger.Debug("Ensured destination directory exists for archive", "file_id", fileID, "path", dest)
+	}
+
+	// ダウンロード実行 (ハッシュ検証含む)
+	// アーカイブの場合、一時ファイルにダウンロードしてから展開する
+	var downloadedFilePath string
+	if fileDef.IsArchive {
+		// 一時ファイルにダウンロード
+		var tempArchiveFile *os.File
+		tempArchiveFile, err = createArchiveTemp(fileID, resolvedURL)
 		if err != nil {
-			logger.Error("Failed to resolve URL template", "file_id", fileID, "error", err)
-			hasError = true
-			continue // 次のファイルへ
+			logger.Error("Failed to create temporary file for archive download", "file_id", fileID, "error", err)
+			return res.Fail(fmt.Errorf("failed to create temporary file for archive download: %w", err))
 		}
-		logger.Debug("Resolved URL for download", "file_id", fileID, "url", resolvedURL)
+		downloadedFilePath = tempArchiveFile.Name()
+		tempArchiveFile.Close()                     // downloader が再度開くので一旦閉じる
+		defer removeArchiveTemp(downloadedFilePath) // 展開後またはエラー時に削除
 
-		// Lock ファイルから期待されるハッシュ値を取得
-		expectedHash, err := lockFile.GetHash(fileID, resolvedURL)
+		logger.Debug("Downloading archive to temporary file", "file_id", fileID, "url", resolvedURL, "temp_path", downloadedFilePath)
+	} else if fileDef.Signature != nil {
+		// 署名を検証する通常ファイルは、検証するまで既存のファイルを置き換えないよう同じディレクトリの一時ファイルに保存する
+		downloadedFilePath, err = createSignedTemp(dest)
 		if err != nil {
-			// ハッシュが見つからないか、不正な形式の場合
-			logger.Error("Failed to get hash from lock file", "file_id", fileID, "url", resolvedURL, "error", err)
-			hasError = true
-			continue // 次のファイルへ
+			logger.Error("Failed to create temporary file for signed download", "file_id", fileID, "error", err)
+			return res.Fail(fmt.Errorf("failed to create temporary file for signed download: %w", err))
 		}
-		logger.Debug("Found expected hash in lock file", "file_id", fileID, "url", resolvedURL, "hash", expectedHash)
-
-		// ダウンロード先パスを決定
-		dest := fileDef.GetEffectiveDestination(targetPlatformID, targetArchID)
-		if dest == "" {
-			// Destination が未指定の場合、URLからファイル名を推測してカレントディレクトリに置く
-			urlParts := strings.Split(string(resolvedURL), "/")
-			dest = urlParts[len(urlParts)-1] // URLの最後の部分をファイル名とする
-			logger.Debug("Destination not specified, using filename from URL", "file_id", fileID, "destination", dest)
-			// この場合、設定ファイル基準ではなくカレントディレクトリ基準とする
-			absDest, err := filepath.Abs(dest)
-			if err != nil {
-				logger.Error("Failed to get absolute path for default destination", "file_id", fileID, "destination", dest, "error", err)
-				hasError = true
-				continue
+		defer os.Remove(downloadedFilePath) // 検証後はリネームされるため何もしない
+		logger.Debug("Downloading file to temporary file for signature verification", "file_id", fileID, "url", resolvedURL, "temp_path", downloadedFilePath)
+	} else {
+		// 通常ファイルは直接ダウンロード先に保存 (FetchToFileWithHashCheck 内で一時ファイルからリネームして上書きする)
+		downloadedFilePath = dest
+		logger.Debug("Downloading file directly", "file_id", fileID, "url", resolvedURL, "destination", downloadedFilePath)
+	}
+	downloader, err = fileDownloader(downloader, target)
+	if err != nil {
+		logger.Error("Failed to resolve request headers", "file_id", fileID, "error", err)
+		return res.Fail(fmt.Errorf("failed to resolve request headers: %w", err))
+	}
+
+	// ディスクキャッシュに同じハッシュ値の内容があればダウンロードしない (コピー時に再検証する)
+	fromCache := false
+	if session.cache != nil {
+		if fromCache, err = session.cache.CopyTo(expectedHash, downloadedFilePath); err != nil {
+			logger.Warn("Failed to read download cache, downloading instead", "file_id", fileID, "hash", expectedHash, "error", err)
+			fromCache = false
+		}
+	}
+	if fromCache {
+		logger.Info("Using file from download cache", "file_id", fileID, "hash", expectedHash, "destination", downloadedFilePath)
+	} else {
+		if len(fileDef.Parts) > 0 {
+			// 分割ファイルの場合は各パートを連結してダウンロード
+			var partURLs []model.ResolvedURL
+			partURLs, err = resolveParts(fileDef.Parts, tmplData)
+			if err == nil {
+				logger.Debug("Downloading split file parts", "file_id", fileID, "parts", partURLs)
+				err = downloader.FetchPartsToFileWithHashCheck(partURLs, downloadedFilePath, expectedHash)
 			}
-			dest = absDest
+		} else if fileDef.PatchFrom != nil {
+			// パッチ指定の場合はベースとパッチをダウンロードして適用する
+			err = fetchPatched(downloader, lockFile, fileID, fileDef.PatchFrom, tmplData, hashAlgo, downloadedFilePath, expectedHash)
 		} else {
-			absDest, err := cfg.ResolveDestPath(dest) // 設定ファイル基準で解決
-			if err != nil {
-				logger.Error("Failed to resolve destination path", "file_id", fileID, "destination", dest, "error", err)
-				hasError = true
-				continue
+			// 既存のファイルを上書きする場合は Lock ファイルの ETag/Last-Modified で条件付きリクエストを送り、
+			// 変更がなければ (既存ファイルのハッシュ値を確認した上で) 書き換えない
+			var validators download.Validators
+			if _, statErr := os.Stat(downloadedFilePath); statErr == nil && downloadedFilePath == dest {
+				validators = lockedValidators(lockFile, fileID, resolvedURL)
+			}
+			_, _, err = downloader.FetchToFileIfModified(resolvedURL, downloadedFilePath, hashAlgo, expectedHash, validators)
+			if errors.Is(err, download.ErrNotModified) {
+				logger.Info("Not modified since the last lock; keeping existing file", "file_id", fileID, "path", downloadedFilePath)
+				res.Detail = "not modified"
+				err = nil
 			}
-			dest = absDest
 		}
-		logger.Debug("Resolved final destination path", "file_id", fileID, "path", dest)
 
-		// 既存ファイルのチェック (非アーカイブの場合のみ事前チェック)
-		if !fileDef.IsArchive {
-			if _, err := os.Stat(dest); err == nil {
-				// ファイルが存在する
-				if !forceDownload {
-					// TODO: インタラクティブな確認を実装する場合はここ
-					logger.Warn("Destination file already exists. Skipping download.", "file_id", fileID, "path", dest, "hint", "Use --force to overwrite.")
-					continue // スキップ
-				} else {
-					logger.Debug("Destination file exists, proceeding with overwrite (--force)", "file_id", fileID, "path", dest)
-					// 上書き実行
-				}
-			} else if !os.IsNotExist(err) {
-				// Stat で予期せぬエラー
-				logger.Error("Failed to check destination file", "file_id", fileID, "path", dest, "error", err)
-				hasError = true
-				continue
-			}
-			// ファイルが存在しない場合はそのまま進む
-		} else {
-			// アーカイブの場合、展開先ディレクトリが存在するかどうかだけ確認・作成
-			// 個々のファイルの上書きは展開処理内で行う
-			if err := os.MkdirAll(dest, 0755); err != nil { // dest はディレクトリパスのはず
-				logger.Error("Failed to create destination directory for archive", "file_id", fileID, "path", dest, "error", err)
-				hasError = true
-				continue
+		if err != nil {
+			logger.Error("Download or hash verification failed", "file_id", fileID, "url", resolvedURL, "error", err)
+			// 中途半端な一時ファイルは FetchToFileWithHashCheck 内で削除される
+			return res.Fail(fmt.Errorf("download or hash verification failed: %w", err))
+		}
+		if session.cache != nil {
+			if err := session.cache.Store(expectedHash, downloadedFilePath); err != nil {
+				logger.Warn("Failed to store file in download cache", "file_id", fileID, "error", err) // キャッシュできなくても処理は続ける
 			}
-			logger.Debug("Ensured destination directory exists for archive", "file_id", fileID, "path", dest)
 		}
+	}
+	logger.Info("Download and hash verification successful", "file_id", fileID, "url", resolvedURL)
 
-		// ダウンロード実行 (ハッシュ検証含む)
-		// アーカイブの場合、一時ファイルにダウンロードしてから展開する
-		var downloadedFilePath string
-		if fileDef.IsArchive {
-			// 一時ファイルにダウンロード
-			var tempArchiveFile *os.File
-			tempArchiveFile, err = os.CreateTemp("", fmt.Sprintf("dltofu-%s-*.tmp", fileID))
-			if err != nil {
-				logger.Error("Failed to create temporary file for archive download", "file_id", fileID, "error", err)
-				hasError = true
-				continue
+	// 署名の検証 (ハッシュ値の検証に加えて行う)
+	if fileDef.Signature != nil {
+		signer, err := verifySignature(cfg, downloader, target, downloadedFilePath)
+		if err != nil {
+			// 検証できなかった一時ファイルは defer で削除され、既存のファイルはそのまま残る
+			logger.Error("Signature verification failed", "file_id", fileID, "url", resolvedURL, "error", err)
+			return res.Fail(err)
+		}
+		logger.Info("Signature verification successful", "file_id", fileID, "signer", signer)
+		res.Detail = "signed by " + signer
+		if !fileDef.IsArchive {
+			if err := os.Rename(downloadedFilePath, dest); err != nil {
+				logger.Error("Failed to move verified file into place", "file_id", fileID, "path", dest, "error", err)
+				return res.Fail(fmt.Errorf("failed to move verified file to %s: %w", dest, err))
 			}
-			downloadedFilePath = tempArchiveFile.Name()
-			tempArchiveFile.Close()             // downloader が再度開くので一旦閉じる
-			defer os.Remove(downloadedFilePath) // 展開後またはエラー時に削除
-
-			logger.Debug("Downloading archive to temporary file", "file_id", fileID, "url", resolvedURL, "temp_path", downloadedFilePath)
-			err = downloader.FetchToFileWithHashCheck(resolvedURL, downloadedFilePath, expectedHash)
-		} else {
-			// 通常ファイルは直接ダウンロード先に保存 (FetchToFile内で上書き処理も行う)
 			downloadedFilePath = dest
-			logger.Debug("Downloading file directly", "file_id", fileID, "url", resolvedURL, "destination", downloadedFilePath)
-			err = downloader.FetchToFileWithHashCheck(resolvedURL, downloadedFilePath, expectedHash)
 		}
+	}
 
+	// アーカイブ展開処理
+	if fileDef.IsArchive {
+		logger.Info("Starting archive extraction", "file_id", fileID, "source", downloadedFilePath, "destination", dest)
+		extractor, err := fileExtractor(fileDef, downloadedFilePath) // 未指定なら一時ファイル名で判定
 		if err != nil {
-			logger.Error("Download or hash verification failed", "file_id", fileID, "url", resolvedURL, "error", err)
-			// FetchToFile 内で中途半端なファイルは削除されるはず
-			hasError = true
-			continue
+			logger.Error("Failed to get extractor for archive", "file_id", fileID, "path", downloadedFilePath, "error", err)
+			return res.Fail(fmt.Errorf("failed to get extractor for archive: %w", err))
 		}
-		logger.Info("Download and hash verification successful", "file_id", fileID, "url", resolvedURL)
+		if session.overwritePrompt != nil {
+			extractor = archive.WithConfirmer(extractor, session.overwritePrompt) // 既存ファイルごとに確認する
+		}
+
+		extractPaths := fileDef.GetEffectiveExtractPaths(targetPlatformID, targetArchID, targetVariant)
+		extractor = archive.WithExclude(extractor, fileDef.GetEffectiveExtractExclude(targetPlatformID, targetArchID, targetVariant))
+		extractor = archive.WithRename(extractor, target.Rename)
+		// mode と executables は今回展開したファイルだけに適用する (展開先の既存のファイルには触れない)
+		var extracted []string
+		extractor = archive.OnExtract(extractor, func(path string) { extracted = append(extracted, path) })
 
-		// アーカイブ展開処理
-		if fileDef.IsArchive {
-			logger.Info("Starting archive extraction", "file_id", fileID, "source", downloadedFilePath, "destination", dest)
-			extractor, err := archive.GetExtractor(downloadedFilePath) // 一時ファイル名で判定
+		// TreeHash が記録されていれば、展開前に展開結果が一致するか確認する
+		if expectedTree := lockFile.GetTreeHash(fileID, resolvedURL); expectedTree != nil {
+			actual, err := archive.ExtractTreeHashes(extractor, downloadedFilePath, fileDef.StripComponents, extractPaths, expectedTree.Algorithm, logger)
 			if err != nil {
-				logger.Error("Failed to get extractor for archive", "file_id", fileID, "path", downloadedFilePath, "error", err)
-				hasError = true
-				continue
+				logger.Error("Failed to calculate tree hash", "file_id", fileID, "source", downloadedFilePath, "error", err)
+				return res.Fail(fmt.Errorf("failed to calculate tree hash: %w", err))
+			}
+			switch {
+			case actual.Tree.Equal(expectedTree):
+				logger.Debug("Tree hash verified", "file_id", fileID, "hash", actual.Tree)
+			case actual.Legacy.Equal(expectedTree):
+				// 以前の形式で記録された TreeHash。次回の lock で現在の形式に置き換えられる
+				logger.Warn("Tree hash is recorded in the old permission-dependent format; run 'dltofu lock' to upgrade it", "file_id", fileID)
+			default:
+				logger.Error("Tree hash mismatch: extraction result differs from lock file", "file_id", fileID, "expected", expectedTree, "actual", actual.Tree)
+				return res.Fail(fmt.Errorf("tree hash mismatch: expected %s, got %s", expectedTree, actual.Tree))
 			}
+		}
 
-			extractPaths := fileDef.GetEffectiveExtractPaths(targetPlatformID, targetArchID)
+		err = extractor.Extract(downloadedFilePath, dest, fileDef.StripComponents, extractPaths, forceDownload, logger)
+		if err != nil {
+			logger.Error("Archive extraction failed", "file_id", fileID, "source", downloadedFilePath, "error", err)
+			// 展開に失敗した場合、部分的に展開されたファイルが残る可能性がある
+			return res.Fail(fmt.Errorf("archive extraction failed: %w", err))
+		}
+		logger.Info("Archive extraction successful", "file_id", fileID, "destination", dest)
 
-			err = extractor.Extract(downloadedFilePath, dest, fileDef.StripComponents, extractPaths, forceDownload, logger)
+		slices.Sort(extracted)
+		extracted = slices.Compact(extracted) // 同じパスのメンバーが複数ある場合
+		// mode はアーカイブ内のパーミッションを上書きし、その後 executables のファイルに実行権限を付与する
+		if mode, ok := fileDef.GetEffectiveMode(targetPlatformID, targetArchID, targetVariant); ok {
+			count, err := archive.ApplyMode(extracted, mode, logger)
 			if err != nil {
-				logger.Error("Archive extraction failed", "file_id", fileID, "source", downloadedFilePath, "error", err)
-				// 展開に失敗した場合、部分的に展開されたファイルが残る可能性がある
-				hasError = true
-				continue
+				logger.Error("Failed to set permission of extracted files", "file_id", fileID, "destination", dest, "error", err)
+				return res.Fail(fmt.Errorf("failed to set permission of extracted files: %w", err))
 			}
-			logger.Info("Archive extraction successful", "file_id", fileID, "destination", dest)
-			// 一時アーカイブファイルは defer で削除される
+			logger.Debug("Set permission of extracted files", "file_id", fileID, "mode", mode, "files", count)
+		}
+		if len(fileDef.Executables) > 0 {
+			marked, err := archive.MarkExecutable(dest, extracted, fileDef.Executables, logger)
+			if err != nil {
+				logger.Error("Failed to mark extracted files executable", "file_id", fileID, "destination", dest, "error", err)
+				return res.Fail(fmt.Errorf("failed to mark extracted files executable: %w", err))
+			}
+			if len(marked) == 0 {
+				logger.Warn("No extracted file matched executables patterns", "file_id", fileID, "patterns", fileDef.Executables)
+			}
+			logger.Debug("Marked extracted files executable", "file_id", fileID, "files", marked)
+		}
+		// 一時アーカイブファイルは defer で削除される
+	} else if runtime.GOOS != "windows" {
+		// 非アーカイブの場合、パーミッションを設定する (Unix系のみ)
+		mode := fileMode(fileDef, targetPlatformID, targetArchID, targetVariant, downloadedFilePath)
+		if err := os.Chmod(downloadedFilePath, mode); err != nil {
+			// エラーにはしないが警告
+			logger.Warn("Failed to set permission", "path", downloadedFilePath, "mode", mode, "error", err)
 		} else {
-			// 非アーカイブの場合、必要なら実行権限を付与
-			// TODO: 設定ファイルでパーミッションを指定できるようにする？
-			// とりあえず、基本的な実行権限を試みる (Unix系のみ)
-			if runtime.GOOS != "windows" {
-				if err := os.Chmod(downloadedFilePath, 0755); err != nil {
-					// エラーにはしないが警告
-					logger.Warn("Failed to set executable permission", "path", downloadedFilePath, "error", err)
-				} else {
-					logger.Debug("Set executable permission", "path", downloadedFilePath)
-				}
+			logger.Debug("Set permission", "path", downloadedFilePath, "mode", mode)
+		}
+	}
+	logger.Info("Successfully processed file", "file_id", fileID)
+	res.Status = report.StatusOK
+	return res
+}
+
+// lockedValidators は Lock ファイルに記録された url の ETag/Last-Modified を返す (記録がなければ空)
+func lockedValidators(lockFile *lock.LockFile, fileID model.FileID, url model.ResolvedURL) download.Validators {
+	return download.Validators(lockFile.GetValidators(fileID, url))
+}
+
+// openDownloadCache は dir (空の場合はデフォルトのディレクトリ) のディスクキャッシュを開く
+func openDownloadCache(dir string) (*cache.Cache, error) {
+	if dir == "" {
+		var err error
+		if dir, err = cache.DefaultDir(); err != nil {
+			return nil, err
+		}
+	}
+	return cache.New(dir, logger)
+}
+
+// fileExtractor はファイル定義の archive_type (未指定なら archivePath の拡張子または内容) と
+// preserve_permissions, flatten に従ってアーカイブの Extractor を返す
+func fileExtractor(fileDef config.FileDef, archivePath string) (archive.Extractor, error) {
+	extractor, err := archive.ResolveExtractor(fileDef.ArchiveType, archivePath)
+	if err != nil {
+		return nil, err
+	}
+	if !fileDef.PreservesPermissions() {
+		extractor = archive.IgnorePermissions(extractor)
+	}
+	if fileDef.Flatten {
+		extractor = archive.Flatten(extractor)
+	}
+	return extractor, nil
+}
+
+// fileMode はダウンロードした非アーカイブファイルのパーミッションを決定する。
+// mode が指定されていればそれを使い、そうでなければ executable: true の場合や
+// 内容が実行ファイルに見える場合は 0755、それ以外は 0644 とする。
+func fileMode(fileDef config.FileDef, platformID, archID, variant, filePath string) fs.FileMode {
+	if mode, ok := fileDef.GetEffectiveMode(platformID, archID, variant); ok {
+		return mode
+	}
+	if fileDef.Executable {
+		return 0755
+	}
+	executable, err := archive.LooksExecutable(filePath)
+	if err != nil {
+		logger.Warn("Failed to detect whether the file is executable", "path", filePath, "error", err)
+	}
+	if executable {
+		return 0755
+	}
+	return 0644
+}
+
+// createArchiveTemp はアーカイブのダウンロード先となる一時ファイルを作成する。
+// Extractor は拡張子で判定し、単一ファイルの圧縮形式では展開後のファイル名にも使うため、
+// 一時ディレクトリ内に URL の末尾要素と同じ名前で作成する。削除には removeArchiveTemp を使う。
+func createArchiveTemp(fileID model.FileID, url model.ResolvedURL) (*os.File, error) {
+	tmpDir, err := os.MkdirTemp("", fmt.Sprintf("dltofu-%s-*", fileID))
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Create(filepath.Join(tmpDir, path.Base(string(url))))
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return nil, err
+	}
+	return f, nil
+}
+
+// createSignedTemp は署名の検証が済むまで dest の代わりにダウンロードする一時ファイルを dest と同じディレクトリに作成し、
+// そのパスを返す (同じファイルシステム上なので検証後にリネームで置き換えられる)
+func createSignedTemp(dest string) (string, error) {
+	dir := filepath.Dir(dest)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(dir, "."+filepath.Base(dest)+".*.unverified")
+	if err != nil {
+		return "", err
+	}
+	f.Close() // downloader が再度開くので一旦閉じる
+	return f.Name(), nil
+}
+
+// removeArchiveTemp は createArchiveTemp で作成した一時ファイルをディレクトリごと削除する
+func removeArchiveTemp(tmpPath string) error {
+	return os.RemoveAll(filepath.Dir(tmpPath))
+}
+
+// resolveParts は分割ファイルの各パートのURLテンプレートを解決する
+func resolveParts(partTemplates []string, data template.TemplateData) ([]model.ResolvedURL, error) {
+	urls := make([]model.ResolvedURL, 0, len(partTemplates))
+	for i, partTemplate := range partTemplates {
+		url, err := template.ResolveURL(partTemplate, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve URL of part %d: %w", i, err)
+		}
+		urls = append(urls, url)
+	}
+	return urls, nil
+}
+
+// addTargetEnvironmentFlags は対象のプラットフォーム/アーキテクチャを指定するフラグを cmd に追加する
+func addTargetEnvironmentFlags(cmd *cobra.Command, platformID, archID, variant *string) {
+	cmd.Flags().StringVar(platformID, "platform", "", "Target platform identifier instead of the current one (e.g. linux)")
+	cmd.Flags().StringVar(archID, "arch", "", "Target architecture identifier instead of the current one (e.g. x86_64)")
+	cmd.Flags().StringVar(variant, "arch-variant", "", "Target 32-bit ARM variant (armv5, armv6, armv7); requires a 32-bit ARM target and is detected only when --arch is not given")
+}
+
+// targetEnvironment は対象とするプラットフォーム/アーキテクチャ/バリアントを返す。
+// フラグで指定された値を優先し、未指定のものは実行環境から検出する。
+// --arch を指定した場合、バリアントは --arch-variant がなければ検出せず空とする (最も古いバリアントが選ばれる)。
+// --arch-variant は対象のアーキテクチャが 32-bit ARM の場合のみ指定できる。
+func targetEnvironment(cfg *config.Config, platformID, archID, variant string) (string, string, string, error) {
+	var err error
+	if platformID == "" {
+		if platformID, err = cfg.CurrentPlatform(); err != nil {
+			return "", "", "", fmt.Errorf("failed to get current platform: %w", err)
+		}
+	} else if !cfg.IsValidPlatform(platformID) {
+		return "", "", "", fmt.Errorf("invalid --platform '%s' (valid: %s)", platformID, strings.Join(cfg.AllPlatforms(), ", "))
+	}
+
+	archDetected := archID == ""
+	if archDetected {
+		if archID, err = cfg.CurrentArch(); err != nil {
+			return "", "", "", fmt.Errorf("failed to get current architecture: %w", err)
+		}
+	} else if !cfg.IsValidArch(archID) {
+		return "", "", "", fmt.Errorf("invalid --arch '%s' (valid: %s)", archID, strings.Join(cfg.AllArchs(), ", "))
+	}
+
+	switch {
+	case variant != "" && !platform.IsValidArchVariant(variant):
+		return "", "", "", fmt.Errorf("invalid --arch-variant '%s' (valid: %s)", variant, strings.Join(platform.GetAllArchVariants(), ", "))
+	case variant != "" && !cfg.IsArmArch(archID):
+		return "", "", "", fmt.Errorf("--arch-variant '%s' requires a 32-bit ARM architecture, but the target architecture is '%s'", variant, archID)
+	case variant == "" && archDetected:
+		variant = platform.GetCurrentArchVariant() // 32-bit ARM 以外、または判定できない場合は空
+	}
+	return platformID, archID, variant, nil
+}
+
+// verifySignature は署名ファイルをダウンロードして filePath の内容を検証し、署名した鍵 (または証明書の ID) を返す
+func verifySignature(cfg *config.Config, downloader *download.Downloader, target config.Target, filePath string) (string, error) {
+	sigDef := target.Def.Signature
+	sigURL, err := template.ResolveURL(sigDef.URL, target.Data)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve signature URL: %w", err)
+	}
+	logger.Debug("Downloading signature", "file_id", target.FileID, "url", sigURL, "type", sigDef.Type)
+	// ファイルの headers (認証情報を含みうる) は、署名が別のホストにある場合は送らない
+	var sig bytes.Buffer
+	if _, err := downloader.ForRelated(target.URL, sigURL).FetchAndHash(sigURL, target.HashAlgorithm, &sig); err != nil {
+		return "", fmt.Errorf("failed to download signature: %w", err)
+	}
+
+	if sigDef.Type == config.SignatureTypeCosign {
+		trustedRoot := sigDef.TrustedRoot
+		if trustedRoot != "" {
+			trustedRoot, err = cfg.ResolveDestPath(trustedRoot) // 設定ファイル基準で解決
+			if err != nil {
+				return "", fmt.Errorf("failed to resolve trusted root path: %w", err)
 			}
 		}
-		logger.Info("Successfully processed file", "file_id", fileID)
+		expected := verify.CosignIdentity{Identity: sigDef.Identity, Issuer: sigDef.Issuer}
+		signer, err := verify.VerifyCosignBundle(sig.Bytes(), filePath, expected, trustedRoot)
+		if err != nil {
+			return "", err
+		}
+		return signer.String(), nil
+	}
 
-	} // end file loop
+	publicKey := sigDef.PublicKey
+	if !signature.IsInlineKey(publicKey) {
+		publicKey, err = cfg.ResolveDestPath(publicKey) // 設定ファイル基準で解決
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve public key path: %w", err)
+		}
+	}
+	keyring, err := signature.LoadKeyRing(publicKey)
+	if err != nil {
+		return "", err
+	}
+	return signature.VerifyFile(keyring, filePath, sig.Bytes())
+}
 
-	if hasError {
-		return fmt.Errorf("download command finished with errors")
+// resolvePatchURLs はパッチ定義のベースとパッチの URL テンプレートを解決する
+func resolvePatchURLs(patchDef *config.PatchDef, data template.TemplateData) (baseURL, patchURL model.ResolvedURL, err error) {
+	baseURL, err = template.ResolveURL(patchDef.BaseURL, data)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to resolve base URL of patch: %w", err)
 	}
+	patchURL, err = template.ResolveURL(patchDef.URL, data)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to resolve patch URL: %w", err)
+	}
+	return baseURL, patchURL, nil
+}
 
-	logger.Info("Download command finished successfully")
+// fetchPatched はベースとパッチを Lock ファイルのハッシュ値で検証しながらダウンロードし、
+// パッチを適用した結果を expectedHash で検証してから destPath に保存する
+func fetchPatched(downloader *download.Downloader, lockFile *lock.LockFile, fileID model.FileID, patchDef *config.PatchDef, data template.TemplateData, algorithm hash.HashAlgorithm, destPath string, expectedHash *hash.Hash) error {
+	baseURL, patchURL, err := resolvePatchURLs(patchDef, data)
+	if err != nil {
+		return err
+	}
+	baseHash, err := lockFile.GetHash(fileID, baseURL, algorithm)
+	if err != nil {
+		return fmt.Errorf("base of patch: %w", err)
+	}
+	patchHash, err := lockFile.GetHash(fileID, patchURL, algorithm)
+	if err != nil {
+		return fmt.Errorf("patch: %w", err)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "dltofu-patch-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	basePath := filepath.Join(tmpDir, "base")
+	logger.Debug("Downloading base of patch", "file_id", fileID, "url", baseURL)
+	if err := downloader.FetchToFileWithHashCheck(baseURL, basePath, baseHash); err != nil {
+		return err
+	}
+	patchPath := filepath.Join(tmpDir, "patch")
+	logger.Debug("Downloading patch", "file_id", fileID, "url", patchURL)
+	if err := downloader.FetchToFileWithHashCheck(patchURL, patchPath, patchHash); err != nil {
+		return err
+	}
+
+	// パッチの適用結果は保存先と同じディレクトリの一時ファイルに書き込み、検証してからリネームする
+	destDir := filepath.Dir(destPath)
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return fmt.Errorf("failed to create destination directory %s: %w", destDir, err)
+	}
+	out, err := os.CreateTemp(destDir, filepath.Base(destPath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file in %s: %w", destDir, err)
+	}
+	outPath := out.Name()
+	defer os.Remove(outPath) // リネーム後は存在しないので失敗するが問題ない
+
+	hasher, err := hash.GetHasher(algorithm)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	err = patch.ApplyFile(basePath, patchPath, io.MultiWriter(out, hasher))
+	if closeErr := out.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to close temporary file %s: %w", outPath, closeErr)
+	}
+	if err != nil {
+		return err
+	}
+	actualHash := hash.NewHash(algorithm, hasher.Sum(nil))
+	if !actualHash.Equal(expectedHash) {
+		return fmt.Errorf("hash mismatch for patched file: expected %s, got %s", expectedHash, actualHash)
+	}
+	if err := os.Rename(outPath, destPath); err != nil {
+		return fmt.Errorf("failed to rename temporary file %s to %s: %w", outPath, destPath, err)
+	}
 	return nil
 }
+
+// fileDownloader は設定されたHTTPヘッダ (Override を考慮) をリクエストに設定し、
+// url の取得に失敗した場合は mirrors を試す Downloader を返す。
+// expect_content_type が指定されている場合は url (と mirrors) のレスポンスの Content-Type も検証する。
+// いずれも設定されていない場合は downloader をそのまま返す。
+func fileDownloader(downloader *download.Downloader, target config.Target) (*download.Downloader, error) {
+	header, err := resolveHeaders(target)
+	if err != nil {
+		return nil, err
+	}
+	return downloader.WithHeader(header).WithMirrors(target.URL, target.Mirrors).WithContentType(target.URL, target.Def.ExpectContentType), nil
+}
+
+// resolveHeaders は設定されたHTTPヘッダ (Override を考慮) のテンプレートを展開する
+func resolveHeaders(target config.Target) (http.Header, error) {
+	header := http.Header{}
+	for key, valueTemplate := range target.Def.GetEffectiveHeaders(target.PlatformID, target.ArchID, target.ArchVariant) {
+		value, err := template.ResolveHeader(valueTemplate, target.Data)
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %w", key, err)
+		}
+		header.Set(key, value)
+	}
+	return header, nil
+}
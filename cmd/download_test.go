@@ -0,0 +1,347 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+
+	"github.com/hrko/dltofu/internal/config"
+)
+
+func TestTargetEnvironment(t *testing.T) {
+	builtin := &config.Config{}
+	mapped := &config.Config{ArchMap: map[string]string{"x64": "amd64", "armhf": "arm"}}
+	tests := []struct {
+		name        string
+		cfg         *config.Config
+		platformID  string
+		archID      string
+		variant     string
+		wantArch    string
+		wantVariant string
+		wantErr     string // 空ならエラーにならない
+	}{
+		{name: "arm with variant", cfg: builtin, platformID: "linux", archID: "arm", variant: "armv7", wantArch: "arm", wantVariant: "armv7"},
+		{name: "arm without variant", cfg: builtin, platformID: "linux", archID: "arm", wantArch: "arm"},
+		{name: "non-arm without variant", cfg: builtin, platformID: "linux", archID: "x86_64", wantArch: "x86_64"},
+		{name: "variant with non-arm arch", cfg: builtin, platformID: "linux", archID: "x86_64", variant: "armv7", wantErr: "requires a 32-bit ARM architecture"},
+		{name: "variant with arm64", cfg: builtin, platformID: "linux", archID: "arm64", variant: "armv6", wantErr: "requires a 32-bit ARM architecture"},
+		{name: "invalid variant", cfg: builtin, platformID: "linux", archID: "arm", variant: "armv9", wantErr: "invalid --arch-variant"},
+		{name: "invalid arch", cfg: builtin, platformID: "linux", archID: "sparc", wantErr: "invalid --arch"},
+		{name: "invalid platform", cfg: builtin, platformID: "plan9", archID: "arm", wantErr: "invalid --platform"},
+		{name: "arch_map arm with variant", cfg: mapped, platformID: "linux", archID: "armhf", variant: "armv6", wantArch: "armhf", wantVariant: "armv6"},
+		{name: "arch_map non-arm with variant", cfg: mapped, platformID: "linux", archID: "x64", variant: "armv6", wantErr: "requires a 32-bit ARM architecture"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			platformID, archID, variant, err := targetEnvironment(tt.cfg, tt.platformID, tt.archID, tt.variant)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("targetEnvironment() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("targetEnvironment() error = %v", err)
+			}
+			if platformID != tt.platformID || archID != tt.wantArch || variant != tt.wantVariant {
+				t.Errorf("targetEnvironment() = %s/%s/%s, want %s/%s/%s", platformID, archID, variant, tt.platformID, tt.wantArch, tt.wantVariant)
+			}
+		})
+	}
+}
+
+func TestDownloadStrictPlatforms(t *testing.T) {
+	tests := []struct {
+		name      string
+		platforms string // files.tool の platforms/architectures (空ならプラットフォーム指定なし)
+		strict    bool
+		wantErr   bool
+		wantFile  bool
+	}{
+		{name: "current platform declared", platforms: "linux: linux\n      windows: windows\n    architectures:\n      x86_64: amd64", strict: true, wantFile: true},
+		{name: "current platform missing", platforms: "windows: windows\n    architectures:\n      x86_64: amd64", wantFile: false},
+		{name: "current platform missing strict", platforms: "windows: windows\n    architectures:\n      x86_64: amd64", strict: true, wantErr: true},
+		{name: "current arch missing strict", platforms: "linux: linux\n    architectures:\n      arm64: arm64", strict: true, wantErr: true},
+		{name: "no platforms strict", strict: true, wantFile: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var content atomic.Value
+			content.Store("tool\n")
+			srv := fileServer(t, &content)
+
+			dir := t.TempDir()
+			cfgPath := filepath.Join(dir, "dltofu.yml")
+			cfg := "version: v1\nfiles:\n  tool:\n    url: " + srv.URL + "/tool-{{.Platform}}\n    destination: tool\n"
+			if tt.platforms != "" {
+				cfg += "    platforms:\n      " + tt.platforms + "\n"
+			} else {
+				cfg = strings.Replace(cfg, "-{{.Platform}}", "", 1)
+			}
+			if err := os.WriteFile(cfgPath, []byte(cfg), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := runCLI(t, "lock", "-c", cfgPath, "--no-progress"); err != nil {
+				t.Fatalf("lock: %v", err)
+			}
+
+			args := []string{"download", "-c", cfgPath, "--no-progress", "--no-cache", "--platform", "linux", "--arch", "x86_64"}
+			if tt.strict {
+				args = append(args, "--strict-platforms")
+			}
+			err := runCLI(t, args...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("download error = %v, wantErr %v", err, tt.wantErr)
+			}
+			_, statErr := os.Stat(filepath.Join(dir, "tool"))
+			if gotFile := statErr == nil; gotFile != tt.wantFile {
+				t.Errorf("destination exists = %v, want %v", gotFile, tt.wantFile)
+			}
+		})
+	}
+}
+
+// tarGz は files (key: アーカイブ内のパス) を含む tar.gz の内容を返す
+func tarGz(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDownloadArchiveType(t *testing.T) {
+	archive := string(tarGz(t, map[string]string{"tool-1.0/bin/tool": "typed tool\n"}))
+	tests := []struct {
+		name        string
+		path        string // アーカイブを配信するパス
+		archiveType string // 空なら archive_type を指定しない
+		wantErr     bool
+	}{
+		{name: "misleading extension", path: "/tool.zip", archiveType: "tar.gz"},
+		{name: "no extension", path: "/download/latest", archiveType: "tar.gz"},
+		{name: "misleading extension without archive_type", path: "/tool.zip", wantErr: true},
+		{name: "wrong archive_type", path: "/tool.tar.gz", archiveType: "zip", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newContentServer(t, map[string]string{tt.path: archive})
+			dir := t.TempDir()
+			cfgPath := filepath.Join(dir, "dltofu.yml")
+			cfg := "version: v1\nfiles:\n  tool:\n    url: " + srv.URL + tt.path + "\n    destination: out\n    is_archive: true\n    strip_components: 1\n"
+			if tt.archiveType != "" {
+				cfg += "    archive_type: " + tt.archiveType + "\n"
+			}
+			if err := os.WriteFile(cfgPath, []byte(cfg), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := runCLI(t, "lock", "-c", cfgPath, "--no-progress"); err != nil {
+				t.Fatalf("lock: %v", err)
+			}
+
+			err := runCLI(t, "download", "-c", cfgPath, "--no-progress", "--no-cache")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("download error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			data, err := os.ReadFile(filepath.Join(dir, "out", "bin", "tool"))
+			if err != nil || string(data) != "typed tool\n" {
+				t.Errorf("extracted bin/tool = %q (error: %v), want %q", data, err, "typed tool\n")
+			}
+		})
+	}
+}
+
+func TestDownloadSplitArchive(t *testing.T) {
+	archive := string(tarGz(t, map[string]string{"tool-1.0/bin/tool": "split tool\n", "tool-1.0/README": "readme\n"}))
+	tests := []struct {
+		name    string
+		splits  []int // 各パートの終了位置 (最後のパートは常に末尾まで)
+		tamper  int   // lock の後に内容を変更するパートの番号 (1 始まり、0 なら変更しない)
+		wantErr bool
+	}{
+		{name: "two parts", splits: []int{len(archive) / 2}},
+		{name: "three parts", splits: []int{10, 20}},
+		{name: "part changed after lock", splits: []int{len(archive) / 2}, tamper: 2, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			served := make(map[string]string)
+			var partURLs []string
+			start := 0
+			srv := newContentServer(t, served)
+			for i, end := range append(slices.Clone(tt.splits), len(archive)) {
+				p := fmt.Sprintf("/tool.tar.gz.%03d", i+1)
+				served[p] = archive[start:end]
+				partURLs = append(partURLs, srv.URL+p)
+				start = end
+			}
+
+			dir := t.TempDir()
+			cfgPath := filepath.Join(dir, "dltofu.yml")
+			cfg := "version: v1\nfiles:\n  tool:\n    url: " + srv.URL + "/tool.tar.gz\n    parts:\n"
+			for _, u := range partURLs {
+				cfg += "      - " + u + "\n"
+			}
+			cfg += "    destination: out\n    is_archive: true\n    strip_components: 1\n"
+			if err := os.WriteFile(cfgPath, []byte(cfg), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := runCLI(t, "lock", "-c", cfgPath, "--no-progress"); err != nil {
+				t.Fatalf("lock: %v", err)
+			}
+			if tt.tamper > 0 {
+				srv.set(fmt.Sprintf("/tool.tar.gz.%03d", tt.tamper), "tampered")
+			}
+
+			err := runCLI(t, "download", "-c", cfgPath, "--no-progress", "--no-cache")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("download error = %v, wantErr %v", err, tt.wantErr)
+			}
+			data, readErr := os.ReadFile(filepath.Join(dir, "out", "bin", "tool"))
+			if tt.wantErr {
+				if readErr == nil {
+					t.Errorf("archive was extracted despite the hash mismatch")
+				}
+				return
+			}
+			if readErr != nil || string(data) != "split tool\n" {
+				t.Errorf("extracted bin/tool = %q (error: %v), want %q", data, readErr, "split tool\n")
+			}
+		})
+	}
+}
+
+func TestDownloadSignatureHeaders(t *testing.T) {
+	const content = "signed tool\n"
+	signer, err := openpgp.NewEntity("release", "", "release@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sig, publicKey bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&sig, signer, strings.NewReader(content), nil); err != nil {
+		t.Fatal(err)
+	}
+	w, err := armor.Encode(&publicKey, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := signer.Serialize(w); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		otherOrigin bool // 署名をファイルと別のサーバーから配信する
+		wantHeaders map[string]string
+	}{
+		{
+			name:        "same origin",
+			wantHeaders: map[string]string{"Authorization": "Bearer secret", "X-Api-Key": "key", "X-Client": "dltofu"},
+		},
+		{
+			name:        "other origin",
+			otherOrigin: true,
+			wantHeaders: map[string]string{"Authorization": "", "X-Api-Key": "", "X-Client": "dltofu"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sigHeader atomic.Value
+			serveSig := func(w http.ResponseWriter, r *http.Request) {
+				sigHeader.Store(r.Header.Clone())
+				w.Write(sig.Bytes())
+			}
+			mux := http.NewServeMux()
+			mux.HandleFunc("/tool", func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Authorization") != "Bearer secret" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.Write([]byte(content))
+			})
+			mux.HandleFunc("/tool.asc", serveSig)
+			srv := httptest.NewServer(mux)
+			defer srv.Close()
+			sigURL := srv.URL + "/tool.asc"
+			if tt.otherOrigin {
+				sigSrv := httptest.NewServer(http.HandlerFunc(serveSig))
+				defer sigSrv.Close()
+				sigURL = sigSrv.URL + "/tool.asc"
+			}
+
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "release.asc"), publicKey.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+			cfgPath := filepath.Join(dir, "dltofu.yml")
+			cfg := `version: v1
+files:
+  tool:
+    url: ` + srv.URL + `/tool
+    destination: tool
+    headers:
+      Authorization: Bearer secret
+      X-Api-Key: key
+      X-Client: dltofu
+    signature:
+      url: ` + sigURL + `
+      public_key: release.asc
+`
+			if err := os.WriteFile(cfgPath, []byte(cfg), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := runCLI(t, "lock", "-c", cfgPath, "--no-progress"); err != nil {
+				t.Fatalf("lock: %v", err)
+			}
+			if err := runCLI(t, "download", "-c", cfgPath, "--no-progress", "--no-cache"); err != nil {
+				t.Fatalf("download: %v", err)
+			}
+			if data, err := os.ReadFile(filepath.Join(dir, "tool")); err != nil || string(data) != content {
+				t.Errorf("tool = %q (error: %v), want %q", data, err, content)
+			}
+
+			header, ok := sigHeader.Load().(http.Header)
+			if !ok {
+				t.Fatal("signature was not requested")
+			}
+			for name, want := range tt.wantHeaders {
+				if got := header.Get(name); got != want {
+					t.Errorf("signature request header %s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
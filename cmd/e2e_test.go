@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// runCLI は args でコマンドを実行する。
+// フラグの値はパッケージ変数に残るため、実行前に全てのコマンドのフラグをデフォルト値に戻す。
+func runCLI(t *testing.T, args ...string) error {
+	t.Helper()
+	resetFlags(rootCmd)
+	cfgFile = ""
+	rootCmd.SetArgs(args)
+	return rootCmd.Execute()
+}
+
+// resetFlags は cmd とそのサブコマンドのフラグをデフォルト値に戻す
+func resetFlags(cmd *cobra.Command) {
+	reset := func(f *pflag.Flag) {
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			_ = sv.Replace(nil)
+		} else {
+			_ = f.Value.Set(f.DefValue)
+		}
+		f.Changed = false
+	}
+	cmd.Flags().VisitAll(reset)
+	cmd.PersistentFlags().VisitAll(reset)
+	for _, sub := range cmd.Commands() {
+		resetFlags(sub)
+	}
+}
+
+// fileServer は content の現在の値を返す httptest のサーバー
+func fileServer(t *testing.T, content *atomic.Value) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(content.Load().(string)))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestLockAndDownloadEndToEnd(t *testing.T) {
+	tests := []struct {
+		name        string
+		lock        bool   // download の前に lock を実行する
+		served      string // download の時にサーバーが返す内容
+		wantErr     bool
+		wantContent string // 空なら保存先が存在しないこと
+	}{
+		{name: "lock then download", lock: true, served: "tool v1\n", wantContent: "tool v1\n"},
+		{name: "content changed after lock", lock: true, served: "tampered\n", wantErr: true},
+		{name: "download without lock", served: "tool v1\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var content atomic.Value
+			content.Store("tool v1\n")
+			srv := fileServer(t, &content)
+
+			dir := t.TempDir()
+			cfgPath := filepath.Join(dir, "dltofu.yml")
+			cfg := "version: v1\nfiles:\n  tool:\n    url: " + srv.URL + "/tool\n    destination: bin/tool\n"
+			if err := os.WriteFile(cfgPath, []byte(cfg), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.lock {
+				if err := runCLI(t, "lock", "-c", cfgPath, "--no-progress"); err != nil {
+					t.Fatalf("lock: %v", err)
+				}
+				if _, err := os.Stat(filepath.Join(dir, "dltofu.lock")); err != nil {
+					t.Fatalf("lock file was not written: %v", err)
+				}
+			}
+
+			content.Store(tt.served)
+			err := runCLI(t, "download", "-c", cfgPath, "--no-progress", "--no-cache", "--force")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("download error = %v, wantErr %v", err, tt.wantErr)
+			}
+			data, err := os.ReadFile(filepath.Join(dir, "bin", "tool"))
+			if tt.wantContent == "" {
+				if err == nil {
+					t.Errorf("destination was written with %q, want no file", data)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("destination was not written: %v", err)
+			}
+			if string(data) != tt.wantContent {
+				t.Errorf("destination content = %q, want %q", data, tt.wantContent)
+			}
+		})
+	}
+}
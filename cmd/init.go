@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hrko/dltofu/internal/config"
+	"github.com/hrko/dltofu/internal/hash"
+)
+
+var initForce bool // --force フラグ用
+
+// configTemplate は init コマンドで生成する設定ファイルの雛形
+var configTemplate = `# dltofu configuration file
+version: ` + config.CurrentVersion + `
+
+# Default hash algorithm for the lock file (sha256, sha512, blake3, blake2b, blake2s)
+hash_algorithm: ` + string(hash.AlgoSHA256) + `
+
+# Files to download. Each key is a file ID used in the lock file.
+files:
+  example-tool:
+    # URL template. Available variables: {{.Version}}, {{.Platform}}, {{.Architecture}}
+    # Functions: lower, upper, title, replace, trimPrefix, trimSuffix
+    #   e.g. {{.Platform | title}}, {{.Architecture | replace "amd64" "x86_64"}}
+    url: https://example.com/releases/download/v{{.Version}}/example-tool_{{.Platform}}_{{.Architecture}}.tar.gz
+    version: "1.0.0"
+
+    # Supported platforms/architectures.
+    # key: dltofu identifier, value: string substituted into the URL template
+    platforms:
+      linux: linux
+      macos: darwin
+      windows: windows
+    architectures:
+      x86_64: amd64
+      arm64: arm64
+
+    # Per platform/architecture overrides ("platform/arch")
+    overrides:
+      windows/x86_64:
+        url: https://example.com/releases/download/v{{.Version}}/example-tool_{{.Platform}}_{{.Architecture}}.zip
+
+    # Download destination (relative to this file, template variables allowed).
+    # For archives this is the extraction directory.
+    destination: bin
+
+    # Archive handling
+    is_archive: true
+    strip_components: 1
+`
+
+// initCmd represents the init command
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Creates a commented skeleton configuration file",
+	Long: `Writes a commented dltofu.yml skeleton with one example file entry
+showing url, version, platforms, architectures, overrides and destination.
+
+The file is written to the path given by --config (default dltofu.yml).
+An existing file is not overwritten unless --force is given.`,
+	RunE: runInit,
+}
+
+func init() {
+	rootCmd.AddCommand(initCmd)
+	initCmd.Flags().BoolVarP(&initForce, "force", "f", false, "Overwrite an existing configuration file")
+}
+
+func runInit(cmd *cobra.Command, args []string) error {
+	path := cfgFile
+	if path == "" {
+		path = "dltofu.yml"
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		if !initForce {
+			return fmt.Errorf("configuration file %s already exists (use --force to overwrite)", path)
+		}
+		logger.Warn("Overwriting existing configuration file", "path", path)
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to check configuration file %s: %w", path, err)
+	}
+
+	if err := os.WriteFile(path, []byte(configTemplate), 0644); err != nil {
+		return fmt.Errorf("failed to write configuration file %s: %w", path, err)
+	}
+	logger.Info("Configuration file created", "path", path)
+	return nil
+}
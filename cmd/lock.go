@@ -1,24 +1,62 @@
 package cmd
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
+	"maps"
+	neturl "net/url"
 	"os"
-	"reflect"
+	"path"
+	"path/filepath"
 	"runtime"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
+	"text/tabwriter"
 
 	"github.com/spf13/cobra"
 	"golang.org/x/sync/errgroup"
 	"golang.org/x/sync/semaphore"
 
+	"github.com/hrko/dltofu/internal/archive"
 	"github.com/hrko/dltofu/internal/config"
 	"github.com/hrko/dltofu/internal/download"
+	"github.com/hrko/dltofu/internal/hash"
 	"github.com/hrko/dltofu/internal/lock"
+	"github.com/hrko/dltofu/internal/metrics"
 	"github.com/hrko/dltofu/internal/model"
+	"github.com/hrko/dltofu/internal/patch"
+	"github.com/hrko/dltofu/internal/report"
 	"github.com/hrko/dltofu/internal/template"
 )
 
+var (
+	lockOnly     []string // --only フラグ用
+	lockExclude  []string // --exclude フラグ用
+	lockVersions []string // --set-version フラグ用
+	lockTreeHash bool     // --tree-hash フラグ用
+	lockJSON     bool     // --json フラグ用
+	lockDedup    bool     // --dedup フラグ用
+	lockSign     bool     // --sign-lock フラグ用
+	lockParallel int      // --parallelism フラグ用
+
+	lockKeepGoing     bool   // --keep-going フラグ用
+	lockWriteComplete bool   // --write-only-if-complete フラグ用
+	lockCheck         bool   // --check フラグ用
+	lockPruneOnly     bool   // --prune-only フラグ用
+	lockOnMismatch    string // --on-mismatch フラグ用
+)
+
+// --on-mismatch の値
+const (
+	mismatchFail   = "fail"   // Lock ファイルを変更せずにエラーにする (デフォルト)
+	mismatchSkip   = "skip"   // 警告して既存のハッシュ値を残す
+	mismatchUpdate = "update" // 警告して新しいハッシュ値で置き換える
+)
+
 // lockCmd represents the lock command
 var lockCmd = &cobra.Command{
 	Use:   "lock",
@@ -28,172 +66,241 @@ var lockCmd = &cobra.Command{
 and writes them to the lock file (dltofu.lock).
 
 It checks for hash inconsistencies with the existing lock file (if any)
-and prunes entries that are no longer in the configuration.`,
+and prunes entries that are no longer in the configuration.
+
+By default the first failure aborts the run and the lock file is left
+untouched. With --keep-going the remaining files are still processed and
+the successful results are written, keeping the existing entries of the
+failed files. Add --write-only-if-complete (which requires --keep-going) to
+never overwrite the lock file after a failure; the partial result is written
+next to it with a .partial suffix for inspection instead.
+
+With --check, nothing is downloaded and the lock file is not written.
+Every URL resolved from the configuration is checked for a recorded hash
+and entries that are no longer resolved are reported as orphaned. Exits
+with a non-zero status if the lock file is out of sync, e.g. in CI or a
+pre-commit hook.
+
+--on-mismatch controls what happens when a downloaded file no longer matches
+its recorded hash. Other files are processed either way and all mismatches
+are reported at the end. With 'fail' (default) the lock file is not written
+and the command fails; with 'skip' the recorded hash is kept; with 'update'
+it is replaced by the new one, like 'dltofu update'.
+
+After locking, URLs shared by several file IDs are compared. If the same URL
+is locked with different hashes, or with no hash algorithm in common, it is
+almost certainly a configuration mistake and a warning is logged; with
+--strict it is an error and the lock file is not written.
+
+With --prune-only, nothing is downloaded: entries whose file ID or URL is no
+longer resolved from the configuration are removed from the existing lock
+file and nothing is added. This is fast, works offline, and is handy after
+removing files or variants from the configuration.
+
+With --sign-lock, a checksum of the lock file's own content is recorded in
+it. Once present, it is kept up to date by every later lock or update.
+Loading a lock file whose content no longer matches its checksum logs a
+warning; pass --verify-lock to any command to make that (or a missing
+checksum) an error. This detects edits made outside of dltofu, not a
+forged lock file, which can simply carry a recomputed checksum.`,
 	RunE: runLock,
 }
 
 func init() {
 	rootCmd.AddCommand(lockCmd)
-	// lock コマンド固有のフラグがあればここに追加
-	// 例: lockCmd.Flags().IntP("parallelism", "p", runtime.NumCPU(), "Number of parallel downloads/hash calculations")
+	lockCmd.Flags().StringArrayVar(&lockOnly, "only", nil, "Only lock file IDs matching the glob pattern (repeatable)")
+	lockCmd.Flags().StringArrayVar(&lockVersions, "set-version", nil, "Override the version of a file for this run as <file-id>=<version> (repeatable)")
+	lockCmd.Flags().StringArrayVar(&lockExclude, "exclude", nil, "Skip file IDs matching the glob pattern, keeping their existing entries (repeatable)")
+	addDeprecatedJSONFlag(lockCmd, &lockJSON)
+	lockCmd.Flags().BoolVar(&lockTreeHash, "tree-hash", false, "Also extract archives and record hashes of the extracted tree and each extracted file")
+	lockCmd.Flags().BoolVar(&lockSign, "sign-lock", false, "Record a checksum of the lock file content in the lock file to detect out-of-band edits")
+	lockCmd.Flags().BoolVar(&lockDedup, "dedup", false, "Write the lock file in the normalized format that stores identical URL/hash pairs once (default: keep the current format)")
+	lockCmd.Flags().IntVarP(&lockParallel, "parallelism", "p", runtime.NumCPU(), "Number of parallel downloads/hash calculations")
+	lockCmd.Flags().BoolVar(&lockKeepGoing, "keep-going", false, "Continue with the remaining files after a failure and write the successful results")
+	lockCmd.Flags().BoolVar(&lockWriteComplete, "write-only-if-complete", false, "With --keep-going, never overwrite the lock file unless every file succeeded; write <lock file>.partial instead")
+	lockCmd.Flags().BoolVar(&lockCheck, "check", false, "Check that the lock file matches the configuration without downloading anything")
+	lockCmd.Flags().BoolVar(&lockPruneOnly, "prune-only", false, "Only remove lock entries no longer resolved from the configuration, without downloading anything")
+	lockCmd.Flags().StringVar(&lockOnMismatch, "on-mismatch", mismatchFail, "What to do when a file no longer matches its recorded hash (fail, skip, update)")
 }
 
-func runLock(cmd *cobra.Command, args []string) error {
+// checkLockFlags は lock コマンドのフラグの組み合わせを検証する
+func checkLockFlags() error {
+	if lockCheck && lockPruneOnly {
+		return fmt.Errorf("--check and --prune-only cannot be used together")
+	}
+	if lockWriteComplete && !lockKeepGoing {
+		// --keep-going なしでは最初の失敗で中断して Lock ファイルを書き込まないため、指定しても意味がない
+		return fmt.Errorf("--write-only-if-complete requires --keep-going")
+	}
+	if lockParallel < 1 {
+		return fmt.Errorf("--parallelism must be at least 1 (got %d)", lockParallel)
+	}
+	switch lockOnMismatch {
+	case mismatchFail, mismatchSkip, mismatchUpdate:
+	default:
+		return fmt.Errorf("unsupported --on-mismatch: %s (supported: %s, %s, %s)", lockOnMismatch, mismatchFail, mismatchSkip, mismatchUpdate)
+	}
+	return nil
+}
+
+func runLock(cmd *cobra.Command, args []string) (err error) {
 	ctx := cmd.Context() // Cobra v1.8+
 
 	logger.Info("Starting lock command")
 
+	var results report.Collector
+	var runMetrics *metrics.Metrics
+	defer func() { writeResult("lock", &results, runMetrics, err) }()
+
+	if err := checkLockFlags(); err != nil {
+		return err
+	}
+
 	if cfgFile == "" {
 		// PersistentPreRun でデフォルトを探した後でも空ならエラー
 		return fmt.Errorf("configuration file must be specified using --config or exist as dltofu.yml/dltofu.yaml")
 	}
 
-	cfg, err := config.LoadConfig(cfgFile, logger)
+	versions, err := parseSetVersions(lockVersions)
+	if err != nil {
+		return err
+	}
+	cfg, err := config.LoadConfig(cfgFile, logger, strict, config.WithVersions(versions))
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
+	if err := cfg.SelectFiles(lockOnly); err != nil {
+		return err
+	}
+	if err := cfg.ExcludeFiles(lockExclude); err != nil {
+		return err
+	}
+	filtered := len(lockOnly) > 0 || len(lockExclude) > 0 // 対象外のファイルIDのエントリはそのまま残す
 
 	// 既存の Lock ファイルを読み込む (存在しなくてもエラーにはしない)
-	configDir := cfg.GetConfigDir()
-	existingLock, err := lock.LoadLockFile(configDir, logger)
+	lockPath, err := cfg.ResolveLockPath(lockName)
+	if err != nil {
+		return err
+	}
+	existingLock, err := lock.LoadLockFile(lockPath, logger)
 	if err != nil {
 		if !errors.Is(err, os.ErrNotExist) {
 			// 読み込み自体に失敗した場合 (JSON不正など) はエラー
 			return fmt.Errorf("failed to load existing lock file: %w", err)
+		} else if lockCheck || lockPruneOnly {
+			return fmt.Errorf("lock file %s not found", lockPath)
 		} else {
 			existingLock = lock.NewLockFile(logger) // 新規作成
 		}
 	}
+	if err := checkLockChecksum(existingLock); err != nil {
+		return err
+	}
+	if lockCheck || lockPruneOnly {
+		// Lock ファイルを検証・整理するだけなので、version: latest は記録済みのリリースを使う
+		cfg.PinLatest(existingLock)
+	} else if err := resolveLatestReleases(cfg); err != nil {
+		return err
+	}
+	if lockCheck {
+		return checkLock(cfg, existingLock, filtered, &results)
+	}
+	if lockPruneOnly {
+		return pruneLock(cfg, existingLock, lockPath, filtered, &results)
+	}
 
 	// 新しいLockファイルデータを準備
 	newLock := existingLock.Copy()
+	// --dedup が明示された場合のみ保存形式を切り替え、それ以外は既存の形式を維持する
+	if cmd.Flags().Changed("dedup") {
+		newLock.SetDedup(lockDedup)
+	}
+	if lockSign {
+		newLock.SetSigned(true)
+	}
 
 	// ダウンローダー準備
-	downloader := download.NewDownloader(0, logger) // Timeout はデフォルト
+	runMetrics = metrics.New()
+	defer printSummary(runMetrics)
+	opts, err := downloaderOptions(cfg,
+		download.WithMetrics(runMetrics),
+		download.WithContext(ctx),
+		sharedURLCache(allTargets(cfg), spillCache()),
+	)
+	if err != nil {
+		return err
+	}
+	downloader := download.NewDownloader(timeout, logger, opts...)
 
 	// 並列処理の準備
-	// parallelism, _ := cmd.Flags().GetInt("parallelism") // フラグから取得する場合
-	parallelism := runtime.NumCPU() // CPU数で制限
+	parallelism := lockParallel
 	logger.Debug("Using parallelism", "count", parallelism)
 	sem := semaphore.NewWeighted(int64(parallelism))
 	g, ctx := errgroup.WithContext(ctx) // エラーが発生したら他のゴルーチンもキャンセル
 
 	// アクティブなファイルとURLのセット (Prune用)
-	activeFiles := make(map[lock.FileID]map[lock.ResolvedURL]struct{})
+	activeFiles := make(map[model.FileID]map[model.ResolvedURL]struct{})
 	var activeFilesMu sync.Mutex // activeFiles へのアクセス保護
 
-	// 設定ファイルの各ファイルを処理
-	for fileID, fileDef := range cfg.Files {
-		// ループ変数をキャプチャ
-		fileID := fileID
-		fileDef := fileDef
-
-		if len(fileDef.Platforms) > 0 && len(fileDef.Architectures) > 0 {
-			// プラットフォーム/アーキテクチャ指定がある場合
-			for pID, pVal := range fileDef.Platforms {
-				for aID, aVal := range fileDef.Architectures {
-					// ループ変数をキャプチャ
-					pID := pID
-					pVal := pVal
-					aID := aID
-					aVal := aVal
-
-					g.Go(func() error {
-						if err := sem.Acquire(ctx, 1); err != nil {
-							return err // Context cancelled or semaphore closed
-						}
-						defer sem.Release(1)
-
-						// URL 解決
-						overrideKey := pID + "/" + aID
-						urlTemplate := fileDef.URL
-						if overrideDef, ok := fileDef.Overrides[overrideKey]; ok && overrideDef.URL != "" {
-							urlTemplate = overrideDef.URL
-						}
-						tmplData := template.TemplateData{
-							Version:      fileDef.Version,
-							Platform:     pVal,
-							Architecture: aVal,
-						}
-						resolvedURL, err := template.ResolveURL(urlTemplate, tmplData)
-						if err != nil {
-							logger.Error("Failed to resolve URL template", "file_id", fileID, "platform", pID, "arch", aID, "error", err)
-							return fmt.Errorf("failed to resolve URL for %s (%s/%s): %w", fileID, pID, aID, err) // エラーを返し、errgroup を停止
-						}
-						logger.Debug("Resolved URL", "file_id", fileID, "platform", pID, "arch", aID, "url", resolvedURL)
-
-						// アクティブな URL として記録
-						activeFilesMu.Lock()
-						if _, ok := activeFiles[fileID]; !ok {
-							activeFiles[fileID] = make(map[model.ResolvedURL]struct{})
-						}
-						activeFiles[fileID][resolvedURL] = struct{}{}
-						activeFilesMu.Unlock()
-
-						// ダウンロードしてハッシュ計算
-						hashAlgo := cfg.GetEffectiveHashAlgorithm(fileID, pID, aID)
-						hash, err := downloader.Hash(resolvedURL, hashAlgo)
-						if err != nil {
-							logger.Error("Failed to download or hash", "file_id", fileID, "platform", pID, "arch", aID, "url", resolvedURL, "error", err)
-							// ダウンロード失敗は lock コマンドではエラーにする (URLが間違っている可能性)
-							return fmt.Errorf("failed download/hash for %s (%s/%s) URL %s: %w", fileID, pID, aID, resolvedURL, err)
-						}
-
-						// 新しい Lock データに設定 (既存チェック含む)
-						// SetHash はスレッドセーフにする必要がある
-						err = newLock.SetHash(fileID, resolvedURL, hash)
-						if err != nil {
-							logger.Error("Hash inconsistency detected", "file_id", fileID, "platform", pID, "arch", aID, "url", resolvedURL, "error", err)
-							// ハッシュ不整合は致命的エラー
-							return fmt.Errorf("hash inconsistency for %s (%s/%s) URL %s: %w", fileID, pID, aID, resolvedURL, err)
-						}
-						logger.Info("Processed", "file_id", fileID, "platform", pID, "arch", aID, "url", resolvedURL, "hash", hash)
-
-						return nil
-					})
-				}
+	var checksums checksumsCache // checksums_url のチェックサムファイル (バリアント間で共有する)
+
+	// --keep-going の場合は失敗したファイルIDを記録し、他のゴルーチンをキャンセルしない
+	failedFiles := make(map[model.FileID]error)
+	var failedFilesMu sync.Mutex
+	// 記録済みのハッシュ値と一致しなかったバリアント (他のゴルーチンをキャンセルせず、最後にまとめて報告する)
+	var mismatches []*lock.InconsistencyError
+	var mismatchesMu sync.Mutex
+	goVariant := func(fileID model.FileID, fn func() error) {
+		g.Go(func() error {
+			err := fn()
+			if err == nil || !lockKeepGoing {
+				return err
 			}
-		} else {
-			// プラットフォーム/アーキテクチャ指定がない場合
-			g.Go(func() error {
+			failedFilesMu.Lock()
+			if _, ok := failedFiles[fileID]; !ok {
+				failedFiles[fileID] = err
+			}
+			failedFilesMu.Unlock()
+			return nil
+		})
+	}
+
+	// 設定ファイルの各ファイルの全バリアントを処理
+	for fileID := range cfg.Files {
+		targets, err := cfg.FileTargets(fileID)
+		if err != nil {
+			logger.Error("Failed to resolve file", "file_id", fileID, "error", err)
+			results.Add(report.FileResult{FileID: fileID}.Fail(err))
+			goVariant(fileID, func() error { return err }) // --keep-going の場合は他のファイルの処理を続ける
+			continue
+		}
+		for _, target := range targets {
+			goVariant(fileID, func() error {
 				if err := sem.Acquire(ctx, 1); err != nil {
-					return err
+					return err // Context cancelled or semaphore closed
 				}
 				defer sem.Release(1)
-
-				// URL 解決 (バージョンのみ)
-				tmplData := template.TemplateData{Version: fileDef.Version}
-				resolvedURL, err := template.ResolveURL(fileDef.URL, tmplData)
-				if err != nil {
-					logger.Error("Failed to resolve URL template", "file_id", fileID, "error", err)
-					return fmt.Errorf("failed to resolve URL for %s: %w", fileID, err)
+				res := targetResult(target)
+				res.Path = "" // lock はダウンロード先を使わない
+				h, err := lockTarget(downloader, &checksums, newLock, target, activeFiles, &activeFilesMu, lockOnMismatch)
+				var mismatch *lock.InconsistencyError
+				if errors.As(err, &mismatch) {
+					mismatchesMu.Lock()
+					mismatches = append(mismatches, mismatch)
+					mismatchesMu.Unlock()
+					res.Status, res.Detail = report.StatusMismatch, fmt.Sprintf("locked %s, got %s", mismatch.Existing, mismatch.New)
+					results.Add(res)
+					return nil
 				}
-				logger.Debug("Resolved URL", "file_id", fileID, "url", resolvedURL)
-
-				// アクティブな URL として記録
-				activeFilesMu.Lock()
-				if _, ok := activeFiles[fileID]; !ok {
-					activeFiles[fileID] = make(map[model.ResolvedURL]struct{})
-				}
-				activeFiles[fileID][resolvedURL] = struct{}{}
-				activeFilesMu.Unlock()
-
-				// ダウンロードしてハッシュ計算
-				hashAlgo := cfg.GetEffectiveHashAlgorithm(fileID, "", "")
-				hash, err := downloader.Hash(resolvedURL, hashAlgo)
 				if err != nil {
-					logger.Error("Failed to download or hash", "file_id", fileID, "url", resolvedURL, "error", err)
-					return fmt.Errorf("failed download/hash for %s URL %s: %w", fileID, resolvedURL, err)
+					results.Add(res.Fail(err))
+					return err
 				}
-
-				// 新しい Lock データに設定
-				err = newLock.SetHash(fileID, resolvedURL, hash)
-				if err != nil {
-					logger.Error("Hash inconsistency detected", "file_id", fileID, "url", resolvedURL, "error", err)
-					return fmt.Errorf("hash inconsistency for %s URL %s: %w", fileID, resolvedURL, err)
+				res.Hash, res.Status = h, report.StatusOK
+				if old, err := existingLock.GetHash(target.FileID, target.URL, h.Algorithm); err == nil && !old.Equal(h) {
+					res.Detail = fmt.Sprintf("replaced %s", old) // --on-mismatch update
 				}
-				logger.Info("Processed", "file_id", fileID, "url", resolvedURL, "hash", hash)
-
+				results.Add(res)
 				return nil
 			})
 		}
@@ -206,6 +313,22 @@ func runLock(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("lock command failed: %w", err)
 	}
 
+	if len(mismatches) > 0 {
+		sort.Slice(mismatches, func(i, j int) bool {
+			if mismatches[i].FileID != mismatches[j].FileID {
+				return mismatches[i].FileID < mismatches[j].FileID
+			}
+			return mismatches[i].URL < mismatches[j].URL
+		})
+		for _, m := range mismatches {
+			logger.Warn("Hash changed since it was locked", "file_id", m.FileID, "url", m.URL, "locked", m.Existing, "got", m.New)
+		}
+		if lockOnMismatch == mismatchFail {
+			return fmt.Errorf("%d file(s) no longer match the locked hash; lock file was not changed (if the new content is legitimate, run 'dltofu update <file-id>' or use --on-mismatch=update)", len(mismatches))
+		}
+		logger.Warn("Kept the locked hashes of changed files", "count", len(mismatches))
+	}
+
 	// 新しいロックデータに既存のロックファイルの情報をマージする (新規エントリのみ)
 	// SetHash 内でチェックしているので、明示的なマージは不要か？
 	// -> SetHash がエラーを返すので、この時点で newLock は一貫性のある状態のはず。
@@ -213,20 +336,663 @@ func runLock(cmd *cobra.Command, args []string) error {
 	// 既存のロックファイルから、設定ファイルに存在しないエントリを削除 (Prune)
 	// SetHash でチェックしているので、newLock に古いエントリは含まれないはずだが、
 	// 念のため Prune を実行する。
+	// --only/--exclude で対象を絞った場合、対象外のファイルIDのエントリはそのまま残す
+	// 失敗したファイルIDのエントリも既存のものを残す
+	if filtered || len(failedFiles) > 0 {
+		for fileID, urls := range existingLock.Files {
+			_, selected := cfg.Files[fileID]
+			_, failed := failedFiles[fileID]
+			if selected && !failed {
+				continue
+			}
+			activeFiles[fileID] = make(map[model.ResolvedURL]struct{})
+			for url := range urls {
+				activeFiles[fileID][url] = struct{}{}
+			}
+		}
+	}
+	// 設定の description を Lock ファイルに反映する (失敗したファイルIDは既存の記録を残す)
+	for fileID, fileDef := range cfg.Files {
+		if _, failed := failedFiles[fileID]; !failed {
+			newLock.SetDescription(fileID, fileDef.Description)
+		}
+	}
 	newLock.Prune(activeFiles)
 
+	// 同じ URL を記録した複数のファイルIDの間の矛盾 (設定のコピー&ペーストのミスなど) を確認する。
+	// --keep-going で一部のファイルが失敗した場合も、結果を書き出す前に確認する
+	if conflicts := newLock.URLConflicts(); len(conflicts) > 0 {
+		for _, c := range conflicts {
+			logger.Warn("Same URL is locked inconsistently by multiple file IDs", "url", c.URL, "file_ids", c.FileIDs, "reason", c.Reason)
+		}
+		if strict {
+			return fmt.Errorf("%d URL(s) are locked inconsistently by multiple file IDs; lock file was not changed (first: %s)", len(conflicts), conflicts[0])
+		}
+	}
+
+	if len(failedFiles) > 0 {
+		return saveIncompleteLock(newLock, lockPath, failedFiles)
+	}
+
 	// 古いロックファイルと新しいロックファイルを比較し、変更があったか確認
-	if reflect.DeepEqual(existingLock.Files, newLock.Files) {
+	// 古い形式から変換して読み込んだ場合や保存形式を切り替えた場合は内容が同じでも保存し直す
+	// Validators (ETag/Last-Modified) が変わった場合は次回の条件付きリクエストに使うため保存する。
+	// 記録日時 (first_seen/locked_at) だけが変わった場合は、Lock ファイルの差分を生まないよう保存しない
+	if !existingLock.Migrated() && existingLock.Dedup() == newLock.Dedup() && existingLock.Signed() == newLock.Signed() &&
+		existingLock.SameContent(newLock) && existingLock.SameValidators(newLock) {
 		logger.Info("Lock file is already up to date.")
 		return nil
 	}
 
 	// 新しいLockファイルを保存
-	err = newLock.Save(configDir)
+	err = newLock.Save(lockPath)
 	if err != nil {
 		return fmt.Errorf("failed to save lock file: %w", err)
 	}
+	removeStalePartialLock(lockPath)
 
 	logger.Info("Lock command finished successfully")
 	return nil
 }
+
+// checkLock は Lock ファイルが設定ファイルと一致しているかをダウンロードせずに確認する。
+// 設定ファイルから解決した URL (パッチのベースとパッチを含む) に有効なアルゴリズムのハッシュ値が記録されていなければ missing、
+// 設定ファイルから解決されない URL が記録されていれば orphaned として報告する。
+// filtered (--only/--exclude で対象を絞った) の場合、対象外のファイルIDのエントリは確認しない。
+func checkLock(cfg *config.Config, lockFile *lock.LockFile, filtered bool, results *report.Collector) error {
+	targets, err := cfg.TargetMatrix()
+	if err != nil {
+		return err
+	}
+
+	expected := make(map[model.FileID]map[model.ResolvedURL]struct{})
+	missing := 0
+	check := func(target config.Target, url model.ResolvedURL) {
+		if _, ok := expected[target.FileID]; !ok {
+			expected[target.FileID] = make(map[model.ResolvedURL]struct{})
+		}
+		expected[target.FileID][url] = struct{}{}
+
+		res := targetResult(target)
+		res.URL, res.Path = url, "" // lock はダウンロード先を使わない
+		h, err := lockFile.GetHash(target.FileID, url, target.HashAlgorithm)
+		if err != nil {
+			missing++
+			res.Status, res.Detail = report.StatusMissing, err.Error()
+		} else {
+			res.Hash, res.Status = h, report.StatusOK
+		}
+		results.Add(res)
+	}
+	for _, target := range targets {
+		urls, err := lockedURLs(target)
+		if err != nil {
+			return err
+		}
+		for _, url := range urls {
+			check(target, url)
+		}
+	}
+
+	orphaned := 0
+	for _, fileID := range slices.Sorted(maps.Keys(lockFile.Files)) {
+		if _, selected := cfg.Files[fileID]; !selected && filtered {
+			continue
+		}
+		for _, url := range slices.Sorted(maps.Keys(lockFile.Files[fileID])) {
+			if _, ok := expected[fileID][url]; ok {
+				continue
+			}
+			orphaned++
+			results.Add(report.FileResult{FileID: fileID, URL: url, Status: report.StatusOrphaned, Detail: "not resolved from the configuration"})
+		}
+	}
+
+	// --output json の場合は writeResult が出力する
+	if outputFormat == outputText && missing+orphaned > 0 {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "FILE ID\tSTATUS\tURL")
+		for _, r := range results.Files() {
+			if r.Failed() {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", r.FileID, strings.ToUpper(r.Status), r.URL)
+			}
+		}
+		w.Flush()
+	}
+
+	if missing+orphaned > 0 {
+		return fmt.Errorf("lock file is out of sync with the configuration: %d missing, %d orphaned (run dltofu lock to update it)", missing, orphaned)
+	}
+	logger.Info("Lock file is in sync with the configuration", "urls", len(results.Files()))
+	return nil
+}
+
+// lockedURLs はバリアントについて Lock ファイルに記録される URL (url とパッチのベース、パッチ) を返す
+func lockedURLs(target config.Target) ([]model.ResolvedURL, error) {
+	urls := []model.ResolvedURL{target.URL}
+	if target.Def.PatchFrom != nil {
+		baseURL, patchURL, err := resolvePatchURLs(target.Def.PatchFrom, target.Data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve patch URLs for %s: %w", target, err)
+		}
+		urls = append(urls, baseURL, patchURL)
+	}
+	return urls, nil
+}
+
+// pruneLock は lock --prune-only の処理。ダウンロードせずに、設定から解決される URL 以外のエントリを Lock ファイルから削除する。
+// filtered の場合、選択されていないファイルIDのエントリはそのまま残す。
+func pruneLock(cfg *config.Config, lockFile *lock.LockFile, lockPath string, filtered bool, results *report.Collector) error {
+	targets, err := cfg.TargetMatrix()
+	if err != nil {
+		return err
+	}
+	activeFiles := make(map[model.FileID]map[model.ResolvedURL]struct{})
+	for _, target := range targets {
+		urls, err := lockedURLs(target)
+		if err != nil {
+			return err
+		}
+		if activeFiles[target.FileID] == nil {
+			activeFiles[target.FileID] = make(map[model.ResolvedURL]struct{})
+		}
+		for _, url := range urls {
+			activeFiles[target.FileID][url] = struct{}{}
+		}
+	}
+
+	pruned := 0
+	for _, fileID := range slices.Sorted(maps.Keys(lockFile.Files)) {
+		if _, selected := cfg.Files[fileID]; !selected && filtered {
+			activeFiles[fileID] = make(map[model.ResolvedURL]struct{})
+			for url := range lockFile.Files[fileID] {
+				activeFiles[fileID][url] = struct{}{}
+			}
+			continue
+		}
+		for _, url := range slices.Sorted(maps.Keys(lockFile.Files[fileID])) {
+			if _, ok := activeFiles[fileID][url]; ok {
+				continue
+			}
+			pruned++
+			logger.Info("Pruning lock entry", "file_id", fileID, "url", url)
+			results.Add(report.FileResult{FileID: fileID, URL: url, Status: report.StatusPruned, Detail: "not resolved from the configuration"})
+		}
+	}
+
+	if pruned == 0 {
+		logger.Info("No lock entries to prune; lock file is not rewritten")
+		return nil
+	}
+	lockFile.Prune(activeFiles)
+	if err := lockFile.Save(lockPath); err != nil {
+		return fmt.Errorf("failed to save lock file: %w", err)
+	}
+	logger.Info("Pruned lock file", "entries", pruned, "path", lockPath)
+	return nil
+}
+
+// lockTarget は1つのバリアントをダウンロードしてハッシュ値を計算し、新しい Lock データに設定して、記録したハッシュ値を返す。
+// ハッシュ値が既存の記録と異なる場合 (TOFU の前提が崩れた場合)、onMismatch が mismatchUpdate であれば記録し直し、
+// それ以外の場合は *lock.InconsistencyError を含むエラーを返す (既存の記録は変更しない)。
+func lockTarget(downloader *download.Downloader, checksums *checksumsCache, newLock *lock.LockFile, target config.Target, activeFiles map[model.FileID]map[model.ResolvedURL]struct{}, activeFilesMu *sync.Mutex, onMismatch string) (*hash.Hash, error) {
+	fileID, resolvedURL := target.FileID, target.URL
+	logger.Debug("Resolved URL", "target", target, "url", resolvedURL)
+
+	// アクティブな URL として記録
+	activeFilesMu.Lock()
+	if _, ok := activeFiles[fileID]; !ok {
+		activeFiles[fileID] = make(map[model.ResolvedURL]struct{})
+	}
+	activeFiles[fileID][resolvedURL] = struct{}{}
+	activeFilesMu.Unlock()
+
+	// ダウンロードしてハッシュ計算
+	fileDL, err := fileDownloader(downloader, target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve headers for %s: %w", target, err)
+	}
+	result, err := hashForLock(fileDL, checksums, target, newLock.CopyEntry(fileID, resolvedURL), download.Validators(newLock.GetValidators(fileID, resolvedURL)))
+	if err != nil {
+		logger.Error("Failed to download or hash", "target", target, "url", resolvedURL, "error", err)
+		// ダウンロード失敗は lock コマンドではエラーにする (URLが間違っている可能性)
+		return nil, fmt.Errorf("failed download/hash for %s URL %s: %w", target, resolvedURL, err)
+	}
+
+	// 新しい Lock データに設定 (既存チェック含む)
+	err = recordLockResult(newLock, target, result, activeFiles, activeFilesMu)
+	var mismatch *lock.InconsistencyError
+	if errors.As(err, &mismatch) && onMismatch == mismatchUpdate {
+		logger.Warn("Replacing locked hash", "target", target, "url", mismatch.URL, "old", mismatch.Existing, "new", mismatch.New)
+		newLock.RemoveURL(fileID, resolvedURL)
+		for url := range result.extra {
+			newLock.RemoveURL(fileID, url)
+		}
+		err = recordLockResult(newLock, target, result, activeFiles, activeFilesMu)
+	}
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Processed", "target", target, "url", resolvedURL, "hash", result.hash)
+	return result.hash, nil
+}
+
+// recordLockResult は lockTarget の結果を新しい Lock データに設定する。
+// ハッシュ値が既存の記録と異なる場合は *lock.InconsistencyError を含むエラーを返す。
+func recordLockResult(newLock *lock.LockFile, target config.Target, result *lockResult, activeFiles map[model.FileID]map[model.ResolvedURL]struct{}, activeFilesMu *sync.Mutex) error {
+	fileID, resolvedURL := target.FileID, target.URL
+	if err := newLock.SetHash(fileID, resolvedURL, result.hash); err != nil {
+		return fmt.Errorf("hash inconsistency for %s URL %s: %w", target, resolvedURL, err)
+	}
+	if result.size > 0 {
+		if err := newLock.SetSize(fileID, resolvedURL, result.size); err != nil {
+			logger.Error("Size inconsistency detected", "target", target, "url", resolvedURL, "error", err)
+			return err
+		}
+	}
+	if err := newLock.SetValidators(fileID, resolvedURL, lock.Validators(result.validators)); err != nil {
+		return err
+	}
+	if err := recordExtraHashes(newLock, fileID, result.extra, activeFiles, activeFilesMu); err != nil {
+		return err
+	}
+	if tree := result.tree; tree != nil {
+		// 以前の形式 (umask に依存するパーミッションを含む) で記録された TreeHash は、同じ展開結果であれば現在の形式に置き換える
+		if newLock.UpgradeTreeHash(fileID, resolvedURL, tree.Legacy, tree.Tree) {
+			logger.Info("Upgraded tree hash to the permission-independent format", "target", target, "url", resolvedURL, "tree_hash", tree.Tree)
+		}
+		if err := newLock.SetTreeHash(fileID, resolvedURL, tree.Tree); err != nil {
+			logger.Error("Tree hash inconsistency detected", "target", target, "url", resolvedURL, "error", err)
+			return fmt.Errorf("tree hash inconsistency for %s URL %s: %w", target, resolvedURL, err)
+		}
+		if err := newLock.SetMemberHashes(fileID, resolvedURL, tree.Members); err != nil {
+			logger.Error("Member hash inconsistency detected", "target", target, "url", resolvedURL, "error", err)
+			return fmt.Errorf("member hash inconsistency for %s URL %s: %w", target, resolvedURL, err)
+		}
+	}
+	if chunks := result.chunks; chunks != nil {
+		if err := newLock.SetChunkHashes(fileID, resolvedURL, chunks); err != nil {
+			logger.Error("Chunk hash inconsistency detected", "target", target, "url", resolvedURL, "error", err)
+			return fmt.Errorf("chunk hash inconsistency for %s URL %s: %w", target, resolvedURL, err)
+		}
+	}
+	return nil
+}
+
+// saveIncompleteLock は --keep-going で一部のファイルが失敗した場合に結果を保存し、失敗を表すエラーを返す。
+// --write-only-if-complete の場合は Lock ファイルを上書きせず、Lock ファイル名に .partial を付けたファイルに書き出す。
+func saveIncompleteLock(newLock *lock.LockFile, lockPath string, failedFiles map[model.FileID]error) error {
+	failedIDs := slices.Sorted(maps.Keys(failedFiles)) // 各ファイルのエラーは writeResult がまとめて報告する
+
+	if lockWriteComplete {
+		partialPath := lockPath + lock.PartialSuffix
+		if err := newLock.SaveAs(partialPath); err != nil {
+			return fmt.Errorf("failed to save partial lock file: %w", err)
+		}
+		logger.Warn("Lock file left unchanged because some files failed; partial result written for inspection", "partial", partialPath, "failed", len(failedIDs))
+		return fmt.Errorf("lock command failed for %d file(s): %v", len(failedIDs), failedIDs)
+	}
+
+	if err := newLock.Save(lockPath); err != nil {
+		return fmt.Errorf("failed to save lock file: %w", err)
+	}
+	logger.Warn("Lock file saved with the previous entries of failed files", "failed", len(failedIDs))
+	return fmt.Errorf("lock command failed for %d file(s): %v", len(failedIDs), failedIDs)
+}
+
+// removeStalePartialLock は以前の実行で書き出された .partial ファイルを削除する
+func removeStalePartialLock(lockPath string) {
+	partialPath := lockPath + lock.PartialSuffix
+	if err := os.Remove(partialPath); err == nil {
+		logger.Info("Removed stale partial lock file", "path", partialPath)
+	} else if !os.IsNotExist(err) {
+		logger.Warn("Failed to remove stale partial lock file", "path", partialPath, "error", err)
+	}
+}
+
+// lockResult は1つのバリアントについて Lock ファイルに記録する内容
+type lockResult struct {
+	hash   *hash.Hash                       // resolvedURL のハッシュ値
+	size   int64                            // resolvedURL のバイト数 (ダウンロードしていない場合は 0)
+	tree   *archive.TreeHashes              // アーカイブ展開結果の TreeHash と各ファイルのハッシュ値 (--tree-hash 指定時のみ)
+	extra  map[model.ResolvedURL]*hash.Hash // パッチのベースなど、resolvedURL 以外に記録するハッシュ値
+	chunks *hash.ChunkHashes                // resolvedURL のチャンクハッシュ (chunk_size 指定時のみ)
+	// validators は次回の条件付きリクエストに使う resolvedURL のレスポンスの ETag/Last-Modified (ダウンロードしていない場合は空)
+	validators download.Validators
+}
+
+// recordExtraHashes は lockResult.extra のハッシュ値を Lock データに設定し、アクティブな URL として記録する
+func recordExtraHashes(newLock *lock.LockFile, fileID model.FileID, extra map[model.ResolvedURL]*hash.Hash, activeFiles map[model.FileID]map[model.ResolvedURL]struct{}, mu *sync.Mutex) error {
+	for url, h := range extra {
+		mu.Lock()
+		if _, ok := activeFiles[fileID]; !ok {
+			activeFiles[fileID] = make(map[model.ResolvedURL]struct{})
+		}
+		activeFiles[fileID][url] = struct{}{}
+		mu.Unlock()
+
+		if err := newLock.SetHash(fileID, url, h); err != nil {
+			return fmt.Errorf("hash inconsistency for %s URL %s: %w", fileID, url, err)
+		}
+	}
+	return nil
+}
+
+// hashForLock はファイルをダウンロードしてハッシュ値を計算する。
+// 分割ファイルの場合は各パートを連結した内容のハッシュ値を計算する。
+// パッチ指定の場合はベースとパッチのハッシュ値を extra に含め、適用結果のハッシュ値を返す。
+// --tree-hash が指定されたアーカイブの場合は一時ファイルに保存して展開し、TreeHash と各ファイルのハッシュ値も合わせて返す。
+// checksums_url が指定されている場合は公開されたチェックサムを使い、ファイルの内容が必要な場合のみダウンロードして照合する。
+// 単一のファイルをそのままハッシュする場合は previous (既存のエントリ) について記録された validators で条件付きリクエストを送る。
+func hashForLock(downloader *download.Downloader, checksums *checksumsCache, target config.Target, previous *lock.Entry, validators download.Validators) (*lockResult, error) {
+	fileID, fileDef, url, algorithm := target.FileID, target.Def, target.URL, target.HashAlgorithm
+	if fileDef.PatchFrom != nil {
+		return hashPatchedForLock(downloader, target)
+	}
+
+	var published *hash.Hash
+	if fileDef.ChecksumsURL != "" {
+		var err error
+		published, err = publishedHash(downloader, checksums, target)
+		if err != nil {
+			return nil, err
+		}
+		if fileDef.ChunkSize == 0 && (!lockTreeHash || !fileDef.IsArchive) {
+			logger.Debug("Using published checksum", "file_id", fileID, "url", url, "hash", published)
+			return &lockResult{hash: published}, nil
+		}
+	}
+	// checkPublished はダウンロードして計算したハッシュ値が公開されたチェックサムと一致するか確認する
+	checkPublished := func(fileHash *hash.Hash) error {
+		if published != nil && !fileHash.Equal(published) {
+			return fmt.Errorf("hash mismatch with checksums file: published %s, got %s", published, fileHash)
+		}
+		return nil
+	}
+
+	partURLs, err := resolveParts(fileDef.Parts, target.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	// chunk_size 指定時はダウンロード中のストリームからチャンクハッシュも計算する
+	var chunkHasher *hash.ChunkHasher
+	if fileDef.ChunkSize > 0 {
+		chunkHasher, err = newLockChunkHasher(fileID, url, algorithm, fileDef.ChunkSize)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Lock ファイルに記録するバイト数はダウンロードした内容から数える
+	var size countingWriter
+	fetch := func(w io.Writer) (*hash.Hash, error) {
+		if w == nil {
+			w = &size
+		} else {
+			w = io.MultiWriter(w, &size)
+		}
+		if len(partURLs) > 0 {
+			return downloader.FetchPartsAndHash(partURLs, algorithm, w)
+		}
+		return downloader.FetchAndHash(url, algorithm, w)
+	}
+
+	if chunkHasher == nil && len(partURLs) == 0 && (!lockTreeHash || !fileDef.IsArchive) {
+		return hashIfModified(downloader, target, previous, validators)
+	}
+	if !lockTreeHash || !fileDef.IsArchive {
+		var w io.Writer
+		if chunkHasher != nil {
+			w = chunkHasher
+		}
+		fileHash, err := fetch(w)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkPublished(fileHash); err != nil {
+			return nil, err
+		}
+		return newLockResult(fileHash, int64(size), chunkHasher)
+	}
+
+	tmpFile, err := createArchiveTemp(fileID, url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file for archive: %w", err)
+	}
+	tmpPath := tmpFile.Name()
+	defer removeArchiveTemp(tmpPath)
+
+	var w io.Writer = tmpFile
+	if chunkHasher != nil {
+		w = io.MultiWriter(tmpFile, chunkHasher)
+	}
+	fileHash, err := fetch(w)
+	tmpFile.Close()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPublished(fileHash); err != nil {
+		return nil, err
+	}
+
+	result, err := newLockResult(fileHash, int64(size), chunkHasher)
+	if err != nil {
+		return nil, err
+	}
+	result.tree, err = treeHashForLock(target, tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// checksumsCache は lock 中にダウンロードしたチェックサムファイルを URL ごとに保持する。
+// マトリクスの各バリアントが同じチェックサムファイルを参照する場合も1度だけダウンロードする。
+type checksumsCache struct {
+	mu      sync.Mutex
+	entries map[model.ResolvedURL]*checksumsEntry
+}
+
+type checksumsEntry struct {
+	once      sync.Once
+	checksums *hash.Checksums
+	err       error
+}
+
+// get は url のチェックサムファイルを (未取得であれば) ダウンロードして解析する
+func (c *checksumsCache) get(downloader *download.Downloader, url model.ResolvedURL) (*hash.Checksums, error) {
+	c.mu.Lock()
+	if c.entries == nil {
+		c.entries = make(map[model.ResolvedURL]*checksumsEntry)
+	}
+	entry, ok := c.entries[url]
+	if !ok {
+		entry = &checksumsEntry{}
+		c.entries[url] = entry
+	}
+	c.mu.Unlock()
+
+	entry.once.Do(func() {
+		logger.Debug("Downloading checksums file", "url", url)
+		var buf bytes.Buffer
+		if _, err := downloader.FetchAndHash(url, hash.AlgoSHA256, &buf); err != nil {
+			entry.err = fmt.Errorf("failed to download checksums file %s: %w", url, err)
+			return
+		}
+		entry.checksums, entry.err = hash.ParseChecksums(&buf)
+		if entry.err != nil {
+			entry.err = fmt.Errorf("failed to parse checksums file %s: %w", url, entry.err)
+		}
+	})
+	return entry.checksums, entry.err
+}
+
+// publishedHash は checksums_url のチェックサムファイルから、URL の最後の要素をファイル名としてハッシュ値を探す
+func publishedHash(downloader *download.Downloader, checksums *checksumsCache, target config.Target) (*hash.Hash, error) {
+	checksumsURL, err := template.ResolveURL(target.Def.ChecksumsURL, target.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve checksums URL: %w", err)
+	}
+	sums, err := checksums.get(downloader, checksumsURL)
+	if err != nil {
+		return nil, err
+	}
+	u, err := neturl.Parse(string(target.URL))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse URL %s: %w", target.URL, err)
+	}
+	h, err := sums.Lookup(path.Base(u.Path), target.HashAlgorithm)
+	if err != nil {
+		return nil, fmt.Errorf("checksums file %s: %w", checksumsURL, err)
+	}
+	return h, nil
+}
+
+// newLockChunkHasher はチャンクごとに進捗をログ出力する ChunkHasher を作成する
+func newLockChunkHasher(fileID model.FileID, url model.ResolvedURL, algorithm hash.HashAlgorithm, chunkSize int64) (*hash.ChunkHasher, error) {
+	return hash.NewChunkHasher(algorithm, chunkSize, func(index int, h *hash.Hash) {
+		logger.Debug("Hashed chunk", "file_id", fileID, "url", url, "chunk", index, "offset", int64(index)*chunkSize, "hash", h)
+	})
+}
+
+// countingWriter は書き込まれたバイト数を数える io.Writer
+type countingWriter int64
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	*c += countingWriter(len(p))
+	return len(p), nil
+}
+
+// newLockResult はファイルのハッシュ値、バイト数と (あれば) チャンクハッシュから lockResult を作成する
+func newLockResult(fileHash *hash.Hash, size int64, chunkHasher *hash.ChunkHasher) (*lockResult, error) {
+	result := &lockResult{hash: fileHash, size: size}
+	if chunkHasher != nil {
+		chunks, err := chunkHasher.Sum()
+		if err != nil {
+			return nil, err
+		}
+		result.chunks = chunks
+	}
+	return result, nil
+}
+
+// hashIfModified は previous について記録された ETag/Last-Modified (validators) で条件付きリクエストを送り、ダウンロードしてハッシュ値を計算する。
+// サーバーが 304 を返した場合 (前回から変更がない場合) はダウンロードせずに previous のハッシュ値とバイト数を使う。
+// 内容が変わっていればサーバーは 304 を返さないため、ダウンロードしてハッシュ値を計算し直すことになる。
+// previous がない場合や設定されたアルゴリズムのハッシュ値が記録されていない場合は通常のリクエストとなる。
+func hashIfModified(downloader *download.Downloader, target config.Target, previous *lock.Entry, validators download.Validators) (*lockResult, error) {
+	var previousHash *hash.Hash
+	if previous != nil {
+		previousHash = previous.Hash(target.HashAlgorithm)
+	}
+	if previousHash == nil {
+		validators = download.Validators{}
+	}
+	// download と同じ条件付きダウンロードを使う。内容はディスクに保存せず、バイト数だけを数える
+	var size countingWriter
+	fileHash, respValidators, err := downloader.FetchIfModified(target.URL, target.HashAlgorithm, &size, validators)
+	if errors.Is(err, download.ErrNotModified) {
+		logger.Info("Not modified since the last lock; reusing the locked hash", "target", target, "url", target.URL)
+		return &lockResult{hash: previousHash, size: previous.Size, validators: validators}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &lockResult{hash: fileHash, size: int64(size), validators: respValidators}, nil
+}
+
+// hashPatchedForLock はベースとパッチをダウンロードしてパッチを適用し、それぞれのハッシュ値を計算する
+func hashPatchedForLock(downloader *download.Downloader, target config.Target) (*lockResult, error) {
+	fileID, fileDef, url, algorithm := target.FileID, target.Def, target.URL, target.HashAlgorithm
+	baseURL, patchURL, err := resolvePatchURLs(fileDef.PatchFrom, target.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpDir, err := os.MkdirTemp("", "dltofu-patch-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	fetch := func(u model.ResolvedURL, dest string) (*hash.Hash, error) {
+		f, err := os.Create(dest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create temporary file %s: %w", dest, err)
+		}
+		defer f.Close()
+		return downloader.FetchAndHash(u, algorithm, f)
+	}
+	basePath := filepath.Join(tmpDir, "base")
+	baseHash, err := fetch(baseURL, basePath)
+	if err != nil {
+		return nil, err
+	}
+	patchPath := filepath.Join(tmpDir, "patch")
+	patchHash, err := fetch(patchURL, patchPath)
+	if err != nil {
+		return nil, err
+	}
+
+	resultPath := filepath.Join(tmpDir, path.Base(string(url)))
+	f, err := os.Create(resultPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file %s: %w", resultPath, err)
+	}
+	if err := patch.ApplyFile(basePath, patchPath, f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to rewind patched file %s: %w", resultPath, err)
+	}
+	var chunkHasher *hash.ChunkHasher
+	var w io.Writer = io.Discard
+	if fileDef.ChunkSize > 0 {
+		chunkHasher, err = newLockChunkHasher(fileID, url, algorithm, fileDef.ChunkSize)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		w = chunkHasher
+	}
+	var size countingWriter
+	resultHash, err := hash.CalculateStreamTee(f, io.MultiWriter(w, &size), algorithm)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("Applied patch", "file_id", fileID, "base", baseURL, "patch", patchURL, "result_hash", resultHash)
+
+	result, err := newLockResult(resultHash, int64(size), chunkHasher)
+	if err != nil {
+		return nil, err
+	}
+	result.extra = map[model.ResolvedURL]*hash.Hash{baseURL: baseHash, patchURL: patchHash}
+	if lockTreeHash && fileDef.IsArchive {
+		result.tree, err = treeHashForLock(target, resultPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// treeHashForLock はダウンロード済みのアーカイブを展開して TreeHash と各ファイルのハッシュ値を計算する
+func treeHashForLock(target config.Target, archivePath string) (*archive.TreeHashes, error) {
+	fileDef := target.Def
+	extractor, err := fileExtractor(fileDef, archivePath)
+	if err != nil {
+		return nil, err
+	}
+	extractPaths := fileDef.GetEffectiveExtractPaths(target.PlatformID, target.ArchID, target.ArchVariant)
+	extractor = archive.WithExclude(extractor, fileDef.GetEffectiveExtractExclude(target.PlatformID, target.ArchID, target.ArchVariant))
+	extractor = archive.WithRename(extractor, target.Rename)
+	tree, err := archive.ExtractTreeHashes(extractor, archivePath, fileDef.StripComponents, extractPaths, target.HashAlgorithm, logger)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("Calculated tree hash", "file_id", target.FileID, "url", target.URL, "tree_hash", tree.Tree, "members", len(tree.Members))
+	return tree, nil
+}
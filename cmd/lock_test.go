@@ -0,0 +1,333 @@
+package cmd
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hrko/dltofu/internal/lock"
+	"github.com/hrko/dltofu/internal/model"
+)
+
+func TestCheckLockFlags(t *testing.T) {
+	tests := []struct {
+		name          string
+		check         bool
+		pruneOnly     bool
+		keepGoing     bool
+		writeComplete bool
+		parallel      int
+		onMismatch    string
+		wantErr       bool
+	}{
+		{name: "defaults", parallel: 4, onMismatch: mismatchFail},
+		{name: "keep-going alone", keepGoing: true, parallel: 4, onMismatch: mismatchFail},
+		{name: "keep-going with write-only-if-complete", keepGoing: true, writeComplete: true, parallel: 4, onMismatch: mismatchFail},
+		{name: "write-only-if-complete alone", writeComplete: true, parallel: 4, onMismatch: mismatchFail, wantErr: true},
+		{name: "check with prune-only", check: true, pruneOnly: true, parallel: 4, onMismatch: mismatchFail, wantErr: true},
+		{name: "zero parallelism", parallel: 0, onMismatch: mismatchFail, wantErr: true},
+		{name: "unknown on-mismatch", parallel: 4, onMismatch: "ignore", wantErr: true},
+	}
+	// フラグ変数はパッケージ変数なので、他のテストに影響しないよう元に戻す
+	saved := []any{lockCheck, lockPruneOnly, lockKeepGoing, lockWriteComplete, lockParallel, lockOnMismatch}
+	t.Cleanup(func() {
+		lockCheck, lockPruneOnly = saved[0].(bool), saved[1].(bool)
+		lockKeepGoing, lockWriteComplete = saved[2].(bool), saved[3].(bool)
+		lockParallel, lockOnMismatch = saved[4].(int), saved[5].(string)
+	})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lockCheck, lockPruneOnly = tt.check, tt.pruneOnly
+			lockKeepGoing, lockWriteComplete = tt.keepGoing, tt.writeComplete
+			lockParallel, lockOnMismatch = tt.parallel, tt.onMismatch
+			if err := checkLockFlags(); (err != nil) != tt.wantErr {
+				t.Errorf("checkLockFlags() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLockFilePerEnvironment(t *testing.T) {
+	srv := newContentServer(t, map[string]string{"/tool": "release 1\n"})
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "dltofu.yml")
+	cfg := "version: v1\nfiles:\n  tool:\n    url: " + srv.URL + "/tool\n    destination: tool\n"
+	if err := os.WriteFile(cfgPath, []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+	const lockName = "dltofu.${DLTOFU_ENV}.lock"
+
+	// prod は release 1、staging は release 2 で lock する
+	t.Setenv("DLTOFU_ENV", "prod")
+	if err := runCLI(t, "lock", "-c", cfgPath, "--no-progress", "--lock-file", lockName); err != nil {
+		t.Fatalf("lock prod: %v", err)
+	}
+	srv.set("/tool", "release 2\n")
+	t.Setenv("DLTOFU_ENV", "staging")
+	if err := runCLI(t, "lock", "-c", cfgPath, "--no-progress", "--lock-file", lockName); err != nil {
+		t.Fatalf("lock staging: %v", err)
+	}
+	for _, name := range []string{"dltofu.prod.lock", "dltofu.staging.lock"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s was not written: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, lock.LockFileName)); !os.IsNotExist(err) {
+		t.Errorf("the default lock file was written (error: %v)", err)
+	}
+
+	tests := []struct {
+		env      string // 空なら DLTOFU_ENV を設定しない
+		lockFile string
+		wantErr  bool
+	}{
+		{env: "staging", lockFile: lockName},                                      // サーバーの内容 (release 2) と一致する
+		{env: "prod", lockFile: lockName, wantErr: true},                          // release 1 で lock したため不一致になる
+		{env: "dev", lockFile: lockName, wantErr: true},                           // Lock ファイルがない
+		{lockFile: "dltofu.${DLTOFU_ENV:-staging}.lock"},                          // デフォルト値で staging を選ぶ
+		{lockFile: lockName, wantErr: true},                                       // 環境変数が未設定
+		{env: "staging", lockFile: "../dltofu.${DLTOFU_ENV}.lock", wantErr: true}, // 設定ファイルのディレクトリの外
+	}
+	for _, tt := range tests {
+		t.Run(tt.env+"/"+tt.lockFile, func(t *testing.T) {
+			if tt.env != "" {
+				t.Setenv("DLTOFU_ENV", tt.env)
+			} else {
+				t.Setenv("DLTOFU_ENV", "")
+				os.Unsetenv("DLTOFU_ENV")
+			}
+			os.Remove(filepath.Join(dir, "tool"))
+			err := runCLI(t, "download", "-c", cfgPath, "--no-progress", "--no-cache", "--lock-file", tt.lockFile)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("download error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			// verify も同じ Lock ファイルを読む
+			if err := runCLI(t, "verify", "-c", cfgPath, "--lock-file", tt.lockFile); err != nil {
+				t.Errorf("verify: %v", err)
+			}
+		})
+	}
+}
+
+func TestLockSavesChangedValidators(t *testing.T) {
+	tests := []struct {
+		name      string
+		before    string // 1回目の lock 時の ETag (空なら ETag を返さない)
+		after     string // 2回目の lock 時の ETag
+		wantSaved bool
+	}{
+		{name: "unchanged", before: `"v1"`, after: `"v1"`},
+		{name: "etag changed", before: `"v1"`, after: `"v2"`, wantSaved: true},
+		{name: "etag added", after: `"v1"`, wantSaved: true},
+		{name: "etag removed", before: `"v1"`, wantSaved: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var etag atomic.Value
+			etag.Store(tt.before)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				current := etag.Load().(string)
+				if current != "" {
+					w.Header().Set("ETag", current)
+					if r.Header.Get("If-None-Match") == current {
+						w.WriteHeader(http.StatusNotModified)
+						return
+					}
+				}
+				w.Write([]byte("same content\n"))
+			}))
+			defer srv.Close()
+
+			dir := t.TempDir()
+			cfgPath := filepath.Join(dir, "dltofu.yml")
+			cfg := "version: v1\nfiles:\n  tool:\n    url: " + srv.URL + "/tool\n    destination: tool\n"
+			if err := os.WriteFile(cfgPath, []byte(cfg), 0644); err != nil {
+				t.Fatal(err)
+			}
+			lockPath := filepath.Join(dir, lock.LockFileName)
+			if err := runCLI(t, "lock", "-c", cfgPath, "--no-progress"); err != nil {
+				t.Fatalf("first lock: %v", err)
+			}
+			before, err := os.ReadFile(lockPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			etag.Store(tt.after)
+			if err := runCLI(t, "lock", "-c", cfgPath, "--no-progress"); err != nil {
+				t.Fatalf("second lock: %v", err)
+			}
+			after, err := os.ReadFile(lockPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if saved := !bytes.Equal(before, after); saved != tt.wantSaved {
+				t.Errorf("lock file changed = %v, want %v\nbefore:\n%s\nafter:\n%s", saved, tt.wantSaved, before, after)
+			}
+			lockFile, err := lock.LoadLockFile(lockPath, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := lockFile.GetValidators("tool", model.ResolvedURL(srv.URL+"/tool")).ETag; got != tt.after {
+				t.Errorf("locked ETag = %q, want %q", got, tt.after)
+			}
+		})
+	}
+}
+
+func TestLockDoesNotWriteTemporaryFiles(t *testing.T) {
+	srv := newContentServer(t, map[string]string{"/tool": "tool content\n"})
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "dltofu.yml")
+	cfg := "version: v1\nfiles:\n  tool:\n    url: " + srv.URL + "/tool\n    destination: tool\n"
+	if err := os.WriteFile(cfgPath, []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// 一時ディレクトリを使えない環境でも、通常のファイルはストリームのまま lock できる
+	t.Setenv("TMPDIR", filepath.Join(dir, "missing"))
+	if err := runCLI(t, "lock", "-c", cfgPath, "--no-progress"); err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	lockFile, err := lock.LoadLockFile(filepath.Join(dir, lock.LockFileName), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, ok := lockFile.GetEntry("tool", model.ResolvedURL(srv.URL+"/tool"))
+	if !ok || entry.Size != int64(len("tool content\n")) {
+		t.Errorf("locked entry = %+v, want size %d", entry, len("tool content\n"))
+	}
+}
+
+func TestLockKeepGoingPartialRun(t *testing.T) {
+	tests := []struct {
+		name          string
+		writeComplete bool
+	}{
+		{name: "write only if complete", writeComplete: true},
+		{name: "keep going", writeComplete: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newContentServer(t, map[string]string{"/stable": "stable\n", "/flaky": "flaky\n", "/added": "added\n"})
+			dir := t.TempDir()
+			cfgPath := filepath.Join(dir, "dltofu.yml")
+			writeConfig := func(fileIDs ...string) {
+				cfg := "version: v1\nfiles:\n"
+				for _, id := range fileIDs {
+					cfg += "  " + id + ":\n    url: " + srv.URL + "/" + id + "\n    destination: " + id + "\n"
+				}
+				if err := os.WriteFile(cfgPath, []byte(cfg), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			writeConfig("stable", "flaky")
+			if err := runCLI(t, "lock", "-c", cfgPath, "--no-progress"); err != nil {
+				t.Fatalf("first lock: %v", err)
+			}
+			lockPath := filepath.Join(dir, lock.LockFileName)
+			partialPath := lockPath + lock.PartialSuffix
+			before, err := os.ReadFile(lockPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// 新しいファイルを追加し、既存のファイルの1つは取得できなくする
+			writeConfig("stable", "flaky", "added")
+			srv.remove("/flaky")
+			args := []string{"lock", "-c", cfgPath, "--no-progress", "--keep-going"}
+			if tt.writeComplete {
+				args = append(args, "--write-only-if-complete")
+			}
+			if err := runCLI(t, args...); err == nil {
+				t.Fatal("lock succeeded although a file failed")
+			}
+
+			after, err := os.ReadFile(lockPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resultPath := lockPath
+			if tt.writeComplete {
+				if !bytes.Equal(before, after) {
+					t.Errorf("lock file changed by a partial run:\nbefore:\n%s\nafter:\n%s", before, after)
+				}
+				resultPath = partialPath
+			} else if _, err := os.Stat(partialPath); !os.IsNotExist(err) {
+				t.Errorf("partial lock file written without --write-only-if-complete (error: %v)", err)
+			}
+
+			// 結果には成功したファイルが追加され、失敗したファイルは以前の記録が残る
+			result, err := lock.LoadLockFile(resultPath, nil)
+			if err != nil {
+				t.Fatalf("failed to load %s: %v", resultPath, err)
+			}
+			for _, id := range []string{"stable", "flaky", "added"} {
+				if _, ok := result.GetEntry(model.FileID(id), model.ResolvedURL(srv.URL+"/"+id)); !ok {
+					t.Errorf("%s has no entry for %s", filepath.Base(resultPath), id)
+				}
+			}
+
+			// 全て成功すれば Lock ファイルが更新され、残っていた .partial は削除される
+			srv.set("/flaky", "flaky\n")
+			if err := runCLI(t, args...); err != nil {
+				t.Fatalf("lock after recovery: %v", err)
+			}
+			if _, err := os.Stat(partialPath); !os.IsNotExist(err) {
+				t.Errorf("stale partial lock file was not removed (error: %v)", err)
+			}
+			recovered, err := lock.LoadLockFile(lockPath, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := recovered.GetEntry("added", model.ResolvedURL(srv.URL+"/added")); !ok {
+				t.Error("lock file has no entry for added after a complete run")
+			}
+		})
+	}
+}
+
+func TestLockKeepGoingReportsURLConflicts(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		wantErr     string
+		wantPartial bool
+	}{
+		{name: "strict", args: []string{"--strict", "--write-only-if-complete"}, wantErr: "locked inconsistently by multiple file IDs"},
+		{name: "not strict", args: []string{"--write-only-if-complete"}, wantErr: "lock command failed for 1 file(s)", wantPartial: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newContentServer(t, map[string]string{"/shared": "shared\n"})
+			dir := t.TempDir()
+			cfgPath := filepath.Join(dir, "dltofu.yml")
+			// 同じ URL を共通のアルゴリズムのない2つのファイルIDで記録し、別のファイルは取得に失敗させる
+			cfg := "version: v1\nfiles:\n" +
+				"  one:\n    url: " + srv.URL + "/shared\n    destination: one\n    hash_algorithm: sha256\n" +
+				"  two:\n    url: " + srv.URL + "/shared\n    destination: two\n    hash_algorithm: sha512\n" +
+				"  broken:\n    url: " + srv.URL + "/missing\n    destination: broken\n"
+			if err := os.WriteFile(cfgPath, []byte(cfg), 0644); err != nil {
+				t.Fatal(err)
+			}
+			err := runCLI(t, append([]string{"lock", "-c", cfgPath, "--no-progress", "--keep-going"}, tt.args...)...)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("lock error = %v, want it to contain %q", err, tt.wantErr)
+			}
+			lockPath := filepath.Join(dir, lock.LockFileName)
+			if _, err := os.Stat(lockPath); !os.IsNotExist(err) {
+				t.Errorf("lock file was written (error: %v)", err)
+			}
+			if _, err := os.Stat(lockPath + lock.PartialSuffix); os.IsNotExist(err) == tt.wantPartial {
+				t.Errorf("partial lock file written = %v, want %v", !os.IsNotExist(err), tt.wantPartial)
+			}
+		})
+	}
+}
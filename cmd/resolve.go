@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hrko/dltofu/internal/config"
+	"github.com/hrko/dltofu/internal/hash"
+	"github.com/hrko/dltofu/internal/lock"
+	"github.com/hrko/dltofu/internal/model"
+)
+
+var resolveOnly []string // --only フラグ用
+
+// resolvedTarget は1つのバリアントの解決結果
+type resolvedTarget struct {
+	FileID        model.FileID       `json:"file_id"`
+	Platform      string             `json:"platform"` // プラットフォーム指定がないファイルは空
+	Arch          string             `json:"arch"`     // プラットフォーム指定がないファイルは空
+	ArchVariant   string             `json:"arch_variant,omitempty"`
+	URL           model.ResolvedURL  `json:"url"`
+	HashAlgorithm hash.HashAlgorithm `json:"hash_algorithm"`
+	Destination   string             `json:"destination"`
+}
+
+// resolveCmd represents the resolve command
+var resolveCmd = &cobra.Command{
+	Use:   "resolve",
+	Short: "Prints the resolved download targets without downloading anything",
+	Long: `Resolves every file variant in the configuration (all platforms and
+architectures, the same matrix lock fetches) and prints the file ID,
+platform, architecture, resolved URL, effective hash algorithm and
+destination of each.
+
+Use --output json to feed the matrix to external build systems.
+Nothing is downloaded. The lock file is only read to pin files with
+version: latest to the recorded release; its checksum is not checked and
+an unreadable lock file is reported as a warning.`,
+	RunE: runResolve,
+}
+
+func init() {
+	rootCmd.AddCommand(resolveCmd)
+	resolveCmd.Flags().StringArrayVar(&resolveOnly, "only", nil, "Only resolve file IDs matching the glob pattern (repeatable)")
+}
+
+func runResolve(cmd *cobra.Command, args []string) error {
+	if cfgFile == "" {
+		return fmt.Errorf("configuration file must be specified using --config or exist as dltofu.yml/dltofu.yaml")
+	}
+
+	cfg, err := config.LoadConfig(cfgFile, logger, strict)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if err := cfg.SelectFiles(resolveOnly); err != nil {
+		return err
+	}
+	// version: latest は Lock ファイルに記録されたリリースを表示する (Lock ファイルがなければタグは latest のまま)。
+	// 解決結果を表示するだけなので checksum は検証せず、読み込めない Lock ファイルも警告にとどめる
+	lockPath, err := cfg.ResolveLockPath(lockName)
+	if err != nil {
+		return err
+	}
+	if lf, err := lock.LoadLockFile(lockPath, logger); err == nil {
+		cfg.PinLatest(lf)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		logger.Warn("Ignoring unreadable lock file; version: latest is not pinned", "path", lockPath, "error", err)
+	}
+
+	matrix, err := cfg.TargetMatrix()
+	if err != nil {
+		return err
+	}
+	targets := make([]resolvedTarget, 0, len(matrix)) // JSON で null ではなく [] を出力する
+	for _, t := range matrix {
+		targets = append(targets, resolvedTarget{
+			FileID:        t.FileID,
+			Platform:      t.PlatformID,
+			Arch:          t.ArchID,
+			ArchVariant:   t.ArchVariant,
+			URL:           t.URL,
+			HashAlgorithm: t.HashAlgorithm,
+			Destination:   t.Destination,
+		})
+	}
+
+	if outputFormat == outputJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(targets)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE ID\tPLATFORM\tARCH\tALGORITHM\tURL\tDESTINATION")
+	for _, t := range targets {
+		arch := t.Arch
+		if t.ArchVariant != "" {
+			arch += "/" + t.ArchVariant
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", t.FileID, t.Platform, arch, t.HashAlgorithm, t.URL, t.Destination)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hrko/dltofu/internal/lock"
+)
+
+func TestResolveMatchesLock(t *testing.T) {
+	var content atomic.Value
+	content.Store("tool\n")
+	srv := fileServer(t, &content)
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "dltofu.yml")
+	cfg := `version: v1
+files:
+  tool:
+    url: ` + srv.URL + `/tool-{{.Platform}}-{{.Architecture}}
+    destination: out/{{.Platform}}/{{.Architecture}}/tool
+    platforms:
+      linux: linux
+      windows: windows
+    architectures:
+      x86_64: amd64
+      arm64: arm64
+    overrides:
+      windows/x86_64:
+        hash_algorithm: sha512
+  readme:
+    url: ` + srv.URL + `/README
+    destination: README
+`
+	if err := os.WriteFile(cfgPath, []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := runCLI(t, "lock", "-c", cfgPath, "--no-progress"); err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	lockPath := filepath.Join(dir, lock.LockFileName)
+	lockData, err := os.ReadFile(lockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockFile, err := lock.LoadLockFile(lockPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockedURLs := 0
+	for fileID := range lockFile.Files {
+		lockedURLs += len(lockFile.URLs(fileID))
+	}
+
+	tests := []struct {
+		name     string
+		lockData []byte // Lock ファイルの内容 (nil なら lock が書いたまま)
+		args     []string
+	}{
+		{name: "lock as written"},
+		{name: "unsigned lock with verify-lock", args: []string{"--verify-lock"}},
+		{name: "corrupt lock", lockData: []byte("{")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := lockData
+			if tt.lockData != nil {
+				data = tt.lockData
+			}
+			if err := os.WriteFile(lockPath, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			var runErr error
+			stdout, _ := captureOutput(t, func() {
+				runErr = runCLI(t, append([]string{"resolve", "-c", cfgPath, "--output", "json"}, tt.args...)...)
+			})
+			if runErr != nil {
+				t.Fatalf("resolve: %v", runErr)
+			}
+			var targets []resolvedTarget
+			if err := json.Unmarshal([]byte(stdout), &targets); err != nil {
+				t.Fatalf("failed to parse resolve output: %v\n%s", err, stdout)
+			}
+
+			// resolve の各ターゲットは lock が同じアルゴリズムで記録した URL と一致し、過不足がない
+			if len(targets) != lockedURLs {
+				t.Errorf("resolve printed %d targets, lock recorded %d URLs", len(targets), lockedURLs)
+			}
+			for _, target := range targets {
+				if _, ok := lockFile.GetEntry(target.FileID, target.URL); !ok {
+					t.Errorf("%s %s/%s: %s is not in the lock file", target.FileID, target.Platform, target.Arch, target.URL)
+					continue
+				}
+				if _, err := lockFile.GetHash(target.FileID, target.URL, target.HashAlgorithm); err != nil {
+					t.Errorf("%s %s/%s: lock has no %s hash for %s: %v", target.FileID, target.Platform, target.Arch, target.HashAlgorithm, target.URL, err)
+				}
+			}
+		})
+	}
+}
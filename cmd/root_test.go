@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hrko/dltofu/internal/config"
+	"github.com/hrko/dltofu/internal/download"
+	"github.com/hrko/dltofu/internal/hash"
+	"github.com/hrko/dltofu/internal/model"
+)
+
+func TestDownloaderOptionsConcurrencyPerHost(t *testing.T) {
+	tests := []struct {
+		perHost int
+		want    int32 // 同時に処理されたリクエストの最大数
+	}{
+		{perHost: 1, want: 1},
+		{perHost: 2, want: 2},
+		{perHost: 0, want: 4}, // 制限なし
+	}
+	saved := perHost
+	t.Cleanup(func() { perHost = saved })
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("per-host=%d", tt.perHost), func(t *testing.T) {
+			var inFlight, maxInFlight atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := inFlight.Add(1)
+				defer inFlight.Add(-1)
+				for {
+					m := maxInFlight.Load()
+					if n <= m || maxInFlight.CompareAndSwap(m, n) {
+						break
+					}
+				}
+				time.Sleep(50 * time.Millisecond)
+				w.Write([]byte("ok"))
+			}))
+			defer srv.Close()
+
+			perHost = tt.perHost
+			opts, err := downloaderOptions(&config.Config{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := download.NewDownloader(0, nil, opts...)
+			var wg sync.WaitGroup
+			for i := 0; i < 4; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if _, err := d.Hash(model.ResolvedURL(srv.URL), hash.AlgoSHA256); err != nil {
+						t.Error(err)
+					}
+				}()
+			}
+			wg.Wait()
+			if got := maxInFlight.Load(); got != tt.want {
+				t.Errorf("--concurrency-per-host=%d: max concurrent requests = %d, want %d", tt.perHost, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeprecatedJSONFlag(t *testing.T) {
+	cfgPath := filepath.Join(t.TempDir(), "dltofu.yml")
+	cfg := "version: v1\nfiles:\n  tool:\n    url: https://example.com/tool\n    destination: tool\n"
+	if err := os.WriteFile(cfgPath, []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		args           []string
+		wantErr        bool
+		wantJSON       bool // 標準出力が JSON であること
+		wantDeprecated bool // 非推奨の警告が出力されること
+	}{
+		{name: "text", args: nil},
+		{name: "output json", args: []string{"--output", "json"}, wantJSON: true},
+		{name: "deprecated json", args: []string{"--json"}, wantJSON: true, wantDeprecated: true},
+		{name: "json with output json", args: []string{"--json", "-o", "json"}, wantJSON: true, wantDeprecated: true},
+		{name: "json with output text", args: []string{"--json", "--output", "text"}, wantErr: true, wantDeprecated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			stdout, stderr := captureOutput(t, func() {
+				err = runCLI(t, append([]string{"status", "-c", cfgPath}, tt.args...)...)
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("status error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := strings.Contains(stderr, "--json has been deprecated"); got != tt.wantDeprecated {
+				t.Errorf("deprecation warning = %v, want %v (stderr: %q)", got, tt.wantDeprecated, stderr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := json.Valid([]byte(stdout)); got != tt.wantJSON {
+				t.Errorf("stdout is JSON = %v, want %v:\n%s", got, tt.wantJSON, stdout)
+			}
+		})
+	}
+}
+
+func TestIPFamilyFlags(t *testing.T) {
+	cfgPath := filepath.Join(t.TempDir(), "dltofu.yml")
+	cfg := "version: v1\nfiles:\n  tool:\n    url: https://example.com/tool\n    destination: tool\n"
+	if err := os.WriteFile(cfgPath, []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args    []string
+		wantErr string // 空ならエラーにならない
+	}{
+		{args: []string{"--prefer-ipv4"}},
+		{args: []string{"--prefer-ipv6", "--ip-strict"}},
+		{args: []string{"--prefer-ipv4", "--prefer-ipv6"}, wantErr: "cannot be used together"},
+		{args: []string{"--ip-strict"}, wantErr: "--ip-strict requires --prefer-ipv4 or --prefer-ipv6"},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			var err error
+			captureOutput(t, func() {
+				err = runCLI(t, append([]string{"status", "-c", cfgPath}, tt.args...)...)
+			})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("status error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("status error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hrko/dltofu/internal/config"
+	"github.com/hrko/dltofu/internal/lock"
+	"github.com/hrko/dltofu/internal/model"
+	"github.com/hrko/dltofu/internal/platform"
+)
+
+var statusJSON bool // --json フラグ用
+
+// status のファイルの状態
+const (
+	statusPresent = "present"
+	statusMissing = "missing"
+	statusError   = "error"
+)
+
+// status の Lock ファイルの記録状況
+const (
+	lockCoverageLocked   = "locked"
+	lockCoverageUnlocked = "unlocked" // Lock ファイルに有効なアルゴリズムのハッシュ値がない
+	lockCoverageNoLock   = "no-lock"  // Lock ファイルが存在しない
+)
+
+// fileStatus は1ファイルの状態
+type fileStatus struct {
+	FileID      model.FileID      `json:"file_id"`
+	URL         model.ResolvedURL `json:"url"`
+	Destination string            `json:"destination"`
+	Archive     bool              `json:"archive"`
+	State       string            `json:"state"` // present, missing, error
+	Lock        string            `json:"lock"`  // locked, unlocked, no-lock
+	Detail      string            `json:"detail,omitempty"`
+}
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Shows which configured files are downloaded and locked",
+	Long: `Lists every file applicable to the current platform/architecture with
+its resolved URL and destination, whether the destination exists and
+whether the lock file has a hash for it.
+
+For archives, present means the extraction directory exists. Unlike
+verify, nothing is hashed, so status is quick but does not detect
+modified files. Files for other platforms are not listed.`,
+	RunE: runStatus,
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+	addDeprecatedJSONFlag(statusCmd, &statusJSON)
+}
+
+func runStatus(cmd *cobra.Command, args []string) error {
+	if cfgFile == "" {
+		return fmt.Errorf("configuration file must be specified using --config or exist as dltofu.yml/dltofu.yaml")
+	}
+
+	cfg, err := config.LoadConfig(cfgFile, logger, strict)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	// Lock ファイルがなくても状態は表示する
+	lockFile, err := loadLockFile(cfg)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to load lock file: %w", err)
+		}
+		lockFile = nil
+	}
+
+	currentPlatform, err := cfg.CurrentPlatform()
+	if err != nil {
+		return fmt.Errorf("failed to get current platform: %w", err)
+	}
+	currentArch, err := cfg.CurrentArch()
+	if err != nil {
+		return fmt.Errorf("failed to get current architecture: %w", err)
+	}
+
+	statuses := []fileStatus{} // JSON で null ではなく [] を出力する
+	currentVariant := platform.GetCurrentArchVariant()
+
+	for fileID := range cfg.Files {
+		target, applicable, err := cfg.SelectTarget(fileID, currentPlatform, currentArch, currentVariant)
+		if !applicable {
+			logger.Debug("Skipping file: not applicable for current platform/architecture", "file_id", fileID)
+			continue
+		}
+		if err != nil {
+			statuses = append(statuses, fileStatus{FileID: fileID, Archive: cfg.Files[fileID].IsArchive, State: statusError, Detail: err.Error()})
+			continue
+		}
+		statuses = append(statuses, statusOf(lockFile, target))
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].FileID < statuses[j].FileID })
+
+	if outputFormat == outputJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(statuses)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE ID\tSTATE\tLOCK\tURL\tDESTINATION\tDETAIL")
+	for _, s := range statuses {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", s.FileID, s.State, s.Lock, s.URL, s.Destination, s.Detail)
+	}
+	return w.Flush()
+}
+
+// statusOf は1ファイルの状態を調べる。ファイルの内容は読まない。
+func statusOf(lockFile *lock.LockFile, target config.Target) fileStatus {
+	status := fileStatus{
+		FileID:      target.FileID,
+		URL:         target.URL,
+		Destination: target.Destination,
+		Archive:     target.Def.IsArchive,
+	}
+
+	if lockFile == nil {
+		status.Lock = lockCoverageNoLock
+	} else if _, err := lockFile.GetHash(target.FileID, target.URL, target.HashAlgorithm); err == nil {
+		status.Lock = lockCoverageLocked
+	} else {
+		status.Lock = lockCoverageUnlocked
+	}
+
+	info, err := os.Stat(target.Destination)
+	switch {
+	case os.IsNotExist(err):
+		status.State = statusMissing
+	case err != nil:
+		status.State, status.Detail = statusError, err.Error()
+	case target.Def.IsArchive && !info.IsDir():
+		status.State, status.Detail = statusError, "destination of archive is not a directory"
+	case !target.Def.IsArchive && info.IsDir():
+		status.State, status.Detail = statusError, "destination is a directory"
+	default:
+		status.State = statusPresent
+	}
+	return status
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/hrko/dltofu/internal/config"
+	"github.com/hrko/dltofu/internal/metrics"
+	"github.com/hrko/dltofu/internal/report"
+)
+
+// printSummary は実行の統計情報をログとして (--quiet の場合は標準エラー出力に1行で) 出力する。
+// --output json の場合は writeResult が実行結果に含めて出力するため、何もしない。
+func printSummary(m *metrics.Metrics) {
+	if outputFormat == outputJSON {
+		return
+	}
+	summary := m.Summary()
+	if quiet {
+		// --quiet では情報ログを出力しないため、統計情報のみ1行で書き出す。
+		// 標準出力はコマンドの出力 (--output json など) に使うため、ログと同じ標準エラー出力に書き出す
+		fmt.Fprintf(os.Stderr, "%d bytes downloaded in %.2fs (%d requests, %d cache hits)\n",
+			summary.BytesDownloaded, summary.DurationSeconds, summary.Requests, summary.CacheHits)
+		return
+	}
+	logger.Info("Run summary",
+		"bytes_downloaded", summary.BytesDownloaded,
+		"duration", fmt.Sprintf("%.2fs", summary.DurationSeconds),
+		"requests", summary.Requests,
+		"requests_per_host", summary.RequestsPerHost,
+		"cache_hits", summary.CacheHits,
+		"cache_misses", summary.CacheMisses,
+	)
+}
+
+// logFailures は失敗したファイルの一覧とコマンドのエラーを最後にまとめてログに出力する。
+// 並列処理では失敗のログが他のファイルのログに埋もれるため、どのファイルが失敗したかをここで確認できるようにする。
+func logFailures(results *report.Collector, err error) {
+	failures := results.Failures()
+	if len(failures) > 0 {
+		logger.Error("Failure summary", "failed", len(failures))
+		for _, f := range failures {
+			logger.Error("Failed", "target", f.Target(), "url", f.URL, "status", f.Status, "error", f.Error)
+		}
+	}
+	if err != nil {
+		logger.Error("Command failed", "error", err)
+	}
+}
+
+// failedFilesError は失敗したファイルIDを含むコマンドのエラーを返す
+func failedFilesError(command string, failures []report.Failure) error {
+	fileIDs := report.FailedFileIDs(failures)
+	return fmt.Errorf("%s command finished with errors: %d file(s) failed: %v", command, len(fileIDs), fileIDs)
+}
+
+// targetResult はバリアントの識別情報を設定した処理結果を返す
+func targetResult(target config.Target) report.FileResult {
+	return report.FileResult{
+		FileID:   target.FileID,
+		Platform: target.PlatformID,
+		Arch:     target.ArchID,
+		Variant:  target.ArchVariant,
+		URL:      target.URL,
+		Path:     target.Destination,
+	}
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hrko/dltofu/internal/metrics"
+)
+
+func TestEffectiveLogLevel(t *testing.T) {
+	tests := []struct {
+		name  string
+		quiet bool
+		want  slog.Level
+	}{
+		{name: "debug", want: slog.LevelDebug},
+		{name: "info", want: slog.LevelInfo},
+		{name: "warn", want: slog.LevelWarn},
+		{name: "error", want: slog.LevelError},
+		{name: "unknown", want: slog.LevelInfo},
+		{name: "debug", quiet: true, want: slog.LevelWarn},
+		{name: "info", quiet: true, want: slog.LevelWarn},
+		{name: "warn", quiet: true, want: slog.LevelWarn},
+		{name: "error", quiet: true, want: slog.LevelError},
+	}
+	for _, tt := range tests {
+		if got := effectiveLogLevel(tt.name, tt.quiet); got != tt.want {
+			t.Errorf("effectiveLogLevel(%q, %v) = %v, want %v", tt.name, tt.quiet, got, tt.want)
+		}
+	}
+}
+
+// captureOutput は f の実行中に標準出力と標準エラー出力に書き込まれた内容を返す
+func captureOutput(t *testing.T, f func()) (stdout, stderr string) {
+	t.Helper()
+	read := func(target **os.File) func() string {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		orig := *target
+		*target = w
+		done := make(chan string)
+		go func() {
+			data, _ := io.ReadAll(r)
+			done <- string(data)
+		}()
+		return func() string {
+			*target = orig
+			w.Close()
+			return <-done
+		}
+	}
+	stopOut, stopErr := read(&os.Stdout), read(&os.Stderr)
+	f()
+	return stopOut(), stopErr()
+}
+
+func TestPrintSummaryOutput(t *testing.T) {
+	savedQuiet, savedFormat, savedLogger := quiet, outputFormat, logger
+	t.Cleanup(func() { quiet, outputFormat, logger = savedQuiet, savedFormat, savedLogger })
+	logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	tests := []struct {
+		name       string
+		quiet      bool
+		format     string
+		wantStdout string // 標準出力に含まれる文字列 (空なら何も出力しない)
+		wantStderr string // 標準エラー出力に含まれる文字列 (空なら何も出力しない)
+	}{
+		{name: "quiet", quiet: true, format: outputText, wantStderr: "bytes downloaded"},
+		{name: "default logs only", format: outputText},
+		{name: "output json", quiet: true, format: outputJSON},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quiet, outputFormat = tt.quiet, tt.format
+			stdout, stderr := captureOutput(t, func() { printSummary(metrics.New()) })
+			check := func(stream, got, want string) {
+				if want == "" && got != "" || !strings.Contains(got, want) {
+					t.Errorf("%s = %q, want %q", stream, got, want)
+				}
+			}
+			check("stdout", stdout, tt.wantStdout)
+			check("stderr", stderr, tt.wantStderr)
+		})
+	}
+}
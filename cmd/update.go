@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hrko/dltofu/internal/config"
+	"github.com/hrko/dltofu/internal/download"
+	"github.com/hrko/dltofu/internal/lock"
+	"github.com/hrko/dltofu/internal/metrics"
+	"github.com/hrko/dltofu/internal/model"
+	"github.com/hrko/dltofu/internal/report"
+)
+
+// updateCmd represents the update command
+var updateCmd = &cobra.Command{
+	Use:   "update <file-id>...",
+	Short: "Re-downloads the given files and replaces their locked hashes",
+	Long: `Re-downloads every variant of the given file IDs and replaces their
+entries in the lock file with the new hashes, even if they differ from the
+recorded ones.
+
+'dltofu lock' refuses to change a recorded hash (trust on first use). Use
+update when an upstream has legitimately re-published an asset and the new
+content has been checked. The old and new hashes of each variant are printed
+and logged for the audit trail.
+
+File IDs may be glob patterns (e.g. 'tool-*'). Without any file ID nothing
+is changed. The lock file is only written if every variant succeeded.`,
+	RunE: runUpdate,
+}
+
+func init() {
+	rootCmd.AddCommand(updateCmd)
+	updateCmd.Flags().BoolVar(&lockTreeHash, "tree-hash", false, "Also extract archives and record hashes of the extracted tree and each extracted file")
+}
+
+func runUpdate(cmd *cobra.Command, args []string) (err error) {
+	logger.Info("Starting update command")
+
+	var results report.Collector
+	var runMetrics *metrics.Metrics
+	defer func() { writeResult("update", &results, runMetrics, err) }()
+
+	if len(args) == 0 {
+		return fmt.Errorf("no file IDs specified; update only replaces the hashes of explicitly given files")
+	}
+
+	if cfgFile == "" {
+		return fmt.Errorf("configuration file must be specified using --config or exist as dltofu.yml/dltofu.yaml")
+	}
+
+	cfg, err := config.LoadConfig(cfgFile, logger, strict)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if err := cfg.SelectFiles(args); err != nil {
+		return err
+	}
+
+	lockPath, err := cfg.ResolveLockPath(lockName)
+	if err != nil {
+		return err
+	}
+	existingLock, err := lock.LoadLockFile(lockPath, logger)
+	if err != nil {
+		return fmt.Errorf("failed to load lock file (run 'dltofu lock' first): %w", err)
+	}
+	if err := checkLockChecksum(existingLock); err != nil {
+		return err
+	}
+	if err := resolveLatestReleases(cfg); err != nil {
+		return err
+	}
+
+	runMetrics = metrics.New()
+	defer printSummary(runMetrics)
+	opts, err := downloaderOptions(cfg, download.WithMetrics(runMetrics), download.WithContext(cmd.Context()), sharedURLCache(allTargets(cfg), spillCache()))
+	if err != nil {
+		return err
+	}
+	downloader := download.NewDownloader(timeout, logger, opts...)
+
+	// 対象のファイルIDのエントリ (パッチのベースなどを含む) を全て削除してから記録し直す
+	newLock := existingLock.Copy()
+	fileIDs := make([]model.FileID, 0, len(cfg.Files))
+	for fileID := range cfg.Files {
+		fileIDs = append(fileIDs, fileID)
+		newLock.RemoveEntry(fileID)
+		newLock.SetDescription(fileID, cfg.Files[fileID].Description)
+	}
+	sort.Slice(fileIDs, func(i, j int) bool { return fileIDs[i] < fileIDs[j] })
+
+	activeFiles := make(map[model.FileID]map[model.ResolvedURL]struct{}) // lockTarget の引数として必要なだけで使わない
+	var activeFilesMu sync.Mutex
+	var checksums checksumsCache
+	changed := 0
+	for _, fileID := range fileIDs {
+		targets, err := cfg.FileTargets(fileID)
+		if err != nil {
+			results.Add(report.FileResult{FileID: fileID}.Fail(err))
+			return err
+		}
+		for _, target := range targets {
+			res := targetResult(target)
+			res.Path = "" // update はダウンロード先を使わない
+			oldHash, _ := existingLock.GetHash(target.FileID, target.URL, target.HashAlgorithm)
+			newHash, err := lockTarget(downloader, &checksums, newLock, target, activeFiles, &activeFilesMu, mismatchFail)
+			if err != nil {
+				results.Add(res.Fail(err))
+				return fmt.Errorf("update failed, lock file was not changed: %w", err)
+			}
+			res.Hash, res.Status = newHash, report.StatusOK
+			switch {
+			case oldHash == nil:
+				res.Detail = "newly locked"
+				changed++
+			case oldHash.Equal(newHash):
+				// 内容が変わっていなければ first_seen などの記録も元のエントリのものを残す
+				if oldEntry, ok := existingLock.GetEntry(target.FileID, target.URL); ok {
+					newLock.SetEntry(target.FileID, target.URL, oldEntry)
+				}
+				res.Detail = "unchanged"
+			default:
+				res.Detail = fmt.Sprintf("replaced %s", oldHash)
+				changed++
+				logger.Warn("Replaced locked hash", "target", target, "url", target.URL, "old", oldHash, "new", newHash)
+			}
+			results.Add(res)
+		}
+	}
+
+	// --output json の場合は writeResult が出力する
+	if outputFormat == outputText {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "FILE ID\tPLATFORM\tURL\tOLD HASH\tNEW HASH")
+		for _, r := range results.Files() {
+			oldHash := "-"
+			if h, err := existingLock.GetHash(r.FileID, r.URL, r.Hash.Algorithm); err == nil {
+				oldHash = h.String()
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.FileID, resultPlatform(r), r.URL, oldHash, r.Hash)
+		}
+		w.Flush()
+	}
+
+	if changed == 0 {
+		logger.Info("All hashes are unchanged; lock file is not rewritten")
+		return nil
+	}
+	if err := newLock.Save(lockPath); err != nil {
+		return fmt.Errorf("failed to save lock file: %w", err)
+	}
+	logger.Info("Update command finished successfully", "changed", changed)
+	return nil
+}
+
+// resultPlatform は処理結果のプラットフォーム/アーキテクチャ (バリアント) を表示用に返す。指定がない場合は "-" を返す。
+func resultPlatform(r report.FileResult) string {
+	switch {
+	case r.Platform == "":
+		return "-"
+	case r.Variant != "":
+		return r.Platform + "/" + r.Arch + "/" + r.Variant
+	default:
+		return r.Platform + "/" + r.Arch
+	}
+}
@@ -0,0 +1,271 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hrko/dltofu/internal/archive"
+	"github.com/hrko/dltofu/internal/config"
+	"github.com/hrko/dltofu/internal/hash"
+	"github.com/hrko/dltofu/internal/lock"
+	"github.com/hrko/dltofu/internal/model"
+	"github.com/hrko/dltofu/internal/report"
+)
+
+var (
+	verifyParallel int      // --parallelism フラグ用
+	verifyOnly     []string // --only フラグ用
+	verifyExclude  []string // --exclude フラグ用
+
+	verifyPlatform    string // --platform フラグ用
+	verifyArch        string // --arch フラグ用
+	verifyArchVariant string // --arch-variant フラグ用
+)
+
+// maxReportedMembers は不一致のメンバーを DETAIL に列挙する最大数
+const maxReportedMembers = 5
+
+// verifyCmd represents the verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verifies already-downloaded files against the lock file",
+	Long: `Resolves the destination of every file applicable to the current
+platform/architecture the same way download does, hashes the file on disk
+and compares it with the hash recorded in the lock file. Nothing is
+downloaded.
+
+Archives are verified member by member against the per-file hashes that
+'lock --tree-hash' records, hashing up to --parallelism files concurrently
+and reporting every member that differs. Archives locked without
+--tree-hash are skipped. Files in the extraction directory that did not
+come from the archive are ignored.
+
+Use --platform and --arch to verify files downloaded for another
+platform or architecture.
+
+Exits with a non-zero status if any file is missing or does not match.`,
+	RunE: runVerify,
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+	verifyCmd.Flags().IntVarP(&verifyParallel, "parallelism", "p", runtime.NumCPU(), "Number of extracted archive members to hash in parallel")
+	verifyCmd.Flags().StringArrayVar(&verifyOnly, "only", nil, "Only verify file IDs matching the glob pattern (repeatable)")
+	verifyCmd.Flags().StringArrayVar(&verifyExclude, "exclude", nil, "Skip file IDs matching the glob pattern (repeatable)")
+	addTargetEnvironmentFlags(verifyCmd, &verifyPlatform, &verifyArch, &verifyArchVariant)
+}
+
+func runVerify(cmd *cobra.Command, args []string) (err error) {
+	logger.Info("Starting verify command")
+
+	var results report.Collector
+	defer func() { writeResult("verify", &results, nil, err) }()
+
+	if verifyParallel < 1 {
+		return fmt.Errorf("--parallelism must be at least 1 (got %d)", verifyParallel)
+	}
+
+	if cfgFile == "" {
+		return fmt.Errorf("configuration file must be specified using --config or exist as dltofu.yml/dltofu.yaml")
+	}
+
+	cfg, err := config.LoadConfig(cfgFile, logger, strict)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if err := cfg.SelectFiles(verifyOnly); err != nil {
+		return err
+	}
+	if err := cfg.ExcludeFiles(verifyExclude); err != nil {
+		return err
+	}
+
+	lockFile, err := loadLockFile(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to load lock file (required for verify): %w", err)
+	}
+
+	currentPlatform, currentArch, currentVariant, err := targetEnvironment(cfg, verifyPlatform, verifyArch, verifyArchVariant)
+	if err != nil {
+		return err
+	}
+
+	for fileID := range cfg.Files {
+		target, applicable, err := cfg.SelectTarget(fileID, currentPlatform, currentArch, currentVariant)
+		if !applicable {
+			logger.Debug("Skipping file: not applicable for current platform/architecture", "file_id", fileID)
+			continue
+		}
+		if err != nil {
+			results.Add(report.FileResult{FileID: fileID}.Fail(err))
+			continue
+		}
+		results.Add(verifyFile(lockFile, target))
+	}
+
+	failed := 0
+	files := results.Files()
+	for _, r := range files {
+		if r.Failed() {
+			failed++
+		}
+	}
+
+	// --output json の場合は writeResult が出力する
+	if outputFormat == outputText {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "FILE ID\tSTATUS\tPATH\tDETAIL")
+		for _, r := range files {
+			detail := r.Detail
+			if r.Error != "" {
+				detail = r.Error
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.FileID, verifyStatusLabel(r.Status), r.Path, detail)
+		}
+		w.Flush()
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("verify failed for %d file(s)", failed)
+	}
+	logger.Info("Verify command finished successfully")
+	return nil
+}
+
+// verifyFile はダウンロード済みの1ファイルを Lock ファイルのハッシュ値と照合する
+func verifyFile(lockFile *lock.LockFile, target config.Target) report.FileResult {
+	fileID, resolvedURL, dest, hashAlgo := target.FileID, target.URL, target.Destination, target.HashAlgorithm
+	result := targetResult(target)
+
+	if target.Def.IsArchive {
+		return verifyArchiveMembers(lockFile, fileID, resolvedURL, result)
+	}
+
+	expectedHash, err := lockFile.GetHash(fileID, resolvedURL, hashAlgo)
+	if err != nil {
+		return result.Fail(err)
+	}
+	result.Hash = expectedHash
+
+	f, err := os.Open(dest)
+	if err != nil {
+		if os.IsNotExist(err) {
+			result.Status = report.StatusMissing
+		} else {
+			result = result.Fail(err)
+		}
+		return result
+	}
+	defer f.Close()
+
+	// バイト数が記録されていれば、ハッシュ値を計算する前に比較して明らかな不一致を検出する
+	if entry, ok := lockFile.GetEntry(fileID, resolvedURL); ok && entry.Size > 0 {
+		info, err := f.Stat()
+		if err != nil {
+			return result.Fail(err)
+		}
+		if info.Size() != entry.Size {
+			logger.Error("Size mismatch", "file_id", fileID, "path", dest, "expected", entry.Size, "actual", info.Size())
+			result.Status, result.Detail = report.StatusMismatch, fmt.Sprintf("expected %d bytes, got %d bytes", entry.Size, info.Size())
+			return result
+		}
+	}
+
+	// チャンクハッシュが記録されていれば同時に計算し、不一致時に破損箇所を特定する
+	expectedChunks := lockFile.GetChunkHashes(fileID, resolvedURL)
+	var chunkHasher *hash.ChunkHasher
+	var w io.Writer = io.Discard
+	if expectedChunks != nil {
+		chunkHasher, err = hash.NewChunkHasher(expectedChunks.Root.Algorithm, expectedChunks.ChunkSize, nil)
+		if err != nil {
+			return result.Fail(err)
+		}
+		w = chunkHasher
+	}
+	actualHash, err := hash.CalculateStreamTee(f, w, hashAlgo)
+	if err != nil {
+		return result.Fail(err)
+	}
+	if !actualHash.Equal(expectedHash) {
+		logger.Error("Hash mismatch", "file_id", fileID, "path", dest, "expected", expectedHash, "actual", actualHash)
+		result.Status, result.Detail = report.StatusMismatch, fmt.Sprintf("expected %s, got %s", expectedHash, actualHash)
+		if chunkHasher != nil {
+			actualChunks, err := chunkHasher.Sum()
+			if err != nil {
+				result.Detail += fmt.Sprintf(" (failed to calculate chunk hashes: %v)", err)
+				return result
+			}
+			if diverged := actualChunks.Diverged(expectedChunks); len(diverged) > 0 {
+				logger.Error("Diverged chunks", "file_id", fileID, "path", dest, "chunk_size", expectedChunks.ChunkSize, "chunks", diverged)
+				result.Detail += fmt.Sprintf(" (diverged chunks: %s)", formatChunkIndices(diverged, expectedChunks.ChunkSize))
+			}
+		}
+		return result
+	}
+	result.Status = report.StatusOK
+	return result
+}
+
+// verifyArchiveMembers は展開先のファイルを Lock ファイルに記録されたメンバーごとのハッシュ値と照合する。
+// 不一致のメンバーは全て集計し、先頭の maxReportedMembers 件を DETAIL に含める。
+func verifyArchiveMembers(lockFile *lock.LockFile, fileID model.FileID, resolvedURL model.ResolvedURL, result report.FileResult) report.FileResult {
+	expected := lockFile.GetMemberHashes(fileID, resolvedURL)
+	if expected == nil {
+		return result.Skip("archive (no member hashes; run lock --tree-hash)")
+	}
+
+	mismatches, err := archive.VerifyMembers(result.Path, expected, verifyParallel)
+	if err != nil {
+		return result.Fail(err)
+	}
+	if len(mismatches) == 0 {
+		result.Status, result.Detail = report.StatusOK, fmt.Sprintf("%d members", len(expected))
+		return result
+	}
+
+	paths := make([]string, 0, min(len(mismatches), maxReportedMembers))
+	for i, m := range mismatches {
+		if m.Actual == nil {
+			logger.Error("Archive member missing", "file_id", fileID, "path", m.Path, "expected", m.Expected)
+		} else {
+			logger.Error("Archive member hash mismatch", "file_id", fileID, "path", m.Path, "expected", m.Expected, "actual", m.Actual)
+		}
+		if i < maxReportedMembers {
+			if m.Actual == nil {
+				paths = append(paths, m.Path+" (missing)")
+			} else {
+				paths = append(paths, m.Path)
+			}
+		}
+	}
+	if len(mismatches) > maxReportedMembers {
+		paths = append(paths, fmt.Sprintf("and %d more", len(mismatches)-maxReportedMembers))
+	}
+	result.Status = report.StatusMismatch
+	result.Detail = fmt.Sprintf("%d of %d members differ: %s", len(mismatches), len(expected), strings.Join(paths, ", "))
+	return result
+}
+
+// verifyStatusLabel は表形式で表示するステータスを返す
+func verifyStatusLabel(status string) string {
+	if status == report.StatusFailed {
+		return "ERROR"
+	}
+	return strings.ToUpper(status)
+}
+
+// formatChunkIndices はチャンクのインデックスをバイト範囲付きで表示用に整形する
+func formatChunkIndices(indices []int, chunkSize int64) string {
+	parts := make([]string, len(indices))
+	for i, index := range indices {
+		start := int64(index) * chunkSize
+		parts[i] = fmt.Sprintf("#%d@%d-%d", index, start, start+chunkSize-1)
+	}
+	return strings.Join(parts, ", ")
+}
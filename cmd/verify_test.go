@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hrko/dltofu/internal/bundle"
+	"github.com/hrko/dltofu/internal/hash"
+	"github.com/hrko/dltofu/internal/lock"
+	"github.com/hrko/dltofu/internal/model"
+)
+
+func TestPerPlatformHashAlgorithm(t *testing.T) {
+	tests := []struct {
+		platform string
+		want     hash.HashAlgorithm
+	}{
+		{platform: "linux", want: hash.AlgoSHA256},
+		{platform: "windows", want: hash.AlgoSHA512},
+	}
+	srv := newContentServer(t, map[string]string{
+		"/tool-linux-amd64":   "linux tool\n",
+		"/tool-windows-amd64": "windows tool\n",
+	})
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "dltofu.yml")
+	cfg := `version: v1
+files:
+  tool:
+    url: ` + srv.URL + `/tool-{{.Platform}}-{{.Architecture}}
+    destination: out/{{.Platform}}/tool
+    hash_algorithm: sha256
+    platforms:
+      linux: linux
+      windows: windows
+    architectures:
+      x86_64: amd64
+    overrides:
+      windows/x86_64:
+        hash_algorithm: sha512
+`
+	if err := os.WriteFile(cfgPath, []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := runCLI(t, "lock", "-c", cfgPath, "--no-progress"); err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	lockFile, err := lock.LoadLockFile(filepath.Join(dir, lock.LockFileName), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.platform, func(t *testing.T) {
+			url := model.ResolvedURL(srv.URL + "/tool-" + tt.platform + "-amd64")
+			entry, ok := lockFile.GetEntry("tool", url)
+			if !ok {
+				t.Fatalf("no lock entry for %s", url)
+			}
+			if len(entry.Hashes) != 1 || entry.Hashes[0].Algorithm != tt.want {
+				t.Fatalf("locked hashes for %s = %v, want only %s", url, entry.Hashes, tt.want)
+			}
+
+			env := []string{"-c", cfgPath, "--platform", tt.platform, "--arch", "x86_64"}
+			if err := runCLI(t, append([]string{"download", "--no-progress", "--no-cache"}, env...)...); err != nil {
+				t.Fatalf("download: %v", err)
+			}
+			if err := runCLI(t, append([]string{"verify"}, env...)...); err != nil {
+				t.Fatalf("verify: %v", err)
+			}
+
+			// ディスク上の内容を変更すると、そのプラットフォームのアルゴリズムで再計算して不一致を検出する
+			dest := filepath.Join(dir, "out", tt.platform, "tool")
+			if err := os.WriteFile(dest, []byte("tampered\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := runCLI(t, append([]string{"verify"}, env...)...); err == nil {
+				t.Errorf("verify succeeded after %s was modified", dest)
+			}
+		})
+	}
+
+	out := filepath.Join(dir, "bundle.tar.gz")
+	if err := runCLI(t, "bundle", "-c", cfgPath, "--no-progress", "-o", out); err != nil {
+		t.Fatalf("bundle: %v", err)
+	}
+	members := readBundle(t, out)
+	var manifest bundle.Manifest
+	if err := json.Unmarshal(members[bundle.ManifestFileName], &manifest); err != nil {
+		t.Fatalf("invalid manifest: %v", err)
+	}
+	got := make(map[model.ResolvedURL]hash.HashAlgorithm)
+	for _, entry := range manifest.Files {
+		got[entry.URL] = entry.Hash.Algorithm
+	}
+	for _, tt := range tests {
+		url := model.ResolvedURL(srv.URL + "/tool-" + tt.platform + "-amd64")
+		if got[url] != tt.want {
+			t.Errorf("bundled %s with %s, want %s", url, got[url], tt.want)
+		}
+	}
+}
+
+func TestVerifyArchiveMembersParallel(t *testing.T) {
+	const count = 60
+	members := make(map[string]string, count)
+	for i := range count {
+		members[fmt.Sprintf("tool-1.0/share/file%02d.txt", i)] = fmt.Sprintf("member %d\n", i)
+	}
+	srv := newContentServer(t, map[string]string{"/tool.tar.gz": string(tarGz(t, members))})
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "dltofu.yml")
+	cfg := "version: v1\nfiles:\n  tool:\n    url: " + srv.URL + "/tool.tar.gz\n    destination: out\n    is_archive: true\n    strip_components: 1\n"
+	if err := os.WriteFile(cfgPath, []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := runCLI(t, "lock", "-c", cfgPath, "--no-progress", "--tree-hash"); err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		tamper     []int // 内容を変更するメンバー
+		wantDetail string
+	}{
+		{name: "untouched", wantDetail: fmt.Sprintf("%d members", count)},
+		{name: "one tampered", tamper: []int{37}, wantDetail: "1 of 60 members differ: share/file37.txt"},
+		{name: "two tampered", tamper: []int{3, 51}, wantDetail: "2 of 60 members differ: share/file03.txt, share/file51.txt"},
+	}
+	for _, tt := range tests {
+		for _, parallelism := range []string{"1", "8"} {
+			t.Run(tt.name+"/p"+parallelism, func(t *testing.T) {
+				if err := runCLI(t, "download", "-c", cfgPath, "--no-progress", "--no-cache", "--force"); err != nil {
+					t.Fatalf("download: %v", err)
+				}
+				for _, i := range tt.tamper {
+					p := filepath.Join(dir, "out", "share", fmt.Sprintf("file%02d.txt", i))
+					if err := os.WriteFile(p, []byte("tampered\n"), 0644); err != nil {
+						t.Fatal(err)
+					}
+				}
+				var err error
+				stdout, _ := captureOutput(t, func() {
+					err = runCLI(t, "verify", "-c", cfgPath, "-p", parallelism)
+				})
+				if (err != nil) != (len(tt.tamper) > 0) {
+					t.Fatalf("verify error = %v, want error %v", err, len(tt.tamper) > 0)
+				}
+				if !strings.Contains(stdout, tt.wantDetail) {
+					t.Errorf("verify output does not contain %q:\n%s", tt.wantDetail, stdout)
+				}
+			})
+		}
+	}
+}
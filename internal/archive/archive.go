@@ -1,10 +1,13 @@
 package archive
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 )
@@ -14,37 +17,298 @@ type Extractor interface {
 	Extract(sourcePath, destDir string, stripComponents int, extractPaths []string, force bool, logger *slog.Logger) error
 }
 
-// CommonExtractOptions は展開時の共通オプション (現在は未使用だが将来的に)
-// type CommonExtractOptions struct {
-// 	Force bool
-// }
+// アーカイブ内のパーミッションが 0 の場合 (Windows で作成された zip など) や、
+// IgnorePermissions を指定した場合に使うパーミッション
+const (
+	DefaultFileMode fs.FileMode = 0644
+	DefaultDirMode  fs.FileMode = 0755
+)
+
+// OverwriteConfirmer は展開先に既存のファイルがある場合に上書きするかを確認する
+type OverwriteConfirmer interface {
+	// ConfirmOverwrite は path を上書きする場合に true を返す。エラーを返した場合は展開を中断する。
+	ConfirmOverwrite(path string) (bool, error)
+}
+
+// extractOptions は展開時の共通オプション。各 Extractor に埋め込んで使う。
+type extractOptions struct {
+	ignorePerms bool               // アーカイブ内のパーミッションを使わず、常にデフォルトを使う
+	confirmer   OverwriteConfirmer // force でない場合に既存ファイルの上書きを確認する (nil の場合はスキップする)
+	exclude     []string           // extractPaths に一致しても展開しないパス (strip 後の相対パス)
+	rename      map[string]string  // key: strip 後の相対パス, value: 展開先での相対パス
+	flatten     bool               // ディレクトリ構造を捨て、全てのファイルを展開先の直下に置く
+	onExtract   func(path string)  // 通常ファイルを展開するたびに展開先のパスで呼ばれる (nil の場合は呼ばない)
+}
+
+// fileMode はアーカイブ内のパーミッションから、展開するファイルのパーミッションを決定する
+func (o *extractOptions) fileMode(mode fs.FileMode) fs.FileMode {
+	if o.ignorePerms || mode.Perm() == 0 {
+		return DefaultFileMode
+	}
+	return mode
+}
+
+// dirMode はアーカイブ内のパーミッションから、作成するディレクトリのパーミッションを決定する
+func (o *extractOptions) dirMode(mode fs.FileMode) fs.FileMode {
+	if o.ignorePerms || mode.Perm() == 0 {
+		return DefaultDirMode
+	}
+	return mode
+}
+
+func (o *extractOptions) options() *extractOptions {
+	return o
+}
+
+// IgnorePermissions は e がアーカイブ内のパーミッションを使わず、ファイルを DefaultFileMode、
+// ディレクトリを DefaultDirMode で展開するように設定して返す。
+// 埋め込まれたパーミッションが意味を持たないクロスプラットフォームのアーカイブ向け。
+func IgnorePermissions(e Extractor) Extractor {
+	if o, ok := e.(interface{ options() *extractOptions }); ok {
+		o.options().ignorePerms = true
+	}
+	return e
+}
+
+// WithConfirmer は force でない場合に既存のファイルを上書きするか c で確認するように e を設定して返す
+func WithConfirmer(e Extractor, c OverwriteConfirmer) Extractor {
+	if o, ok := e.(interface{ options() *extractOptions }); ok {
+		o.options().confirmer = c
+	}
+	return e
+}
+
+// WithExclude は strip 後のパスが patterns のいずれかに一致するファイル/ディレクトリを
+// 展開しないように e を設定して返す。パターンの扱いは extractPaths と同じ。
+func WithExclude(e Extractor, patterns []string) Extractor {
+	if o, ok := e.(interface{ options() *extractOptions }); ok {
+		o.options().exclude = patterns
+	}
+	return e
+}
+
+// WithRename は strip 後のパスが rename のキーに一致するファイル/ディレクトリを、
+// 値のパスに展開するように e を設定して返す。キーがディレクトリの場合はその中身も移動する。
+// extractPaths と exclude の判定には変更前のパスを使う。
+func WithRename(e Extractor, rename map[string]string) Extractor {
+	if o, ok := e.(interface{ options() *extractOptions }); ok {
+		o.options().rename = rename
+	}
+	return e
+}
+
+// OnExtract は e が通常ファイルを展開するたびに、展開先のパスで fn を呼ぶように設定して返す。
+// 展開後のパーミッションの変更などを、展開先の既存のファイルではなく今回展開したファイルだけに適用するために使う。
+func OnExtract(e Extractor, fn func(path string)) Extractor {
+	if o, ok := e.(interface{ options() *extractOptions }); ok {
+		o.options().onExtract = fn
+	}
+	return e
+}
+
+// extracted は destPath に通常ファイルを展開したことを OnExtract の fn に通知する
+func (o *extractOptions) extracted(destPath string) {
+	if o.onExtract != nil {
+		o.onExtract(destPath)
+	}
+}
+
+// renamed は strip 後のパス strippedPath を rename に従って変換して返す。
+// 複数のキーに一致する場合は最も長い (深い) キーを使う。
+func (o *extractOptions) renamed(strippedPath string) string {
+	matched, result := "", strippedPath
+	for from, to := range o.rename {
+		from, to = filepath.FromSlash(path.Clean(from)), filepath.FromSlash(path.Clean(to))
+		if len(from) <= len(matched) {
+			continue
+		}
+		if strippedPath == from {
+			matched, result = from, to
+		} else if rest, ok := strings.CutPrefix(strippedPath, from+string(os.PathSeparator)); ok {
+			matched, result = from, filepath.Join(to, rest)
+		}
+	}
+	return result
+}
+
+// Flatten は e がアーカイブ内のディレクトリ構造を捨て、全てのファイルを展開先の直下に
+// ファイル名のみで展開するように設定して返す。rename はファイル名にする前に適用する。
+// 同じファイル名になるファイルが複数ある場合は展開時にエラーとなる。
+func Flatten(e Extractor) Extractor {
+	if o, ok := e.(interface{ options() *extractOptions }); ok {
+		o.options().flatten = true
+	}
+	return e
+}
+
+// flattener は flatten 時に展開先のファイル名の衝突を検出する。
+// key: 展開先のファイル名, value: アーカイブ内の元のパス
+type flattener map[string]string
+
+// flatten は targetRelPath をファイル名のみにして返す。既に同じファイル名で展開したファイルがあればエラーを返す。
+func (f flattener) flatten(targetRelPath, originalPath string) (string, error) {
+	name := filepath.Base(targetRelPath)
+	if prev, ok := f[name]; ok {
+		return "", fmt.Errorf("cannot flatten archive: '%s' and '%s' would both be extracted as '%s'", prev, originalPath, name)
+	}
+	f[name] = originalPath
+	return name, nil
+}
+
+// アーカイブ形式 (設定ファイルの archive_type で指定できる値)
+const (
+	TypeZip    = "zip"
+	TypeSevenZ = "7z"
+	TypeTarGz  = "tar.gz"
+	TypeTarXz  = "tar.xz"
+	TypeTarZst = "tar.zst"
+	TypeGzip   = "gz"  // tar を含まない単一ファイル
+	TypeBzip2  = "bz2" // tar を含まない単一ファイル
+)
+
+// SupportedTypes は archive_type として指定可能なアーカイブ形式の一覧
+var SupportedTypes = []string{TypeZip, TypeSevenZ, TypeTarGz, TypeTarXz, TypeTarZst, TypeGzip, TypeBzip2}
+
+// typeExtensions は拡張子とアーカイブ形式の対応 (GetExtractor での判定用)。
+// 先頭から順に判定するため、.tar.gz などは単一ファイルの .gz より前に置く。
+var typeExtensions = []struct {
+	suffix      string
+	archiveType string
+}{
+	{".zip", TypeZip},
+	{".7z", TypeSevenZ},
+	{".tar.gz", TypeTarGz},
+	{".tgz", TypeTarGz},
+	{".tar.xz", TypeTarXz},
+	{".txz", TypeTarXz},
+	{".tar.zst", TypeTarZst},
+	{".tzst", TypeTarZst},
+	{".gz", TypeGzip},
+	{".bz2", TypeBzip2},
+}
+
+// NewExtractor は指定されたアーカイブ形式の Extractor を返す
+func NewExtractor(archiveType string) (Extractor, error) {
+	switch archiveType {
+	case TypeZip:
+		return &ZipExtractor{}, nil
+	case TypeSevenZ:
+		return &SevenZipExtractor{}, nil
+	case TypeTarGz:
+		return &TarGzExtractor{}, nil
+	case TypeTarXz:
+		return &TarXzExtractor{}, nil
+	case TypeTarZst:
+		return &TarZstExtractor{}, nil
+	case TypeGzip:
+		return &GzipExtractor{}, nil
+	case TypeBzip2:
+		return &Bzip2Extractor{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported archive type: %s (supported: %s)", archiveType, strings.Join(SupportedTypes, ", "))
+	}
+}
 
 // GetExtractor はファイルパスの拡張子に基づいて適切な Extractor を返す
 func GetExtractor(filePath string) (Extractor, error) {
 	lowerPath := strings.ToLower(filePath)
-	if strings.HasSuffix(lowerPath, ".zip") {
-		return &ZipExtractor{}, nil
-	}
-	if strings.HasSuffix(lowerPath, ".tar.gz") || strings.HasSuffix(lowerPath, ".tgz") {
-		return &TarGzExtractor{}, nil
+	for _, ext := range typeExtensions {
+		if strings.HasSuffix(lowerPath, ext.suffix) {
+			return NewExtractor(ext.archiveType)
+		}
 	}
-	// 他の形式 (e.g., .tar.bz2, .tar.xz) を追加する場合はここに追記
+	// 他の形式 (e.g., .tar.bz2) を追加する場合は NewExtractor と typeExtensions に追記
 	return nil, fmt.Errorf("unsupported archive format for file: %s", filePath)
 }
 
+// ResolveExtractor は archiveType が指定されていればその形式の Extractor を、
+// 指定されていなければ filePath の拡張子から判定した Extractor を返す。
+// 拡張子で判定できない場合はファイルの内容から判定する (DetectType)。
+func ResolveExtractor(archiveType, filePath string) (Extractor, error) {
+	if archiveType != "" {
+		return NewExtractor(archiveType)
+	}
+	if extractor, err := GetExtractor(filePath); err == nil {
+		return extractor, nil
+	}
+	detected, err := DetectType(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported archive format for file: %s (set archive_type to specify it): %w", filePath, err)
+	}
+	return NewExtractor(detected)
+}
+
 // --- Helper functions ---
 
 // secureJoin は filepath.Join と似ているが、Zip Slip 攻撃を防ぐ
-// destDir 外へのパス "../" などが含まれていないかチェックする
+// destDir 外へのパス "../" などが含まれていないかチェックする。
+// また、先に展開したシンボリックリンク (a -> . など) を経由して書き込むことがないよう、
+// destDir から targetPath までの途中のディレクトリが既存のシンボリックリンクであるパスも拒否する。
 func secureJoin(destDir, targetPath string) (string, error) {
 	joinedPath := filepath.Join(destDir, targetPath)
 	if !strings.HasPrefix(joinedPath, filepath.Clean(destDir)+string(os.PathSeparator)) && joinedPath != filepath.Clean(destDir) {
 		// joinedPath が destDir の外を指している場合
 		return "", fmt.Errorf("invalid path in archive: '%s' attempts to escape destination directory", targetPath)
 	}
+	if err := checkSymlinkParents(destDir, joinedPath); err != nil {
+		return "", fmt.Errorf("invalid path in archive: '%s': %w", targetPath, err)
+	}
 	return joinedPath, nil
 }
 
+// checkSymlinkParents は destDir 配下のパス p の親ディレクトリ (destDir 自身は除く) に
+// シンボリックリンクが含まれている場合にエラーを返す。存在しないディレクトリ以降は確認しない。
+func checkSymlinkParents(destDir, p string) error {
+	rel, err := filepath.Rel(destDir, filepath.Dir(p))
+	if err != nil || rel == "." {
+		return err
+	}
+	cur := filepath.Clean(destDir)
+	for _, name := range strings.Split(rel, string(os.PathSeparator)) {
+		cur = filepath.Join(cur, name)
+		info, err := os.Lstat(cur)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to check %s: %w", cur, err)
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("path passes through symlink %s", cur)
+		}
+	}
+	return nil
+}
+
+// secureLinkTarget はシンボリックリンク linkPath (destDir 配下) のリンク先 linkname が destDir の外を指していないか検証する。
+// 相対パスのリンク先はリンクのあるディレクトリ基準で解決して secureJoin と同じ判定を行う。絶対パスは常に拒否する。
+// "dir/../x" のように途中に ".." を含むリンク先は、dir がシンボリックリンクの場合 (後のメンバーで置き換えられる場合を含む) に
+// 字句上の解決結果と実際のリンク先が異なるため拒否する。".." はリンク先の先頭でのみ許可する。
+func secureLinkTarget(destDir, linkPath, linkname string) error {
+	if filepath.IsAbs(linkname) || strings.HasPrefix(linkname, "/") || strings.HasPrefix(linkname, `\`) {
+		return fmt.Errorf("invalid symlink in archive: '%s' points to absolute path '%s'", linkPath, linkname)
+	}
+	leading := true
+	for _, name := range strings.FieldsFunc(linkname, func(r rune) bool { return r == '/' || r == os.PathSeparator }) {
+		switch {
+		case name == "..":
+			if !leading {
+				return fmt.Errorf("invalid symlink in archive: '%s' -> '%s' has '..' after another path component", linkPath, linkname)
+			}
+		case name != ".":
+			leading = false
+		}
+	}
+	linkDir, err := filepath.Rel(destDir, filepath.Dir(linkPath))
+	if err != nil {
+		return fmt.Errorf("invalid symlink in archive: '%s': %w", linkPath, err)
+	}
+	if _, err := secureJoin(destDir, filepath.Join(linkDir, linkname)); err != nil {
+		return fmt.Errorf("invalid symlink in archive: '%s' -> '%s' escapes destination directory", linkPath, linkname)
+	}
+	return nil
+}
+
 // stripPathComponents はパス文字列から指定された数の先頭コンポーネントを削除する
 func stripPathComponents(path string, count int) string {
 	if count <= 0 {
@@ -59,38 +323,51 @@ func stripPathComponents(path string, count int) string {
 	return filepath.Join(components[count:]...)
 }
 
-// shouldExtract は strip/extractPaths を考慮してファイル/ディレクトリを展開すべきか判断する
-func shouldExtract(originalPath string, stripComponents int, extractPaths []string) (string, bool) {
+// shouldExtract は strip/extractPaths/exclude を考慮してファイル/ディレクトリを展開すべきか判断する
+func shouldExtract(originalPath string, stripComponents int, extractPaths, exclude []string) (string, bool) {
 	strippedPath := stripPathComponents(originalPath, stripComponents)
 	if strippedPath == "" {
 		return "", false // パスが空になった場合はスキップ
 	}
 
-	if len(extractPaths) == 0 {
-		return strippedPath, true // extractPaths がなければ常に展開
+	// extractPaths がなければ常に展開
+	if len(extractPaths) > 0 && !matchesAnyPath(strippedPath, extractPaths) {
+		return "", false // どのパターンにも一致しない
+	}
+	if matchesAnyPath(strippedPath, exclude) {
+		return "", false // 除外パターンに一致する
 	}
+	return strippedPath, true
+}
 
-	// extractPaths が指定されている場合、前方一致でチェック
-	for _, pattern := range extractPaths {
+// matchesAnyPath は strip 後のパスが patterns のいずれかに一致するか返す。
+// グロブは一致、それ以外は完全一致またはディレクトリの前方一致でチェックする。
+func matchesAnyPath(strippedPath string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if IsGlobPattern(pattern) {
+			if matchGlobOrParent(path.Clean(filepath.ToSlash(pattern)), filepath.ToSlash(strippedPath)) {
+				return true
+			}
+			continue
+		}
 		pattern = filepath.Clean(pattern) // パターンも正規化
 		// 1. 完全一致
 		if strippedPath == pattern {
-			return strippedPath, true
+			return true
 		}
 		// 2. ディレクトリ指定の場合 (パターンが "/" で終わるか、strippedPath がパターン + "/" で始まる)
 		if strings.HasSuffix(pattern, string(os.PathSeparator)) {
 			if strings.HasPrefix(strippedPath, pattern) {
-				return strippedPath, true
+				return true
 			}
 		} else {
 			// ファイル指定の場合、ディレクトリ内の一致も考慮
 			if strings.HasPrefix(strippedPath, pattern+string(os.PathSeparator)) {
-				return strippedPath, true
+				return true
 			}
 		}
 	}
-
-	return "", false // どのパターンにも一致しない
+	return false
 }
 
 // writeFile は io.Reader の内容をディスク上のファイルに書き込む
@@ -105,16 +382,11 @@ func writeFile(destPath string, reader io.Reader, mode os.FileMode, force bool)
 			return fmt.Errorf("failed to check destination file %s: %w", destPath, err)
 		}
 		// ファイルが存在しない場合は続行
-	} else {
-		// force=true の場合、既存ファイルを削除してから作成 (os.Createがトランケートするため不要かも)
-		// _, err := os.Stat(destPath)
-		// if err == nil {
-		//     if err := os.Remove(destPath); err != nil {
-		//          return fmt.Errorf("failed to remove existing file %s for overwrite: %w", destPath, err)
-		//     }
-		// } else if !os.IsNotExist(err) {
-		//     return fmt.Errorf("failed to check destination file %s before overwrite: %w", destPath, err)
-		// }
+	} else if info, err := os.Lstat(destPath); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		// 既存のシンボリックリンクはリンク先に書き込まず、リンク自体をファイルで置き換える
+		if err := os.Remove(destPath); err != nil {
+			return fmt.Errorf("failed to remove existing symlink %s for overwrite: %w", destPath, err)
+		}
 	}
 
 	// ディレクトリが存在しない場合は作成
@@ -138,9 +410,9 @@ func writeFile(destPath string, reader io.Reader, mode os.FileMode, force bool)
 	return nil
 }
 
-// checkOverwrite はファイル/ディレクトリの上書きを確認する (インタラクティブ or --force)
-// このサンプル実装ではインタラクティブな確認は省略し、force フラグのみ考慮
-func checkOverwrite(destPath string, isDir, force bool, logger *slog.Logger) (bool, error) {
+// checkOverwrite はファイル/ディレクトリの上書きを確認する (--force or インタラクティブ)。
+// force でなく confirmer が設定されている場合は、既存のファイルごとに上書きするか確認する。
+func (o *extractOptions) checkOverwrite(destPath string, isDir, force bool, logger *slog.Logger) (bool, error) {
 	stat, err := os.Stat(destPath)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -159,6 +431,21 @@ func checkOverwrite(destPath string, isDir, force bool, logger *slog.Logger) (bo
 			return false, fmt.Errorf("cannot overwrite path %s: type mismatch (file/directory)", destPath)
 		}
 		return true, nil // force=true なら上書きOK
+	} else if o.confirmer != nil {
+		if isDir && stat.IsDir() {
+			return true, nil // 既存のディレクトリは確認せずにそのまま使い、中のファイルごとに確認する
+		}
+		if stat.IsDir() != isDir {
+			return false, fmt.Errorf("cannot overwrite path %s: type mismatch (file/directory)", destPath)
+		}
+		overwrite, err := o.confirmer.ConfirmOverwrite(destPath)
+		if err != nil {
+			return false, err
+		}
+		if !overwrite {
+			logger.Info("Skipping extraction: keeping existing path", "path", destPath)
+		}
+		return overwrite, nil
 	} else {
 		// force=false で存在する場合
 		logger.Warn("Skipping extraction: destination path already exists. Use --force to overwrite.", "path", destPath)
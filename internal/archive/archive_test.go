@@ -0,0 +1,74 @@
+package archive
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveExtractor(t *testing.T) {
+	tarGzPath := writeTarGz(t, []tarEntry{{name: "bin/tool", body: "tool"}})
+	zipPath := writeZip(t, []string{"bin/tool"})
+	// 内容と一致しない拡張子 (誤解を招くURL) でアーカイブを置く
+	misnamed := func(src, name string) string {
+		data, err := os.ReadFile(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(p, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	tests := []struct {
+		name        string
+		archiveType string
+		filePath    string
+		want        Extractor
+		wantErr     string // 空ならエラーにならない
+	}{
+		{name: "extension", filePath: tarGzPath, want: &TarGzExtractor{}},
+		{name: "zip named tar.gz", archiveType: TypeZip, filePath: misnamed(zipPath, "tool.tar.gz"), want: &ZipExtractor{}},
+		{name: "tar.gz named zip", archiveType: TypeTarGz, filePath: misnamed(tarGzPath, "tool.zip"), want: &TarGzExtractor{}},
+		{name: "tar.gz without extension", archiveType: TypeTarGz, filePath: misnamed(tarGzPath, "download"), want: &TarGzExtractor{}},
+		{name: "unsupported type", archiveType: "rar", filePath: zipPath, wantErr: "unsupported archive type: rar"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			extractor, err := ResolveExtractor(tt.archiveType, tt.filePath)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ResolveExtractor() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveExtractor() error = %v", err)
+			}
+			if reflect.TypeOf(extractor) != reflect.TypeOf(tt.want) {
+				t.Fatalf("ResolveExtractor() = %T, want %T", extractor, tt.want)
+			}
+			destDir := t.TempDir()
+			if err := extractor.Extract(tt.filePath, destDir, 0, nil, true, nil); err != nil {
+				t.Fatalf("Extract() error = %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(destDir, "bin", "tool")); err != nil {
+				t.Errorf("bin/tool was not extracted: %v", err)
+			}
+		})
+	}
+}
+
+func TestNewExtractorSupportedTypes(t *testing.T) {
+	for _, archiveType := range SupportedTypes {
+		t.Run(archiveType, func(t *testing.T) {
+			if _, err := NewExtractor(archiveType); err != nil {
+				t.Errorf("NewExtractor(%q) error = %v", archiveType, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package archive
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// GzipExtractor は tar を含まない単一ファイルの .gz を展開する
+type GzipExtractor struct{ extractOptions }
+
+// Extract は .gz ファイルを展開するメソッド
+func (g *GzipExtractor) Extract(sourcePath, destDir string, stripComponents int, extractPaths []string, force bool, logger *slog.Logger) error {
+	return extractSingle(sourcePath, destDir, ".gz", force, &g.extractOptions, logger, func(r io.Reader) (io.Reader, error) {
+		return gzip.NewReader(r)
+	})
+}
+
+// Bzip2Extractor は tar を含まない単一ファイルの .bz2 を展開する
+type Bzip2Extractor struct{ extractOptions }
+
+// Extract は .bz2 ファイルを展開するメソッド
+func (b *Bzip2Extractor) Extract(sourcePath, destDir string, stripComponents int, extractPaths []string, force bool, logger *slog.Logger) error {
+	return extractSingle(sourcePath, destDir, ".bz2", force, &b.extractOptions, logger, func(r io.Reader) (io.Reader, error) {
+		return bzip2.NewReader(r), nil
+	})
+}
+
+// extractSingle は単一の圧縮ストリームを展開し、アーカイブ名から圧縮形式の拡張子を除いた名前で destDir に書き込む。
+// 展開結果はファイル1つのため、strip_components と extract_paths/extract_exclude は使わない。
+// rename にその名前が含まれていれば変換後のパスに書き込む。
+func extractSingle(sourcePath, destDir, suffix string, force bool, opts *extractOptions, logger *slog.Logger, newReader func(io.Reader) (io.Reader, error)) error {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	name := filepath.Base(sourcePath)
+	if strings.HasSuffix(strings.ToLower(name), suffix) {
+		name = name[:len(name)-len(suffix)]
+	}
+	if name == "" {
+		return fmt.Errorf("cannot determine output file name for %s", sourcePath)
+	}
+	destPath, err := secureJoin(destDir, opts.renamed(name))
+	if err != nil {
+		return err
+	}
+	logger.Info("Decompressing single-file archive", "source", sourcePath, "destination", destPath, "force", force)
+
+	proceed, err := opts.checkOverwrite(destPath, false, force, logger)
+	if err != nil {
+		return err
+	}
+	if !proceed {
+		return nil
+	}
+
+	file, err := os.Open(sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to open compressed file %s: %w", sourcePath, err)
+	}
+	defer file.Close()
+
+	r, err := newReader(bufio.NewReader(file))
+	if err != nil {
+		return fmt.Errorf("failed to create %s reader for %s: %w", strings.TrimPrefix(suffix, "."), sourcePath, err)
+	}
+
+	// 単一ファイルは主に実行ファイルの配布に使われるため、実行権限を付与する
+	if err := writeFile(destPath, r, 0755, true); err != nil { // 上書きするかは checkOverwrite で確認済み
+		return err
+	}
+	opts.extracted(destPath)
+	logger.Info("Single-file archive decompressed successfully", "source", sourcePath, "destination", destPath)
+	return nil
+}
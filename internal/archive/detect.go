@@ -0,0 +1,90 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// 各形式のファイル先頭のマジックナンバー
+var (
+	zipMagic      = []byte("PK\x03\x04")
+	zipEmptyMagic = []byte("PK\x05\x06") // エントリのない zip
+	sevenZMagic   = []byte("7z\xbc\xaf\x27\x1c")
+	gzipMagic     = []byte{0x1f, 0x8b}
+	xzMagic       = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	zstdMagic     = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	bzip2Magic    = []byte("BZh")
+)
+
+// DetectType はファイルの内容 (先頭のマジックナンバー) からアーカイブ形式を判定する。
+// 圧縮形式の場合は展開した先頭が tar ヘッダかどうかで tar.* と単一ファイルを区別する。
+// 拡張子で判定できないURL (download?id=123 など) 向け。
+func DetectType(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	br := bufio.NewReader(file)
+	head, _ := br.Peek(8) // 8 バイト未満のファイルでも読めた分で判定する
+
+	switch {
+	case bytes.HasPrefix(head, zipMagic), bytes.HasPrefix(head, zipEmptyMagic):
+		return TypeZip, nil
+	case bytes.HasPrefix(head, sevenZMagic):
+		return TypeSevenZ, nil
+	case bytes.HasPrefix(head, gzipMagic):
+		gzr, err := gzip.NewReader(br)
+		if err != nil {
+			return "", fmt.Errorf("failed to read gzip data of %s: %w", filePath, err)
+		}
+		defer gzr.Close()
+		if isTar(gzr) {
+			return TypeTarGz, nil
+		}
+		return TypeGzip, nil
+	case bytes.HasPrefix(head, xzMagic):
+		xzr, err := xz.NewReader(br)
+		if err != nil {
+			return "", fmt.Errorf("failed to read xz data of %s: %w", filePath, err)
+		}
+		if isTar(xzr) {
+			return TypeTarXz, nil
+		}
+		return "", fmt.Errorf("%s is xz-compressed but not a tar archive (single-file xz is not supported)", filePath)
+	case bytes.HasPrefix(head, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return "", fmt.Errorf("failed to read zstd data of %s: %w", filePath, err)
+		}
+		defer zr.Close()
+		if isTar(zr) {
+			return TypeTarZst, nil
+		}
+		return "", fmt.Errorf("%s is zstd-compressed but not a tar archive (single-file zstd is not supported)", filePath)
+	case bytes.HasPrefix(head, bzip2Magic):
+		if isTar(bzip2.NewReader(br)) {
+			return "", fmt.Errorf("%s is a bzip2-compressed tar archive, which is not supported", filePath)
+		}
+		return TypeBzip2, nil
+	}
+	return "", fmt.Errorf("could not detect archive format of %s from its content", filePath)
+}
+
+// isTar は r の先頭が tar ヘッダ (POSIX ustar / GNU のマジック) かどうかを返す
+func isTar(r io.Reader) bool {
+	header := make([]byte, 512)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return false
+	}
+	return bytes.Equal(header[257:262], []byte("ustar"))
+}
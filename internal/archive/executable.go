@@ -0,0 +1,125 @@
+package archive
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// MarkExecutable は files (destDir 配下に展開したファイルのパス) の通常ファイルのうち、destDir からの相対パス ("/" 区切り) が
+// patterns (path.Match 形式) のいずれかに一致するものに実行権限を付与する。
+// アーカイブ内のパーミッションが保持されていない場合でも、メインのバイナリなどを実行可能にするために使う。
+// 展開先に元からあったファイルには触れないよう、files には OnExtract で記録した今回展開したファイルを渡す。
+// 実行権限を付与したファイルの相対パスを返す。
+func MarkExecutable(destDir string, files, patterns []string, logger *slog.Logger) ([]string, error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+
+	var marked []string
+	for _, p := range files {
+		relPath, err := filepath.Rel(destDir, p)
+		if err != nil {
+			return nil, err
+		}
+		relPath = filepath.ToSlash(relPath)
+		if !matchesAny(relPath, patterns) {
+			continue
+		}
+		info, err := os.Lstat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.Mode().IsRegular() {
+			continue // 後のメンバーでシンボリックリンクなどに置き換えられた場合は対象外
+		}
+		// 読み取り権限のあるユーザーに実行権限を付与する (chmod +x と同様)
+		mode := info.Mode().Perm()
+		mode |= (mode & 0444) >> 2
+		if err := os.Chmod(p, mode); err != nil {
+			return nil, fmt.Errorf("failed to set executable permission on %s: %w", p, err)
+		}
+		logger.Debug("Set executable permission", "path", p, "mode", mode)
+		marked = append(marked, relPath)
+	}
+	return marked, nil
+}
+
+// matchesAny は name が patterns のいずれかに一致する場合に true を返す
+func matchesAny(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok { // パターンの構文は設定読み込み時に検証済み
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyMode は files (展開したファイルのパス) の通常ファイルのパーミッションを mode に変更し、変更したファイル数を返す。
+// 設定ファイルの mode でアーカイブ内のパーミッションを上書きするために使う。
+// 展開先に元からあったファイルには触れないよう、files には OnExtract で記録した今回展開したファイルを渡す。
+func ApplyMode(files []string, mode fs.FileMode, logger *slog.Logger) (int, error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	count := 0
+	for _, p := range files {
+		info, err := os.Lstat(p)
+		if err != nil {
+			return 0, err
+		}
+		if !info.Mode().IsRegular() {
+			continue // 後のメンバーでシンボリックリンクなどに置き換えられた場合は対象外
+		}
+		if err := os.Chmod(p, mode); err != nil {
+			return 0, fmt.Errorf("failed to set permission on %s: %w", p, err)
+		}
+		logger.Debug("Set permission", "path", p, "mode", mode)
+		count++
+	}
+	return count, nil
+}
+
+// executableMagics は実行ファイルとみなすファイル先頭のバイト列
+var executableMagics = [][]byte{
+	[]byte("\x7fELF"),        // ELF
+	[]byte("MZ"),             // PE (Windows)
+	[]byte("#!"),             // シェバン付きのスクリプト
+	{0xfe, 0xed, 0xfa, 0xce}, // Mach-O 32-bit (big endian)
+	{0xfe, 0xed, 0xfa, 0xcf}, // Mach-O 64-bit (big endian)
+	{0xce, 0xfa, 0xed, 0xfe}, // Mach-O 32-bit (little endian)
+	{0xcf, 0xfa, 0xed, 0xfe}, // Mach-O 64-bit (little endian)
+	{0xca, 0xfe, 0xba, 0xbe}, // Mach-O Universal Binary
+}
+
+// LooksExecutable はファイルの先頭のバイト列から実行ファイル (ELF, PE, Mach-O, シェバン付きのスクリプト) かどうかを判定する
+func LooksExecutable(p string) (bool, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	head := make([]byte, 4)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return false, fmt.Errorf("failed to read %s: %w", p, err)
+	}
+	head = head[:n]
+	for _, magic := range executableMagics {
+		if bytes.HasPrefix(head, magic) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
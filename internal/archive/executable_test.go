@@ -0,0 +1,178 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// tarEntry はテスト用の tar.gz に含めるメンバー
+type tarEntry struct {
+	name     string
+	typeflag byte
+	linkname string
+	body     string
+	mode     int64
+}
+
+// writeTarGz は entries を含む tar.gz を一時ディレクトリに作成し、そのパスを返す
+func writeTarGz(t *testing.T, entries []tarEntry) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "test.tar.gz")
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gw := gzip.NewWriter(f)
+	writeTarEntries(t, gw, entries)
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// writeTarEntries は entries を tar として w に書き込む
+func writeTarEntries(t *testing.T, w io.Writer, entries []tarEntry) {
+	t.Helper()
+	tw := tar.NewWriter(w)
+	for _, e := range entries {
+		typeflag := e.typeflag
+		if typeflag == 0 {
+			typeflag = tar.TypeReg
+		}
+		mode := e.mode
+		if mode == 0 {
+			mode = 0644
+		}
+		hdr := &tar.Header{Name: e.name, Typeflag: typeflag, Linkname: e.linkname, Mode: mode, Size: int64(len(e.body))}
+		if typeflag != tar.TypeReg {
+			hdr.Size = 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Size > 0 {
+			if _, err := tw.Write([]byte(e.body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// extractRecording は src を destDir に展開し、OnExtract で記録した展開先のパスを返す
+func extractRecording(t *testing.T, src, destDir string) []string {
+	t.Helper()
+	var extracted []string
+	e := OnExtract(&TarGzExtractor{}, func(path string) { extracted = append(extracted, path) })
+	if err := e.Extract(src, destDir, 0, nil, true, nil); err != nil {
+		t.Fatalf("Extract() error = %v", err)
+	}
+	return extracted
+}
+
+func TestApplyModeOnlyExtractedFiles(t *testing.T) {
+	destDir := t.TempDir()
+	existing := filepath.Join(destDir, "existing")
+	if err := os.WriteFile(existing, []byte("keep"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	src := writeTarGz(t, []tarEntry{
+		{name: "bin/", typeflag: tar.TypeDir, mode: 0755},
+		{name: "bin/tool", body: "tool"},
+		{name: "README", body: "readme"},
+	})
+
+	extracted := extractRecording(t, src, destDir)
+	count, err := ApplyMode(extracted, 0640, nil)
+	if err != nil {
+		t.Fatalf("ApplyMode() error = %v", err)
+	}
+	if count != 2 {
+		t.Errorf("ApplyMode() = %d, want 2", count)
+	}
+
+	tests := []struct {
+		path string
+		want fs.FileMode
+	}{
+		{path: "bin/tool", want: 0640},
+		{path: "README", want: 0640},
+		{path: "existing", want: 0600}, // 展開していないファイルは変更しない
+	}
+	for _, tt := range tests {
+		info, err := os.Stat(filepath.Join(destDir, tt.path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode().Perm(); got != tt.want {
+			t.Errorf("mode of %s = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestOnExtractSkipsDirectoriesAndSymlinks(t *testing.T) {
+	destDir := t.TempDir()
+	src := writeTarGz(t, []tarEntry{
+		{name: "dir/", typeflag: tar.TypeDir, mode: 0755},
+		{name: "dir/file", body: "file"},
+		{name: "link", typeflag: tar.TypeSymlink, linkname: "dir/file"},
+	})
+
+	extracted := extractRecording(t, src, destDir)
+	want := []string{filepath.Join(destDir, "dir", "file")}
+	if !slices.Equal(extracted, want) {
+		t.Errorf("extracted = %v, want %v", extracted, want)
+	}
+}
+
+func TestMarkExecutableOnlyExtractedFiles(t *testing.T) {
+	destDir := t.TempDir()
+	// 展開先に元からある、パターンに一致するファイル
+	existing := filepath.Join(destDir, "bin", "other")
+	if err := os.MkdirAll(filepath.Dir(existing), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(existing, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src := writeTarGz(t, []tarEntry{
+		{name: "bin/tool", body: "tool"},
+		{name: "README", body: "readme"},
+	})
+
+	extracted := extractRecording(t, src, destDir)
+	marked, err := MarkExecutable(destDir, extracted, []string{"bin/*"}, nil)
+	if err != nil {
+		t.Fatalf("MarkExecutable() error = %v", err)
+	}
+	if want := []string{"bin/tool"}; !slices.Equal(marked, want) {
+		t.Errorf("MarkExecutable() = %v, want %v", marked, want)
+	}
+
+	tests := []struct {
+		path string
+		want fs.FileMode
+	}{
+		{path: "bin/tool", want: 0755},
+		{path: "README", want: 0644},    // パターンに一致しない
+		{path: "bin/other", want: 0644}, // 展開していないファイルは変更しない
+	}
+	for _, tt := range tests {
+		info, err := os.Stat(filepath.Join(destDir, tt.path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode().Perm(); got != tt.want {
+			t.Errorf("mode of %s = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
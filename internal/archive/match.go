@@ -0,0 +1,69 @@
+package archive
+
+import (
+	"path"
+	"strings"
+)
+
+// IsGlobPattern は extract_paths のパターンがグロブ (*, ?, [...] を含む) の場合に true を返す。
+// グロブでないパターンは従来どおり完全一致またはディレクトリの前方一致で扱う。
+func IsGlobPattern(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// ValidateGlob はグロブの構文を検証する
+func ValidateGlob(pattern string) error {
+	for _, segment := range strings.Split(pattern, "/") {
+		if segment == "**" {
+			continue
+		}
+		if _, err := path.Match(segment, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MatchGlob は "/" 区切りのパス name がグロブ pattern に一致するか返す。
+// 各要素は path.Match 形式で比較し、要素全体が "**" の場合は0個以上の任意の要素に一致する。
+// 構文の誤りは ValidateGlob で検証済みである前提とし、一致しないものとして扱う。
+func MatchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// "**" が0個以上の要素に一致するとして残りを試す
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// matchGlobOrParent は name またはその親ディレクトリのいずれかがグロブ pattern に一致するか返す。
+// ディレクトリに一致するパターン ("bin/*" に対する "bin/sub" など) はその中身全体を対象にする。
+func matchGlobOrParent(pattern, name string) bool {
+	for {
+		if MatchGlob(pattern, name) {
+			return true
+		}
+		i := strings.LastIndex(name, "/")
+		if i < 0 {
+			return false
+		}
+		name = name[:i]
+	}
+}
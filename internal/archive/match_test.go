@@ -0,0 +1,193 @@
+package archive
+
+import (
+	"archive/zip"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		// *: 1つの要素の中の任意の文字列 ("/" を含まない)
+		{pattern: "bin/*", name: "bin/tool", want: true},
+		{pattern: "bin/*", name: "bin/sub/tool", want: false},
+		{pattern: "bin/*", name: "bin", want: false},
+		{pattern: "*.so", name: "libfoo.so", want: true},
+		{pattern: "*.so", name: "lib/libfoo.so", want: false},
+		{pattern: "lib*/foo", name: "lib64/foo", want: true},
+		// **: 0個以上の任意の要素
+		{pattern: "**/*.so", name: "libfoo.so", want: true},
+		{pattern: "**/*.so", name: "lib/libfoo.so", want: true},
+		{pattern: "**/*.so", name: "usr/lib/x86_64/libfoo.so", want: true},
+		{pattern: "**/*.so", name: "lib/libfoo.so.1", want: false},
+		{pattern: "share/**", name: "share/man/man1/tool.1", want: true},
+		{pattern: "share/**", name: "share", want: true},
+		{pattern: "a/**/z", name: "a/z", want: true},
+		{pattern: "a/**/z", name: "a/b/c/z", want: true},
+		{pattern: "a/**/z", name: "a/b/c/y", want: false},
+		{pattern: "**", name: "any/path/at/all", want: true},
+		// ?: 1文字 ("/" を除く)
+		{pattern: "doc/?.txt", name: "doc/a.txt", want: true},
+		{pattern: "doc/?.txt", name: "doc/ab.txt", want: false},
+		{pattern: "doc/?.txt", name: "doc/.txt", want: false},
+		{pattern: "bin/tool?", name: "bin/tool/", want: false},
+		// [...]: 文字クラス
+		{pattern: "bin/tool-[ab]", name: "bin/tool-a", want: true},
+		{pattern: "bin/tool-[ab]", name: "bin/tool-c", want: false},
+		// 要素の一部の ** は * と同じ
+		{pattern: "bin/**tool", name: "bin/mytool", want: true},
+		{pattern: "bin/**tool", name: "bin/sub/mytool", want: false},
+	}
+	for _, tt := range tests {
+		if got := MatchGlob(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("MatchGlob(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValidateGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		wantErr bool
+	}{
+		{pattern: "bin/*"},
+		{pattern: "**/*.so"},
+		{pattern: "doc/?.txt"},
+		{pattern: "bin/[a-z]*"},
+		{pattern: "bin/[a-", wantErr: true},
+		{pattern: "**/[", wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := ValidateGlob(tt.pattern); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateGlob(%q) error = %v, wantErr %v", tt.pattern, err, tt.wantErr)
+		}
+	}
+}
+
+func TestShouldExtract(t *testing.T) {
+	tests := []struct {
+		name         string
+		path         string
+		strip        int
+		extractPaths []string
+		exclude      []string
+		want         bool
+	}{
+		{name: "no patterns", path: "any/file", want: true},
+		{name: "literal file", path: "bin/tool", extractPaths: []string{"bin/tool"}, want: true},
+		{name: "literal directory", path: "bin/sub/tool", extractPaths: []string{"bin"}, want: true},
+		{name: "literal does not prefix-match names", path: "binary", extractPaths: []string{"bin"}, want: false},
+		{name: "star", path: "bin/tool", extractPaths: []string{"bin/*"}, want: true},
+		{name: "star includes matched directory contents", path: "bin/sub/tool", extractPaths: []string{"bin/*"}, want: true},
+		{name: "star other directory", path: "lib/tool", extractPaths: []string{"bin/*"}, want: false},
+		{name: "double star", path: "usr/lib/libfoo.so", extractPaths: []string{"**/*.so"}, want: true},
+		{name: "double star no match", path: "usr/lib/libfoo.a", extractPaths: []string{"**/*.so"}, want: false},
+		{name: "question mark", path: "doc/a.txt", extractPaths: []string{"doc/?.txt"}, want: true},
+		{name: "question mark too long", path: "doc/ab.txt", extractPaths: []string{"doc/?.txt"}, want: false},
+		{name: "glob after strip", path: "tool-1.0/bin/tool", strip: 1, extractPaths: []string{"bin/*"}, want: true},
+		{name: "glob before strip does not match", path: "tool-1.0/bin/tool", strip: 1, extractPaths: []string{"*/bin/*"}, want: false},
+		{name: "glob exclude", path: "lib/libfoo.so", extractPaths: []string{"lib"}, exclude: []string{"**/*.so"}, want: false},
+		{name: "literal exclude after glob", path: "bin/debug", extractPaths: []string{"bin/*"}, exclude: []string{"bin/debug"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, got := shouldExtract(tt.path, tt.strip, tt.extractPaths, tt.exclude)
+			if got != tt.want {
+				t.Errorf("shouldExtract(%q, %d, %v, %v) = %v, want %v", tt.path, tt.strip, tt.extractPaths, tt.exclude, got, tt.want)
+			}
+		})
+	}
+}
+
+// writeZip は names のファイルを含む zip を一時ディレクトリに作成し、そのパスを返す
+func writeZip(t *testing.T, names []string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "test.zip")
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for _, name := range names {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestExtractGlobPaths(t *testing.T) {
+	names := []string{
+		"bin/tool",
+		"bin/helper",
+		"bin/sub/nested",
+		"lib/libfoo.so",
+		"lib/x86_64/libbar.so",
+		"lib/libfoo.a",
+		"doc/a.txt",
+		"doc/ab.txt",
+		"README",
+	}
+	tests := []struct {
+		name         string
+		extractPaths []string
+		want         []string
+	}{
+		{name: "star", extractPaths: []string{"bin/*"}, want: []string{"bin/helper", "bin/sub/nested", "bin/tool"}},
+		{name: "double star", extractPaths: []string{"**/*.so"}, want: []string{"lib/libfoo.so", "lib/x86_64/libbar.so"}},
+		{name: "question mark", extractPaths: []string{"doc/?.txt"}, want: []string{"doc/a.txt"}},
+		{name: "glob and literal", extractPaths: []string{"README", "lib/*.a"}, want: []string{"README", "lib/libfoo.a"}},
+	}
+	for _, format := range []string{"tar.gz", "zip"} {
+		for _, tt := range tests {
+			t.Run(format+"/"+tt.name, func(t *testing.T) {
+				var src string
+				var extractor Extractor
+				if format == "zip" {
+					src, extractor = writeZip(t, names), &ZipExtractor{}
+				} else {
+					var entries []tarEntry
+					for _, name := range names {
+						entries = append(entries, tarEntry{name: name, body: name})
+					}
+					src, extractor = writeTarGz(t, entries), &TarGzExtractor{}
+				}
+				destDir := t.TempDir()
+				if err := extractor.Extract(src, destDir, 0, tt.extractPaths, true, nil); err != nil {
+					t.Fatalf("Extract() error = %v", err)
+				}
+				var got []string
+				err := filepath.WalkDir(destDir, func(p string, d fs.DirEntry, err error) error {
+					if err != nil || d.IsDir() {
+						return err
+					}
+					rel, err := filepath.Rel(destDir, p)
+					got = append(got, filepath.ToSlash(rel))
+					return err
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				slices.Sort(got)
+				if !slices.Equal(got, tt.want) {
+					t.Errorf("extracted %v, want %v", got, tt.want)
+				}
+			})
+		}
+	}
+}
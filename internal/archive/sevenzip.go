@@ -0,0 +1,108 @@
+package archive
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/bodgit/sevenzip"
+)
+
+// SevenZipExtractor は 7-Zip (.7z) ファイルを展開する
+type SevenZipExtractor struct{ extractOptions }
+
+// Extract は 7-Zip ファイルを展開するメソッド
+func (s *SevenZipExtractor) Extract(sourcePath, destDir string, stripComponents int, extractPaths []string, force bool, logger *slog.Logger) error {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger.Info("Extracting 7z archive", "source", sourcePath, "destination", destDir, "strip", stripComponents, "force", force)
+
+	r, err := sevenzip.OpenReader(sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to open 7z file %s: %w", sourcePath, err)
+	}
+	defer r.Close()
+
+	// 展開先ディレクトリが存在しない場合は作成
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return fmt.Errorf("failed to create destination directory %s: %w", destDir, err)
+	}
+
+	flat := make(flattener)
+	for _, f := range r.File {
+		// strip/extractPaths を考慮して展開すべきか、最終的な相対パスは何かを取得
+		targetRelPath, should := shouldExtract(f.Name, stripComponents, extractPaths, s.exclude)
+		if !should {
+			logger.Debug("Skipping entry based on strip/extract paths", "original_path", f.Name)
+			continue
+		}
+		if renamed := s.renamed(targetRelPath); renamed != targetRelPath {
+			logger.Debug("Renaming entry", "original_path", f.Name, "path", renamed)
+			targetRelPath = renamed
+		}
+		if s.flatten {
+			if f.FileInfo().IsDir() {
+				continue // ディレクトリは作成しない
+			}
+			if targetRelPath, err = flat.flatten(targetRelPath, f.Name); err != nil {
+				return err
+			}
+		}
+
+		// Zip Slip 攻撃を防ぎつつ、最終的な展開先パスを計算
+		finalDestPath, err := secureJoin(destDir, targetRelPath)
+		if err != nil {
+			logger.Error("Skipping potentially unsafe path", "original_path", f.Name, "error", err)
+			continue // 安全でないパスはスキップ
+		}
+		// logger.Debug("Processing archive entry", "original_path", f.Name, "target_relative_path", targetRelPath, "final_destination", finalDestPath)
+
+		if f.FileInfo().IsDir() {
+			// ディレクトリの場合
+			proceed, err := s.checkOverwrite(finalDestPath, true, force, logger)
+			if err != nil {
+				return err // Statエラーなど
+			}
+			if !proceed {
+				continue // 上書きしない場合はスキップ
+			}
+			logger.Debug("Creating directory", "path", finalDestPath)
+			if err := os.MkdirAll(finalDestPath, s.dirMode(f.Mode())); err != nil {
+				return fmt.Errorf("failed to create directory %s: %w", finalDestPath, err)
+			}
+		} else {
+			// ファイルの場合
+			proceed, err := s.checkOverwrite(finalDestPath, false, force, logger)
+			if err != nil {
+				return err
+			}
+			if !proceed {
+				continue
+			}
+
+			// ディレクトリが存在しない場合は作成 (writeFile 内でも行うが念のため)
+			if err := os.MkdirAll(filepath.Dir(finalDestPath), 0755); err != nil {
+				return fmt.Errorf("failed to create directory for file %s: %w", finalDestPath, err)
+			}
+
+			rc, err := f.Open()
+			if err != nil {
+				return fmt.Errorf("failed to open file in 7z archive %s: %w", f.Name, err)
+			}
+
+			mode := s.fileMode(f.Mode())
+			logger.Debug("Extracting file", "path", finalDestPath, "mode", mode)
+			// 上書きするかは checkOverwrite で確認済み
+			err = writeFile(finalDestPath, rc, mode, true)
+			rc.Close() // 必ず閉じる
+			if err != nil {
+				return fmt.Errorf("failed to extract file %s: %w", f.Name, err)
+			}
+			s.extracted(finalDestPath)
+		}
+	}
+	logger.Info("7z archive extracted successfully", "source", sourcePath)
+	return nil
+}
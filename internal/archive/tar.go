@@ -2,17 +2,20 @@ package archive
 
 import (
 	"archive/tar"
+	"bufio"
 	"compress/gzip"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
 	"path/filepath"
-	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
 )
 
 // TarGzExtractor は Tar.gz ファイルを展開する
-type TarGzExtractor struct{}
+type TarGzExtractor struct{ extractOptions }
 
 // Extract は Tar.gz ファイルを展開するメソッド
 func (t *TarGzExtractor) Extract(sourcePath, destDir string, stripComponents int, extractPaths []string, force bool, logger *slog.Logger) error {
@@ -33,13 +36,81 @@ func (t *TarGzExtractor) Extract(sourcePath, destDir string, stripComponents int
 	}
 	defer gzr.Close()
 
-	tr := tar.NewReader(gzr)
+	if err := extractTar(gzr, destDir, stripComponents, extractPaths, force, &t.extractOptions, logger); err != nil {
+		return err
+	}
+	logger.Info("Tar.gz archive extracted successfully", "source", sourcePath)
+	return nil
+}
+
+// TarXzExtractor は Tar.xz ファイルを展開する
+type TarXzExtractor struct{ extractOptions }
+
+// Extract は Tar.xz ファイルを展開するメソッド
+func (t *TarXzExtractor) Extract(sourcePath, destDir string, stripComponents int, extractPaths []string, force bool, logger *slog.Logger) error {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger.Info("Extracting tar.xz archive", "source", sourcePath, "destination", destDir, "strip", stripComponents, "force", force)
+
+	file, err := os.Open(sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to open tar.xz file %s: %w", sourcePath, err)
+	}
+	defer file.Close()
+
+	xzr, err := xz.NewReader(bufio.NewReader(file))
+	if err != nil {
+		return fmt.Errorf("failed to create xz reader for %s: %w", sourcePath, err)
+	}
+
+	if err := extractTar(xzr, destDir, stripComponents, extractPaths, force, &t.extractOptions, logger); err != nil {
+		return err
+	}
+	logger.Info("Tar.xz archive extracted successfully", "source", sourcePath)
+	return nil
+}
+
+// TarZstExtractor は Tar.zst (Zstandard) ファイルを展開する
+type TarZstExtractor struct{ extractOptions }
+
+// Extract は Tar.zst ファイルを展開するメソッド
+func (t *TarZstExtractor) Extract(sourcePath, destDir string, stripComponents int, extractPaths []string, force bool, logger *slog.Logger) error {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger.Info("Extracting tar.zst archive", "source", sourcePath, "destination", destDir, "strip", stripComponents, "force", force)
+
+	file, err := os.Open(sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to open tar.zst file %s: %w", sourcePath, err)
+	}
+	defer file.Close()
+
+	zr, err := zstd.NewReader(bufio.NewReader(file))
+	if err != nil {
+		return fmt.Errorf("failed to create zstd reader for %s: %w", sourcePath, err)
+	}
+	defer zr.Close()
+
+	if err := extractTar(zr, destDir, stripComponents, extractPaths, force, &t.extractOptions, logger); err != nil {
+		return err
+	}
+	logger.Info("Tar.zst archive extracted successfully", "source", sourcePath)
+	return nil
+}
+
+// extractTar は非圧縮の tar ストリームを destDir に展開する。
+// 圧縮形式ごとの Extractor は展開したストリームをこの関数に渡す。opts は Extractor に埋め込まれた設定。
+func extractTar(reader io.Reader, destDir string, stripComponents int, extractPaths []string, force bool, opts *extractOptions, logger *slog.Logger) error {
+	tr := tar.NewReader(reader)
 
 	// 展開先ディレクトリが存在しない場合は作成
 	if err := os.MkdirAll(destDir, 0755); err != nil {
 		return fmt.Errorf("failed to create destination directory %s: %w", destDir, err)
 	}
 
+	flat := make(flattener)
 	for {
 		header, err := tr.Next()
 		if err == io.EOF {
@@ -50,11 +121,23 @@ func (t *TarGzExtractor) Extract(sourcePath, destDir string, stripComponents int
 		}
 
 		// strip/extractPaths を考慮して展開すべきか、最終的な相対パスは何かを取得
-		targetRelPath, should := shouldExtract(header.Name, stripComponents, extractPaths)
+		targetRelPath, should := shouldExtract(header.Name, stripComponents, extractPaths, opts.exclude)
 		if !should {
 			logger.Debug("Skipping entry based on strip/extract paths", "original_path", header.Name)
 			continue
 		}
+		if renamed := opts.renamed(targetRelPath); renamed != targetRelPath {
+			logger.Debug("Renaming entry", "original_path", header.Name, "path", renamed)
+			targetRelPath = renamed
+		}
+		if opts.flatten {
+			if header.Typeflag == tar.TypeDir {
+				continue // ディレクトリは作成しない
+			}
+			if targetRelPath, err = flat.flatten(targetRelPath, header.Name); err != nil {
+				return err
+			}
+		}
 
 		// Zip Slip 攻撃を防ぎつつ、最終的な展開先パスを計算
 		finalDestPath, err := secureJoin(destDir, targetRelPath)
@@ -70,20 +153,21 @@ func (t *TarGzExtractor) Extract(sourcePath, destDir string, stripComponents int
 		switch header.Typeflag {
 		case tar.TypeDir:
 			// ディレクトリの場合
-			proceed, err := checkOverwrite(finalDestPath, true, force, logger)
+			proceed, err := opts.checkOverwrite(finalDestPath, true, force, logger)
 			if err != nil {
 				return err
 			}
 			if !proceed {
 				continue
 			}
+			mode = opts.dirMode(mode)
 			logger.Debug("Creating directory", "path", finalDestPath, "mode", mode)
 			if err := os.MkdirAll(finalDestPath, mode); err != nil {
 				return fmt.Errorf("failed to create directory %s: %w", finalDestPath, err)
 			}
 		case tar.TypeReg:
 			// 通常ファイルの場合
-			proceed, err := checkOverwrite(finalDestPath, false, force, logger)
+			proceed, err := opts.checkOverwrite(finalDestPath, false, force, logger)
 			if err != nil {
 				return err
 			}
@@ -96,19 +180,21 @@ func (t *TarGzExtractor) Extract(sourcePath, destDir string, stripComponents int
 				return fmt.Errorf("failed to create directory for file %s: %w", finalDestPath, err)
 			}
 
+			mode = opts.fileMode(mode)
 			logger.Debug("Extracting file", "path", finalDestPath, "mode", mode)
-			// writeFile 内で force フラグが考慮される
-			err = writeFile(finalDestPath, tr, mode, force) // tr (tar.Reader) は io.Reader を満たす
+			// 上書きするかは checkOverwrite で確認済み
+			err = writeFile(finalDestPath, tr, mode, true) // tr (tar.Reader) は io.Reader を満たす
 			if err != nil {
-				if strings.Contains(err.Error(), "destination file already exists") {
-					logger.Warn("Skipping existing file", "path", finalDestPath)
-					continue
-				}
 				return fmt.Errorf("failed to extract file %s: %w", header.Name, err)
 			}
+			opts.extracted(finalDestPath)
 		case tar.TypeSymlink:
-			// シンボリックリンクの場合 (注意: セキュリティリスクの可能性)
-			proceed, err := checkOverwrite(finalDestPath, false, force, logger) // Link もファイルとして扱う
+			// シンボリックリンクの場合。展開先の外 (/etc/passwd や ../../outside など) を指すリンクは作成しない
+			if err := secureLinkTarget(destDir, finalDestPath, header.Linkname); err != nil {
+				logger.Error("Skipping potentially unsafe symlink", "original_path", header.Name, "target", header.Linkname, "error", err)
+				continue
+			}
+			proceed, err := opts.checkOverwrite(finalDestPath, false, force, logger) // Link もファイルとして扱う
 			if err != nil {
 				return err
 			}
@@ -134,6 +220,5 @@ func (t *TarGzExtractor) Extract(sourcePath, destDir string, stripComponents int
 			logger.Warn("Unsupported tar entry type", "type", header.Typeflag, "name", header.Name)
 		}
 	}
-	logger.Info("Tar.gz archive extracted successfully", "source", sourcePath)
 	return nil
 }
@@ -0,0 +1,213 @@
+package archive
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestExtractTarSymlinkEscape(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tarEntry
+		wantErr bool // 展開を中断する場合
+		// check は展開先 destDir の状態を検証する (展開先の外に書き込まれていないことは共通で確認する)
+		check func(t *testing.T, destDir string)
+	}{
+		{
+			name: "write through symlink chain",
+			entries: []tarEntry{
+				{name: "a", typeflag: tar.TypeSymlink, linkname: "."},
+				{name: "a/b", typeflag: tar.TypeSymlink, linkname: ".."}, // 字句上は展開先だが、実際は a -> . なので親ディレクトリ
+				{name: "a/b/escaped", body: "pwned"},
+			},
+			check: func(t *testing.T, destDir string) {
+				assertNotExist(t, filepath.Join(destDir, "b"))
+			},
+		},
+		{
+			name: "dotdot after symlinked component",
+			entries: []tarEntry{
+				{name: "d/", typeflag: tar.TypeDir, mode: 0755},
+				{name: "x", typeflag: tar.TypeSymlink, linkname: "d/../../escaped"},
+				{name: "y", typeflag: tar.TypeSymlink, linkname: "d/../escaped"}, // d を後で置き換えると外を指す
+				{name: "d", typeflag: tar.TypeSymlink, linkname: "."},            // ディレクトリはシンボリックリンクで置き換えない
+				{name: "y", body: "pwned"},
+			},
+			wantErr: true,
+			check: func(t *testing.T, destDir string) {
+				assertNotExist(t, filepath.Join(destDir, "x"))
+				if info, err := os.Lstat(filepath.Join(destDir, "d")); err != nil || !info.IsDir() {
+					t.Errorf("directory d was replaced (info: %v, error: %v)", info, err)
+				}
+			},
+		},
+		{
+			name: "write into symlinked directory",
+			entries: []tarEntry{
+				{name: "sub/", typeflag: tar.TypeDir, mode: 0755},
+				{name: "link", typeflag: tar.TypeSymlink, linkname: "sub"},
+				{name: "link/file", body: "data"},
+			},
+			check: func(t *testing.T, destDir string) {
+				assertNotExist(t, filepath.Join(destDir, "sub", "file"))
+			},
+		},
+		{
+			name: "file replaces symlink",
+			entries: []tarEntry{
+				{name: "target", body: "original"},
+				{name: "link", typeflag: tar.TypeSymlink, linkname: "target"},
+				{name: "link", body: "replaced"},
+			},
+			check: func(t *testing.T, destDir string) {
+				assertContent(t, filepath.Join(destDir, "target"), "original")
+				assertContent(t, filepath.Join(destDir, "link"), "replaced")
+				if info, err := os.Lstat(filepath.Join(destDir, "link")); err != nil || !info.Mode().IsRegular() {
+					t.Errorf("link is not a regular file (info: %v, error: %v)", info, err)
+				}
+			},
+		},
+		{
+			name: "safe relative symlink",
+			entries: []tarEntry{
+				{name: "lib/", typeflag: tar.TypeDir, mode: 0755},
+				{name: "lib/libfoo.so.1", body: "lib"},
+				{name: "bin/", typeflag: tar.TypeDir, mode: 0755},
+				{name: "bin/libfoo.so", typeflag: tar.TypeSymlink, linkname: "../lib/libfoo.so.1"},
+			},
+			check: func(t *testing.T, destDir string) {
+				assertContent(t, filepath.Join(destDir, "bin", "libfoo.so"), "lib")
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			destDir := filepath.Join(root, "dest")
+			src := writeTarGz(t, tt.entries)
+			err := (&TarGzExtractor{}).Extract(src, destDir, 0, nil, true, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Extract() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			entries, err := os.ReadDir(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range entries {
+				if e.Name() != "dest" {
+					t.Errorf("file written outside destination: %s", filepath.Join(root, e.Name()))
+				}
+			}
+			tt.check(t, destDir)
+		})
+	}
+}
+
+// writeTarZst は entries を含む tar.zst を一時ディレクトリに作成し、そのパスを返す
+func writeTarZst(t *testing.T, entries []tarEntry) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "test.tar.zst")
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw, err := zstd.NewWriter(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTarEntries(t, zw, entries)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestExtractTarZstSymlink(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tarEntry
+		check   func(t *testing.T, destDir string)
+	}{
+		{
+			name: "safe relative symlink",
+			entries: []tarEntry{
+				{name: "tool-1.0/lib/libfoo.so.1", body: "lib"},
+				{name: "tool-1.0/bin/", typeflag: tar.TypeDir, mode: 0755},
+				{name: "tool-1.0/bin/libfoo.so", typeflag: tar.TypeSymlink, linkname: "../lib/libfoo.so.1"},
+				{name: "tool-1.0/current", typeflag: tar.TypeSymlink, linkname: "bin"},
+			},
+			check: func(t *testing.T, destDir string) {
+				assertContent(t, filepath.Join(destDir, "bin", "libfoo.so"), "lib")
+				if target, err := os.Readlink(filepath.Join(destDir, "bin", "libfoo.so")); err != nil || target != "../lib/libfoo.so.1" {
+					t.Errorf("bin/libfoo.so -> %q (error: %v), want a symlink to ../lib/libfoo.so.1", target, err)
+				}
+				if target, err := os.Readlink(filepath.Join(destDir, "current")); err != nil || target != "bin" {
+					t.Errorf("current -> %q (error: %v), want a symlink to bin", target, err)
+				}
+			},
+		},
+		{
+			name: "escaping symlinks are skipped",
+			entries: []tarEntry{
+				{name: "tool-1.0/bin/tool", body: "tool"},
+				{name: "tool-1.0/bin/up", typeflag: tar.TypeSymlink, linkname: "../../outside"},
+				{name: "tool-1.0/passwd", typeflag: tar.TypeSymlink, linkname: "/etc/passwd"},
+			},
+			check: func(t *testing.T, destDir string) {
+				assertContent(t, filepath.Join(destDir, "bin", "tool"), "tool")
+				assertNotExist(t, filepath.Join(destDir, "bin", "up"))
+				assertNotExist(t, filepath.Join(destDir, "passwd"))
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			destDir := filepath.Join(root, "dest")
+			src := writeTarZst(t, tt.entries)
+			extractor, err := GetExtractor(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := extractor.(*TarZstExtractor); !ok {
+				t.Fatalf("GetExtractor(%s) = %T, want *TarZstExtractor", src, extractor)
+			}
+			if err := extractor.Extract(src, destDir, 1, nil, true, nil); err != nil {
+				t.Fatalf("Extract() error = %v", err)
+			}
+			entries, err := os.ReadDir(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range entries {
+				if e.Name() != "dest" {
+					t.Errorf("file written outside destination: %s", filepath.Join(root, e.Name()))
+				}
+			}
+			tt.check(t, destDir)
+		})
+	}
+}
+
+func assertNotExist(t *testing.T, p string) {
+	t.Helper()
+	if _, err := os.Lstat(p); !os.IsNotExist(err) {
+		t.Errorf("%s exists (error: %v)", p, err)
+	}
+}
+
+func assertContent(t *testing.T, p, want string) {
+	t.Helper()
+	got, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("content of %s = %q, want %q", p, got, want)
+	}
+}
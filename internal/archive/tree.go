@@ -0,0 +1,247 @@
+package archive
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/hrko/dltofu/internal/hash"
+)
+
+// TreeHashes はツリーのハッシュ値の計算結果
+type TreeHashes struct {
+	Tree *hash.Hash // TreeHash
+	// Legacy は以前の形式 (パーミッションの文字列をそのまま含むため umask に依存する) の TreeHash。
+	// 以前の形式で記録された Lock ファイルとの照合にのみ使う。
+	Legacy  *hash.Hash
+	Members map[string]*hash.Hash // 各ファイルのハッシュ値 (MemberHashes と同じ形式)
+}
+
+// TreeHash はディレクトリ配下のツリーを正規化してハッシュ値を計算する。
+// 各エントリの相対パス (ソート済み)、種別 (ディレクトリ、シンボリックリンク、通常ファイル、実行可能なファイル)、
+// ファイルのハッシュ値 (シンボリックリンクの場合はリンク先) を1行ずつ並べたマニフェストのハッシュ値を返す。
+// パーミッションは実行ビットの有無のみを含め、展開時の umask に依存しないようにする。
+func TreeHash(dir string, algorithm hash.HashAlgorithm) (*hash.Hash, error) {
+	hashes, err := treeHashes(dir, algorithm, runtime.NumCPU())
+	if err != nil {
+		return nil, err
+	}
+	return hashes.Tree, nil
+}
+
+// treeEntryKind は TreeHash のマニフェストに記録するエントリの種別を返す
+func treeEntryKind(mode fs.FileMode) string {
+	switch {
+	case mode&fs.ModeSymlink != 0:
+		return "link"
+	case mode.IsDir():
+		return "dir"
+	case mode&0111 != 0:
+		return "exec"
+	default:
+		return "file"
+	}
+}
+
+// treeHashes は TreeHash と以前の形式の TreeHash、その計算に使ったファイルごとのハッシュ値を返す
+func treeHashes(dir string, algorithm hash.HashAlgorithm, concurrency int) (*TreeHashes, error) {
+	members, err := MemberHashes(dir, algorithm, concurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries, legacyEntries []string
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dir {
+			return nil // ルート自体は含めない
+		}
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		var content string
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			content = "-> " + filepath.ToSlash(target)
+		case info.IsDir():
+			content = "-"
+		default:
+			fileHash, ok := members[relPath]
+			if !ok {
+				return fmt.Errorf("file appeared while hashing: %s", path)
+			}
+			content = fileHash.String()
+		}
+		entries = append(entries, fmt.Sprintf("%s %s %s", treeEntryKind(info.Mode()), content, relPath))
+		legacyEntries = append(legacyEntries, fmt.Sprintf("%s %s %s", info.Mode().String(), content, relPath))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk directory %s: %w", dir, err)
+	}
+
+	treeHash, err := manifestHash(entries, algorithm)
+	if err != nil {
+		return nil, err
+	}
+	legacyHash, err := manifestHash(legacyEntries, algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return &TreeHashes{Tree: treeHash, Legacy: legacyHash, Members: members}, nil
+}
+
+// manifestHash はマニフェストの各行をソートして連結した内容のハッシュ値を返す
+func manifestHash(entries []string, algorithm hash.HashAlgorithm) (*hash.Hash, error) {
+	sort.Strings(entries)
+	var manifest bytes.Buffer
+	for _, e := range entries {
+		manifest.WriteString(e)
+		manifest.WriteByte('\n')
+	}
+	return hash.CalculateStream(&manifest, algorithm)
+}
+
+// MemberHashes はディレクトリ配下の通常ファイルのハッシュ値を、最大 concurrency 個のファイルを並行して計算する。
+// キーは dir からの相対パス ("/" 区切り)。ディレクトリやシンボリックリンクは含まない。
+func MemberHashes(dir string, algorithm hash.HashAlgorithm, concurrency int) (map[string]*hash.Hash, error) {
+	var relPaths []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		relPaths = append(relPaths, filepath.ToSlash(relPath))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk directory %s: %w", dir, err)
+	}
+
+	hashes := make([]*hash.Hash, len(relPaths))
+	var g errgroup.Group
+	g.SetLimit(max(concurrency, 1))
+	for i, relPath := range relPaths {
+		g.Go(func() error {
+			fileHash, err := hashMember(dir, relPath, algorithm)
+			if err != nil {
+				return err
+			}
+			hashes[i] = fileHash
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	members := make(map[string]*hash.Hash, len(relPaths))
+	for i, relPath := range relPaths {
+		members[relPath] = hashes[i]
+	}
+	return members, nil
+}
+
+// MemberMismatch は VerifyMembers で検出した、Lock ファイルと一致しないメンバー
+type MemberMismatch struct {
+	Path     string     // 展開先からの相対パス
+	Expected *hash.Hash // Lock ファイルに記録されたハッシュ値
+	Actual   *hash.Hash // 実際のハッシュ値 (ファイルが存在しない場合は nil)
+}
+
+// VerifyMembers は dir 配下のファイルを expected (MemberHashes と同じ形式) と照合し、一致しないメンバーを全て返す。
+// ハッシュ計算は最大 concurrency 個のファイルを並行して行う。
+// expected にないファイルは (他のアーカイブと展開先を共有している場合などがあるため) 無視する。
+func VerifyMembers(dir string, expected map[string]*hash.Hash, concurrency int) ([]MemberMismatch, error) {
+	relPaths := make([]string, 0, len(expected))
+	for relPath := range expected {
+		relPaths = append(relPaths, relPath)
+	}
+	sort.Strings(relPaths)
+
+	var (
+		mu         sync.Mutex
+		mismatches []MemberMismatch
+	)
+	var g errgroup.Group
+	g.SetLimit(max(concurrency, 1))
+	for _, relPath := range relPaths {
+		g.Go(func() error {
+			want := expected[relPath]
+			got, err := hashMember(dir, relPath, want.Algorithm)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			if got != nil && got.Equal(want) {
+				return nil
+			}
+			mu.Lock()
+			mismatches = append(mismatches, MemberMismatch{Path: relPath, Expected: want, Actual: got})
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	sort.Slice(mismatches, func(i, j int) bool { return mismatches[i].Path < mismatches[j].Path })
+	return mismatches, nil
+}
+
+// hashMember は dir からの相対パス relPath のファイルのハッシュ値を計算する
+func hashMember(dir, relPath string, algorithm hash.HashAlgorithm) (*hash.Hash, error) {
+	path := filepath.Join(dir, filepath.FromSlash(relPath))
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fileHash, err := hash.CalculateStream(f, algorithm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate hash of %s: %w", path, err)
+	}
+	return fileHash, nil
+}
+
+// ExtractTreeHashes はアーカイブを一時ディレクトリに展開し、展開結果の TreeHash と展開された各ファイルのハッシュ値を返す。
+// 一時ディレクトリは処理後に削除される。
+func ExtractTreeHashes(extractor Extractor, sourcePath string, stripComponents int, extractPaths []string, algorithm hash.HashAlgorithm, logger *slog.Logger) (*TreeHashes, error) {
+	tmpDir, err := os.MkdirTemp("", "dltofu-tree-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := extractor.Extract(sourcePath, tmpDir, stripComponents, extractPaths, true, logger); err != nil {
+		return nil, fmt.Errorf("failed to extract archive %s: %w", sourcePath, err)
+	}
+	return treeHashes(tmpDir, algorithm, runtime.NumCPU())
+}
@@ -0,0 +1,229 @@
+package archive
+
+import (
+	"archive/tar"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/hrko/dltofu/internal/hash"
+)
+
+func TestTreeHashUsesExecutableBitOnly(t *testing.T) {
+	// writeTree は bin/tool (toolMode) と README (readmeMode) を含むツリーを作成し、TreeHash を返す
+	writeTree := func(t *testing.T, toolMode, readmeMode, dirMode fs.FileMode) *hash.Hash {
+		t.Helper()
+		dir := t.TempDir()
+		bin := filepath.Join(dir, "bin")
+		if err := os.Mkdir(bin, 0755); err != nil {
+			t.Fatal(err)
+		}
+		files := []struct {
+			path string
+			mode fs.FileMode
+		}{
+			{path: filepath.Join(bin, "tool"), mode: toolMode},
+			{path: filepath.Join(dir, "README"), mode: readmeMode},
+		}
+		for _, f := range files {
+			if err := os.WriteFile(f.path, []byte(f.path[len(dir):]), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(f.path, f.mode); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.Chmod(bin, dirMode); err != nil {
+			t.Fatal(err)
+		}
+		h, err := TreeHash(dir, hash.AlgoSHA256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	base := writeTree(t, 0755, 0644, 0755)
+	tests := []struct {
+		name                          string
+		toolMode, readmeMode, dirMode fs.FileMode
+		wantSame                      bool
+	}{
+		{name: "umask 077", toolMode: 0700, readmeMode: 0600, dirMode: 0700, wantSame: true},
+		{name: "umask 002", toolMode: 0775, readmeMode: 0664, dirMode: 0775, wantSame: true},
+		{name: "group executable only", toolMode: 0654, readmeMode: 0644, dirMode: 0755, wantSame: true},
+		{name: "tool not executable", toolMode: 0644, readmeMode: 0644, dirMode: 0755, wantSame: false},
+		{name: "readme executable", toolMode: 0755, readmeMode: 0755, dirMode: 0755, wantSame: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := writeTree(t, tt.toolMode, tt.readmeMode, tt.dirMode)
+			if same := got.Equal(base); same != tt.wantSame {
+				t.Errorf("TreeHash() equal to 0755/0644 tree = %v, want %v", same, tt.wantSame)
+			}
+		})
+	}
+}
+
+func TestTreeHashOfExtractedArchive(t *testing.T) {
+	// extractTreeHash は entries を含む tar.gz を実際の展開処理で展開し、展開先の TreeHash を返す
+	extractTreeHash := func(t *testing.T, entries []tarEntry) *hash.Hash {
+		t.Helper()
+		src := writeTarGz(t, entries)
+		destDir := filepath.Join(t.TempDir(), "dest")
+		if err := (&TarGzExtractor{}).Extract(src, destDir, 1, nil, true, nil); err != nil {
+			t.Fatalf("Extract() error = %v", err)
+		}
+		h, err := TreeHash(destDir, hash.AlgoSHA256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	baseEntries := func() []tarEntry {
+		return []tarEntry{
+			{name: "tool-1.0/bin/", typeflag: tar.TypeDir, mode: 0755},
+			{name: "tool-1.0/bin/tool", body: "#!/bin/sh\necho tool\n", mode: 0755},
+			{name: "tool-1.0/README", body: "readme"},
+			{name: "tool-1.0/bin/tool-link", typeflag: tar.TypeSymlink, linkname: "tool"},
+		}
+	}
+
+	base := extractTreeHash(t, baseEntries())
+	if again := extractTreeHash(t, baseEntries()); !again.Equal(base) {
+		t.Fatalf("TreeHash() of the same archive extracted twice differs: %s != %s", again, base)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(entries []tarEntry) []tarEntry
+	}{
+		{name: "content changed", modify: func(entries []tarEntry) []tarEntry {
+			entries[2].body = "readme v2"
+			return entries
+		}},
+		{name: "executable bit dropped", modify: func(entries []tarEntry) []tarEntry {
+			entries[1].mode = 0644
+			return entries
+		}},
+		{name: "member renamed", modify: func(entries []tarEntry) []tarEntry {
+			entries[2].name = "tool-1.0/README.md"
+			return entries
+		}},
+		{name: "symlink target changed", modify: func(entries []tarEntry) []tarEntry {
+			entries[3].linkname = "../README"
+			return entries
+		}},
+		{name: "member added", modify: func(entries []tarEntry) []tarEntry {
+			return append(entries, tarEntry{name: "tool-1.0/LICENSE", body: "license"})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractTreeHash(t, tt.modify(baseEntries())); got.Equal(base) {
+				t.Errorf("TreeHash() = %s, want it to differ from the unmodified archive", got)
+			}
+		})
+	}
+}
+
+func TestTreeEntryKind(t *testing.T) {
+	tests := []struct {
+		mode fs.FileMode
+		want string
+	}{
+		{mode: 0644, want: "file"},
+		{mode: 0600, want: "file"},
+		{mode: 0755, want: "exec"},
+		{mode: 0700, want: "exec"},
+		{mode: 0001, want: "exec"},
+		{mode: fs.ModeDir | 0700, want: "dir"},
+		{mode: fs.ModeSymlink | 0777, want: "link"},
+	}
+	for _, tt := range tests {
+		if got := treeEntryKind(tt.mode); got != tt.want {
+			t.Errorf("treeEntryKind(%v) = %q, want %q", tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyMembers(t *testing.T) {
+	const count = 200
+	memberPath := func(i int) string { return fmt.Sprintf("dir%d/file%03d.txt", i%7, i) }
+	tests := []struct {
+		name        string
+		concurrency int
+		tamper      []int // 内容を変更するメンバー
+		remove      []int // 削除するメンバー
+		want        []int // 不一致となるメンバー
+	}{
+		{name: "all match", concurrency: 8},
+		{name: "one tampered serial", concurrency: 1, tamper: []int{137}, want: []int{137}},
+		{name: "one tampered parallel", concurrency: 8, tamper: []int{137}, want: []int{137}},
+		{name: "one tampered more workers than files", concurrency: count * 2, tamper: []int{0}, want: []int{0}},
+		{name: "zero concurrency", concurrency: 0, tamper: []int{199}, want: []int{199}},
+		{name: "tampered and missing", concurrency: 8, tamper: []int{5}, remove: []int{42}, want: []int{5, 42}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			expected := make(map[string]*hash.Hash, count)
+			for i := range count {
+				p := filepath.Join(dir, filepath.FromSlash(memberPath(i)))
+				if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+					t.Fatal(err)
+				}
+				content := fmt.Sprintf("member %d\n", i)
+				if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+				h, err := hash.CalculateStream(strings.NewReader(content), hash.AlgoSHA256)
+				if err != nil {
+					t.Fatal(err)
+				}
+				expected[memberPath(i)] = h
+			}
+			// 展開先にある Lock ファイルに記録されていないファイルは無視される
+			if err := os.WriteFile(filepath.Join(dir, "extra.txt"), []byte("extra"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			for _, i := range tt.tamper {
+				if err := os.WriteFile(filepath.Join(dir, filepath.FromSlash(memberPath(i))), []byte("tampered\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, i := range tt.remove {
+				if err := os.Remove(filepath.Join(dir, filepath.FromSlash(memberPath(i)))); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			mismatches, err := VerifyMembers(dir, expected, tt.concurrency)
+			if err != nil {
+				t.Fatalf("VerifyMembers() error = %v", err)
+			}
+			var got []string
+			for _, m := range mismatches {
+				got = append(got, m.Path)
+				if !m.Expected.Equal(expected[m.Path]) {
+					t.Errorf("mismatch %s: Expected = %v, want %v", m.Path, m.Expected, expected[m.Path])
+				}
+				if wantMissing := slices.ContainsFunc(tt.remove, func(i int) bool { return memberPath(i) == m.Path }); (m.Actual == nil) != wantMissing {
+					t.Errorf("mismatch %s: Actual = %v, want missing %v", m.Path, m.Actual, wantMissing)
+				}
+			}
+			var want []string
+			for _, i := range tt.want {
+				want = append(want, memberPath(i))
+			}
+			slices.Sort(want)
+			if !slices.Equal(got, want) {
+				t.Errorf("VerifyMembers() mismatches = %v, want %v", got, want)
+			}
+		})
+	}
+}
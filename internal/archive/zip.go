@@ -6,11 +6,10 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
-	"strings"
 )
 
 // ZipExtractor は Zip ファイルを展開する
-type ZipExtractor struct{}
+type ZipExtractor struct{ extractOptions }
 
 // Extract は Zip ファイルを展開するメソッド
 func (z *ZipExtractor) Extract(sourcePath, destDir string, stripComponents int, extractPaths []string, force bool, logger *slog.Logger) error {
@@ -30,13 +29,26 @@ func (z *ZipExtractor) Extract(sourcePath, destDir string, stripComponents int,
 		return fmt.Errorf("failed to create destination directory %s: %w", destDir, err)
 	}
 
+	flat := make(flattener)
 	for _, f := range r.File {
 		// strip/extractPaths を考慮して展開すべきか、最終的な相対パスは何かを取得
-		targetRelPath, should := shouldExtract(f.Name, stripComponents, extractPaths)
+		targetRelPath, should := shouldExtract(f.Name, stripComponents, extractPaths, z.exclude)
 		if !should {
 			logger.Debug("Skipping entry based on strip/extract paths", "original_path", f.Name)
 			continue
 		}
+		if renamed := z.renamed(targetRelPath); renamed != targetRelPath {
+			logger.Debug("Renaming entry", "original_path", f.Name, "path", renamed)
+			targetRelPath = renamed
+		}
+		if z.flatten {
+			if f.FileInfo().IsDir() {
+				continue // ディレクトリは作成しない
+			}
+			if targetRelPath, err = flat.flatten(targetRelPath, f.Name); err != nil {
+				return err
+			}
+		}
 
 		// Zip Slip 攻撃を防ぎつつ、最終的な展開先パスを計算
 		finalDestPath, err := secureJoin(destDir, targetRelPath)
@@ -48,7 +60,7 @@ func (z *ZipExtractor) Extract(sourcePath, destDir string, stripComponents int,
 
 		if f.FileInfo().IsDir() {
 			// ディレクトリの場合
-			proceed, err := checkOverwrite(finalDestPath, true, force, logger)
+			proceed, err := z.checkOverwrite(finalDestPath, true, force, logger)
 			if err != nil {
 				return err // Statエラーなど
 			}
@@ -56,12 +68,12 @@ func (z *ZipExtractor) Extract(sourcePath, destDir string, stripComponents int,
 				continue // 上書きしない場合はスキップ
 			}
 			logger.Debug("Creating directory", "path", finalDestPath)
-			if err := os.MkdirAll(finalDestPath, f.Mode()); err != nil {
+			if err := os.MkdirAll(finalDestPath, z.dirMode(f.Mode())); err != nil {
 				return fmt.Errorf("failed to create directory %s: %w", finalDestPath, err)
 			}
 		} else {
 			// ファイルの場合
-			proceed, err := checkOverwrite(finalDestPath, false, force, logger)
+			proceed, err := z.checkOverwrite(finalDestPath, false, force, logger)
 			if err != nil {
 				return err
 			}
@@ -79,18 +91,15 @@ func (z *ZipExtractor) Extract(sourcePath, destDir string, stripComponents int,
 				return fmt.Errorf("failed to open file in zip archive %s: %w", f.Name, err)
 			}
 
-			logger.Debug("Extracting file", "path", finalDestPath, "mode", f.Mode())
-			// writeFile 内で force フラグが考慮される
-			err = writeFile(finalDestPath, rc, f.Mode(), force)
+			mode := z.fileMode(f.Mode())
+			logger.Debug("Extracting file", "path", finalDestPath, "mode", mode)
+			// 上書きするかは checkOverwrite で確認済み
+			err = writeFile(finalDestPath, rc, mode, true)
 			rc.Close() // 必ず閉じる
 			if err != nil {
-				// writeFile 内で force=false によるエラーも含まれる
-				if strings.Contains(err.Error(), "destination file already exists") {
-					logger.Warn("Skipping existing file", "path", finalDestPath)
-					continue // ログは checkOverwrite で出すのでここでは不要かも
-				}
 				return fmt.Errorf("failed to extract file %s: %w", f.Name, err)
 			}
+			z.extracted(finalDestPath)
 		}
 	}
 	logger.Info("Zip archive extracted successfully", "source", sourcePath)
@@ -0,0 +1,209 @@
+package bundle
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/hrko/dltofu/internal/hash"
+	"github.com/hrko/dltofu/internal/model"
+)
+
+// ManifestFileName はバンドル内のマニフェストファイル名
+const ManifestFileName = "manifest.json"
+
+// ManifestVersion はマニフェストの形式バージョン
+const ManifestVersion = 1
+
+// Manifest はバンドルに含まれるファイルとファイルIDの対応を表す
+type Manifest struct {
+	Version int             `json:"version"`
+	Files   []ManifestEntry `json:"files"`
+}
+
+// ManifestEntry はバンドル内の1ファイルの情報
+type ManifestEntry struct {
+	Path   string            `json:"path"` // バンドル内のパス
+	FileID model.FileID      `json:"file_id"`
+	URL    model.ResolvedURL `json:"url"`
+	Hash   *hash.Hash        `json:"hash"`
+}
+
+// Writer はバンドルファイルへの書き込みを行うインターフェース
+type Writer interface {
+	// Add は name というパスで r の内容 (size バイト) をバンドルに追加する
+	Add(name string, r io.Reader, size int64, mode os.FileMode) error
+	// Close はバンドルを完成させて閉じる
+	Close() error
+}
+
+// バンドルの形式
+const (
+	FormatTarGz  = "tar.gz"
+	FormatTarZst = "tar.zst"
+	FormatZip    = "zip"
+)
+
+// DefaultLevel は形式ごとのデフォルトの圧縮レベルを使うことを示す
+const DefaultLevel = 0
+
+// FormatFromPath は出力パスの拡張子からバンドルの形式を判定する
+func FormatFromPath(outputPath string) (string, error) {
+	lowerPath := strings.ToLower(outputPath)
+	switch {
+	case strings.HasSuffix(lowerPath, ".tar.gz") || strings.HasSuffix(lowerPath, ".tgz"):
+		return FormatTarGz, nil
+	case strings.HasSuffix(lowerPath, ".tar.zst") || strings.HasSuffix(lowerPath, ".tzst"):
+		return FormatTarZst, nil
+	case strings.HasSuffix(lowerPath, ".zip"):
+		return FormatZip, nil
+	default:
+		return "", fmt.Errorf("unsupported bundle format for file: %s (supported: .tar.gz, .tgz, .tar.zst, .tzst, .zip)", outputPath)
+	}
+}
+
+// NewWriter は指定された形式と圧縮レベルの Writer を返す。
+// level は tar.gz と zip では 1-9、tar.zst では 1-22 (zstd のレベル) で、DefaultLevel の場合は形式ごとのデフォルト値を使う。
+// 各ファイルは Add 時に直接圧縮ストリームに書き込まれ、メモリ上にバッファされない。
+func NewWriter(outputPath, format string, level int) (Writer, error) {
+	if err := validateLevel(format, level); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(outputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bundle file %s: %w", outputPath, err)
+	}
+
+	switch format {
+	case FormatTarGz:
+		if level == DefaultLevel {
+			level = gzip.DefaultCompression
+		}
+		gzw, err := gzip.NewWriterLevel(f, level)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to create gzip writer: %w", err)
+		}
+		return &tarWriter{file: f, comp: gzw, tw: tar.NewWriter(gzw)}, nil
+	case FormatTarZst:
+		encoderLevel := zstd.SpeedDefault
+		if level != DefaultLevel {
+			encoderLevel = zstd.EncoderLevelFromZstd(level)
+		}
+		zw, err := zstd.NewWriter(f, zstd.WithEncoderLevel(encoderLevel))
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to create zstd writer: %w", err)
+		}
+		return &tarWriter{file: f, comp: zw, tw: tar.NewWriter(zw)}, nil
+	default: // FormatZip (validateLevel で検証済み)
+		zw := zip.NewWriter(f)
+		if level != DefaultLevel {
+			zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+				return flate.NewWriter(w, level)
+			})
+		}
+		return &zipWriter{file: f, zw: zw}, nil
+	}
+}
+
+// validateLevel は形式と圧縮レベルの組み合わせを検証する
+func validateLevel(format string, level int) error {
+	minLevel, maxLevel := 1, 9
+	switch format {
+	case FormatTarGz, FormatZip:
+	case FormatTarZst:
+		maxLevel = 22
+	default:
+		return fmt.Errorf("unsupported bundle format: %s (supported: %s, %s, %s)", format, FormatTarGz, FormatTarZst, FormatZip)
+	}
+	if level != DefaultLevel && (level < minLevel || level > maxLevel) {
+		return fmt.Errorf("invalid compression level %d for %s (supported: %d-%d)", level, format, minLevel, maxLevel)
+	}
+	return nil
+}
+
+// WriteManifest はマニフェストを JSON としてバンドルに追加する
+func WriteManifest(w Writer, manifest *Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	return w.Add(ManifestFileName, strings.NewReader(string(data)), int64(len(data)), 0644)
+}
+
+// tarWriter は圧縮された tar 形式のバンドルを書き込む
+type tarWriter struct {
+	file *os.File
+	comp io.WriteCloser // tar ストリームの圧縮 (gzip, zstd)
+	tw   *tar.Writer
+}
+
+func (t *tarWriter) Add(name string, r io.Reader, size int64, mode os.FileMode) error {
+	header := &tar.Header{
+		Name:     name,
+		Mode:     int64(mode.Perm()),
+		Size:     size,
+		ModTime:  time.Now(),
+		Typeflag: tar.TypeReg,
+	}
+	if err := t.tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write tar header for %s: %w", name, err)
+	}
+	if _, err := io.Copy(t.tw, r); err != nil {
+		return fmt.Errorf("failed to write %s to bundle: %w", name, err)
+	}
+	return nil
+}
+
+func (t *tarWriter) Close() error {
+	if err := t.tw.Close(); err != nil {
+		t.file.Close()
+		return fmt.Errorf("failed to close tar writer: %w", err)
+	}
+	if err := t.comp.Close(); err != nil {
+		t.file.Close()
+		return fmt.Errorf("failed to close compression writer: %w", err)
+	}
+	return t.file.Close()
+}
+
+// zipWriter は zip 形式のバンドルを書き込む
+type zipWriter struct {
+	file *os.File
+	zw   *zip.Writer
+}
+
+func (z *zipWriter) Add(name string, r io.Reader, size int64, mode os.FileMode) error {
+	header := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	}
+	header.SetMode(mode)
+	w, err := z.zw.CreateHeader(header)
+	if err != nil {
+		return fmt.Errorf("failed to create zip entry for %s: %w", name, err)
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		return fmt.Errorf("failed to write %s to bundle: %w", name, err)
+	}
+	return nil
+}
+
+func (z *zipWriter) Close() error {
+	if err := z.zw.Close(); err != nil {
+		z.file.Close()
+		return fmt.Errorf("failed to close zip writer: %w", err)
+	}
+	return z.file.Close()
+}
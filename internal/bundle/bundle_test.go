@@ -0,0 +1,219 @@
+package bundle
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// fixtures は大きめのテスト用ファイル (圧縮しやすい内容とランダムな内容) を返す
+func fixtures() map[string][]byte {
+	rng := rand.New(rand.NewPCG(1, 2))
+	random := make([]byte, 128<<10)
+	for i := range random {
+		random[i] = byte(rng.Uint32())
+	}
+	// 圧縮レベルによって圧縮率に差が出るよう、単語をランダムに並べたテキストにする
+	words := strings.Fields("download lock hash verify bundle archive platform architecture variant manifest checksum mirror proxy cache")
+	var text bytes.Buffer
+	for text.Len() < 1<<20 {
+		text.WriteString(words[rng.IntN(len(words))])
+		text.WriteByte(" \n"[rng.IntN(2)])
+	}
+	return map[string][]byte{
+		"files/text/a.txt":   text.Bytes(),
+		"files/random/b.bin": random,
+		"files/zero/c.bin":   make([]byte, 1<<20),
+		"files/empty/d":      nil,
+	}
+}
+
+// readMembers は形式 format のバンドルを読み込み、メンバーの内容を返す
+func readMembers(t *testing.T, path, format string) map[string][]byte {
+	t.Helper()
+	members := make(map[string][]byte)
+	if format == FormatZip {
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatalf("failed to read %s: %v", f.Name, err)
+			}
+			members[f.Name] = data
+		}
+		return members
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var r io.Reader
+	if format == FormatTarGz {
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = gr
+	} else {
+		zr, err := zstd.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return members
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", hdr.Name, err)
+		}
+		members[hdr.Name] = data
+	}
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	files := fixtures()
+	tests := []struct {
+		format    string
+		low, high int
+	}{
+		{format: FormatTarGz, low: 1, high: 9},
+		{format: FormatTarZst, low: 1, high: 22},
+		{format: FormatZip, low: 1, high: 9},
+	}
+	for _, tt := range tests {
+		sizes := make(map[int]int64)
+		for _, level := range []int{tt.low, DefaultLevel, tt.high} {
+			t.Run(fmt.Sprintf("%s/level%d", tt.format, level), func(t *testing.T) {
+				out := filepath.Join(t.TempDir(), "bundle")
+				w, err := NewWriter(out, tt.format, level)
+				if err != nil {
+					t.Fatalf("NewWriter() error = %v", err)
+				}
+				for name, data := range files {
+					if err := w.Add(name, bytes.NewReader(data), int64(len(data)), 0644); err != nil {
+						t.Fatalf("Add(%s) error = %v", name, err)
+					}
+				}
+				manifest := &Manifest{Version: ManifestVersion}
+				if err := WriteManifest(w, manifest); err != nil {
+					t.Fatalf("WriteManifest() error = %v", err)
+				}
+				if err := w.Close(); err != nil {
+					t.Fatalf("Close() error = %v", err)
+				}
+
+				members := readMembers(t, out, tt.format)
+				if len(members) != len(files)+1 {
+					t.Errorf("bundle has %d members, want %d", len(members), len(files)+1)
+				}
+				for name, want := range files {
+					if got, ok := members[name]; !ok || !bytes.Equal(got, want) {
+						t.Errorf("member %s: got %d bytes (present: %v), want %d bytes", name, len(got), ok, len(want))
+					}
+				}
+				if _, ok := members[ManifestFileName]; !ok {
+					t.Errorf("manifest %s is missing", ManifestFileName)
+				}
+				stat, err := os.Stat(out)
+				if err != nil {
+					t.Fatal(err)
+				}
+				sizes[level] = stat.Size()
+			})
+		}
+		if sizes[tt.high] > sizes[tt.low] {
+			t.Errorf("%s: level %d produced %d bytes, more than level %d (%d bytes)", tt.format, tt.high, sizes[tt.high], tt.low, sizes[tt.low])
+		}
+	}
+}
+
+func TestValidateLevel(t *testing.T) {
+	tests := []struct {
+		format  string
+		level   int
+		wantErr string // 空ならエラーにならない
+	}{
+		{format: FormatTarGz, level: DefaultLevel},
+		{format: FormatTarGz, level: 1},
+		{format: FormatTarGz, level: 9},
+		{format: FormatTarGz, level: 10, wantErr: "invalid compression level 10 for tar.gz (supported: 1-9)"},
+		{format: FormatTarGz, level: -1, wantErr: "invalid compression level -1"},
+		{format: FormatZip, level: 9},
+		{format: FormatZip, level: 22, wantErr: "invalid compression level 22 for zip"},
+		{format: FormatTarZst, level: 22},
+		{format: FormatTarZst, level: 23, wantErr: "invalid compression level 23 for tar.zst (supported: 1-22)"},
+		{format: "tar.xz", level: DefaultLevel, wantErr: "unsupported bundle format: tar.xz"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%d", tt.format, tt.level), func(t *testing.T) {
+			err := validateLevel(tt.format, tt.level)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateLevel() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateLevel() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFormatFromPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{path: "bundle.tar.gz", want: FormatTarGz},
+		{path: "out/BUNDLE.TGZ", want: FormatTarGz},
+		{path: "bundle.tar.zst", want: FormatTarZst},
+		{path: "bundle.tzst", want: FormatTarZst},
+		{path: "bundle.zip", want: FormatZip},
+		{path: "bundle.tar", wantErr: true},
+		{path: "bundle.zst", wantErr: true},
+		{path: "bundle", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := FormatFromPath(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FormatFromPath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("FormatFromPath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
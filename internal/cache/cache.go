@@ -0,0 +1,178 @@
+package cache
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/hrko/dltofu/internal/hash"
+)
+
+// Cache はダウンロード済みのファイルを内容のハッシュ値をキーとして保存するディスク上のキャッシュ。
+// ハッシュ値で内容が決まるため、URL が変わっても同じ内容であれば再利用できる。
+type Cache struct {
+	dir    string
+	logger *slog.Logger
+}
+
+// DefaultDir はキャッシュディレクトリのデフォルト ($XDG_CACHE_HOME/dltofu など、OS のユーザーキャッシュディレクトリ配下) を返す
+func DefaultDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine user cache directory: %w", err)
+	}
+	return filepath.Join(dir, "dltofu"), nil
+}
+
+// New は dir をキャッシュディレクトリとする Cache を作成する。dir が存在しない場合は作成する。
+func New(dir string, logger *slog.Logger) (*Cache, error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory %s: %w", dir, err)
+	}
+	return &Cache{dir: dir, logger: logger}, nil
+}
+
+// path は h の内容を保存するパス (<dir>/<algorithm>/<先頭2文字>/<ハッシュ値>) を返す
+func (c *Cache) path(h *hash.Hash) string {
+	value := hex.EncodeToString(h.HashValue)
+	return filepath.Join(c.dir, string(h.Algorithm), value[:2], value)
+}
+
+// CopyTo は h の内容がキャッシュにあれば、ハッシュ値を検証しながら destPath にコピーして true を返す。
+// キャッシュにない場合は false を返す。キャッシュの内容が壊れている場合はそのエントリを削除して false を返す。
+func (c *Cache) CopyTo(h *hash.Hash, destPath string) (bool, error) {
+	if len(h.HashValue) == 0 {
+		return false, nil
+	}
+	cachedPath := c.path(h)
+	src, err := os.Open(cachedPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to open cached file %s: %w", cachedPath, err)
+	}
+	defer src.Close()
+
+	actual, err := writeAtomic(destPath, func(w io.Writer) (*hash.Hash, error) {
+		return hash.CalculateStreamTee(src, w, h.Algorithm)
+	}, h)
+	if err != nil {
+		var mismatch *mismatchError
+		if errors.As(err, &mismatch) {
+			c.logger.Warn("Cached file is corrupted, removing it", "path", cachedPath, "expected", h, "actual", mismatch.actual)
+			os.Remove(cachedPath)
+			return false, nil
+		}
+		return false, err
+	}
+	c.logger.Debug("Copied file from cache", "hash", actual, "destination", destPath)
+	return true, nil
+}
+
+// Store は内容のハッシュ値が h であるファイル srcPath をキャッシュに保存する。
+// 既に保存されている場合は何もしない。保存時にハッシュ値を検証する。
+func (c *Cache) Store(h *hash.Hash, srcPath string) error {
+	if len(h.HashValue) == 0 {
+		return nil
+	}
+	cachedPath := c.path(h)
+	if _, err := os.Stat(cachedPath); err == nil {
+		return nil
+	}
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", srcPath, err)
+	}
+	defer src.Close()
+
+	if _, err := writeAtomic(cachedPath, func(w io.Writer) (*hash.Hash, error) {
+		return hash.CalculateStreamTee(src, w, h.Algorithm)
+	}, h); err != nil {
+		return fmt.Errorf("failed to store %s in cache: %w", srcPath, err)
+	}
+	c.logger.Debug("Stored file in cache", "hash", h, "path", cachedPath)
+	return nil
+}
+
+// Open は h の内容がキャッシュにあれば開いて返す。ない場合は os.ErrNotExist を返す。
+// 内容は検証しないため、呼び出し元で読み込みながらハッシュ値を確認すること。
+func (c *Cache) Open(h *hash.Hash) (*os.File, error) {
+	if len(h.HashValue) == 0 {
+		return nil, os.ErrNotExist
+	}
+	return os.Open(c.path(h))
+}
+
+// CreateTemp はキャッシュディレクトリに一時ファイルを作成する。
+// 書き込んだ後は Adopt でキャッシュに移動するか、呼び出し元で削除する。
+func (c *Cache) CreateTemp() (*os.File, error) {
+	f, err := os.CreateTemp(c.dir, "spill-*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file in %s: %w", c.dir, err)
+	}
+	return f, nil
+}
+
+// Adopt は CreateTemp で作成した、内容のハッシュ値が h である tmpPath をキャッシュに移動する。
+// 既に保存されている場合は tmpPath を削除する。h は書き込み時に計算した値であること (ここでは検証しない)。
+func (c *Cache) Adopt(h *hash.Hash, tmpPath string) error {
+	cachedPath := c.path(h)
+	if _, err := os.Stat(cachedPath); err == nil {
+		return os.Remove(tmpPath)
+	}
+	if err := os.MkdirAll(filepath.Dir(cachedPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", filepath.Dir(cachedPath), err)
+	}
+	if err := os.Rename(tmpPath, cachedPath); err != nil {
+		return fmt.Errorf("failed to rename temporary file %s to %s: %w", tmpPath, cachedPath, err)
+	}
+	c.logger.Debug("Stored file in cache", "hash", h, "path", cachedPath)
+	return nil
+}
+
+// mismatchError はコピーした内容のハッシュ値が期待値と一致しなかったことを示す
+type mismatchError struct {
+	expected, actual *hash.Hash
+}
+
+func (e *mismatchError) Error() string {
+	return fmt.Sprintf("hash mismatch: expected %s, got %s", e.expected, e.actual)
+}
+
+// writeAtomic は destPath と同じディレクトリの一時ファイルに write で書き込み、
+// 書き込んだ内容のハッシュ値が expected と一致した場合のみ destPath にリネームする。
+func writeAtomic(destPath string, write func(w io.Writer) (*hash.Hash, error), expected *hash.Hash) (*hash.Hash, error) {
+	dir := filepath.Dir(destPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(destPath)+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file in %s: %w", dir, err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath) // リネーム後は存在しないので失敗するが問題ない
+
+	actual, err := write(tmp)
+	if closeErr := tmp.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to close temporary file %s: %w", tmpPath, closeErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !actual.Equal(expected) {
+		return nil, &mismatchError{expected: expected, actual: actual}
+	}
+	if err := os.Rename(tmpPath, destPath); err != nil {
+		return nil, fmt.Errorf("failed to rename temporary file %s to %s: %w", tmpPath, destPath, err)
+	}
+	return actual, nil
+}
@@ -0,0 +1,197 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hrko/dltofu/internal/hash"
+)
+
+// contentHash は content の algorithm のハッシュ値を返す
+func contentHash(t *testing.T, content string, algorithm hash.HashAlgorithm) *hash.Hash {
+	t.Helper()
+	h, err := hash.CalculateStream(bytes.NewReader([]byte(content)), algorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestCacheStoreAndCopyTo(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm hash.HashAlgorithm
+		stored    string // キャッシュに保存する内容 (空なら保存しない)
+		content   string // CopyTo で要求する内容
+		wantFound bool
+	}{
+		{name: "stored sha256", algorithm: hash.AlgoSHA256, stored: "cached\n", content: "cached\n", wantFound: true},
+		{name: "stored sha512", algorithm: hash.AlgoSHA512, stored: "cached\n", content: "cached\n", wantFound: true},
+		{name: "not stored", algorithm: hash.AlgoSHA256, content: "cached\n"},
+		{name: "other content stored", algorithm: hash.AlgoSHA256, stored: "other\n", content: "cached\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := New(filepath.Join(t.TempDir(), "cache"), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.stored != "" {
+				src := filepath.Join(t.TempDir(), "src")
+				if err := os.WriteFile(src, []byte(tt.stored), 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := c.Store(contentHash(t, tt.stored, tt.algorithm), src); err != nil {
+					t.Fatalf("Store() error = %v", err)
+				}
+			}
+
+			dest := filepath.Join(t.TempDir(), "out", "file")
+			found, err := c.CopyTo(contentHash(t, tt.content, tt.algorithm), dest)
+			if err != nil {
+				t.Fatalf("CopyTo() error = %v", err)
+			}
+			if found != tt.wantFound {
+				t.Fatalf("CopyTo() = %v, want %v", found, tt.wantFound)
+			}
+			data, err := os.ReadFile(dest)
+			if !tt.wantFound {
+				if !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("destination exists after a cache miss (error: %v)", err)
+				}
+				return
+			}
+			if err != nil || string(data) != tt.content {
+				t.Errorf("copied content = %q (error: %v), want %q", data, err, tt.content)
+			}
+		})
+	}
+}
+
+func TestCacheStoreRejectsWrongHash(t *testing.T) {
+	c, err := New(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(t.TempDir(), "src")
+	if err := os.WriteFile(src, []byte("actual\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	claimed := contentHash(t, "claimed\n", hash.AlgoSHA256)
+	if err := c.Store(claimed, src); err == nil {
+		t.Fatal("Store() succeeded for content that does not match the hash")
+	}
+	if f, err := c.Open(claimed); !errors.Is(err, os.ErrNotExist) {
+		if f != nil {
+			f.Close()
+		}
+		t.Errorf("Open() error = %v, want os.ErrNotExist after a rejected Store", err)
+	}
+}
+
+func TestCacheCopyToDetectsTampering(t *testing.T) {
+	c, err := New(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const content = "cached\n"
+	h := contentHash(t, content, hash.AlgoSHA256)
+	src := filepath.Join(t.TempDir(), "src")
+	if err := os.WriteFile(src, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Store(h, src); err != nil {
+		t.Fatal(err)
+	}
+	// キャッシュの内容を書き換える
+	if err := os.WriteFile(c.path(h), []byte("tampered\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := filepath.Join(t.TempDir(), "file")
+	found, err := c.CopyTo(h, dest)
+	if err != nil {
+		t.Fatalf("CopyTo() error = %v", err)
+	}
+	if found {
+		t.Fatal("CopyTo() = true for tampered cache content, want false")
+	}
+	if _, err := os.Stat(dest); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("tampered content was copied to the destination (stat error: %v)", err)
+	}
+	if _, err := os.Stat(c.path(h)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("tampered cache entry was not removed (stat error: %v)", err)
+	}
+	if entries, err := os.ReadDir(filepath.Dir(dest)); err != nil || len(entries) != 0 {
+		t.Errorf("files left next to the destination: %v (error: %v)", entries, err)
+	}
+}
+
+func TestCacheCreateTempAdoptOpen(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing bool // Adopt の前に同じ内容を保存しておく
+	}{
+		{name: "new entry"},
+		{name: "already cached"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := New(t.TempDir(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			const content = "spilled content\n"
+			h := contentHash(t, content, hash.AlgoSHA256)
+			if tt.existing {
+				src := filepath.Join(t.TempDir(), "src")
+				if err := os.WriteFile(src, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := c.Store(h, src); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if f, err := c.Open(contentHash(t, "other\n", hash.AlgoSHA256)); !errors.Is(err, os.ErrNotExist) {
+				if f != nil {
+					f.Close()
+				}
+				t.Errorf("Open() of a missing entry error = %v, want os.ErrNotExist", err)
+			}
+
+			tmp, err := c.CreateTemp()
+			if err != nil {
+				t.Fatalf("CreateTemp() error = %v", err)
+			}
+			if filepath.Dir(tmp.Name()) != c.dir {
+				t.Errorf("CreateTemp() = %s, want a file in %s", tmp.Name(), c.dir)
+			}
+			if _, err := tmp.WriteString(content); err != nil {
+				t.Fatal(err)
+			}
+			if err := tmp.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Adopt(h, tmp.Name()); err != nil {
+				t.Fatalf("Adopt() error = %v", err)
+			}
+			if _, err := os.Stat(tmp.Name()); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("temporary file still exists after Adopt (stat error: %v)", err)
+			}
+
+			f, err := c.Open(h)
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			defer f.Close()
+			data, err := io.ReadAll(f)
+			if err != nil || string(data) != content {
+				t.Errorf("Open() content = %q (error: %v), want %q", data, err, content)
+			}
+		})
+	}
+}
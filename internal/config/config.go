@@ -2,14 +2,23 @@ package config
 
 import (
 	"fmt"
+	"io/fs"
 	"log/slog"
+	"maps"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 
+	"github.com/hrko/dltofu/internal/archive"
+	"github.com/hrko/dltofu/internal/download"
 	"github.com/hrko/dltofu/internal/hash" // 自身のモジュールパス
+	"github.com/hrko/dltofu/internal/lock"
 	"github.com/hrko/dltofu/internal/model"
 	"github.com/hrko/dltofu/internal/platform"
+	"github.com/hrko/dltofu/internal/template"
 	"gopkg.in/yaml.v3"
 )
 
@@ -19,36 +28,156 @@ const CurrentVersion = "v1"
 type Config struct {
 	Version       string                   `yaml:"version"`
 	HashAlgorithm hash.HashAlgorithm       `yaml:"hash_algorithm,omitempty"` // デフォルトは sha256
+	Include       []string                 `yaml:"include,omitempty"`        // files をマージする他の設定ファイル (このファイルのディレクトリ基準、グロブ可)。詳細は loadIncludes を参照
+	Defaults      *DefaultsDef             `yaml:"defaults,omitempty"`       // 各ファイル定義で省略した項目に適用するデフォルト値
 	Files         map[model.FileID]FileDef `yaml:"files"`                    // キーはファイル識別子
+	PlatformMap   map[string]string        `yaml:"platform_map,omitempty"`   // key: プラットフォーム識別子 (mac), value: runtime.GOOS (darwin)。指定時は組み込みの識別子の代わりに使う
+	ArchMap       map[string]string        `yaml:"arch_map,omitempty"`       // key: アーキテクチャ識別子 (x64), value: runtime.GOARCH (amd64)。指定時は組み込みの識別子の代わりに使う
+	Proxy         string                   `yaml:"proxy,omitempty"`          // 接続に使うプロキシの URL。指定時は HTTP_PROXY などの環境変数より優先する。${NAME} で環境変数を参照できる
+	TLS           *TLSDef                  `yaml:"tls,omitempty"`            // HTTPS 接続の TLS 設定
+	URLResolver   []string                 `yaml:"url_resolver,omitempty"`   // 各URLの取得直前に実行し、署名付きURLなどに変換する外部コマンド (コマンドと引数)。セキュリティ上の注意は download.URLResolver を参照
 	path          string                   // 設定ファイルのパス (相対パス解決用)
 	logger        *slog.Logger
 }
 
-// FileDef はダウンロードするファイルごとの定義
+// FileDef はダウンロードするファイルごとの定義。
+// url, mirrors, parts, destination, headers, patch_from (Override を含む) では ${NAME} や ${NAME:-default} で環境変数を参照できる。
 type FileDef struct {
-	URL             string                     `yaml:"url"` // テンプレート可
-	Version         string                     `yaml:"version,omitempty"`
-	Platforms       map[string]string          `yaml:"platforms,omitempty"`     // key: platform_id (linux), value: template_value (linux)
-	Architectures   map[string]string          `yaml:"architectures,omitempty"` // key: arch_id (amd64), value: template_value (amd64, x86_64)
-	Destination     string                     `yaml:"destination,omitempty"`   // ダウンロード/展開先 (相対/絶対パス)
-	IsArchive       bool                       `yaml:"is_archive,omitempty"`
-	StripComponents int                        `yaml:"strip_components,omitempty"`
-	ExtractPaths    []string                   `yaml:"extract_paths,omitempty"`
-	HashAlgorithm   hash.HashAlgorithm         `yaml:"hash_algorithm,omitempty"` // ファイル固有設定
-	Overrides       map[string]OverrideFileDef `yaml:"overrides,omitempty"`      // key: "platform/arch" (e.g., "linux/amd64")
+	Description         string                     `yaml:"description,omitempty"` // 人向けの説明。Lock ファイルにも記録され、差分のレビュー時にファイルIDが何を指すかを示す
+	URL                 string                     `yaml:"url"`                   // テンプレート可。http, https, file (ローカルファイル), s3, gs (実行環境の認証情報を使う) に対応
+	Source              string                     `yaml:"source,omitempty"`      // "github" の場合は repo, tag, asset から url を生成する
+	Repo                string                     `yaml:"repo,omitempty"`        // source: github のリポジトリ (owner/name)
+	Tag                 string                     `yaml:"tag,omitempty"`         // source: github のリリースタグ (テンプレート可、省略時は "{{.Version}}")
+	Asset               string                     `yaml:"asset,omitempty"`       // source: github のアセット名 (テンプレート可)
+	Mirrors             []string                   `yaml:"mirrors,omitempty"`     // url の取得に失敗した場合に順に試す代替URL (テンプレート可)。Lock ファイルは常に url をキーとし、代替URLの内容も url のハッシュ値で検証する
+	Parts               []string                   `yaml:"parts,omitempty"`       // 分割ファイルの各パートのURL (テンプレート可、連結順)。指定時 url は論理的な識別子となる
+	Version             string                     `yaml:"version,omitempty"`
+	Platforms           map[string]string          `yaml:"platforms,omitempty"`     // key: platform_id (linux), value: template_value (linux)
+	Architectures       map[string]string          `yaml:"architectures,omitempty"` // key: arch_id (amd64), value: template_value (amd64, x86_64)
+	ArchVariants        map[string]string          `yaml:"arch_variants,omitempty"` // 32-bit ARM のバリアント。key: variant_id (armv7), value: template_value (armv7, armhf)
+	Destination         string                     `yaml:"destination,omitempty"`   // ダウンロード/展開先 (相対/絶対パス、テンプレート可)
+	IsArchive           bool                       `yaml:"is_archive,omitempty"`
+	ArchiveType         string                     `yaml:"archive_type,omitempty"` // アーカイブ形式を明示する (tar.gz, zip, ...)。未指定なら拡張子、次に内容から判定する
+	StripComponents     int                        `yaml:"strip_components,omitempty"`
+	ExtractPaths        []string                   `yaml:"extract_paths,omitempty"`        // 展開するパス (strip 後の相対パス)。ディレクトリは中身全体が対象。*, ?, [...] と要素全体の ** によるグロブも使える
+	ExtractExclude      []string                   `yaml:"extract_exclude,omitempty"`      // extract_paths に一致しても展開しないパス (書式は extract_paths と同じ)
+	Rename              map[string]string          `yaml:"rename,omitempty"`               // 展開時のリネーム。key: strip 後のパス, value: 展開先からの相対パス (テンプレート可)
+	Flatten             bool                       `yaml:"flatten,omitempty"`              // アーカイブ内のディレクトリ構造を捨て、全てのファイルを destination の直下に展開する
+	Executables         []string                   `yaml:"executables,omitempty"`          // 展開後に実行権限を付与するファイルのパターン (展開先からの相対パス、path.Match 形式)
+	Mode                string                     `yaml:"mode,omitempty"`                 // 最終的なパーミッション (8進数、e.g. "0644")。アーカイブの場合は展開された各ファイルに適用する
+	Executable          bool                       `yaml:"executable,omitempty"`           // mode 未指定の非アーカイブファイルを実行可能 (0755) にする
+	PreservePermissions *bool                      `yaml:"preserve_permissions,omitempty"` // false の場合はアーカイブ内のパーミッションを使わず 0644/0755 で展開する (デフォルト true)
+	HashAlgorithm       hash.HashAlgorithm         `yaml:"hash_algorithm,omitempty"`       // ファイル固有設定
+	Overrides           map[string]OverrideFileDef `yaml:"overrides,omitempty"`            // key: "platform/arch" または "platform/arch/variant" (e.g., "linux/amd64", "linux/arm/armv7")。platform と arch には全てに一致する "*" も使える
+	PatchFrom           *PatchDef                  `yaml:"patch_from,omitempty"`           // 指定時はベースにパッチを適用してファイルを生成する
+	Signature           *SignatureDef              `yaml:"signature,omitempty"`            // 指定時はダウンロード後に GPG の分離署名を検証する
+	ChunkSize           int64                      `yaml:"chunk_size,omitempty"`           // 指定時はチャンクごとのハッシュ値も Lock ファイルに記録する (バイト数)
+	ChecksumsURL        string                     `yaml:"checksums_url,omitempty"`        // 公開されたチェックサムファイル (SHA256SUMS など) のURL (テンプレート可)。指定時 lock はファイルをダウンロードせずにハッシュ値を記録する
+	Headers             map[string]string          `yaml:"headers,omitempty"`              // リクエストに設定するHTTPヘッダ (テンプレート可)
+	ExpectContentType   string                     `yaml:"expect_content_type,omitempty"`  // 指定時はレスポンスの Content-Type が一致しない場合に失敗する (e.g. "application/gzip", "application/*")
+}
+
+// DefaultsDef は各ファイル定義に共通する設定。ファイル定義で指定されていない項目にのみ適用される。
+// 多数のファイルで同じ platforms/architectures を繰り返す設定を短くするために使う。
+type DefaultsDef struct {
+	Platforms       map[string]string  `yaml:"platforms,omitempty"`
+	Architectures   map[string]string  `yaml:"architectures,omitempty"`
+	HashAlgorithm   hash.HashAlgorithm `yaml:"hash_algorithm,omitempty"`
+	StripComponents int                `yaml:"strip_components,omitempty"` // is_archive: true で strip_components が 0 (未指定) のファイルにのみ適用する
+	Destination     string             `yaml:"destination,omitempty"`      // テンプレート可
+}
+
+// applyDefaults は defaults の値をファイル定義の未指定の項目に適用する。
+// platforms と architectures はどちらも未指定の場合にのみ適用し、片方だけ指定したファイル定義と組み合わせない。
+func (d *DefaultsDef) applyDefaults(fileDef *FileDef) {
+	if d == nil {
+		return
+	}
+	if len(fileDef.Platforms) == 0 && len(fileDef.Architectures) == 0 {
+		fileDef.Platforms = maps.Clone(d.Platforms)
+		fileDef.Architectures = maps.Clone(d.Architectures)
+	}
+	if fileDef.HashAlgorithm == "" {
+		fileDef.HashAlgorithm = d.HashAlgorithm
+	}
+	if fileDef.IsArchive && fileDef.StripComponents == 0 {
+		fileDef.StripComponents = d.StripComponents
+	}
+	if fileDef.Destination == "" {
+		fileDef.Destination = d.Destination
+	}
+}
+
+// PatchDef はベースとなるファイルに bsdiff パッチを適用してファイルを生成する場合の定義。
+// ベース、パッチ、適用結果のハッシュ値はそれぞれ Lock ファイルに記録される (適用結果は url をキーとする)。
+type PatchDef struct {
+	BaseURL string `yaml:"base_url"` // ベースとなるファイルのURL (テンプレート可)
+	URL     string `yaml:"url"`      // bsdiff パッチのURL (テンプレート可)
+}
+
+// signature.type の値
+const (
+	SignatureTypeGPG    = "gpg"    // GPG の分離署名 (デフォルト)
+	SignatureTypeCosign = "cosign" // cosign (sigstore) のキーレス署名のバンドル
+)
+
+// TLSDef は HTTPS 接続の TLS 設定。パスは設定ファイル基準で、${NAME} で環境変数を参照できる。
+type TLSDef struct {
+	CACert             string `yaml:"ca_cert,omitempty"`              // 追加で信頼する CA 証明書 (PEM 形式) のパス。システムの CA に加えて使う
+	ClientCert         string `yaml:"client_cert,omitempty"`          // クライアント証明書 (PEM 形式) のパス
+	ClientKey          string `yaml:"client_key,omitempty"`           // クライアント証明書の秘密鍵 (PEM 形式) のパス
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty"` // サーバー証明書を検証しない (開発環境用)
+}
+
+// SignatureDef は署名による検証の定義。type: gpg では GPG の分離署名 (.asc/.sig) を、
+// type: cosign では sigstore バンドル (cosign sign-blob --bundle の出力) を url からダウンロードして検証する。
+// 署名はハッシュ値の検証に加えて行われ、署名ファイル自体のハッシュ値は Lock ファイルに記録しない。
+type SignatureDef struct {
+	Type        string `yaml:"type,omitempty"`         // gpg (デフォルト) または cosign
+	URL         string `yaml:"url"`                    // 署名ファイル/バンドルのURL (テンプレート可)
+	PublicKey   string `yaml:"public_key,omitempty"`   // type: gpg の公開鍵ファイルのパス (設定ファイル基準) または ASCII armor 形式の公開鍵そのもの
+	Identity    string `yaml:"identity,omitempty"`     // type: cosign で期待する証明書の ID (メールアドレスやワークフローのURL)
+	Issuer      string `yaml:"issuer,omitempty"`       // type: cosign で期待する OIDC 発行者
+	TrustedRoot string `yaml:"trusted_root,omitempty"` // type: cosign の trusted_root.json のパス (設定ファイル基準)。省略時は Sigstore の公開インスタンスのものを取得する
 }
 
 // OverrideFileDef はプラットフォーム/アーキテクチャごとの上書き設定
 type OverrideFileDef struct {
-	URL           string             `yaml:"url,omitempty"`
-	Destination   string             `yaml:"destination,omitempty"`
-	HashAlgorithm hash.HashAlgorithm `yaml:"hash_algorithm,omitempty"`
-	ExtractPaths  []string           `yaml:"extract_paths,omitempty"`
+	URL            string             `yaml:"url,omitempty"`
+	Mirrors        []string           `yaml:"mirrors,omitempty"`
+	Destination    string             `yaml:"destination,omitempty"`
+	HashAlgorithm  hash.HashAlgorithm `yaml:"hash_algorithm,omitempty"`
+	ExtractPaths   []string           `yaml:"extract_paths,omitempty"`
+	ExtractExclude []string           `yaml:"extract_exclude,omitempty"`
+	Rename         map[string]string  `yaml:"rename,omitempty"`  // FileDef の rename を置き換える
+	Headers        map[string]string  `yaml:"headers,omitempty"` // FileDef の headers にキーごとにマージされる
+	Mode           string             `yaml:"mode,omitempty"`
 	// IsArchive や StripComponents は通常 Override しない想定だが、必要なら追加
 }
 
-// LoadConfig は指定されたパスから設定ファイルを読み込み、パースして検証する
-func LoadConfig(configPath string, logger *slog.Logger) (*Config, error) {
+// LoadOption は LoadConfig に渡すオプション
+type LoadOption func(o *loadOptions)
+
+type loadOptions struct {
+	versions map[model.FileID]string // ファイルIDごとの version の上書き
+}
+
+// WithVersions は versions (key: ファイルID, value: version) で各ファイル定義の version を上書きする。
+// source: github の URL の生成や version: latest の解決より前に適用されるため、設定ファイルを編集した場合と同じ結果になる。
+// 設定ファイルに存在しないファイルIDを指定した場合、LoadConfig はエラーを返す。
+func WithVersions(versions map[model.FileID]string) LoadOption {
+	return func(o *loadOptions) {
+		o.versions = versions
+	}
+}
+
+// LoadConfig は指定されたパスから設定ファイルを読み込み、パースして検証する。
+// strict の場合は、通常は警告のみとする問題 (効果のない設定など) もエラーとする。
+func LoadConfig(configPath string, logger *slog.Logger, strict bool, opts ...LoadOption) (*Config, error) {
+	var options loadOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
 	if logger == nil {
 		logger = slog.Default() // フォールバック
 	}
@@ -78,7 +207,36 @@ func LoadConfig(configPath string, logger *slog.Logger) (*Config, error) {
 	cfg.path = absPath // 読み込んだファイルの絶対パスを保持
 	cfg.logger = logger
 
-	if err := cfg.validate(); err != nil {
+	if err := cfg.loadIncludes(); err != nil {
+		return nil, err
+	}
+
+	for _, fileID := range slices.Sorted(maps.Keys(options.versions)) {
+		if _, ok := cfg.Files[fileID]; !ok {
+			return nil, fmt.Errorf("cannot override version of file ID '%s': not defined in the configuration", fileID)
+		}
+	}
+
+	// 環境変数を展開する (URL テンプレートの展開とは別に、読み込み時に1回だけ行う)
+	if err := expandGlobalEnv(&cfg); err != nil {
+		return nil, err
+	}
+	for fileID, fileDef := range cfg.Files {
+		cfg.Defaults.applyDefaults(&fileDef)
+		if version, ok := options.versions[fileID]; ok {
+			logger.Info("Overriding version", "file_id", fileID, "config", fileDef.Version, "version", version)
+			fileDef.Version = version
+		}
+		if err := expandFileEnv(&fileDef); err != nil {
+			return nil, fmt.Errorf("file '%s': %w", fileID, err)
+		}
+		if err := expandSource(&fileDef); err != nil {
+			return nil, fmt.Errorf("file '%s': %w", fileID, err)
+		}
+		cfg.Files[fileID] = fileDef
+	}
+
+	if err := cfg.validate(strict); err != nil {
 		return nil, fmt.Errorf("config file validation failed: %w", err)
 	}
 	logger.Info("Config file loaded and validated successfully", "path", absPath)
@@ -86,8 +244,9 @@ func LoadConfig(configPath string, logger *slog.Logger) (*Config, error) {
 	return &cfg, nil
 }
 
-// validate は読み込んだ設定の内容を検証する
-func (c *Config) validate() error {
+// validate は読み込んだ設定の内容を検証する。
+// 設定として誤りではないが意図と異なる可能性が高いものは警告とし、strict の場合はまとめてエラーとして返す。
+func (c *Config) validate(strict bool) error {
 	if c.Version == "" {
 		return fmt.Errorf("config version is missing")
 	}
@@ -102,15 +261,76 @@ func (c *Config) validate() error {
 		return fmt.Errorf("invalid global hash_algorithm '%s': %w", c.HashAlgorithm, err)
 	}
 
+	if err := validateIDMap("platform_map", c.PlatformMap); err != nil {
+		return err
+	}
+	if err := validateIDMap("arch_map", c.ArchMap); err != nil {
+		return err
+	}
+	if c.Proxy != "" {
+		if _, err := download.ParseProxyURL(c.Proxy); err != nil {
+			return fmt.Errorf("proxy: %w", err)
+		}
+	}
+	if len(c.URLResolver) > 0 {
+		if _, err := download.NewURLResolver(c.URLResolver); err != nil {
+			return fmt.Errorf("url_resolver: %w", err)
+		}
+	}
+	if c.TLS != nil && (c.TLS.ClientCert == "") != (c.TLS.ClientKey == "") {
+		return fmt.Errorf("tls: client_cert and client_key must be specified together")
+	}
+
+	var warnings configWarnings
 	if len(c.Files) == 0 {
-		c.logger.Warn("No files defined in the configuration")
-		// エラーにはしないが警告
+		warnings.add("", "no files are defined in the configuration")
 	}
 
 	for fileID, fileDef := range c.Files {
 		if fileDef.URL == "" {
 			return fmt.Errorf("file '%s': url is required", fileID)
 		}
+		for i, part := range fileDef.Parts {
+			if part == "" {
+				return fmt.Errorf("file '%s': parts[%d] is empty", fileID, i)
+			}
+		}
+		if fileDef.ArchiveType != "" {
+			if !fileDef.IsArchive {
+				return fmt.Errorf("file '%s': archive_type requires is_archive: true", fileID)
+			}
+			if !slices.Contains(archive.SupportedTypes, fileDef.ArchiveType) {
+				return fmt.Errorf("file '%s': unsupported archive_type '%s' (supported: %s)", fileID, fileDef.ArchiveType, strings.Join(archive.SupportedTypes, ", "))
+			}
+		}
+		if fileDef.ChunkSize < 0 {
+			return fmt.Errorf("file '%s': chunk_size must not be negative", fileID)
+		}
+		if fileDef.PatchFrom != nil {
+			if fileDef.PatchFrom.BaseURL == "" || fileDef.PatchFrom.URL == "" {
+				return fmt.Errorf("file '%s': patch_from requires both base_url and url", fileID)
+			}
+			if len(fileDef.Parts) > 0 {
+				return fmt.Errorf("file '%s': patch_from cannot be combined with parts", fileID)
+			}
+		}
+		if err := validateMirrors(fileDef); err != nil {
+			return fmt.Errorf("file '%s': %w", fileID, err)
+		}
+		if err := validateURLTemplates(fileDef); err != nil {
+			return fmt.Errorf("file '%s': %w", fileID, err)
+		}
+		if err := template.Check("destination", fileDef.Destination); err != nil {
+			return fmt.Errorf("file '%s': invalid destination template: %w", fileID, err)
+		}
+		if fileDef.ChecksumsURL != "" && (len(fileDef.Parts) > 0 || fileDef.PatchFrom != nil) {
+			return fmt.Errorf("file '%s': checksums_url cannot be combined with parts or patch_from", fileID)
+		}
+		if fileDef.Signature != nil {
+			if err := validateSignature(fileDef.Signature); err != nil {
+				return fmt.Errorf("file '%s': %w", fileID, err)
+			}
+		}
 		if fileDef.HashAlgorithm != "" {
 			if _, err := hash.GetHasher(fileDef.HashAlgorithm); err != nil {
 				return fmt.Errorf("file '%s': invalid hash_algorithm '%s': %w", fileID, fileDef.HashAlgorithm, err)
@@ -119,8 +339,46 @@ func (c *Config) validate() error {
 		if fileDef.IsArchive && fileDef.StripComponents < 0 {
 			return fmt.Errorf("file '%s': strip_components cannot be negative", fileID)
 		}
+		if err := validateExtractPaths("extract_paths", fileDef.ExtractPaths); err != nil {
+			return fmt.Errorf("file '%s': %w", fileID, err)
+		}
+		if len(fileDef.ExtractExclude) > 0 && !fileDef.IsArchive {
+			return fmt.Errorf("file '%s': extract_exclude requires is_archive: true", fileID)
+		}
+		if err := validateExtractPaths("extract_exclude", fileDef.ExtractExclude); err != nil {
+			return fmt.Errorf("file '%s': %w", fileID, err)
+		}
+		if len(fileDef.Rename) > 0 && !fileDef.IsArchive {
+			return fmt.Errorf("file '%s': rename requires is_archive: true (use destination to name a plain file)", fileID)
+		}
+		if err := validateRename(fileDef.Rename); err != nil {
+			return fmt.Errorf("file '%s': %w", fileID, err)
+		}
+		if fileDef.Flatten && !fileDef.IsArchive {
+			return fmt.Errorf("file '%s': flatten requires is_archive: true", fileID)
+		}
+		if len(fileDef.Executables) > 0 && !fileDef.IsArchive {
+			return fmt.Errorf("file '%s': executables requires is_archive: true", fileID)
+		}
+		for _, pattern := range fileDef.Executables {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("file '%s': invalid executables pattern '%s': %w", fileID, pattern, err)
+			}
+		}
+		if _, err := ParseMode(fileDef.Mode); err != nil {
+			return fmt.Errorf("file '%s': %w", fileID, err)
+		}
+		if fileDef.Executable && fileDef.IsArchive {
+			return fmt.Errorf("file '%s': executable cannot be used with is_archive: true (use executables instead)", fileID)
+		}
+		if fileDef.PreservePermissions != nil && !fileDef.IsArchive {
+			return fmt.Errorf("file '%s': preserve_permissions requires is_archive: true", fileID)
+		}
+		if fileDef.Executable && fileDef.Mode != "" {
+			return fmt.Errorf("file '%s': executable cannot be combined with mode", fileID)
+		}
 		if !fileDef.IsArchive && (fileDef.StripComponents > 0 || len(fileDef.ExtractPaths) > 0) {
-			c.logger.Warn("file '%s': strip_components and extract_paths are ignored when is_archive is false", "file_id", fileID)
+			warnings.add(fileID, "strip_components and extract_paths are ignored when is_archive is false")
 		}
 
 		// プラットフォーム/アーキテクチャ定義の検証
@@ -132,47 +390,373 @@ func (c *Config) validate() error {
 				return fmt.Errorf("file '%s': platforms defined but architectures is missing", fileID)
 			}
 			for pID := range fileDef.Platforms {
-				if !platform.IsValidPlatform(pID) {
-					return fmt.Errorf("file '%s': invalid platform identifier '%s'", fileID, pID)
+				if !c.IsValidPlatform(pID) {
+					return fmt.Errorf("file '%s': invalid platform identifier '%s' (valid: %s)", fileID, pID, strings.Join(c.AllPlatforms(), ", "))
 				}
 			}
 			for aID := range fileDef.Architectures {
-				if !platform.IsValidArch(aID) {
-					return fmt.Errorf("file '%s': invalid architecture identifier '%s'", fileID, aID)
+				if !c.IsValidArch(aID) {
+					return fmt.Errorf("file '%s': invalid architecture identifier '%s' (valid: %s)", fileID, aID, strings.Join(c.AllArchs(), ", "))
+				}
+			}
+			if len(fileDef.ArchVariants) > 0 && !c.hasArmArch(fileDef.Architectures) {
+				return fmt.Errorf("file '%s': arch_variants requires a 32-bit ARM architecture in architectures", fileID)
+			}
+			for vID := range fileDef.ArchVariants {
+				if !platform.IsValidArchVariant(vID) {
+					return fmt.Errorf("file '%s': invalid arch variant identifier '%s' (valid: %s)", fileID, vID, strings.Join(platform.GetAllArchVariants(), ", "))
 				}
 			}
 		} else {
-			// プラットフォーム定義がないのに override があるのはおかしい
-			if len(fileDef.Overrides) > 0 {
-				return fmt.Errorf("file '%s': overrides are defined but platforms/architectures are not specified", fileID)
+			// 全プラットフォーム共通のファイルでも overrides は指定できる (特定のプラットフォームでのみURLを変えるなど)
+			if len(fileDef.ArchVariants) > 0 {
+				return fmt.Errorf("file '%s': arch_variants are defined but platforms/architectures are not specified", fileID)
+			}
+		}
+
+		if err := validateHeaders(fileDef.Headers); err != nil {
+			return fmt.Errorf("file '%s': %w", fileID, err)
+		}
+		if fileDef.ExpectContentType != "" {
+			if err := download.ParseContentType(fileDef.ExpectContentType); err != nil {
+				return fmt.Errorf("file '%s': expect_content_type: %w", fileID, err)
 			}
 		}
 
 		// Override の検証
 		for overrideKey, overrideDef := range fileDef.Overrides {
-			parts := strings.SplitN(overrideKey, "/", 2)
-			if len(parts) != 2 {
-				return fmt.Errorf("file '%s': invalid override key format '%s', expected 'platform/arch'", fileID, overrideKey)
-			}
-			pID, aID := parts[0], parts[1]
-			if _, ok := fileDef.Platforms[pID]; !ok {
-				return fmt.Errorf("file '%s': override key '%s' contains platform '%s' not defined in platforms section", fileID, overrideKey, pID)
-			}
-			if _, ok := fileDef.Architectures[aID]; !ok {
-				return fmt.Errorf("file '%s': override key '%s' contains architecture '%s' not defined in architectures section", fileID, overrideKey, aID)
+			if err := c.validateOverrideKey(fileDef, overrideKey); err != nil {
+				return fmt.Errorf("file '%s': %w", fileID, err)
 			}
 			if overrideDef.HashAlgorithm != "" {
 				if _, err := hash.GetHasher(overrideDef.HashAlgorithm); err != nil {
 					return fmt.Errorf("file '%s', override '%s': invalid hash_algorithm '%s': %w", fileID, overrideKey, overrideDef.HashAlgorithm, err)
 				}
 			}
+			if err := validateExtractPaths("extract_paths", overrideDef.ExtractPaths); err != nil {
+				return fmt.Errorf("file '%s', override '%s': %w", fileID, overrideKey, err)
+			}
+			if len(overrideDef.ExtractExclude) > 0 && !fileDef.IsArchive {
+				return fmt.Errorf("file '%s', override '%s': extract_exclude requires is_archive: true", fileID, overrideKey)
+			}
+			if err := validateExtractPaths("extract_exclude", overrideDef.ExtractExclude); err != nil {
+				return fmt.Errorf("file '%s', override '%s': %w", fileID, overrideKey, err)
+			}
+			if len(overrideDef.Rename) > 0 && !fileDef.IsArchive {
+				return fmt.Errorf("file '%s', override '%s': rename requires is_archive: true", fileID, overrideKey)
+			}
+			if err := validateRename(overrideDef.Rename); err != nil {
+				return fmt.Errorf("file '%s', override '%s': %w", fileID, overrideKey, err)
+			}
+			if err := validateHeaders(overrideDef.Headers); err != nil {
+				return fmt.Errorf("file '%s', override '%s': %w", fileID, overrideKey, err)
+			}
+			if err := template.Check("url", overrideDef.URL); err != nil {
+				return fmt.Errorf("file '%s', override '%s': invalid url template: %w", fileID, overrideKey, err)
+			}
+			if err := template.Check("destination", overrideDef.Destination); err != nil {
+				return fmt.Errorf("file '%s', override '%s': invalid destination template: %w", fileID, overrideKey, err)
+			}
+			if _, err := ParseMode(overrideDef.Mode); err != nil {
+				return fmt.Errorf("file '%s', override '%s': %w", fileID, overrideKey, err)
+			}
 			// 他のOverrideフィールドのバリデーションが必要なら追加
 		}
 	}
 
+	if strict {
+		return warnings.err()
+	}
+	warnings.log(c.logger)
+	return nil
+}
+
+// configWarnings は validate で見つかった警告。同じ内容の警告はファイルIDをまとめて扱う。
+type configWarnings struct {
+	messages []string                  // 最初に見つかった順
+	fileIDs  map[string][]model.FileID // key: メッセージ
+}
+
+// add は fileID (設定全体の警告の場合は空) についての警告を追加する
+func (w *configWarnings) add(fileID model.FileID, message string) {
+	if w.fileIDs == nil {
+		w.fileIDs = make(map[string][]model.FileID)
+	}
+	if _, ok := w.fileIDs[message]; !ok {
+		w.messages = append(w.messages, message)
+	}
+	if fileID != "" {
+		w.fileIDs[message] = append(w.fileIDs[message], fileID)
+	}
+}
+
+// log は警告をログに出力する
+func (w *configWarnings) log(logger *slog.Logger) {
+	for _, message := range w.messages {
+		if fileIDs := w.fileIDs[message]; len(fileIDs) > 0 {
+			slices.Sort(fileIDs)
+			logger.Warn(message, "file_ids", fileIDs)
+		} else {
+			logger.Warn(message)
+		}
+	}
+}
+
+// err は警告をまとめたエラーを返す (--strict 用)。警告がない場合は nil を返す。
+func (w *configWarnings) err() error {
+	if len(w.messages) == 0 {
+		return nil
+	}
+	problems := make([]string, 0, len(w.messages))
+	for _, message := range w.messages {
+		fileIDs := w.fileIDs[message]
+		if len(fileIDs) == 0 {
+			problems = append(problems, message)
+			continue
+		}
+		slices.Sort(fileIDs)
+		ids := make([]string, len(fileIDs))
+		for i, id := range fileIDs {
+			ids[i] = string(id)
+		}
+		problems = append(problems, fmt.Sprintf("%s (file IDs: %s)", message, strings.Join(ids, ", ")))
+	}
+	return fmt.Errorf("strict mode: %d warning(s) treated as errors: %s", len(problems), strings.Join(problems, "; "))
+}
+
+// ParseMode は mode の値 (8進数の文字列) をパーミッションに変換する。空の場合は 0 を返す。
+func ParseMode(mode string) (fs.FileMode, error) {
+	if mode == "" {
+		return 0, nil
+	}
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || perm > 0o777 {
+		return 0, fmt.Errorf("invalid mode '%s' (expected an octal permission such as \"0644\")", mode)
+	}
+	return fs.FileMode(perm), nil
+}
+
+// validateOverrideKey は override のキー ("platform/arch" または "platform/arch/variant") を検証する。
+// platforms/architectures を指定したファイルではそこで定義した識別子、全プラットフォーム共通のファイルでは
+// 使用できる全ての識別子を指定できる。プラットフォームとアーキテクチャには全てに一致する "*" も使える (バリアントを含むキーを除く)。
+func (c *Config) validateOverrideKey(fileDef FileDef, key string) error {
+	parts := strings.Split(key, "/")
+	if len(parts) != 2 && len(parts) != 3 {
+		return fmt.Errorf("invalid override key format '%s', expected 'platform/arch' or 'platform/arch/variant'", key)
+	}
+	pID, aID := parts[0], parts[1]
+	switch {
+	case pID == overrideWildcard:
+	case !fileDef.hasVariants():
+		if !c.IsValidPlatform(pID) {
+			return fmt.Errorf("override key '%s' contains invalid platform identifier '%s' (valid: %s)", key, pID, strings.Join(c.AllPlatforms(), ", "))
+		}
+	default:
+		if _, ok := fileDef.Platforms[pID]; !ok {
+			return fmt.Errorf("override key '%s' contains platform '%s' not defined in platforms section", key, pID)
+		}
+	}
+	switch {
+	case aID == overrideWildcard:
+	case !fileDef.hasVariants():
+		if !c.IsValidArch(aID) {
+			return fmt.Errorf("override key '%s' contains invalid architecture identifier '%s' (valid: %s)", key, aID, strings.Join(c.AllArchs(), ", "))
+		}
+	default:
+		if _, ok := fileDef.Architectures[aID]; !ok {
+			return fmt.Errorf("override key '%s' contains architecture '%s' not defined in architectures section", key, aID)
+		}
+	}
+	if len(parts) == 3 {
+		if pID == overrideWildcard || aID == overrideWildcard {
+			return fmt.Errorf("override key '%s' cannot use '%s' together with a variant", key, overrideWildcard)
+		}
+		if _, ok := fileDef.ArchVariants[parts[2]]; !ok {
+			return fmt.Errorf("override key '%s' contains variant '%s' not defined in arch_variants section", key, parts[2])
+		}
+		if !c.IsArmArch(aID) {
+			return fmt.Errorf("override key '%s' specifies a variant for non-ARM architecture '%s'", key, aID)
+		}
+	}
+	return nil
+}
+
+// validateMirrors は mirrors (Override を含む) を検証する。
+// mirrors は url の代替であり、パートごとに取得する parts や、url を取得しない patch_from とは組み合わせられない。
+func validateMirrors(fileDef FileDef) error {
+	check := func(field string, mirrors []string) error {
+		for i, mirror := range mirrors {
+			if mirror == "" {
+				return fmt.Errorf("%s[%d] is empty", field, i)
+			}
+		}
+		if len(mirrors) > 0 && (len(fileDef.Parts) > 0 || fileDef.PatchFrom != nil) {
+			return fmt.Errorf("%s cannot be combined with parts or patch_from", field)
+		}
+		return nil
+	}
+	if err := check("mirrors", fileDef.Mirrors); err != nil {
+		return err
+	}
+	for _, key := range slices.Sorted(maps.Keys(fileDef.Overrides)) {
+		if err := check("overrides."+key+".mirrors", fileDef.Overrides[key].Mirrors); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateURLTemplates は URL のテンプレート (url, mirrors, parts, checksums_url, patch_from, signature.url) の構文と
+// 参照している変数を検証する。Override の url と mirrors も含む。
+func validateURLTemplates(fileDef FileDef) error {
+	type field struct{ key, value string }
+	fields := []field{{"url", fileDef.URL}, {"checksums_url", fileDef.ChecksumsURL}}
+	for i, mirror := range fileDef.Mirrors {
+		fields = append(fields, field{fmt.Sprintf("mirrors[%d]", i), mirror})
+	}
+	for i, part := range fileDef.Parts {
+		fields = append(fields, field{fmt.Sprintf("parts[%d]", i), part})
+	}
+	if fileDef.PatchFrom != nil {
+		fields = append(fields, field{"patch_from.base_url", fileDef.PatchFrom.BaseURL}, field{"patch_from.url", fileDef.PatchFrom.URL})
+	}
+	if fileDef.Signature != nil {
+		fields = append(fields, field{"signature.url", fileDef.Signature.URL})
+	}
+	for _, key := range slices.Sorted(maps.Keys(fileDef.Overrides)) {
+		for i, mirror := range fileDef.Overrides[key].Mirrors {
+			fields = append(fields, field{fmt.Sprintf("overrides.%s.mirrors[%d]", key, i), mirror})
+		}
+	}
+	for _, f := range fields {
+		if err := template.Check("url", f.value); err != nil {
+			return fmt.Errorf("invalid %s template: %w", f.key, err)
+		}
+	}
+	return nil
+}
+
+// validateSignature は signature の指定が type に対して正しいことを検証する
+func validateSignature(sig *SignatureDef) error {
+	if sig.URL == "" {
+		return fmt.Errorf("signature requires url")
+	}
+	switch sig.Type {
+	case "", SignatureTypeGPG:
+		if sig.PublicKey == "" {
+			return fmt.Errorf("signature of type %s requires public_key", SignatureTypeGPG)
+		}
+		if sig.Identity != "" || sig.Issuer != "" || sig.TrustedRoot != "" {
+			return fmt.Errorf("identity, issuer and trusted_root require signature type %s", SignatureTypeCosign)
+		}
+	case SignatureTypeCosign:
+		if sig.Identity == "" || sig.Issuer == "" {
+			return fmt.Errorf("signature of type %s requires both identity and issuer", SignatureTypeCosign)
+		}
+		if sig.PublicKey != "" {
+			return fmt.Errorf("public_key cannot be used with signature type %s (only keyless signatures are supported)", SignatureTypeCosign)
+		}
+	default:
+		return fmt.Errorf("unsupported signature type '%s' (valid: %s, %s)", sig.Type, SignatureTypeGPG, SignatureTypeCosign)
+	}
+	return nil
+}
+
+// validateHeaders は headers のキーがHTTPヘッダ名として使えることを検証する
+func validateHeaders(headers map[string]string) error {
+	for key := range headers {
+		if key == "" || strings.ContainsAny(key, " \t\r\n:") {
+			return fmt.Errorf("invalid header name '%s'", key)
+		}
+	}
+	return nil
+}
+
+// validateExtractPaths は extract_paths (または extract_exclude) の各パターンが ".." を含まず、グロブの構文が正しいことを検証する。
+// パターンは常に strip 後のアーカイブのルートからの相対パスとして扱われるため、".." は意味を持たない。
+// key はエラーメッセージに使う設定項目名。
+func validateExtractPaths(key string, extractPaths []string) error {
+	for _, p := range extractPaths {
+		for _, component := range strings.FieldsFunc(p, func(r rune) bool { return r == '/' || r == '\\' }) {
+			if component == ".." {
+				return fmt.Errorf("%s entry '%s' must not contain '..'", key, p)
+			}
+		}
+		if archive.IsGlobPattern(p) {
+			if err := archive.ValidateGlob(p); err != nil {
+				return fmt.Errorf("invalid %s pattern '%s': %w", key, p, err)
+			}
+		}
+	}
+	return nil
+}
+
+// SelectFiles は patterns (path.Match 形式のグロブ) のいずれかに一致するファイルIDのみを残す。
+// patterns が空の場合は何もしない。どのファイルIDにも一致しないパターンがある場合はエラーを返す。
+func (c *Config) SelectFiles(patterns []string) error {
+	if len(patterns) == 0 {
+		return nil
+	}
+
+	selected := make(map[model.FileID]FileDef)
+	for _, pattern := range patterns {
+		fileIDs, err := c.matchFiles(pattern)
+		if err != nil {
+			return err
+		}
+		for _, fileID := range fileIDs {
+			selected[fileID] = c.Files[fileID]
+		}
+	}
+
+	c.logger.Debug("Selected files", "patterns", patterns, "count", len(selected))
+	c.Files = selected
+	return nil
+}
+
+// ExcludeFiles は patterns (path.Match 形式のグロブ) のいずれかに一致するファイルIDを取り除く。
+// どのファイルIDにも一致しないパターンがある場合はエラーを返す (SelectFiles の後に呼ぶ場合は選択後のファイルIDが対象)。
+func (c *Config) ExcludeFiles(patterns []string) error {
+	if len(patterns) == 0 {
+		return nil
+	}
+
+	excluded := make(map[model.FileID]struct{})
+	for _, pattern := range patterns {
+		fileIDs, err := c.matchFiles(pattern)
+		if err != nil {
+			return err
+		}
+		for _, fileID := range fileIDs {
+			excluded[fileID] = struct{}{}
+		}
+	}
+	for fileID := range excluded {
+		delete(c.Files, fileID)
+	}
+
+	c.logger.Debug("Excluded files", "patterns", patterns, "count", len(excluded), "remaining", len(c.Files))
 	return nil
 }
 
+// matchFiles は pattern に一致するファイルIDを返す。一致するファイルIDがない場合はエラーを返す。
+func (c *Config) matchFiles(pattern string) ([]model.FileID, error) {
+	var fileIDs []model.FileID
+	for fileID := range c.Files {
+		ok, err := path.Match(pattern, string(fileID))
+		if err != nil {
+			return nil, fmt.Errorf("invalid file ID pattern '%s': %w", pattern, err)
+		}
+		if ok {
+			fileIDs = append(fileIDs, fileID)
+		}
+	}
+	if len(fileIDs) == 0 {
+		return nil, fmt.Errorf("file ID pattern '%s' does not match any file in the configuration", pattern)
+	}
+	return fileIDs, nil
+}
+
 // GetConfigDir は設定ファイルが存在するディレクトリのパスを返す
 func (c *Config) GetConfigDir() string {
 	return filepath.Dir(c.path)
@@ -180,19 +764,16 @@ func (c *Config) GetConfigDir() string {
 
 // GetEffectiveHashAlgorithm はファイル定義とグローバル設定を考慮して、
 // 特定のファイル (または Override) に適用されるハッシュアルゴリズムを返す
-func (c *Config) GetEffectiveHashAlgorithm(fileID model.FileID, platformID, archID string) hash.HashAlgorithm {
+func (c *Config) GetEffectiveHashAlgorithm(fileID model.FileID, platformID, archID, variant string) hash.HashAlgorithm {
 	fileDef, ok := c.Files[fileID]
 	if !ok {
 		// 通常は呼び出し元でチェックされるはず
 		return c.HashAlgorithm // fallback to global
 	}
 
-	if platformID != "" && archID != "" {
-		overrideKey := platformID + "/" + archID
-		if overrideDef, ok := fileDef.Overrides[overrideKey]; ok {
-			if overrideDef.HashAlgorithm != "" {
-				return overrideDef.HashAlgorithm
-			}
+	for _, overrideDef := range fileDef.overridesFor(platformID, archID, variant) {
+		if overrideDef.HashAlgorithm != "" {
+			return overrideDef.HashAlgorithm
 		}
 	}
 
@@ -205,21 +786,59 @@ func (c *Config) GetEffectiveHashAlgorithm(fileID model.FileID, platformID, arch
 
 // --- Helper functions to get effective values considering overrides ---
 
-func (f *FileDef) GetEffectiveURLTemplate(platformID, archID string) string {
-	if platformID != "" && archID != "" {
-		overrideKey := platformID + "/" + archID
-		if overrideDef, ok := f.Overrides[overrideKey]; ok && overrideDef.URL != "" {
+// overrideWildcard は override のキーで全てのプラットフォーム (またはアーキテクチャ) に一致する指定
+const overrideWildcard = "*"
+
+// overridesFor は適用される Override を優先度の高い順に返す。
+// "platform/arch/variant"、"platform/arch"、"platform/*"、"*/arch"、"*/*" の順に優先される。
+func (f *FileDef) overridesFor(platformID, archID, variant string) []OverrideFileDef {
+	if platformID == "" || archID == "" {
+		return nil
+	}
+	keys := []string{
+		platformID + "/" + archID,
+		platformID + "/" + overrideWildcard,
+		overrideWildcard + "/" + archID,
+		overrideWildcard + "/" + overrideWildcard,
+	}
+	if variant != "" {
+		keys = slices.Insert(keys, 0, platformID+"/"+archID+"/"+variant)
+	}
+	var overrides []OverrideFileDef
+	for i, key := range keys {
+		if slices.Contains(keys[:i], key) {
+			continue // platformID や archID 自体が "*" の場合の重複
+		}
+		if overrideDef, ok := f.Overrides[key]; ok {
+			overrides = append(overrides, overrideDef)
+		}
+	}
+	return overrides
+}
+
+func (f *FileDef) GetEffectiveURLTemplate(platformID, archID, variant string) string {
+	for _, overrideDef := range f.overridesFor(platformID, archID, variant) {
+		if overrideDef.URL != "" {
 			return overrideDef.URL
 		}
 	}
 	return f.URL
 }
 
+// GetEffectiveMirrors は Override を考慮した mirrors のテンプレートを返す
+func (f *FileDef) GetEffectiveMirrors(platformID, archID, variant string) []string {
+	for _, overrideDef := range f.overridesFor(platformID, archID, variant) {
+		if len(overrideDef.Mirrors) > 0 {
+			return overrideDef.Mirrors
+		}
+	}
+	return f.Mirrors
+}
+
 // GetEffectiveDestination は Override を考慮した Destination を返す
-func (f *FileDef) GetEffectiveDestination(platformID, archID string) string {
-	if platformID != "" && archID != "" {
-		overrideKey := platformID + "/" + archID
-		if overrideDef, ok := f.Overrides[overrideKey]; ok && overrideDef.Destination != "" {
+func (f *FileDef) GetEffectiveDestination(platformID, archID, variant string) string {
+	for _, overrideDef := range f.overridesFor(platformID, archID, variant) {
+		if overrideDef.Destination != "" {
 			return overrideDef.Destination
 		}
 	}
@@ -227,16 +846,141 @@ func (f *FileDef) GetEffectiveDestination(platformID, archID string) string {
 }
 
 // GetEffectiveExtractPaths は Override を考慮した ExtractPaths を返す
-func (f *FileDef) GetEffectiveExtractPaths(platformID, archID string) []string {
-	if platformID != "" && archID != "" {
-		overrideKey := platformID + "/" + archID
-		if overrideDef, ok := f.Overrides[overrideKey]; ok && len(overrideDef.ExtractPaths) > 0 {
+func (f *FileDef) GetEffectiveExtractPaths(platformID, archID, variant string) []string {
+	for _, overrideDef := range f.overridesFor(platformID, archID, variant) {
+		if len(overrideDef.ExtractPaths) > 0 {
 			return overrideDef.ExtractPaths
 		}
 	}
 	return f.ExtractPaths
 }
 
+// validateRename は rename のキーと値がいずれも空でなく、絶対パスや ".." を含まないことを検証する。
+// 展開先の外に書き込まないよう、値も展開先からの相対パスに限る。
+func validateRename(rename map[string]string) error {
+	for from, to := range rename {
+		for _, p := range []string{from, to} {
+			if strings.TrimSpace(p) == "" {
+				return fmt.Errorf("rename entry '%s' -> '%s' must not be empty", from, to)
+			}
+			if path.IsAbs(filepath.ToSlash(p)) || filepath.IsAbs(p) {
+				return fmt.Errorf("rename entry '%s' -> '%s' must be a relative path", from, to)
+			}
+			for _, component := range strings.FieldsFunc(p, func(r rune) bool { return r == '/' || r == '\\' }) {
+				if component == ".." {
+					return fmt.Errorf("rename entry '%s' -> '%s' must not contain '..'", from, to)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// GetEffectiveRename は Override を考慮した Rename を返す
+func (f *FileDef) GetEffectiveRename(platformID, archID, variant string) map[string]string {
+	for _, overrideDef := range f.overridesFor(platformID, archID, variant) {
+		if len(overrideDef.Rename) > 0 {
+			return overrideDef.Rename
+		}
+	}
+	return f.Rename
+}
+
+// GetEffectiveExtractExclude は Override を考慮した ExtractExclude を返す
+func (f *FileDef) GetEffectiveExtractExclude(platformID, archID, variant string) []string {
+	for _, overrideDef := range f.overridesFor(platformID, archID, variant) {
+		if len(overrideDef.ExtractExclude) > 0 {
+			return overrideDef.ExtractExclude
+		}
+	}
+	return f.ExtractExclude
+}
+
+// PreservesPermissions はアーカイブ内のパーミッションを使って展開する場合に true を返す
+func (f *FileDef) PreservesPermissions() bool {
+	return f.PreservePermissions == nil || *f.PreservePermissions
+}
+
+// GetEffectiveMode は Override を考慮した mode をパーミッションに変換して返す。未指定の場合は ok が false となる。
+func (f *FileDef) GetEffectiveMode(platformID, archID, variant string) (mode fs.FileMode, ok bool) {
+	modeStr := f.Mode
+	for _, overrideDef := range f.overridesFor(platformID, archID, variant) {
+		if overrideDef.Mode != "" {
+			modeStr = overrideDef.Mode
+			break
+		}
+	}
+	if modeStr == "" {
+		return 0, false
+	}
+	mode, err := ParseMode(modeStr)
+	if err != nil {
+		return 0, false // 設定読み込み時に検証済み
+	}
+	return mode, true
+}
+
+// GetEffectiveHeaders は Override を考慮した Headers を返す。
+// Override の headers はキーごとに FileDef の headers を上書きする ("platform/arch/variant" が最も優先される)。
+func (f *FileDef) GetEffectiveHeaders(platformID, archID, variant string) map[string]string {
+	headers := make(map[string]string, len(f.Headers))
+	for key, value := range f.Headers {
+		headers[key] = value
+	}
+	overrides := f.overridesFor(platformID, archID, variant)
+	for i := len(overrides) - 1; i >= 0; i-- {
+		for key, value := range overrides[i].Headers {
+			headers[key] = value
+		}
+	}
+	return headers
+}
+
+// ResolveLockPath は Lock ファイルのパスを解決する。name が空の場合はデフォルトの Lock ファイル名を使う。
+// name では ${NAME} や ${NAME:-default} で環境変数を参照できる (環境ごとに Lock ファイルを分ける場合など)。
+// 相対パスは設定ファイルのディレクトリ基準で解決し、そのディレクトリの外を指す場合はエラーを返す。
+func (c *Config) ResolveLockPath(name string) (string, error) {
+	if name == "" {
+		name = lock.LockFileName
+	}
+	expanded, err := ExpandEnv(name)
+	if err != nil {
+		return "", fmt.Errorf("lock file path: %w", err)
+	}
+	if expanded == "" {
+		return "", fmt.Errorf("lock file path %q is empty after expansion", name)
+	}
+	if filepath.IsAbs(expanded) {
+		return expanded, nil
+	}
+	lockPath := filepath.Join(c.GetConfigDir(), expanded)
+	rel, err := filepath.Rel(c.GetConfigDir(), lockPath)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("lock file path %s must be within the config directory %s (use an absolute path otherwise)", expanded, c.GetConfigDir())
+	}
+	return lockPath, nil
+}
+
+// TLSOptions は tls の設定をパスを設定ファイル基準で解決して返す
+func (c *Config) TLSOptions() download.TLSOptions {
+	if c.TLS == nil {
+		return download.TLSOptions{}
+	}
+	resolve := func(p string) string {
+		if p == "" {
+			return ""
+		}
+		resolved, _ := c.ResolveDestPath(p) // 空でなければエラーにならない
+		return resolved
+	}
+	return download.TLSOptions{
+		CACertFile:         resolve(c.TLS.CACert),
+		ClientCertFile:     resolve(c.TLS.ClientCert),
+		ClientKeyFile:      resolve(c.TLS.ClientKey),
+		InsecureSkipVerify: c.TLS.InsecureSkipVerify,
+	}
+}
+
 // ResolveDestPath は Destination を設定ファイルのパス基準で解決する
 func (c *Config) ResolveDestPath(dest string) (string, error) {
 	if dest == "" {
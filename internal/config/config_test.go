@@ -0,0 +1,274 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hrko/dltofu/internal/hash"
+	"github.com/hrko/dltofu/internal/model"
+)
+
+func TestLoadConfigValidatesTemplates(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string // files.tool の定義
+		wantErr string // 空ならエラーにならない
+	}{
+		{
+			name: "valid templates",
+			file: `
+    url: https://example.com/tool-{{.Platform}}-{{.Architecture}}
+    destination: bin/{{.Platform}}/tool`,
+		},
+		{
+			name: "unclosed destination",
+			file: `
+    url: https://example.com/tool
+    destination: bin/{{.Platform/tool`,
+			wantErr: "file 'tool': invalid destination template",
+		},
+		{
+			name: "unknown field in destination",
+			file: `
+    url: https://example.com/tool
+    destination: bin/{{.Os}}/tool`,
+			wantErr: "file 'tool': invalid destination template",
+		},
+		{
+			name: "unclosed url",
+			file: `
+    url: https://example.com/tool-{{.Platform
+    destination: tool`,
+			wantErr: "file 'tool': invalid url template",
+		},
+		{
+			name: "unclosed override destination",
+			file: `
+    url: https://example.com/tool
+    destination: tool
+    overrides:
+      linux/x86_64:
+        destination: bin/{{.Architecture`,
+			wantErr: "file 'tool', override 'linux/x86_64': invalid destination template",
+		},
+		{
+			name: "unclosed override url",
+			file: `
+    url: https://example.com/tool
+    destination: tool
+    overrides:
+      linux/x86_64:
+        url: https://example.com/{{.Version`,
+			wantErr: "file 'tool', override 'linux/x86_64': invalid url template",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(t.TempDir(), "dltofu.yml")
+			content := "version: v1\nfiles:\n  tool:" + tt.file + "\n"
+			if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadConfig(p, nil, false)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("LoadConfig() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("LoadConfig() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadConfigValidatesExtractPaths(t *testing.T) {
+	tests := []struct {
+		name         string
+		extractPaths string // files.tool の extract_paths
+		override     string // overrides.linux/x86_64 の extract_paths
+		wantErr      string // 空ならエラーにならない
+	}{
+		{name: "relative patterns", extractPaths: `["bin/*", "share/man/**", "README.md"]`},
+		{name: "dot components", extractPaths: `["./bin/tool", "lib/./libtool.so"]`},
+		{name: "dots inside a name", extractPaths: `["bin/..tool", "tool..bak", "..."]`},
+		{name: "relative override patterns", override: `["bin/tool"]`},
+		{name: "leading parent", extractPaths: `["../etc/passwd"]`, wantErr: "file 'tool': extract_paths entry '../etc/passwd' must not contain '..'"},
+		{name: "inner parent", extractPaths: `["bin/../../etc"]`, wantErr: "must not contain '..'"},
+		{name: "only parent", extractPaths: `[".."]`, wantErr: "must not contain '..'"},
+		{name: "backslash parent", extractPaths: `['bin\..\etc']`, wantErr: "must not contain '..'"},
+		{name: "parent in override", override: `["../etc"]`, wantErr: "file 'tool', override 'linux/x86_64': extract_paths entry '../etc' must not contain '..'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := "version: v1\nfiles:\n  tool:\n    url: https://example.com/tool.tar.gz\n    destination: tool\n    is_archive: true\n"
+			if tt.extractPaths != "" {
+				content += "    extract_paths: " + tt.extractPaths + "\n"
+			}
+			if tt.override != "" {
+				content += "    overrides:\n      linux/x86_64:\n        extract_paths: " + tt.override + "\n"
+			}
+			p := filepath.Join(t.TempDir(), "dltofu.yml")
+			if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadConfig(p, nil, false)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("LoadConfig() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("LoadConfig() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadConfigValidatesArchiveType(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string // files.tool の定義 (url と destination 以外)
+		wantErr string // 空ならエラーにならない
+	}{
+		{name: "supported type", file: "    is_archive: true\n    archive_type: tar.gz\n"},
+		{name: "zip", file: "    is_archive: true\n    archive_type: zip\n"},
+		{name: "unsupported type", file: "    is_archive: true\n    archive_type: rar\n", wantErr: "file 'tool': unsupported archive_type 'rar'"},
+		{name: "without is_archive", file: "    archive_type: zip\n", wantErr: "file 'tool': archive_type requires is_archive: true"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(t.TempDir(), "dltofu.yml")
+			content := "version: v1\nfiles:\n  tool:\n    url: https://example.com/download?id=1\n    destination: tool\n" + tt.file
+			if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadConfig(p, nil, false)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("LoadConfig() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("LoadConfig() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGetEffectiveHashAlgorithm(t *testing.T) {
+	content := `version: v1
+hash_algorithm: blake3
+files:
+  tool:
+    url: https://example.com/tool-{{.Platform}}-{{.Architecture}}
+    destination: tool
+    hash_algorithm: sha256
+    platforms:
+      linux: linux
+      windows: windows
+      macos: darwin
+    architectures:
+      x86_64: amd64
+      arm64: arm64
+    overrides:
+      windows/*:
+        hash_algorithm: sha512
+      windows/arm64:
+        hash_algorithm: blake2b
+      macos/x86_64:
+        url: https://example.com/tool-mac
+  plain:
+    url: https://example.com/plain
+    destination: plain
+`
+	p := filepath.Join(t.TempDir(), "dltofu.yml")
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := LoadConfig(p, nil, false)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	tests := []struct {
+		fileID         model.FileID
+		platform, arch string
+		want           hash.HashAlgorithm
+	}{
+		{fileID: "tool", platform: "linux", arch: "x86_64", want: hash.AlgoSHA256},
+		{fileID: "tool", platform: "windows", arch: "x86_64", want: hash.AlgoSHA512},
+		{fileID: "tool", platform: "windows", arch: "arm64", want: hash.AlgoBLAKE2b},
+		{fileID: "tool", platform: "macos", arch: "x86_64", want: hash.AlgoSHA256}, // hash_algorithm のない Override は無視される
+		{fileID: "tool", want: hash.AlgoSHA256},
+		{fileID: "plain", platform: "windows", arch: "x86_64", want: hash.AlgoBLAKE3},
+		{fileID: "missing", want: hash.AlgoBLAKE3},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.fileID)+"/"+tt.platform+"/"+tt.arch, func(t *testing.T) {
+			if got := c.GetEffectiveHashAlgorithm(tt.fileID, tt.platform, tt.arch, ""); got != tt.want {
+				t.Errorf("GetEffectiveHashAlgorithm() = %s, want %s", got, tt.want)
+			}
+			if tt.platform == "" {
+				return
+			}
+			// SelectTarget も同じアルゴリズムを使う
+			target, applicable, err := c.SelectTarget(tt.fileID, tt.platform, tt.arch, "")
+			if err != nil || !applicable {
+				t.Fatalf("SelectTarget() = %v, %v, %v", target, applicable, err)
+			}
+			if target.HashAlgorithm != tt.want {
+				t.Errorf("SelectTarget().HashAlgorithm = %s, want %s", target.HashAlgorithm, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveLockPath(t *testing.T) {
+	t.Setenv("DLTOFU_TEST_ENV", "prod")
+	t.Setenv("DLTOFU_TEST_EMPTY", "")
+	c := loadFiles(t, "tool")
+	dir := c.GetConfigDir()
+	abs := filepath.Join(t.TempDir(), "shared.lock")
+	tests := []struct {
+		name    string
+		want    string
+		wantErr string // 空ならエラーにならない
+	}{
+		{name: "", want: filepath.Join(dir, "dltofu.lock")},
+		{name: "custom.lock", want: filepath.Join(dir, "custom.lock")},
+		{name: "dltofu.${DLTOFU_TEST_ENV}.lock", want: filepath.Join(dir, "dltofu.prod.lock")},
+		{name: "locks/${DLTOFU_TEST_ENV}/dltofu.lock", want: filepath.Join(dir, "locks", "prod", "dltofu.lock")},
+		{name: "dltofu.${DLTOFU_TEST_UNSET:-dev}.lock", want: filepath.Join(dir, "dltofu.dev.lock")},
+		{name: "dltofu.${DLTOFU_TEST_EMPTY:-dev}.lock", want: filepath.Join(dir, "dltofu.dev.lock")},
+		{name: "locks/../dltofu.lock", want: filepath.Join(dir, "dltofu.lock")},
+		{name: abs, want: abs},
+		{name: "dltofu.${DLTOFU_TEST_UNSET}.lock", wantErr: "environment variable DLTOFU_TEST_UNSET is not set"},
+		{name: "${DLTOFU_TEST_EMPTY}", wantErr: "is empty after expansion"},
+		{name: "../dltofu.lock", wantErr: "must be within the config directory"},
+		{name: "locks/../../dltofu.lock", wantErr: "must be within the config directory"},
+		{name: "../${DLTOFU_TEST_ENV}.lock", wantErr: "must be within the config directory"},
+		{name: ".", wantErr: "must be within the config directory"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.ResolveLockPath(tt.name)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ResolveLockPath() error = %v", err)
+				}
+				if got != tt.want {
+					t.Errorf("ResolveLockPath() = %s, want %s", got, tt.want)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ResolveLockPath() = %s, %v, want error containing %q", got, err, tt.wantErr)
+			}
+		})
+	}
+}
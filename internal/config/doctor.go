@@ -0,0 +1,295 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/hrko/dltofu/internal/model"
+)
+
+// Issue は Diagnose が検出した設定ファイルの問題
+type Issue struct {
+	FileID  model.FileID // 設定ファイル全体に関する問題の場合は空
+	Message string
+	Fixable bool // 意味を変えずに自動修正できるか
+}
+
+// Diagnose は設定ファイルの内容 (YAML) を検査し、検出した問題を返す。
+// 自動修正できる問題 (Fixable) は修正を適用した内容を fixed として返す (修正がない場合は nil)。
+// 自動修正できない問題は、修正後の内容を検証した結果として報告する。
+// コメントや記述順をできるだけ保つため、構造体ではなく yaml.Node 上で修正する。
+func Diagnose(data []byte) (issues []Issue, fixed []byte, err error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, nil, fmt.Errorf("config must be a YAML mapping")
+	}
+	root := doc.Content[0]
+
+	// version が無ければ現在のバージョンを先頭に追加する
+	if mappingValue(root, "version") == nil {
+		issues = append(issues, Issue{Message: fmt.Sprintf("version is missing (adding 'version: %s')", CurrentVersion), Fixable: true})
+		root.Content = append([]*yaml.Node{
+			{Kind: yaml.ScalarNode, Tag: "!!str", Value: "version"},
+			{Kind: yaml.ScalarNode, Tag: "!!str", Value: CurrentVersion},
+		}, root.Content...)
+	}
+
+	if files := mappingValue(root, "files"); files != nil && files.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(files.Content); i += 2 {
+			fileID := model.FileID(files.Content[i].Value)
+			if fileDef := files.Content[i+1]; fileDef.Kind == yaml.MappingNode {
+				issues = append(issues, diagnoseFile(fileID, fileDef)...)
+			}
+		}
+		// 差分が安定するよう files をファイルID順に並べる
+		if sortMapping(files) {
+			issues = append(issues, Issue{Message: "files are not sorted by ID (sorting)", Fixable: true})
+		}
+	}
+
+	hasFix := false
+	for _, issue := range issues {
+		hasFix = hasFix || issue.Fixable
+	}
+	if hasFix {
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(&doc); err != nil {
+			return nil, nil, fmt.Errorf("failed to encode fixed config: %w", err)
+		}
+		enc.Close()
+		fixed = buf.Bytes()
+	}
+
+	// 自動修正できない問題は修正後の内容の検証で検出する
+	var cfg Config
+	if err := doc.Decode(&cfg); err != nil {
+		issues = append(issues, Issue{Message: err.Error()})
+		return issues, fixed, nil
+	}
+	cfg.logger = slog.New(slog.NewTextHandler(io.Discard, nil)) // 警告は Diagnose の結果として報告済み
+	for fileID, fileDef := range cfg.Files {
+		cfg.Defaults.applyDefaults(&fileDef)
+		if err := expandSource(&fileDef); err != nil {
+			issues = append(issues, Issue{FileID: fileID, Message: err.Error()})
+			delete(cfg.Files, fileID)
+			continue
+		}
+		cfg.Files[fileID] = fileDef
+	}
+	if err := cfg.validate(false); err != nil {
+		issues = append(issues, Issue{Message: err.Error()})
+	}
+	return issues, fixed, nil
+}
+
+// diagnoseFile は1つのファイル定義を検査し、安全な修正をその場で適用する
+func diagnoseFile(fileID model.FileID, fileDef *yaml.Node) []Issue {
+	var issues []Issue
+
+	isArchive := false
+	if v := mappingValue(fileDef, "is_archive"); v != nil {
+		_ = v.Decode(&isArchive)
+	}
+	if !isArchive {
+		// アーカイブでなければ展開設定は使われないので削除する
+		for _, key := range []string{"strip_components", "extract_paths", "extract_exclude", "rename", "flatten"} {
+			if removeMappingKey(fileDef, key) {
+				issues = append(issues, Issue{FileID: fileID, Message: fmt.Sprintf("%s is ignored because is_archive is false (removing)", key), Fixable: true})
+			}
+		}
+	}
+
+	overrides := mappingValue(fileDef, "overrides")
+	if overrides == nil || overrides.Kind != yaml.MappingNode {
+		return issues
+	}
+	existing := make(map[string]bool)
+	for i := 0; i < len(overrides.Content); i += 2 {
+		existing[overrides.Content[i].Value] = true
+	}
+	for i := 0; i+1 < len(overrides.Content); i += 2 {
+		keyNode := overrides.Content[i]
+		key := keyNode.Value
+		if normalized := normalizeOverrideKey(key); normalized != key {
+			if existing[normalized] {
+				// 正規化すると既存のキーと重複する場合はどちらを残すべきか判断できない
+				issues = append(issues, Issue{FileID: fileID, Message: fmt.Sprintf("override key '%s' conflicts with '%s' after normalization", key, normalized)})
+			} else {
+				issues = append(issues, Issue{FileID: fileID, Message: fmt.Sprintf("override key '%s' is not normalized (renaming to '%s')", key, normalized), Fixable: true})
+				delete(existing, key)
+				existing[normalized] = true
+				keyNode.Value = normalized
+				key = normalized
+			}
+		}
+		if override := overrides.Content[i+1]; !isArchive && override.Kind == yaml.MappingNode {
+			for _, field := range []string{"extract_paths", "extract_exclude", "rename"} {
+				if removeMappingKey(override, field) {
+					issues = append(issues, Issue{FileID: fileID, Message: fmt.Sprintf("override '%s': %s is ignored because is_archive is false (removing)", key, field), Fixable: true})
+				}
+			}
+		}
+	}
+	return issues
+}
+
+// normalizeOverrideKey は override のキーを "platform/arch" の正規形 (小文字、空白なし) にする
+func normalizeOverrideKey(key string) string {
+	return strings.ToLower(strings.Join(strings.Fields(key), ""))
+}
+
+// mappingValue はマッピングノードから key に対応する値のノードを返す。存在しない場合は nil を返す。
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// removeMappingKey はマッピングノードから key を削除する。削除した場合は true を返す。
+func removeMappingKey(mapping *yaml.Node, key string) bool {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// sortMapping はマッピングノードのキーを昇順に並べ替える。並び順が変わった場合は true を返す。
+func sortMapping(mapping *yaml.Node) bool {
+	type pair struct{ key, value *yaml.Node }
+	pairs := make([]pair, 0, len(mapping.Content)/2)
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		pairs = append(pairs, pair{mapping.Content[i], mapping.Content[i+1]})
+	}
+	if sort.SliceIsSorted(pairs, func(i, j int) bool { return pairs[i].key.Value < pairs[j].key.Value }) {
+		return false
+	}
+	sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].key.Value < pairs[j].key.Value })
+	mapping.Content = mapping.Content[:0]
+	for _, p := range pairs {
+		mapping.Content = append(mapping.Content, p.key, p.value)
+	}
+	return true
+}
+
+// ApplyFixes は Diagnose が返した修正後の内容 fixed を設定ファイル path に書き込む。
+// 書き込む前に fixed を再検査し、自動修正できる問題が残っている場合や、
+// 元の内容 original より手動修正が必要な問題が増えた場合は何も書き込まずにエラーを返す。
+// 元の内容は既存のファイルを上書きしないバックアップ (<path>.bak、既にあれば <path>.bak.1 など) に保存し、
+// 設定ファイルは一時ファイル経由でアトミックに置き換える。作成したバックアップのパスを返す。
+func ApplyFixes(path string, original, fixed []byte) (string, error) {
+	before, _, err := Diagnose(original)
+	if err != nil {
+		return "", err
+	}
+	after, _, err := Diagnose(fixed)
+	if err != nil {
+		return "", fmt.Errorf("fixed config is invalid: %w", err)
+	}
+	// 検証エラーのメッセージはマップの走査順で変わりうるため、手動修正が必要な問題の数で比較する
+	manual := 0
+	for _, issue := range before {
+		if !issue.Fixable {
+			manual++
+		}
+	}
+	for _, issue := range after {
+		if issue.Fixable {
+			return "", fmt.Errorf("fixed config failed re-validation: %s", issue.Message)
+		}
+		manual--
+	}
+	if manual < 0 {
+		return "", fmt.Errorf("fixed config failed re-validation: %s", after[len(after)-1].Message)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat config file %s: %w", path, err)
+	}
+	backupPath, err := writeBackup(path, original, info.Mode().Perm())
+	if err != nil {
+		return "", err
+	}
+	if err := writeFileAtomic(path, fixed, info.Mode().Perm()); err != nil {
+		return "", err
+	}
+	return backupPath, nil
+}
+
+// writeBackup は data を既存のファイルと重ならないバックアップファイルに書き込み、そのパスを返す
+func writeBackup(path string, data []byte, perm fs.FileMode) (string, error) {
+	for i := 0; ; i++ {
+		backupPath := path + ".bak"
+		if i > 0 {
+			backupPath += "." + strconv.Itoa(i)
+		}
+		f, err := os.OpenFile(backupPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to create backup %s: %w", backupPath, err)
+		}
+		_, err = f.Write(data)
+		if err == nil {
+			err = f.Sync()
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			_ = os.Remove(backupPath)
+			return "", fmt.Errorf("failed to write backup %s: %w", backupPath, err)
+		}
+		return backupPath, nil
+	}
+}
+
+// writeFileAtomic は data を同じディレクトリの一時ファイルに書き込み、path にリネームする
+func writeFileAtomic(path string, data []byte, perm fs.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for %s: %w", path, err)
+	}
+	tmpPath := tmp.Name()
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// messyConfig は意味を変えずに自動修正できる問題だけを含む設定
+const messyConfig = `files:
+  zeta:
+    url: https://example.com/zeta
+    destination: zeta
+    strip_components: 1
+  alpha:
+    url: https://example.com/alpha-{{.Platform}}-{{.Architecture}}
+    destination: alpha
+    overrides:
+      "Linux / x86_64":
+        url: https://example.com/alpha-linux
+`
+
+func TestApplyFixes(t *testing.T) {
+	_, fixed, err := Diagnose([]byte(messyConfig))
+	if err != nil {
+		t.Fatalf("Diagnose() error = %v", err)
+	}
+	if fixed == nil {
+		t.Fatal("Diagnose() returned no fix for a messy config")
+	}
+
+	tests := []struct {
+		name       string
+		fixed      []byte
+		backups    []string // 事前に存在するバックアップ
+		wantBackup string
+		wantErr    bool
+	}{
+		{name: "writes fix and backup", fixed: fixed, wantBackup: "dltofu.yml.bak"},
+		{name: "keeps existing backup", fixed: fixed, backups: []string{"dltofu.yml.bak"}, wantBackup: "dltofu.yml.bak.1"},
+		{name: "keeps existing numbered backups", fixed: fixed, backups: []string{"dltofu.yml.bak", "dltofu.yml.bak.1"}, wantBackup: "dltofu.yml.bak.2"},
+		{name: "fixable issues remain", fixed: []byte(messyConfig), wantErr: true},
+		{name: "fix breaks the config", fixed: []byte("version: v1\nfiles:\n  alpha: {}\n"), wantErr: true},
+		{name: "fix is not YAML", fixed: []byte("files: ["), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "dltofu.yml")
+			if err := os.WriteFile(path, []byte(messyConfig), 0640); err != nil {
+				t.Fatal(err)
+			}
+			for _, b := range tt.backups {
+				if err := os.WriteFile(filepath.Join(dir, b), []byte("old backup"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			backupPath, err := ApplyFixes(path, []byte(messyConfig), tt.fixed)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ApplyFixes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			// 既存のバックアップは上書きされない
+			for _, b := range tt.backups {
+				if got := readFile(t, filepath.Join(dir, b)); got != "old backup" {
+					t.Errorf("existing backup %s was overwritten: %q", b, got)
+				}
+			}
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantErr {
+				if got := readFile(t, path); got != messyConfig {
+					t.Errorf("config was modified after a failed re-validation:\n%s", got)
+				}
+				if len(entries) != 1+len(tt.backups) {
+					t.Errorf("ApplyFixes() left extra files: %v", entries)
+				}
+				return
+			}
+
+			if filepath.Base(backupPath) != tt.wantBackup {
+				t.Errorf("ApplyFixes() backup = %s, want %s", filepath.Base(backupPath), tt.wantBackup)
+			}
+			if got := readFile(t, backupPath); got != messyConfig {
+				t.Errorf("backup content = %q, want the original config", got)
+			}
+			if got := readFile(t, path); got != string(fixed) {
+				t.Errorf("config content = %q, want the fixed config", got)
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0640 {
+				t.Errorf("config mode = %v, want 0640", info.Mode().Perm())
+			}
+			if len(entries) != 2+len(tt.backups) {
+				t.Errorf("ApplyFixes() left temporary files: %v", entries)
+			}
+			issues, refixed, err := Diagnose([]byte(readFile(t, path)))
+			if err != nil || refixed != nil || len(issues) != 0 {
+				t.Errorf("Diagnose(fixed) = %v, %q, %v; want no issues", issues, refixed, err)
+			}
+		})
+	}
+}
+
+// readFile は path の内容を文字列で返す
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
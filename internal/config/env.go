@@ -0,0 +1,141 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// expandEnv は s 内の ${NAME} を環境変数 NAME の値に置換する。
+// ${NAME:-default} の形式では NAME が未設定または空の場合に default を使う。
+// "$$" は "$" そのものを表す。それ以外の "$" (${...} の形でないもの) はそのまま残す。
+// デフォルト値のない変数が未設定の場合はエラーを返す。
+func ExpandEnv(s string) (string, error) {
+	if !strings.Contains(s, "$") {
+		return s, nil
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 >= len(s) {
+			sb.WriteByte(s[i])
+			continue
+		}
+		switch s[i+1] {
+		case '$':
+			sb.WriteByte('$')
+			i++
+		case '{':
+			end := strings.IndexByte(s[i+2:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated variable reference in %q", s)
+			}
+			expr := s[i+2 : i+2+end]
+			value, err := lookupEnvExpr(expr)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(value)
+			i += 2 + end
+		default:
+			sb.WriteByte('$')
+		}
+	}
+	return sb.String(), nil
+}
+
+// lookupEnvExpr は ${...} の中身 (NAME または NAME:-default) を評価する
+func lookupEnvExpr(expr string) (string, error) {
+	name, defaultValue, hasDefault := strings.Cut(expr, ":-")
+	if !isEnvName(name) {
+		return "", fmt.Errorf("invalid environment variable name in ${%s}", expr)
+	}
+	value, ok := os.LookupEnv(name)
+	if hasDefault && value == "" {
+		return defaultValue, nil
+	}
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set (use ${%s:-default} to provide a fallback)", name, name)
+	}
+	return value, nil
+}
+
+// isEnvName は name が環境変数名 ([A-Za-z_][A-Za-z0-9_]*) として正しい場合に true を返す
+func isEnvName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r == '_', 'A' <= r && r <= 'Z', 'a' <= r && r <= 'z':
+		case '0' <= r && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// expandGlobalEnv はファイル定義以外の proxy, tls の環境変数を展開する
+func expandGlobalEnv(cfg *Config) error {
+	var err error
+	expand := func(field string, s *string) {
+		if err != nil {
+			return
+		}
+		if *s, err = ExpandEnv(*s); err != nil {
+			err = fmt.Errorf("%s: %w", field, err)
+		}
+	}
+
+	expand("proxy", &cfg.Proxy)
+	if cfg.TLS != nil {
+		expand("tls.ca_cert", &cfg.TLS.CACert)
+		expand("tls.client_cert", &cfg.TLS.ClientCert)
+		expand("tls.client_key", &cfg.TLS.ClientKey)
+	}
+	return err
+}
+
+// expandFileEnv はファイル定義の url, mirrors, parts, destination, headers (Override を含む) の環境変数を展開する
+func expandFileEnv(fileDef *FileDef) error {
+	var err error
+	expand := func(field string, s *string) {
+		if err != nil {
+			return
+		}
+		if *s, err = ExpandEnv(*s); err != nil {
+			err = fmt.Errorf("%s: %w", field, err)
+		}
+	}
+
+	expand("url", &fileDef.URL)
+	for i := range fileDef.Mirrors {
+		expand(fmt.Sprintf("mirrors[%d]", i), &fileDef.Mirrors[i])
+	}
+	for i := range fileDef.Parts {
+		expand(fmt.Sprintf("parts[%d]", i), &fileDef.Parts[i])
+	}
+	expand("destination", &fileDef.Destination)
+	for key, value := range fileDef.Headers {
+		expand("headers."+key, &value)
+		fileDef.Headers[key] = value
+	}
+	if fileDef.PatchFrom != nil {
+		expand("patch_from.base_url", &fileDef.PatchFrom.BaseURL)
+		expand("patch_from.url", &fileDef.PatchFrom.URL)
+	}
+	for overrideKey, overrideDef := range fileDef.Overrides {
+		expand("overrides."+overrideKey+".url", &overrideDef.URL)
+		for i := range overrideDef.Mirrors {
+			expand(fmt.Sprintf("overrides.%s.mirrors[%d]", overrideKey, i), &overrideDef.Mirrors[i])
+		}
+		expand("overrides."+overrideKey+".destination", &overrideDef.Destination)
+		for key, value := range overrideDef.Headers {
+			expand("overrides."+overrideKey+".headers."+key, &value)
+			overrideDef.Headers[key] = value
+		}
+		fileDef.Overrides[overrideKey] = overrideDef
+	}
+	return err
+}
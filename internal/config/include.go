@@ -0,0 +1,131 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/hrko/dltofu/internal/model"
+	"gopkg.in/yaml.v3"
+)
+
+// includedConfig は include で読み込む設定ファイルの内容。
+// 設定全体に関わる項目 (proxy, tls など) は読み込み元の設定ファイルでのみ指定できる。
+type includedConfig struct {
+	Version  string                   `yaml:"version,omitempty"` // 指定する場合は読み込み元と同じバージョンであること
+	Include  []string                 `yaml:"include,omitempty"`
+	Defaults *DefaultsDef             `yaml:"defaults,omitempty"` // このファイル (とこのファイルが include するファイル) の files にのみ適用する
+	Files    map[model.FileID]FileDef `yaml:"files"`
+}
+
+// loadIncludes は include で指定された設定ファイルを再帰的に読み込み、その files を c.Files にマージする。
+// include のパスは指定したファイルのディレクトリ基準で解決し、*.yml のようなグロブも使える (ファイル名の順に読み込む)。
+// 読み込んだファイルの defaults はそのファイル (とそこから include したファイル) の files に先に適用され、
+// 未指定の項目には読み込み元の defaults が適用される。
+// destination などのパスは include されたファイルでも最上位の設定ファイルのディレクトリ基準となる。
+// 同じファイルIDが複数のファイルで定義されている場合や、include が循環している場合はエラーを返す。
+func (c *Config) loadIncludes() error {
+	included, err := c.readIncludes(c.path, c.Include, []string{c.path})
+	if err != nil {
+		return err
+	}
+	if c.Files == nil {
+		c.Files = make(map[model.FileID]FileDef)
+	}
+	return mergeFiles(c.Files, included, "included config files")
+}
+
+// readIncludes は configPath で指定された includes の各ファイルを読み込み、files をまとめて返す。
+// visiting は読み込み中のファイルの絶対パス (循環の検出用)。
+func (c *Config) readIncludes(configPath string, includes []string, visiting []string) (map[model.FileID]FileDef, error) {
+	files := make(map[model.FileID]FileDef)
+	for _, include := range includes {
+		paths, err := resolveInclude(filepath.Dir(configPath), include)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", configPath, err)
+		}
+		for _, path := range paths {
+			if i := slices.Index(visiting, path); i >= 0 {
+				return nil, fmt.Errorf("include cycle detected: %s", strings.Join(slices.Concat(visiting[i:], []string{path}), " -> "))
+			}
+			included, err := c.readInclude(path, slices.Concat(visiting, []string{path}))
+			if err != nil {
+				return nil, err
+			}
+			if err := mergeFiles(files, included, path); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return files, nil
+}
+
+// readInclude は include された1つの設定ファイルを読み込み、そこから include したファイルを含めた files を返す
+func (c *Config) readInclude(path string, visiting []string) (map[model.FileID]FileDef, error) {
+	c.logger.Debug("Loading included config file", "path", path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read included config file %s: %w", path, err)
+	}
+	var inc includedConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true) // proxy などの読み込み元でのみ指定できる項目を黙って無視しない
+	if err := decoder.Decode(&inc); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal included config file %s (only version, include, defaults and files are allowed): %w", path, err)
+	}
+	if inc.Version != "" && inc.Version != c.Version {
+		return nil, fmt.Errorf("included config file %s has version %s, but %s uses %s", path, inc.Version, c.path, c.Version)
+	}
+
+	files, err := c.readIncludes(path, inc.Include, visiting)
+	if err != nil {
+		return nil, err
+	}
+	if err := mergeFiles(files, inc.Files, path); err != nil {
+		return nil, err
+	}
+	for fileID, fileDef := range files {
+		inc.Defaults.applyDefaults(&fileDef)
+		files[fileID] = fileDef
+	}
+	return files, nil
+}
+
+// mergeFiles は src のファイル定義を dst に追加する。dst に同じファイルIDがある場合はエラーを返す。
+// source はエラーメッセージに使う src の読み込み元の説明。
+func mergeFiles(dst, src map[model.FileID]FileDef, source string) error {
+	for _, fileID := range slices.Sorted(maps.Keys(src)) {
+		if _, exists := dst[fileID]; exists {
+			return fmt.Errorf("file ID '%s' is defined more than once (while merging %s)", fileID, source)
+		}
+		dst[fileID] = src[fileID]
+	}
+	return nil
+}
+
+// resolveInclude は include の1つの指定を dir 基準の絶対パスに解決する。グロブの場合は一致したファイルを名前の順に返す。
+func resolveInclude(dir, include string) ([]string, error) {
+	if include == "" {
+		return nil, fmt.Errorf("include entry is empty")
+	}
+	path := include
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	if !strings.ContainsAny(include, "*?[") {
+		return []string{path}, nil
+	}
+	matches, err := filepath.Glob(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid include pattern '%s': %w", include, err)
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("include pattern '%s' does not match any file", include)
+	}
+	slices.Sort(matches)
+	return matches, nil
+}
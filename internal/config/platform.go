@@ -0,0 +1,95 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/hrko/dltofu/internal/platform"
+)
+
+// IsValidPlatform は platform_map (未指定の場合は組み込みの対応) でプラットフォーム識別子が定義されているか返す
+func (c *Config) IsValidPlatform(platformID string) bool {
+	if len(c.PlatformMap) > 0 {
+		_, ok := c.PlatformMap[platformID]
+		return ok
+	}
+	return platform.IsValidPlatform(platformID)
+}
+
+// IsValidArch は arch_map (未指定の場合は組み込みの対応) でアーキテクチャ識別子が定義されているか返す
+func (c *Config) IsValidArch(archID string) bool {
+	if len(c.ArchMap) > 0 {
+		_, ok := c.ArchMap[archID]
+		return ok
+	}
+	return platform.IsValidArch(archID)
+}
+
+// AllPlatforms は使用できるプラットフォーム識別子をソートして返す
+func (c *Config) AllPlatforms() []string {
+	if len(c.PlatformMap) > 0 {
+		return slices.Sorted(maps.Keys(c.PlatformMap))
+	}
+	return platform.GetAllPlatforms()
+}
+
+// AllArchs は使用できるアーキテクチャ識別子をソートして返す
+func (c *Config) AllArchs() []string {
+	if len(c.ArchMap) > 0 {
+		return slices.Sorted(maps.Keys(c.ArchMap))
+	}
+	return platform.GetAllArchs()
+}
+
+// CurrentPlatform は実行環境のプラットフォーム識別子を platform_map (未指定の場合は組み込みの対応) で返す
+func (c *Config) CurrentPlatform() (string, error) {
+	if len(c.PlatformMap) > 0 {
+		return platform.GetCurrentPlatformFrom(c.PlatformMap)
+	}
+	return platform.GetCurrentPlatform()
+}
+
+// CurrentArch は実行環境のアーキテクチャ識別子を arch_map (未指定の場合は組み込みの対応) で返す
+func (c *Config) CurrentArch() (string, error) {
+	if len(c.ArchMap) > 0 {
+		return platform.GetCurrentArchFrom(c.ArchMap)
+	}
+	return platform.GetCurrentArch()
+}
+
+// IsArmArch はアーキテクチャ識別子が arch_variants の対象となる 32-bit ARM (GOARCH=arm) か返す
+func (c *Config) IsArmArch(archID string) bool {
+	if len(c.ArchMap) > 0 {
+		return platform.IsArmGoarch(c.ArchMap[archID])
+	}
+	goarch, ok := platform.GetGoarch(archID)
+	return ok && platform.IsArmGoarch(goarch)
+}
+
+// hasArmArch は architectures に 32-bit ARM のアーキテクチャが含まれる場合に true を返す
+func (c *Config) hasArmArch(architectures map[string]string) bool {
+	for archID := range architectures {
+		if c.IsArmArch(archID) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateIDMap は platform_map/arch_map を検証する。
+// 実行環境の識別子を一意に決められるよう、同じ GOOS/GOARCH を複数の識別子に対応させることはできない。
+func validateIDMap(name string, m map[string]string) error {
+	seen := make(map[string]string, len(m))
+	for _, id := range slices.Sorted(maps.Keys(m)) {
+		goValue := m[id]
+		if id == "" || goValue == "" {
+			return fmt.Errorf("%s: identifiers and values must not be empty", name)
+		}
+		if other, ok := seen[goValue]; ok {
+			return fmt.Errorf("%s: '%s' and '%s' both map to '%s'", name, other, id, goValue)
+		}
+		seen[goValue] = id
+	}
+	return nil
+}
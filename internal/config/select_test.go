@@ -0,0 +1,77 @@
+package config
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/hrko/dltofu/internal/model"
+)
+
+// loadFiles は fileIDs のファイルを定義した設定を読み込む
+func loadFiles(t *testing.T, fileIDs ...string) *Config {
+	t.Helper()
+	var b strings.Builder
+	b.WriteString("version: v1\nfiles:\n")
+	for _, id := range fileIDs {
+		b.WriteString("  " + id + ":\n    url: https://example.com/" + id + "\n    destination: " + id + "\n")
+	}
+	p := filepath.Join(t.TempDir(), "dltofu.yml")
+	if err := os.WriteFile(p, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := LoadConfig(p, nil, false)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	return c
+}
+
+func TestSelectAndExcludeFiles(t *testing.T) {
+	all := []string{"node", "node-lts", "nodemon", "deno", "go"}
+	tests := []struct {
+		name    string
+		only    []string
+		exclude []string
+		want    []model.FileID
+		wantErr string // 空ならエラーにならない
+	}{
+		{name: "no patterns", want: []model.FileID{"deno", "go", "node", "node-lts", "nodemon"}},
+		{name: "literal", only: []string{"go"}, want: []model.FileID{"go"}},
+		{name: "star", only: []string{"node*"}, want: []model.FileID{"node", "node-lts", "nodemon"}},
+		{name: "question mark", only: []string{"?o"}, want: []model.FileID{"go"}},
+		{name: "character class", only: []string{"[dg]*"}, want: []model.FileID{"deno", "go"}},
+		{name: "multiple patterns", only: []string{"node-*", "go"}, want: []model.FileID{"go", "node-lts"}},
+		{name: "overlapping patterns", only: []string{"node*", "*mon"}, want: []model.FileID{"node", "node-lts", "nodemon"}},
+		{name: "exclude", exclude: []string{"*no*"}, want: []model.FileID{"go"}},
+		{name: "only and exclude", only: []string{"node*"}, exclude: []string{"*-lts"}, want: []model.FileID{"node", "nodemon"}},
+		{name: "only matches nothing", only: []string{"python*"}, wantErr: "does not match any file"},
+		{name: "exclude matches nothing", exclude: []string{"python"}, wantErr: "does not match any file"},
+		{name: "exclude outside selection", only: []string{"go"}, exclude: []string{"node"}, wantErr: "does not match any file"},
+		{name: "invalid pattern", only: []string{"node["}, wantErr: "invalid file ID pattern"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := loadFiles(t, all...)
+			err := c.SelectFiles(tt.only)
+			if err == nil {
+				err = c.ExcludeFiles(tt.exclude)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error = %v", err)
+			}
+			if got := slices.Sorted(maps.Keys(c.Files)); !slices.Equal(got, tt.want) {
+				t.Errorf("files = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/hrko/dltofu/internal/github"
+	"github.com/hrko/dltofu/internal/lock"
+	"github.com/hrko/dltofu/internal/model"
+)
+
+// SourceGitHub は GitHub のリリースアセットを表す source の値
+const SourceGitHub = "github"
+
+// VersionLatest は最新のリリースを使うことを示す version の値 (source: github の場合のみ)
+const VersionLatest = "latest"
+
+// expandSource は source の指定に従って url を生成する。
+// source: github の場合は https://github.com/{repo}/releases/download/{tag}/{asset} を URL テンプレートとする。
+// version: latest の場合はここでは最新リリースを問い合わせず、タグを "latest" のままにする。
+// 実際のタグは lock/update で ResolveLatest により、それ以外のコマンドでは PinLatest により Lock ファイルから決定する。
+func expandSource(fileDef *FileDef) error {
+	switch fileDef.Source {
+	case "":
+		if fileDef.Repo != "" || fileDef.Tag != "" || fileDef.Asset != "" {
+			return fmt.Errorf("repo, tag and asset require source: %s", SourceGitHub)
+		}
+		return nil
+	case SourceGitHub:
+	default:
+		return fmt.Errorf("unsupported source '%s' (supported: %s)", fileDef.Source, SourceGitHub)
+	}
+
+	if fileDef.URL != "" {
+		return fmt.Errorf("url cannot be combined with source: %s", SourceGitHub)
+	}
+	if err := github.ValidateRepo(fileDef.Repo); err != nil {
+		return err
+	}
+	if fileDef.Asset == "" {
+		return fmt.Errorf("asset is required for source: %s", SourceGitHub)
+	}
+
+	tag := fileDef.Tag
+	if tag == "" {
+		tag = "{{.Version}}"
+	}
+	fileDef.URL = github.ReleaseAssetURL(fileDef.Repo, tag, fileDef.Asset)
+	return nil
+}
+
+// isLatestRelease は fileDef が GitHub の最新リリース (source: github, version: latest) を使う場合に true を返す
+func (f *FileDef) isLatestRelease() bool {
+	return f.Source == SourceGitHub && f.Version == VersionLatest
+}
+
+// pinRelease は最新リリースのタグ tag で url と version を確定する。version には先頭の "v" を除いたタグ名を使う。
+func (f *FileDef) pinRelease(tag string) {
+	f.URL = github.ReleaseAssetURL(f.Repo, tag, f.Asset)
+	f.Version = strings.TrimPrefix(tag, "v")
+}
+
+// ResolveLatest は version: latest のファイルについて、latestTag (GitHub API の問い合わせ) で取得した
+// 最新リリースのタグで url と version を確定する。Lock ファイルを更新する lock/update でのみ使う。
+func (c *Config) ResolveLatest(latestTag func(repo string) (string, error)) error {
+	for _, fileID := range slices.Sorted(maps.Keys(c.Files)) {
+		fileDef := c.Files[fileID]
+		if !fileDef.isLatestRelease() {
+			continue
+		}
+		tag, err := latestTag(fileDef.Repo)
+		if err != nil {
+			return fmt.Errorf("file '%s': %w", fileID, err)
+		}
+		c.logger.Info("Resolved latest GitHub release", "file_id", fileID, "repo", fileDef.Repo, "tag", tag)
+		fileDef.pinRelease(tag)
+		c.Files[fileID] = fileDef
+	}
+	return nil
+}
+
+// PinLatest は version: latest のファイルについて、Lock ファイルに記録されたリリースのタグで url と version を確定する。
+// GitHub API には問い合わせないため、lock を実行した時点のリリースが使われる。
+// Lock ファイルに記録がないファイルはそのまま (タグが "latest" の URL) とし、ハッシュ値の取得時にエラーとなる。
+func (c *Config) PinLatest(lf *lock.LockFile) {
+	for _, fileID := range slices.Sorted(maps.Keys(c.Files)) {
+		fileDef := c.Files[fileID]
+		if !fileDef.isLatestRelease() {
+			continue
+		}
+		tag, ok := c.lockedRelease(fileID, lf)
+		if !ok {
+			c.logger.Warn("Latest GitHub release is not recorded in the lock file (run 'dltofu lock')", "file_id", fileID, "repo", fileDef.Repo)
+			continue
+		}
+		c.logger.Debug("Using GitHub release recorded in the lock file", "file_id", fileID, "repo", fileDef.Repo, "tag", tag)
+		fileDef.pinRelease(tag)
+		c.Files[fileID] = fileDef
+	}
+}
+
+// lockedRelease は fileID のリリースのタグを Lock ファイルに記録された URL から探す。
+// パッチのベースなど別のリリースの URL が記録されている場合もあるため、
+// そのタグで生成したいずれかのバリアントの URL が Lock ファイルに記録されているタグを選ぶ。
+func (c *Config) lockedRelease(fileID model.FileID, lf *lock.LockFile) (string, bool) {
+	original := c.Files[fileID]
+	defer func() { c.Files[fileID] = original }()
+	for _, url := range lf.URLs(fileID) {
+		tag, ok := github.ReleaseTag(original.Repo, string(url))
+		if !ok {
+			continue
+		}
+		pinned := original
+		pinned.pinRelease(tag)
+		c.Files[fileID] = pinned
+		targets, err := c.FileTargets(fileID)
+		if err != nil {
+			continue
+		}
+		for _, target := range targets {
+			if _, ok := lf.GetEntry(fileID, target.URL); ok {
+				return tag, true
+			}
+		}
+	}
+	return "", false
+}
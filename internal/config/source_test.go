@@ -0,0 +1,138 @@
+package config
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hrko/dltofu/internal/github"
+	"github.com/hrko/dltofu/internal/hash"
+	"github.com/hrko/dltofu/internal/lock"
+	"github.com/hrko/dltofu/internal/model"
+)
+
+const latestConfig = `version: v1
+files:
+  tool:
+    source: github
+    repo: owner/tool
+    asset: tool-{{.Version}}.tar.gz
+    version: latest
+`
+
+// loadTestConfig は content を一時ディレクトリの設定ファイルとして LoadConfig で読み込む
+func loadTestConfig(t *testing.T, content string) *Config {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "dltofu.yml")
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(p, nil, false)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	return cfg
+}
+
+// failOnGitHubAPI は GitHub API への問い合わせがあればテストを失敗させる
+func failOnGitHubAPI(t *testing.T) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected GitHub API request: %s", r.URL)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(srv.Close)
+	orig := github.APIBaseURL
+	github.APIBaseURL = srv.URL
+	t.Cleanup(func() { github.APIBaseURL = orig })
+}
+
+func TestLoadConfigDoesNotResolveLatest(t *testing.T) {
+	failOnGitHubAPI(t)
+	cfg := loadTestConfig(t, latestConfig)
+	fileDef := cfg.Files["tool"]
+	if fileDef.Version != VersionLatest {
+		t.Errorf("version = %q, want %q", fileDef.Version, VersionLatest)
+	}
+}
+
+func TestResolveLatest(t *testing.T) {
+	tests := []struct {
+		name        string
+		tag         string
+		err         error
+		wantURL     string
+		wantVersion string
+		wantErr     bool
+	}{
+		{name: "v prefix", tag: "v1.2.3", wantURL: "https://github.com/owner/tool/releases/download/v1.2.3/tool-{{.Version}}.tar.gz", wantVersion: "1.2.3"},
+		{name: "no prefix", tag: "2024.01", wantURL: "https://github.com/owner/tool/releases/download/2024.01/tool-{{.Version}}.tar.gz", wantVersion: "2024.01"},
+		{name: "API error", err: errors.New("rate limited"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := loadTestConfig(t, latestConfig)
+			err := cfg.ResolveLatest(func(repo string) (string, error) {
+				if repo != "owner/tool" {
+					t.Errorf("repo = %q, want owner/tool", repo)
+				}
+				return tt.tag, tt.err
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveLatest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			fileDef := cfg.Files["tool"]
+			if fileDef.URL != tt.wantURL || fileDef.Version != tt.wantVersion {
+				t.Errorf("url, version = (%q, %q), want (%q, %q)", fileDef.URL, fileDef.Version, tt.wantURL, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestPinLatest(t *testing.T) {
+	tests := []struct {
+		name        string
+		locked      []model.ResolvedURL
+		wantVersion string
+	}{
+		{
+			name:        "locked release",
+			locked:      []model.ResolvedURL{"https://github.com/owner/tool/releases/download/v1.2.3/tool-1.2.3.tar.gz"},
+			wantVersion: "1.2.3",
+		},
+		{
+			// パッチのベースなど、別のリリースの URL も記録されている場合
+			name: "other release recorded",
+			locked: []model.ResolvedURL{
+				"https://github.com/owner/tool/releases/download/v1.0.0/base.tar.gz",
+				"https://github.com/owner/tool/releases/download/v1.2.3/tool-1.2.3.tar.gz",
+			},
+			wantVersion: "1.2.3",
+		},
+		{
+			name:        "not locked",
+			locked:      nil,
+			wantVersion: VersionLatest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failOnGitHubAPI(t)
+			cfg := loadTestConfig(t, latestConfig)
+			lf := lock.NewLockFile(nil)
+			for _, url := range tt.locked {
+				lf.SetEntry("tool", url, lock.NewEntry(hash.NewHash(hash.AlgoSHA256, make([]byte, 32))))
+			}
+			cfg.PinLatest(lf)
+			if got := cfg.Files["tool"].Version; got != tt.wantVersion {
+				t.Errorf("version = %q, want %q", got, tt.wantVersion)
+			}
+		})
+	}
+}
@@ -0,0 +1,269 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/hrko/dltofu/internal/hash"
+	"github.com/hrko/dltofu/internal/model"
+	"github.com/hrko/dltofu/internal/platform"
+	"github.com/hrko/dltofu/internal/template"
+)
+
+// Target はファイルの1つのバリアント (プラットフォーム/アーキテクチャの組み合わせ) を解決した結果。
+// lock, download, verify などのコマンドは Target を通してファイルを扱い、解決方法を共有する。
+type Target struct {
+	FileID        model.FileID
+	Def           FileDef
+	PlatformID    string                // プラットフォーム指定がないファイルは空 (overrides が適用されるバリアントを除く)
+	ArchID        string                // プラットフォーム指定がないファイルは空 (overrides が適用されるバリアントを除く)
+	ArchVariant   string                // arch_variants のバリアント (32-bit ARM のみ)。指定がない場合は空
+	Data          template.TemplateData // URL などのテンプレートに渡すデータ
+	URL           model.ResolvedURL
+	Mirrors       []model.ResolvedURL // URL の取得に失敗した場合に順に試す代替URL
+	HashAlgorithm hash.HashAlgorithm  // Override を考慮した有効なアルゴリズム
+	Destination   string              // ダウンロード/展開先の絶対パス
+	Rename        map[string]string   // テンプレート展開済みの rename (アーカイブのみ)
+}
+
+// TargetMatrix は全てのファイルの全バリアントを解決して返す。
+// 順序はファイルID、プラットフォーム、アーキテクチャ、バリアントの順にソートされる。
+func (c *Config) TargetMatrix() ([]Target, error) {
+	var targets []Target
+	for _, fileID := range slices.Sorted(maps.Keys(c.Files)) {
+		fileTargets, err := c.FileTargets(fileID)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, fileTargets...)
+	}
+	return targets, nil
+}
+
+// FileTargets は fileID の全バリアントを解決して返す (プラットフォーム、アーキテクチャ、バリアントの順にソート済み)。
+// プラットフォーム指定がないファイルは PlatformID/ArchID が空のバリアント1つと、overrides が適用される環境ごとのバリアントとなる
+// (キーが "*/*" の場合は全ての環境に適用されるため、空のバリアントは含まない)。
+// arch_variants が指定されている場合、32-bit ARM のアーキテクチャはバリアントごとに展開される。
+func (c *Config) FileTargets(fileID model.FileID) ([]Target, error) {
+	fileDef, ok := c.Files[fileID]
+	if !ok {
+		return nil, fmt.Errorf("unknown file ID: %s", fileID)
+	}
+	if !fileDef.hasVariants() {
+		return c.universalTargets(fileID, fileDef)
+	}
+
+	var targets []Target
+	for _, platformID := range slices.Sorted(maps.Keys(fileDef.Platforms)) {
+		for _, archID := range slices.Sorted(maps.Keys(fileDef.Architectures)) {
+			for _, variant := range c.archVariants(fileDef, archID) {
+				target, err := c.resolveTarget(fileID, fileDef, platformID, archID, variant)
+				if err != nil {
+					return nil, err
+				}
+				targets = append(targets, target)
+			}
+		}
+	}
+	return targets, nil
+}
+
+// SelectTarget は実行環境のプラットフォーム/アーキテクチャに対応する fileID のバリアントを解決する。
+// プラットフォーム指定がないファイルは常に対象となり、実行環境に一致する overrides があればそれが適用される。
+// 対応するバリアントがない場合は applicable が false となる。
+// arch_variants が指定されている場合は currentVariant で実行できる最も新しいバリアントを選ぶ
+// (currentVariant が不明な場合は最も古いバリアント)。
+func (c *Config) SelectTarget(fileID model.FileID, currentPlatform, currentArch, currentVariant string) (target Target, applicable bool, err error) {
+	fileDef, ok := c.Files[fileID]
+	if !ok {
+		return Target{}, false, fmt.Errorf("unknown file ID: %s", fileID)
+	}
+	platformID, archID, variant := "", "", ""
+	if fileDef.hasVariants() {
+		_, okPlatform := fileDef.Platforms[currentPlatform]
+		_, okArch := fileDef.Architectures[currentArch]
+		if !okPlatform || !okArch {
+			return Target{}, false, nil
+		}
+		platformID, archID = currentPlatform, currentArch
+		variant, ok = selectArchVariant(c.archVariants(fileDef, archID), currentVariant)
+		if !ok {
+			return Target{}, false, nil
+		}
+	} else if len(fileDef.overridesFor(currentPlatform, currentArch, "")) > 0 {
+		platformID, archID = currentPlatform, currentArch
+	}
+	target, err = c.resolveTarget(fileID, fileDef, platformID, archID, variant)
+	if err != nil {
+		return Target{}, true, err
+	}
+	return target, true, nil
+}
+
+// universalTargets はプラットフォーム指定がないファイルのバリアントを解決する。
+// "*" を含む overrides のキーはテンプレートに渡す値が決まらないため、使用できる全てのプラットフォーム/アーキテクチャの
+// 組み合わせのうち overrides が適用されるものをそれぞれ解決し、URL が同じバリアントは最初の1つにまとめる。
+func (c *Config) universalTargets(fileID model.FileID, fileDef FileDef) ([]Target, error) {
+	var targets []Target
+	if _, ok := fileDef.Overrides[overrideWildcard+"/"+overrideWildcard]; !ok {
+		target, err := c.resolveTarget(fileID, fileDef, "", "", "")
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+	if len(fileDef.Overrides) == 0 {
+		return targets, nil
+	}
+	seen := make(map[model.ResolvedURL]struct{})
+	for _, t := range targets {
+		seen[t.URL] = struct{}{}
+	}
+	for _, platformID := range c.AllPlatforms() {
+		for _, archID := range c.AllArchs() {
+			if len(fileDef.overridesFor(platformID, archID, "")) == 0 {
+				continue
+			}
+			target, err := c.resolveTarget(fileID, fileDef, platformID, archID, "")
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := seen[target.URL]; ok {
+				continue
+			}
+			seen[target.URL] = struct{}{}
+			targets = append(targets, target)
+		}
+	}
+	return targets, nil
+}
+
+// hasVariants はプラットフォーム/アーキテクチャごとのバリアントを持つ場合に true を返す
+func (f *FileDef) hasVariants() bool {
+	return len(f.Platforms) > 0 && len(f.Architectures) > 0
+}
+
+// archVariants は archID で展開するバリアントをソートして返す。
+// 32-bit ARM 以外のアーキテクチャ、または arch_variants 未指定の場合はバリアントなし ("") のみを返す。
+func (c *Config) archVariants(fileDef FileDef, archID string) []string {
+	if len(fileDef.ArchVariants) == 0 || !c.IsArmArch(archID) {
+		return []string{""}
+	}
+	return slices.Sorted(maps.Keys(fileDef.ArchVariants))
+}
+
+// selectArchVariant は variants から current で実行できる最も新しいバリアントを選ぶ。
+// current が不明 (空) の場合は最も古いバリアントを選ぶ。実行できるバリアントがない場合は ok が false となる。
+func selectArchVariant(variants []string, current string) (variant string, ok bool) {
+	if len(variants) == 1 && variants[0] == "" {
+		return "", true
+	}
+	currentLevel := platform.ArchVariantLevel(current)
+	bestLevel := 0
+	for _, v := range variants {
+		level := platform.ArchVariantLevel(v)
+		switch {
+		case currentLevel == 0:
+			if !ok || level < bestLevel {
+				variant, bestLevel, ok = v, level, true
+			}
+		case level <= currentLevel && level > bestLevel:
+			variant, bestLevel, ok = v, level, true
+		}
+	}
+	return variant, ok
+}
+
+// resolveTarget は1つのバリアントの URL、アルゴリズム、ダウンロード先を解決する
+func (c *Config) resolveTarget(fileID model.FileID, fileDef FileDef, platformID, archID, variant string) (Target, error) {
+	target := Target{
+		FileID:      fileID,
+		Def:         fileDef,
+		PlatformID:  platformID,
+		ArchID:      archID,
+		ArchVariant: variant,
+		Data: template.TemplateData{
+			Version:      fileDef.Version,
+			Platform:     templateValue(fileDef, fileDef.Platforms, platformID),
+			Architecture: templateValue(fileDef, fileDef.Architectures, archID),
+			Variant:      fileDef.ArchVariants[variant],
+		},
+		HashAlgorithm: c.GetEffectiveHashAlgorithm(fileID, platformID, archID, variant),
+	}
+
+	var err error
+	target.URL, err = template.ResolveURL(fileDef.GetEffectiveURLTemplate(platformID, archID, variant), target.Data)
+	if err != nil {
+		return Target{}, fmt.Errorf("failed to resolve URL for %s: %w", target, err)
+	}
+	for i, mirror := range fileDef.GetEffectiveMirrors(platformID, archID, variant) {
+		resolved, err := template.ResolveURL(mirror, target.Data)
+		if err != nil {
+			return Target{}, fmt.Errorf("failed to resolve mirrors[%d] for %s: %w", i, target, err)
+		}
+		target.Mirrors = append(target.Mirrors, resolved)
+	}
+	for from, to := range fileDef.GetEffectiveRename(platformID, archID, variant) {
+		resolvedFrom, err := template.ResolveRename(from, target.Data)
+		if err != nil {
+			return Target{}, fmt.Errorf("failed to resolve rename for %s: %w", target, err)
+		}
+		resolvedTo, err := template.ResolveRename(to, target.Data)
+		if err != nil {
+			return Target{}, fmt.Errorf("failed to resolve rename for %s: %w", target, err)
+		}
+		if target.Rename == nil {
+			target.Rename = make(map[string]string)
+		}
+		target.Rename[resolvedFrom] = resolvedTo
+	}
+	dest, err := template.ResolveDestination(fileDef.GetEffectiveDestination(platformID, archID, variant), target.Data)
+	if err != nil {
+		return Target{}, fmt.Errorf("failed to resolve destination for %s: %w", target, err)
+	}
+	target.Destination, err = c.resolveDestination(dest, target.URL)
+	if err != nil {
+		return Target{}, fmt.Errorf("failed to resolve destination for %s: %w", target, err)
+	}
+	return target, nil
+}
+
+// templateValue は識別子 id に対応するテンプレートの値を values から返す。
+// プラットフォーム指定がないファイルには対応の定義がないため、overrides が適用される場合は識別子をそのまま使う。
+func templateValue(fileDef FileDef, values map[string]string, id string) string {
+	if !fileDef.hasVariants() {
+		return id
+	}
+	return values[id]
+}
+
+// resolveDestination はダウンロード先の絶対パスを決定する。
+// Destination が未指定の場合は URL の最後の要素をファイル名としてカレントディレクトリ基準で解決し、
+// 指定されている場合は設定ファイルのディレクトリ基準で解決する。
+func (c *Config) resolveDestination(dest string, resolvedURL model.ResolvedURL) (string, error) {
+	if dest == "" {
+		urlParts := strings.Split(string(resolvedURL), "/")
+		dest = urlParts[len(urlParts)-1] // URLの最後の部分をファイル名とする
+		absDest, err := filepath.Abs(dest)
+		if err != nil {
+			return "", fmt.Errorf("failed to get absolute path for default destination %s: %w", dest, err)
+		}
+		return absDest, nil
+	}
+	return c.ResolveDestPath(dest) // 設定ファイル基準で解決
+}
+
+// String はログやエラーメッセージ用に "fileID (platform/arch)" 形式の文字列を返す。
+// バリアントがある場合は "fileID (platform/arch/variant)" となる。
+func (t Target) String() string {
+	if t.PlatformID == "" {
+		return string(t.FileID)
+	}
+	if t.ArchVariant != "" {
+		return fmt.Sprintf("%s (%s/%s/%s)", t.FileID, t.PlatformID, t.ArchID, t.ArchVariant)
+	}
+	return fmt.Sprintf("%s (%s/%s)", t.FileID, t.PlatformID, t.ArchID)
+}
@@ -0,0 +1,308 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/hrko/dltofu/internal/hash"
+	"github.com/hrko/dltofu/internal/model"
+)
+
+func TestUniversalTargets(t *testing.T) {
+	// 組み合わせを少なくするため、プラットフォームとアーキテクチャを2つずつに限定する
+	const header = `version: v1
+platform_map:
+  linux: linux
+  windows: windows
+arch_map:
+  amd64: amd64
+  arm64: arm64
+files:
+  tool:
+    url: https://example.com/tool.sh
+    overrides:
+`
+	tests := []struct {
+		name      string
+		overrides string
+		wantURLs  []model.ResolvedURL // FileTargets の URL (順序どおり)
+		selectFor [2]string           // SelectTarget に渡すプラットフォームとアーキテクチャ
+		wantURL   model.ResolvedURL   // SelectTarget の URL
+	}{
+		{
+			name: "wildcard architecture in template",
+			overrides: `      "windows/*":
+        url: https://example.com/tool-{{.Platform}}-{{.Architecture}}.exe
+`,
+			wantURLs: []model.ResolvedURL{
+				"https://example.com/tool.sh",
+				"https://example.com/tool-windows-amd64.exe",
+				"https://example.com/tool-windows-arm64.exe",
+			},
+			selectFor: [2]string{"windows", "arm64"},
+			wantURL:   "https://example.com/tool-windows-arm64.exe",
+		},
+		{
+			name: "wildcard platform in template",
+			overrides: `      "*/arm64":
+        url: https://example.com/tool-{{.Platform}}-{{.Architecture}}
+`,
+			wantURLs: []model.ResolvedURL{
+				"https://example.com/tool.sh",
+				"https://example.com/tool-linux-arm64",
+				"https://example.com/tool-windows-arm64",
+			},
+			selectFor: [2]string{"linux", "amd64"},
+			wantURL:   "https://example.com/tool.sh",
+		},
+		{
+			name: "same url is locked once",
+			overrides: `      "windows/*":
+        url: https://example.com/tool.exe
+`,
+			wantURLs: []model.ResolvedURL{
+				"https://example.com/tool.sh",
+				"https://example.com/tool.exe",
+			},
+			selectFor: [2]string{"windows", "amd64"},
+			wantURL:   "https://example.com/tool.exe",
+		},
+		{
+			name: "more specific key wins",
+			overrides: `      "windows/*":
+        url: https://example.com/tool-{{.Platform}}.exe
+      "*/arm64":
+        url: https://example.com/tool-{{.Architecture}}
+`,
+			wantURLs: []model.ResolvedURL{
+				"https://example.com/tool.sh",
+				"https://example.com/tool-arm64",
+				"https://example.com/tool-windows.exe",
+			},
+			selectFor: [2]string{"windows", "arm64"},
+			wantURL:   "https://example.com/tool-windows.exe",
+		},
+		{
+			name: "all environments overridden",
+			overrides: `      "*/*":
+        url: https://example.com/tool-{{.Platform}}-{{.Architecture}}
+`,
+			wantURLs: []model.ResolvedURL{
+				"https://example.com/tool-linux-amd64",
+				"https://example.com/tool-linux-arm64",
+				"https://example.com/tool-windows-amd64",
+				"https://example.com/tool-windows-arm64",
+			},
+			selectFor: [2]string{"linux", "amd64"},
+			wantURL:   "https://example.com/tool-linux-amd64",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(t.TempDir(), "dltofu.yml")
+			if err := os.WriteFile(p, []byte(header+tt.overrides), 0644); err != nil {
+				t.Fatal(err)
+			}
+			c, err := LoadConfig(p, nil, false)
+			if err != nil {
+				t.Fatalf("LoadConfig() error = %v", err)
+			}
+
+			targets, err := c.FileTargets("tool")
+			if err != nil {
+				t.Fatalf("FileTargets() error = %v", err)
+			}
+			var urls []model.ResolvedURL
+			for _, target := range targets {
+				urls = append(urls, target.URL)
+				if target.PlatformID == overrideWildcard || target.ArchID == overrideWildcard {
+					t.Errorf("FileTargets() returned a wildcard target %s", target)
+				}
+			}
+			if !slices.Equal(urls, tt.wantURLs) {
+				t.Errorf("FileTargets() URLs = %v, want %v", urls, tt.wantURLs)
+			}
+
+			target, applicable, err := c.SelectTarget("tool", tt.selectFor[0], tt.selectFor[1], "")
+			if err != nil || !applicable {
+				t.Fatalf("SelectTarget() = %v, %v, want applicable", applicable, err)
+			}
+			if target.URL != tt.wantURL {
+				t.Errorf("SelectTarget(%s/%s) URL = %s, want %s", tt.selectFor[0], tt.selectFor[1], target.URL, tt.wantURL)
+			}
+		})
+	}
+}
+
+func TestTargetDestination(t *testing.T) {
+	dir := t.TempDir()
+	const config = `version: v1
+files:
+  tool:
+    version: "1.2.3"
+    url: https://example.com/tool-{{.Version}}-{{.Platform}}-{{.Architecture}}
+    destination: bin/tool-{{.Version}}/{{.Platform}}-{{.Architecture}}/tool
+    platforms:
+      linux: linux
+      windows: win
+    architectures:
+      x86_64: amd64
+      arm64: aarch64
+    overrides:
+      windows/x86_64:
+        destination: win/tool-{{.Version}}.exe
+      "linux/*":
+        destination: /opt/tool/{{.Version}}/{{.Architecture | upper}}/tool
+      "*/arm64":
+        url: https://example.com/tool-{{.Version}}-{{.Platform}}-arm
+`
+	p := filepath.Join(dir, "dltofu.yml")
+	if err := os.WriteFile(p, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := LoadConfig(p, nil, false)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+
+	tests := []struct {
+		platformID string
+		archID     string
+		want       string
+	}{
+		// 設定ファイル基準の相対パスは展開後に解決される
+		{platformID: "windows", archID: "arm64", want: filepath.Join(dir, "bin", "tool-1.2.3", "win-aarch64", "tool")},
+		{platformID: "windows", archID: "x86_64", want: filepath.Join(dir, "win", "tool-1.2.3.exe")},
+		// 絶対パスは展開後もそのまま使われる
+		{platformID: "linux", archID: "x86_64", want: "/opt/tool/1.2.3/AMD64/tool"},
+		// destination を持たない override (*/arm64) より、destination を持つ override (linux/*) が使われる
+		{platformID: "linux", archID: "arm64", want: "/opt/tool/1.2.3/AARCH64/tool"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.platformID+"/"+tt.archID, func(t *testing.T) {
+			target, applicable, err := c.SelectTarget("tool", tt.platformID, tt.archID, "")
+			if err != nil || !applicable {
+				t.Fatalf("SelectTarget() = %v, %v, want applicable", applicable, err)
+			}
+			if target.Destination != tt.want {
+				t.Errorf("Destination = %s, want %s", target.Destination, tt.want)
+			}
+		})
+	}
+}
+
+func TestTargetMatrixMatchesSelectTarget(t *testing.T) {
+	const config = `version: v1
+hash_algorithm: sha256
+files:
+  cli:
+    url: https://example.com/cli-{{.Platform}}-{{.Architecture}}{{.Variant}}
+    destination: bin/{{.Platform}}/cli
+    platforms:
+      linux: linux
+      windows: windows
+    architectures:
+      x86_64: amd64
+      arm: arm
+    arch_variants:
+      armv6: v6
+      armv7: v7
+    overrides:
+      windows/x86_64:
+        url: https://example.com/cli-win64.exe
+        hash_algorithm: sha512
+      linux/arm/armv6:
+        destination: bin/legacy/cli
+  script:
+    url: https://example.com/install.sh
+    destination: install.sh
+  tool:
+    url: https://example.com/tool.tar.gz
+    overrides:
+      "windows/*":
+        url: https://example.com/tool-{{.Platform}}.zip
+        hash_algorithm: blake3
+`
+	p := filepath.Join(t.TempDir(), "dltofu.yml")
+	if err := os.WriteFile(p, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := LoadConfig(p, nil, false)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	matrix, err := c.TargetMatrix()
+	if err != nil {
+		t.Fatalf("TargetMatrix() error = %v", err)
+	}
+
+	// TargetMatrix はファイルIDの順に FileTargets を連結したものと一致する
+	var concatenated []Target
+	for _, fileID := range []model.FileID{"cli", "script", "tool"} {
+		targets, err := c.FileTargets(fileID)
+		if err != nil {
+			t.Fatalf("FileTargets(%s) error = %v", fileID, err)
+		}
+		concatenated = append(concatenated, targets...)
+	}
+	if !reflect.DeepEqual(matrix, concatenated) {
+		t.Errorf("TargetMatrix() differs from FileTargets:\n got %v\nwant %v", matrix, concatenated)
+	}
+
+	tests := []struct {
+		fileID                  model.FileID
+		platform, arch, variant string
+		wantURL                 model.ResolvedURL
+		wantAlgorithm           hash.HashAlgorithm
+		wantApplicable          bool
+	}{
+		{fileID: "cli", platform: "linux", arch: "x86_64", wantURL: "https://example.com/cli-linux-amd64", wantAlgorithm: hash.AlgoSHA256, wantApplicable: true},
+		{fileID: "cli", platform: "windows", arch: "x86_64", wantURL: "https://example.com/cli-win64.exe", wantAlgorithm: hash.AlgoSHA512, wantApplicable: true},
+		{fileID: "cli", platform: "linux", arch: "arm", variant: "armv7", wantURL: "https://example.com/cli-linux-armv7", wantAlgorithm: hash.AlgoSHA256, wantApplicable: true},
+		{fileID: "cli", platform: "linux", arch: "arm", variant: "armv6", wantURL: "https://example.com/cli-linux-armv6", wantAlgorithm: hash.AlgoSHA256, wantApplicable: true},
+		{fileID: "cli", platform: "linux", arch: "arm", wantURL: "https://example.com/cli-linux-armv6", wantAlgorithm: hash.AlgoSHA256, wantApplicable: true}, // 不明なら最も古いバリアント
+		{fileID: "cli", platform: "linux", arch: "arm", variant: "armv5"},
+		{fileID: "cli", platform: "macos", arch: "x86_64"},
+		{fileID: "cli", platform: "linux", arch: "arm64"},
+		// プラットフォーム指定がないファイルは lock と download で同じく全ての環境が対象になる
+		{fileID: "script", platform: "macos", arch: "arm64", wantURL: "https://example.com/install.sh", wantAlgorithm: hash.AlgoSHA256, wantApplicable: true},
+		{fileID: "tool", platform: "linux", arch: "x86_64", wantURL: "https://example.com/tool.tar.gz", wantAlgorithm: hash.AlgoSHA256, wantApplicable: true},
+		{fileID: "tool", platform: "windows", arch: "arm64", wantURL: "https://example.com/tool-windows.zip", wantAlgorithm: hash.AlgoBLAKE3, wantApplicable: true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.fileID)+"/"+tt.platform+"/"+tt.arch+"/"+tt.variant, func(t *testing.T) {
+			selected, applicable, err := c.SelectTarget(tt.fileID, tt.platform, tt.arch, tt.variant)
+			if err != nil {
+				t.Fatalf("SelectTarget() error = %v", err)
+			}
+			if applicable != tt.wantApplicable {
+				t.Fatalf("SelectTarget() applicable = %v, want %v", applicable, tt.wantApplicable)
+			}
+			if !applicable {
+				return
+			}
+			if selected.URL != tt.wantURL || selected.HashAlgorithm != tt.wantAlgorithm {
+				t.Errorf("SelectTarget() = %s with %s, want %s with %s", selected.URL, selected.HashAlgorithm, tt.wantURL, tt.wantAlgorithm)
+			}
+
+			// download が選ぶバリアントは lock が記録するバリアントのいずれかと同じに解決される
+			i := slices.IndexFunc(matrix, func(target Target) bool {
+				return target.FileID == tt.fileID && target.URL == selected.URL
+			})
+			if i < 0 {
+				t.Fatalf("TargetMatrix() has no target for %s", selected.URL)
+			}
+			got := matrix[i]
+			if got.HashAlgorithm != selected.HashAlgorithm || got.Destination != selected.Destination ||
+				!reflect.DeepEqual(got.Mirrors, selected.Mirrors) || !reflect.DeepEqual(got.Rename, selected.Rename) {
+				t.Errorf("TargetMatrix() = %+v, SelectTarget() = %+v", got, selected)
+			}
+			if selected.Def.hasVariants() && !reflect.DeepEqual(got, selected) {
+				t.Errorf("TargetMatrix() = %+v, SelectTarget() = %+v", got, selected)
+			}
+		})
+	}
+}
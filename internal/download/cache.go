@@ -0,0 +1,304 @@
+package download
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/hrko/dltofu/internal/cache"
+	"github.com/hrko/dltofu/internal/hash"
+	"github.com/hrko/dltofu/internal/model"
+)
+
+const (
+	// maxCachedBodySize はメモリに保持する1つの内容の最大バイト数。超える内容はディスクキャッシュに退避する。
+	maxCachedBodySize = 32 << 20
+	// maxCacheMemory はメモリに保持する内容の合計の最大バイト数
+	maxCacheMemory = 256 << 20
+)
+
+// fetchCache は1回の実行中に複数回取得されるURLの内容を保持する。
+// 最初の取得時に内容を保持し、2回目以降はダウンロードせずに保持した内容からハッシュ値を計算する。
+// 大きな内容はディスクキャッシュ (指定された場合) に退避し、退避できない場合は保持しない。
+// 見込みの回数だけ使われた内容は破棄する。
+type fetchCache struct {
+	mu        sync.Mutex
+	remaining map[model.ResolvedURL]int // URL ごとの内容を使う残りの回数 (最初の取得を含む)
+	entries   map[cacheKey]*cacheEntry
+	disk      *cache.Cache // 大きな内容の退避先 (nil の場合は退避しない)
+	memUsed   int64        // メモリに保持している内容の合計バイト数
+}
+
+// cacheKey はキャッシュのキー。同じ URL でも異なるヘッダや代替URLで取得した内容は共有しない。
+type cacheKey struct {
+	url     model.ResolvedURL
+	variant string // Downloader.variantKey の値
+}
+
+type cacheEntry struct {
+	once     sync.Once
+	body     []byte      // メモリに保持した内容 (保持していない場合は nil)
+	diskHash *hash.Hash  // ディスクキャッシュに退避した場合の内容のハッシュ値
+	header   http.Header // 最初の取得時のレスポンスヘッダ (Content-Type の照合と ETag などの取得用)
+}
+
+// WithCache は uses で2回以上使われる見込みのURLの内容を実行中保持し、再利用する。
+// uses のキーは URL、値はその URL を取得する見込みの回数。
+// maxCachedBodySize を超える内容やメモリの上限を超える分は disk に退避する (nil の場合は保持せず、取得し直す)。
+// 条件付きリクエストや分割ダウンロードはキャッシュしない。
+func WithCache(uses map[model.ResolvedURL]int, disk *cache.Cache) Option {
+	return func(d *Downloader) {
+		c := &fetchCache{
+			remaining: make(map[model.ResolvedURL]int),
+			entries:   make(map[cacheKey]*cacheEntry),
+			disk:      disk,
+		}
+		for url, n := range uses {
+			if n >= 2 {
+				c.remaining[url] = n
+			}
+		}
+		if len(c.remaining) > 0 {
+			d.cache = c
+		}
+	}
+}
+
+// variantKey は url の取得に使うリクエストの違い (WithHeader のヘッダと WithMirrors の代替URL) を表す文字列を返す。
+// 認証ヘッダなどが異なれば取得できる内容も異なり得るため、キャッシュはこの値ごとに分ける。
+func (d *Downloader) variantKey(url model.ResolvedURL) string {
+	var b strings.Builder
+	for _, name := range slices.Sorted(maps.Keys(d.header)) {
+		for _, value := range d.header[name] {
+			fmt.Fprintf(&b, "%s: %s\n", name, value)
+		}
+	}
+	for _, mirror := range d.mirrors[url] {
+		fmt.Fprintf(&b, "mirror: %s\n", mirror)
+	}
+	return b.String()
+}
+
+// fetchAndHash は url をキャッシュを使って取得し、writer に書き込むと同時にハッシュ値を計算する。
+// respHeader は最初の取得時のレスポンスヘッダ。キャッシュ対象でない URL の場合は ok = false を返し、何もしない。
+func (c *fetchCache) fetchAndHash(d *Downloader, url model.ResolvedURL, algorithm hash.HashAlgorithm, writer io.Writer) (h *hash.Hash, respHeader http.Header, ok bool, err error) {
+	key := cacheKey{url: url, variant: d.variantKey(url)}
+	c.mu.Lock()
+	if _, ok := c.remaining[url]; !ok {
+		c.mu.Unlock()
+		return nil, nil, false, nil
+	}
+	entry, ok := c.entries[key]
+	if !ok {
+		entry = &cacheEntry{}
+		c.entries[key] = entry
+	}
+	c.mu.Unlock()
+
+	fetched := false
+	entry.once.Do(func() {
+		fetched = true
+		if d.metrics != nil {
+			d.metrics.CacheMiss()
+		}
+		h, entry.header, err = c.fetchAndKeep(d, url, algorithm, writer, entry)
+	})
+	defer c.use(url)
+	if fetched {
+		return h, entry.header, true, err
+	}
+
+	var body io.Reader
+	var size int64
+	switch {
+	case entry.body != nil:
+		body, size = bytes.NewReader(entry.body), int64(len(entry.body))
+	case entry.diskHash != nil:
+		f, openErr := c.disk.Open(entry.diskHash)
+		if openErr != nil {
+			d.logger.Debug("Spilled content is no longer available, fetching again", "url", url, "error", openErr)
+			return nil, nil, false, nil
+		}
+		defer f.Close()
+		if info, statErr := f.Stat(); statErr == nil {
+			size = info.Size()
+		}
+		body = f
+	default:
+		// 最初の取得に失敗した場合や内容を保持しなかった場合はキャッシュを使わずに取得し直す
+		return nil, nil, false, nil
+	}
+
+	// 最初の取得とは別のファイルとして取得する場合もあるため、Content-Type はここでも照合する
+	if err := d.matchContentType(url, entry.header.Get("Content-Type"), size); err != nil {
+		return nil, nil, true, err
+	}
+	d.logger.Debug("Reusing downloaded content from cache", "url", url, "bytes", size, "spilled", entry.diskHash != nil)
+	if d.metrics != nil {
+		d.metrics.CacheHit()
+	}
+	if entry.diskHash != nil {
+		return c.hashSpilled(body, entry.diskHash, algorithm, writer, entry.header)
+	}
+	if writer == nil {
+		h, err = hash.CalculateStream(body, algorithm)
+	} else {
+		h, err = hash.CalculateStreamTee(body, writer, algorithm)
+	}
+	return h, entry.header, true, err
+}
+
+// fetchAndKeep は url を取得して writer に書き込み、内容を entry に保持する
+func (c *fetchCache) fetchAndKeep(d *Downloader, url model.ResolvedURL, algorithm hash.HashAlgorithm, writer io.Writer, entry *cacheEntry) (*hash.Hash, http.Header, error) {
+	sw := &spillWriter{cache: c}
+	w := io.Writer(sw)
+	if writer != nil {
+		w = io.MultiWriter(writer, sw)
+	}
+	h, header, err := d.fetchAndHashDirect([]model.ResolvedURL{url}, algorithm, w, nil)
+	if err != nil {
+		sw.discard()
+		return nil, header, err
+	}
+	if sw.file != nil {
+		tmpPath := sw.file.Name()
+		closeErr := sw.file.Close()
+		if closeErr == nil {
+			closeErr = c.disk.Adopt(h, tmpPath)
+		}
+		if closeErr != nil {
+			d.logger.Warn("Failed to spill downloaded content to the disk cache", "url", url, "error", closeErr)
+			os.Remove(tmpPath)
+			return h, header, nil
+		}
+		entry.diskHash = h
+		return h, header, nil
+	}
+	if !sw.dropped {
+		entry.body = sw.buf.Bytes()
+	}
+	return h, header, nil
+}
+
+// hashSpilled はディスクキャッシュに退避した内容を writer に書き込みながら algorithm のハッシュ値を計算する。
+// 退避した内容が書き換えられていないことを退避時のハッシュ値 spilled で確認する。
+func (c *fetchCache) hashSpilled(body io.Reader, spilled *hash.Hash, algorithm hash.HashAlgorithm, writer io.Writer, header http.Header) (*hash.Hash, http.Header, bool, error) {
+	verifier, err := hash.GetHasher(spilled.Algorithm)
+	if err != nil {
+		return nil, nil, true, err
+	}
+	if writer == nil {
+		writer = io.Discard
+	}
+	h, err := hash.CalculateStreamTee(io.TeeReader(body, verifier), writer, algorithm)
+	if err != nil {
+		return nil, nil, true, err
+	}
+	if actual := hash.NewHash(spilled.Algorithm, verifier.Sum(nil)); !actual.Equal(spilled) {
+		return nil, nil, true, fmt.Errorf("cached content was modified on disk: expected %s, got %s", spilled, actual)
+	}
+	return h, header, true, nil
+}
+
+// use は url の残りの使用回数を減らす。使い切った場合は url の全ての内容のメモリを解放する。
+func (c *fetchCache) use(url model.ResolvedURL) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remaining[url]--
+	if c.remaining[url] > 0 {
+		return
+	}
+	delete(c.remaining, url)
+	for key, entry := range c.entries {
+		if key.url == url {
+			c.memUsed -= int64(len(entry.body))
+			delete(c.entries, key)
+		}
+	}
+}
+
+// reserve はメモリに n バイト保持できる場合に予約して true を返す
+func (c *fetchCache) reserve(n int64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.memUsed+n > maxCacheMemory {
+		return false
+	}
+	c.memUsed += n
+	return true
+}
+
+// release は reserve で予約した n バイトを解放する
+func (c *fetchCache) release(n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.memUsed -= n
+}
+
+// spillWriter は最初の取得の内容を保持する io.Writer。
+// maxCachedBodySize またはメモリの上限を超えるとディスクキャッシュの一時ファイルに切り替え、
+// ディスクキャッシュがない場合や書き込みに失敗した場合は保持をやめる。
+// 内容の保持はダウンロードの成否に影響しないため、Write はエラーを返さない。
+type spillWriter struct {
+	cache   *fetchCache
+	buf     bytes.Buffer
+	file    *os.File // 退避先の一時ファイル (退避していない場合は nil)
+	dropped bool     // 保持をやめた場合 true
+}
+
+func (w *spillWriter) Write(p []byte) (int, error) {
+	switch {
+	case w.dropped:
+	case w.file != nil:
+		if _, err := w.file.Write(p); err != nil {
+			w.discard()
+		}
+	case int64(w.buf.Len()+len(p)) <= maxCachedBodySize && w.cache.reserve(int64(len(p))):
+		w.buf.Write(p)
+	default:
+		w.spill(p)
+	}
+	return len(p), nil
+}
+
+// spill はメモリに保持した内容と p をディスクキャッシュの一時ファイルに書き込み、以降の書き込み先にする
+func (w *spillWriter) spill(p []byte) {
+	held := w.buf.Bytes()
+	w.buf = bytes.Buffer{}
+	w.cache.release(int64(len(held)))
+	if w.cache.disk == nil {
+		w.dropped = true
+		return
+	}
+	f, err := w.cache.disk.CreateTemp()
+	if err != nil {
+		w.dropped = true
+		return
+	}
+	w.file = f
+	if _, err := f.Write(held); err != nil {
+		w.discard()
+		return
+	}
+	if _, err := f.Write(p); err != nil {
+		w.discard()
+	}
+}
+
+// discard は保持している内容を破棄し、以降の書き込みを無視する
+func (w *spillWriter) discard() {
+	if w.file != nil {
+		w.file.Close()
+		os.Remove(w.file.Name())
+		w.file = nil
+	}
+	w.cache.release(int64(w.buf.Len()))
+	w.buf = bytes.Buffer{}
+	w.dropped = true
+}
@@ -0,0 +1,294 @@
+package download
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hrko/dltofu/internal/cache"
+	"github.com/hrko/dltofu/internal/hash"
+	"github.com/hrko/dltofu/internal/model"
+)
+
+func TestFetchCacheSeparatesHeaderVariants(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		io.WriteString(w, "content for "+r.Header.Get("Authorization"))
+	}))
+	defer srv.Close()
+	url := model.ResolvedURL(srv.URL + "/file")
+	mirror := model.ResolvedURL(srv.URL + "/mirror")
+
+	base := NewDownloader(10*time.Second, nil, WithCache(map[model.ResolvedURL]int{url: 6}, nil))
+	alice := base.WithHeader(http.Header{"Authorization": {"alice"}})
+	bob := base.WithHeader(http.Header{"Authorization": {"bob"}})
+	aliceMirrored := alice.WithMirrors(url, []model.ResolvedURL{mirror})
+
+	tests := []struct {
+		name         string
+		d            *Downloader
+		want         string
+		wantRequests int32 // この取得までの累計のリクエスト数
+	}{
+		{name: "first header", d: alice, want: "content for alice", wantRequests: 1},
+		{name: "other header is not served from cache", d: bob, want: "content for bob", wantRequests: 2},
+		{name: "same header reuses", d: alice, want: "content for alice", wantRequests: 2},
+		{name: "no header", d: base, want: "content for ", wantRequests: 3},
+		{name: "mirrors are a separate variant", d: aliceMirrored, want: "content for alice", wantRequests: 4},
+		{name: "other header reuses", d: bob, want: "content for bob", wantRequests: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if _, err := tt.d.FetchAndHash(url, hash.AlgoSHA256, &buf); err != nil {
+				t.Fatalf("FetchAndHash() error = %v", err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("content = %q, want %q", buf.String(), tt.want)
+			}
+			if got := requests.Load(); got != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", got, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestFetchCacheSpillsLargeBodies(t *testing.T) {
+	large := bytes.Repeat([]byte("x"), maxCachedBodySize+1)
+	tests := []struct {
+		name         string
+		body         []byte
+		disk         bool
+		wantRequests int32
+		wantSpilled  bool
+	}{
+		{name: "small body kept in memory", body: []byte("small"), disk: true, wantRequests: 1},
+		{name: "large body spilled to disk", body: large, disk: true, wantRequests: 1, wantSpilled: true},
+		{name: "large body without disk cache is fetched again", body: large, wantRequests: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				w.Write(tt.body)
+			}))
+			defer srv.Close()
+			url := model.ResolvedURL(srv.URL + "/file")
+
+			cacheDir := t.TempDir()
+			var disk *cache.Cache
+			if tt.disk {
+				var err error
+				if disk, err = cache.New(cacheDir, nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+			d := NewDownloader(10*time.Second, nil, WithCache(map[model.ResolvedURL]int{url: 2}, disk))
+			want, err := hash.CalculateStream(bytes.NewReader(tt.body), hash.AlgoSHA256)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 2; i++ {
+				var buf bytes.Buffer
+				h, err := d.FetchAndHash(url, hash.AlgoSHA256, &buf)
+				if err != nil {
+					t.Fatalf("FetchAndHash() #%d error = %v", i, err)
+				}
+				if !h.Equal(want) || !bytes.Equal(buf.Bytes(), tt.body) {
+					t.Errorf("FetchAndHash() #%d = %v (%d bytes), want %v (%d bytes)", i, h, buf.Len(), want, len(tt.body))
+				}
+			}
+			if got := requests.Load(); got != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", got, tt.wantRequests)
+			}
+			if spilled := len(cachedFiles(t, cacheDir)) > 0; spilled != tt.wantSpilled {
+				t.Errorf("spilled = %v, want %v", spilled, tt.wantSpilled)
+			}
+			if d.cache.memUsed != 0 {
+				t.Errorf("memory still held after all uses: %d bytes", d.cache.memUsed)
+			}
+		})
+	}
+}
+
+func TestFetchCacheDetectsModifiedSpill(t *testing.T) {
+	body := bytes.Repeat([]byte("y"), maxCachedBodySize+1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	defer srv.Close()
+	url := model.ResolvedURL(srv.URL + "/file")
+
+	cacheDir := t.TempDir()
+	disk, err := cache.New(cacheDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDownloader(10*time.Second, nil, WithCache(map[model.ResolvedURL]int{url: 2}, disk))
+	if _, err := d.FetchAndHash(url, hash.AlgoSHA256, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	files := cachedFiles(t, cacheDir)
+	if len(files) != 1 {
+		t.Fatalf("cached files = %v, want 1", files)
+	}
+	if err := os.WriteFile(files[0], []byte("tampered"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = d.FetchAndHash(url, hash.AlgoSHA512, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "modified on disk") {
+		t.Errorf("FetchAndHash() error = %v, want modified on disk", err)
+	}
+}
+
+func TestSpillWriterSpill(t *testing.T) {
+	tests := []struct {
+		name      string
+		disk      string // "ok": 使えるディスクキャッシュ、"broken": 一時ファイルを作れない、"": なし
+		memUsed   int64  // 書き込み前に他の内容が使っているメモリ
+		wantFile  bool
+		wantKept  string // メモリに残る内容
+		wantDrop  bool
+		wantSpill string // 退避先の一時ファイルの内容
+	}{
+		{name: "within memory budget", disk: "ok", wantKept: "firstsecond"},
+		{name: "memory budget exhausted", disk: "ok", memUsed: maxCacheMemory - 5, wantFile: true, wantSpill: "firstsecond"},
+		{name: "exhausted without disk cache", memUsed: maxCacheMemory - 5, wantDrop: true},
+		{name: "exhausted with broken disk cache", disk: "broken", memUsed: maxCacheMemory - 5, wantDrop: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &fetchCache{memUsed: tt.memUsed}
+			var cacheDir string
+			if tt.disk != "" {
+				cacheDir = filepath.Join(t.TempDir(), "cache")
+				disk, err := cache.New(cacheDir, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tt.disk == "broken" {
+					if err := os.RemoveAll(cacheDir); err != nil {
+						t.Fatal(err)
+					}
+				}
+				c.disk = disk
+			}
+			w := &spillWriter{cache: c}
+			// 1回目の "first" はメモリに収まり、2回目の "second" で上限を超える (上限まで余裕がある場合を除く)
+			for _, p := range []string{"first", "second"} {
+				if n, err := w.Write([]byte(p)); n != len(p) || err != nil {
+					t.Fatalf("Write(%q) = %d, %v; want %d, nil", p, n, err, len(p))
+				}
+			}
+
+			if got := w.buf.String(); got != tt.wantKept {
+				t.Errorf("kept in memory = %q, want %q", got, tt.wantKept)
+			}
+			if got := c.memUsed - tt.memUsed; got != int64(len(tt.wantKept)) {
+				t.Errorf("reserved memory = %d, want %d (the spilled or dropped part must be released)", got, len(tt.wantKept))
+			}
+			if w.dropped != tt.wantDrop {
+				t.Errorf("dropped = %v, want %v", w.dropped, tt.wantDrop)
+			}
+			if (w.file != nil) != tt.wantFile {
+				t.Fatalf("spilled to file = %v, want %v", w.file != nil, tt.wantFile)
+			}
+			if w.file == nil {
+				return
+			}
+			tmpPath := w.file.Name()
+			if filepath.Dir(tmpPath) != cacheDir {
+				t.Errorf("spill file %s is not in the disk cache %s", tmpPath, cacheDir)
+			}
+			if err := w.file.Sync(); err != nil {
+				t.Fatal(err)
+			}
+			if data, err := os.ReadFile(tmpPath); err != nil || string(data) != tt.wantSpill {
+				t.Errorf("spill file content = %q (error: %v), want %q", data, err, tt.wantSpill)
+			}
+			w.discard()
+			if _, err := os.Stat(tmpPath); !os.IsNotExist(err) {
+				t.Errorf("spill file still exists after discard (stat error: %v)", err)
+			}
+		})
+	}
+}
+
+func TestHashSpilled(t *testing.T) {
+	const content = "spilled content\n"
+	spilled, err := hash.CalculateStream(strings.NewReader(content), hash.AlgoSHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := hash.CalculateStream(strings.NewReader(content), hash.AlgoSHA512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		onDisk  string
+		wantErr bool
+	}{
+		{name: "unchanged", onDisk: content},
+		{name: "modified", onDisk: "tampered content\n", wantErr: true},
+		{name: "truncated", onDisk: content[:5], wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &fetchCache{}
+			header := http.Header{"Etag": {`"v1"`}}
+			var buf bytes.Buffer
+			h, gotHeader, ok, err := c.hashSpilled(strings.NewReader(tt.onDisk), spilled, hash.AlgoSHA512, &buf, header)
+			if !ok {
+				t.Errorf("hashSpilled() ok = false, want true")
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("hashSpilled() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !strings.Contains(err.Error(), "modified on disk") {
+					t.Errorf("hashSpilled() error = %v, want modified on disk", err)
+				}
+				return
+			}
+			if !h.Equal(want) {
+				t.Errorf("hashSpilled() = %v, want %v", h, want)
+			}
+			if buf.String() != content {
+				t.Errorf("written content = %q, want %q", buf.String(), content)
+			}
+			if gotHeader.Get("ETag") != `"v1"` {
+				t.Errorf("hashSpilled() header = %v, want the first response header", gotHeader)
+			}
+		})
+	}
+}
+
+// cachedFiles は dir 以下の通常ファイルのパスを返す
+func cachedFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type().IsRegular() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
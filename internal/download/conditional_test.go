@@ -0,0 +1,167 @@
+package download
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hrko/dltofu/internal/hash"
+	"github.com/hrko/dltofu/internal/model"
+)
+
+func TestFetchToFileIfModified(t *testing.T) {
+	const etag = `"v1"`
+	content := []byte("new content\n")
+	contentHash, err := hash.CalculateStream(bytes.NewReader(content), hash.AlgoSHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		existing     string // 既存ファイルの内容 (空なら作成しない)
+		expectedHash *hash.Hash
+		validators   Validators
+		wantErr      error
+		wantContent  string
+		wantRequests int
+	}{
+		{
+			name:         "not modified keeps existing file",
+			existing:     string(content),
+			expectedHash: contentHash,
+			validators:   Validators{ETag: etag},
+			wantErr:      ErrNotModified,
+			wantContent:  string(content),
+			wantRequests: 1,
+		},
+		{
+			name:         "not modified but existing file differs",
+			existing:     "tampered\n",
+			expectedHash: contentHash,
+			validators:   Validators{ETag: etag},
+			wantContent:  string(content),
+			wantRequests: 2,
+		},
+		{
+			name:         "not modified but file missing",
+			expectedHash: contentHash,
+			validators:   Validators{ETag: etag},
+			wantContent:  string(content),
+			wantRequests: 2,
+		},
+		{
+			name:         "stale validators download",
+			existing:     "old\n",
+			expectedHash: contentHash,
+			validators:   Validators{ETag: `"v0"`},
+			wantContent:  string(content),
+			wantRequests: 1,
+		},
+		{
+			name:         "without expected hash",
+			wantContent:  string(content),
+			wantRequests: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				w.Header().Set("ETag", etag)
+				if r.Header.Get("If-None-Match") == etag {
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				w.Write(content)
+			}))
+			defer srv.Close()
+
+			destPath := filepath.Join(t.TempDir(), "file")
+			if tt.existing != "" {
+				if err := os.WriteFile(destPath, []byte(tt.existing), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			d := NewDownloader(10*time.Second, nil)
+			h, validators, err := d.FetchToFileIfModified(model.ResolvedURL(srv.URL+"/file"), destPath, hash.AlgoSHA256, tt.expectedHash, tt.validators)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("FetchToFileIfModified() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && !h.Equal(contentHash) {
+				t.Errorf("FetchToFileIfModified() hash = %v, want %v", h, contentHash)
+			}
+			if validators.ETag != etag {
+				t.Errorf("FetchToFileIfModified() validators = %+v, want ETag %s", validators, etag)
+			}
+			if requests != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", requests, tt.wantRequests)
+			}
+			got, err := os.ReadFile(destPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.wantContent {
+				t.Errorf("content = %q, want %q", got, tt.wantContent)
+			}
+		})
+	}
+}
+
+func TestFetchIfModified(t *testing.T) {
+	const etag = `"v1"`
+	content := []byte("streamed content\n")
+	contentHash, err := hash.CalculateStream(bytes.NewReader(content), hash.AlgoSHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		validators Validators
+		wantErr    error
+		wantBody   string
+	}{
+		{name: "unconditional", wantBody: string(content)},
+		{name: "stale validators", validators: Validators{ETag: `"v0"`}, wantBody: string(content)},
+		{name: "not modified", validators: Validators{ETag: etag}, wantErr: ErrNotModified},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", etag)
+				if r.Header.Get("If-None-Match") == etag {
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				w.Write(content)
+			}))
+			defer srv.Close()
+
+			var buf bytes.Buffer
+			d := NewDownloader(10*time.Second, nil)
+			h, validators, err := d.FetchIfModified(model.ResolvedURL(srv.URL+"/file"), hash.AlgoSHA256, &buf, tt.validators)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("FetchIfModified() error = %v, want %v", err, tt.wantErr)
+			}
+			if buf.String() != tt.wantBody {
+				t.Errorf("written = %q, want %q", buf.String(), tt.wantBody)
+			}
+			if err != nil {
+				return
+			}
+			if !h.Equal(contentHash) {
+				t.Errorf("FetchIfModified() hash = %v, want %v", h, contentHash)
+			}
+			if validators.ETag != etag {
+				t.Errorf("FetchIfModified() validators = %+v, want ETag %s", validators, etag)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package download
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/hrko/dltofu/internal/model"
+)
+
+// suspiciousHTMLSize はこれより小さい (またはサイズ不明の) text/html のレスポンスを
+// エラーページやログインページの可能性があるとして警告するサイズ
+const suspiciousHTMLSize = 1 << 20
+
+// htmlSniffSize は内容が HTML かどうかの判定に使う先頭のバイト数
+const htmlSniffSize = 512
+
+// ParseContentType は expect_content_type の値 ("application/gzip" や "application/*" など) を検証する
+func ParseContentType(contentType string) error {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("invalid content type '%s': %w", contentType, err)
+	}
+	if major, minor, ok := strings.Cut(mediaType, "/"); !ok || major == "*" || minor == "" {
+		return fmt.Errorf("invalid content type '%s' (expected type/subtype or type/*)", contentType)
+	}
+	return nil
+}
+
+// WithContentType は url (とその代替URL) のレスポンスの Content-Type が contentType と一致しない場合に
+// 失敗する Downloader を返す。contentType のパラメータ (charset など) は無視し、"application/*" のように
+// サブタイプを * にすると同じタイプの全てに一致する。統計情報や同時接続数の制限などは d と共有される。
+func (d *Downloader) WithContentType(url model.ResolvedURL, contentType string) *Downloader {
+	if contentType == "" {
+		return d
+	}
+	clone := *d
+	clone.contentTypes = maps.Clone(d.contentTypes)
+	if clone.contentTypes == nil {
+		clone.contentTypes = make(map[model.ResolvedURL]string)
+	}
+	clone.contentTypes[url] = contentType
+	return &clone
+}
+
+// checkContentType は url のレスポンスの Content-Type を WithContentType の指定と照合する。
+// 一致しない場合はレスポンスボディを閉じてエラーを返す。
+func (d *Downloader) checkContentType(url model.ResolvedURL, resp *http.Response) error {
+	if err := d.matchContentType(url, resp.Header.Get("Content-Type"), resp.ContentLength); err != nil {
+		resp.Body.Close()
+		return err
+	}
+	return nil
+}
+
+// matchContentType は url の内容の Content-Type (actual) を WithContentType の指定と照合する。
+// 指定がない場合、小さな text/html の内容はエラーページなどの可能性があるため警告する (size が -1 の場合はサイズ不明)。
+func (d *Downloader) matchContentType(url model.ResolvedURL, actual string, size int64) error {
+	expected, ok := d.contentTypes[url]
+	if !ok {
+		if mediaType(actual) == "text/html" && size < suspiciousHTMLSize {
+			d.logger.Warn("Response is a small HTML document; the URL may point to an error or login page (set expect_content_type to silence)", "url", url, "content_type", actual, "size", size)
+		}
+		return nil
+	}
+	if !contentTypeMatches(expected, actual) {
+		if actual == "" {
+			return fmt.Errorf("unexpected response from %s: expected Content-Type %s, but the response has none", url, expected)
+		}
+		return fmt.Errorf("unexpected response from %s: expected Content-Type %s, got %s", url, expected, actual)
+	}
+	return nil
+}
+
+// contentTypeMatches は Content-Type ヘッダの値 actual が expected に一致するか返す
+func contentTypeMatches(expected, actual string) bool {
+	want, got := mediaType(expected), mediaType(actual)
+	if got == "" {
+		return false
+	}
+	if major, ok := strings.CutSuffix(want, "/*"); ok {
+		return strings.HasPrefix(got, major+"/")
+	}
+	return want == got
+}
+
+// mediaType は Content-Type の値からパラメータを除いたメディアタイプを小文字で返す。解釈できない場合は空文字列を返す。
+func mediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+// expectsHTML は WithContentType で url に text/html (または text/*) が指定されている場合に true を返す
+func (d *Downloader) expectsHTML(url model.ResolvedURL) bool {
+	expected, ok := d.contentTypes[url]
+	return ok && contentTypeMatches(expected, "text/html")
+}
+
+// htmlSniffer は読み込んだ内容の先頭とサイズを記録し、HTML 文書に見えるかを判定する io.Reader。
+// Content-Type が正しく設定されていないエラーページやログインページを検出するために使う。
+type htmlSniffer struct {
+	r    io.Reader
+	head []byte // 先頭 htmlSniffSize バイトまで
+	size int64  // 読み込んだバイト数
+}
+
+func (s *htmlSniffer) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if rest := htmlSniffSize - len(s.head); rest > 0 && n > 0 {
+		s.head = append(s.head, p[:min(n, rest)]...)
+	}
+	s.size += int64(n)
+	return n, err
+}
+
+// looksLikeHTML は内容が小さな HTML 文書 (<!DOCTYPE html または <html で始まる) に見える場合に true を返す
+func (s *htmlSniffer) looksLikeHTML() bool {
+	if s.size >= suspiciousHTMLSize {
+		return false
+	}
+	head := bytes.TrimPrefix(s.head, []byte("\xef\xbb\xbf")) // UTF-8 の BOM
+	head = bytes.ToLower(bytes.TrimLeft(head, " \t\r\n"))
+	return bytes.HasPrefix(head, []byte("<!doctype html")) || bytes.HasPrefix(head, []byte("<html"))
+}
@@ -0,0 +1,132 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// IPFamily は接続に使う IP のバージョン
+type IPFamily int
+
+const (
+	IPAny IPFamily = iota // OS のデフォルトに従う
+	IPv4
+	IPv6
+)
+
+// String は IPFamily の表示用文字列を返す
+func (f IPFamily) String() string {
+	switch f {
+	case IPv4:
+		return "IPv4"
+	case IPv6:
+		return "IPv6"
+	default:
+		return "any"
+	}
+}
+
+// network は IPFamily に対応する net.Dial のネットワーク名を返す
+func (f IPFamily) network() string {
+	switch f {
+	case IPv4:
+		return "tcp4"
+	case IPv6:
+		return "tcp6"
+	default:
+		return "tcp"
+	}
+}
+
+// dialTimeout と dialKeepAlive は http.DefaultTransport と同じ値
+const (
+	dialTimeout   = 30 * time.Second
+	dialKeepAlive = 30 * time.Second
+)
+
+// WithIPFamily は family のアドレスを優先して接続する。
+// strict が true の場合は family のアドレスのみに接続し、もう一方にはフォールバックしない。
+// family が IPAny の場合は何もしない。
+func WithIPFamily(family IPFamily, strict bool) Option {
+	return func(d *Downloader) {
+		if family == IPAny {
+			return
+		}
+		netDialer := &net.Dialer{Timeout: dialTimeout, KeepAlive: dialKeepAlive}
+		fd := &familyDialer{
+			prefer: family,
+			strict: strict,
+			lookup: net.DefaultResolver.LookupIPAddr,
+			dial:   netDialer.DialContext,
+			logger: d.logger,
+		}
+		d.transport().DialContext = fd.DialContext
+	}
+}
+
+// familyDialer は名前解決したアドレスを IPFamily の優先順に並べて順に接続を試みる
+type familyDialer struct {
+	prefer IPFamily
+	strict bool
+	// lookup はホスト名を IP アドレスに解決する
+	lookup func(ctx context.Context, host string) ([]net.IPAddr, error)
+	// dial は実際の接続処理 (network は "tcp4" または "tcp6"、address は IP アドレスとポート)
+	dial   func(ctx context.Context, network, address string) (net.Conn, error)
+	logger *slog.Logger
+}
+
+// DialContext は http.Transport.DialContext として使う
+func (fd *familyDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := fd.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var preferred, others []net.IP
+	for _, addr := range addrs {
+		if familyOf(addr.IP) == fd.prefer {
+			preferred = append(preferred, addr.IP)
+		} else {
+			others = append(others, addr.IP)
+		}
+	}
+	candidates := preferred
+	if !fd.strict {
+		candidates = append(candidates, others...)
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no %s address found for %s", fd.prefer, host)
+	}
+
+	var errs []error
+	for _, ip := range candidates {
+		family := familyOf(ip)
+		target := net.JoinHostPort(ip.String(), port)
+		fd.logger.Debug("Dialing", "host", host, "address", target, "family", family)
+		conn, err := fd.dial(ctx, family.network(), target)
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// familyOf は ip の IPFamily を返す
+func familyOf(ip net.IP) IPFamily {
+	if ip.To4() != nil {
+		return IPv4
+	}
+	return IPv6
+}
@@ -0,0 +1,127 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/hrko/dltofu/internal/hash"
+	"github.com/hrko/dltofu/internal/model"
+)
+
+func TestFamilyDialer(t *testing.T) {
+	const (
+		v4 = "192.0.2.1"
+		v6 = "2001:db8::1"
+	)
+	tests := []struct {
+		name      string
+		prefer    IPFamily
+		strict    bool
+		addrs     []string // 名前解決の結果 (この順で返す)
+		failing   []string // 接続に失敗するアドレス
+		wantDials []string // 試行する "network address" (この順)
+		wantErr   string   // 空ならエラーにならない
+	}{
+		{name: "prefer IPv4", prefer: IPv4, addrs: []string{v6, v4}, wantDials: []string{"tcp4 192.0.2.1:443"}},
+		{name: "prefer IPv6", prefer: IPv6, addrs: []string{v4, v6}, wantDials: []string{"tcp6 [2001:db8::1]:443"}},
+		{name: "IPv4 falls back to IPv6", prefer: IPv4, addrs: []string{v6, v4}, failing: []string{v4},
+			wantDials: []string{"tcp4 192.0.2.1:443", "tcp6 [2001:db8::1]:443"}},
+		{name: "IPv6 falls back to IPv4", prefer: IPv6, addrs: []string{v4, v6}, failing: []string{v6},
+			wantDials: []string{"tcp6 [2001:db8::1]:443", "tcp4 192.0.2.1:443"}},
+		{name: "no preferred address", prefer: IPv6, addrs: []string{v4}, wantDials: []string{"tcp4 192.0.2.1:443"}},
+		{name: "strict IPv4 does not fall back", prefer: IPv4, strict: true, addrs: []string{v6, v4}, failing: []string{v4},
+			wantDials: []string{"tcp4 192.0.2.1:443"}, wantErr: "connection refused"},
+		{name: "strict IPv6 without IPv6 address", prefer: IPv6, strict: true, addrs: []string{v4},
+			wantErr: "no IPv6 address found for mirror.example.com"},
+		{name: "all addresses fail", prefer: IPv4, addrs: []string{v4, v6}, failing: []string{v4, v6},
+			wantDials: []string{"tcp4 192.0.2.1:443", "tcp6 [2001:db8::1]:443"}, wantErr: "connection refused"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dials []string
+			fd := &familyDialer{
+				prefer: tt.prefer,
+				strict: tt.strict,
+				lookup: func(ctx context.Context, host string) ([]net.IPAddr, error) {
+					if host != "mirror.example.com" {
+						t.Errorf("lookup(%q), want mirror.example.com", host)
+					}
+					var addrs []net.IPAddr
+					for _, a := range tt.addrs {
+						addrs = append(addrs, net.IPAddr{IP: net.ParseIP(a)})
+					}
+					return addrs, nil
+				},
+				dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+					dials = append(dials, network+" "+address)
+					host, _, _ := net.SplitHostPort(address)
+					if slices.Contains(tt.failing, host) {
+						return nil, errors.New("connection refused")
+					}
+					client, server := net.Pipe()
+					server.Close()
+					return client, nil
+				},
+				logger: slog.Default(),
+			}
+			conn, err := fd.DialContext(context.Background(), "tcp", "mirror.example.com:443")
+			if conn != nil {
+				conn.Close()
+			}
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("DialContext() error = %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("DialContext() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+			if !slices.Equal(dials, tt.wantDials) {
+				t.Errorf("dials = %q, want %q", dials, tt.wantDials)
+			}
+		})
+	}
+}
+
+func TestWithIPFamily(t *testing.T) {
+	// httptest のサーバーは 127.0.0.1 で待ち受ける
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		family  IPFamily
+		strict  bool
+		wantErr bool
+	}{
+		{family: IPAny},
+		{family: IPv4, strict: true},
+		{family: IPv6},
+		{family: IPv6, strict: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		name := tt.family.String()
+		if tt.strict {
+			name += "/strict"
+		}
+		t.Run(name, func(t *testing.T) {
+			d := NewDownloader(0, nil, WithIPFamily(tt.family, tt.strict))
+			var buf bytes.Buffer
+			_, err := d.FetchAndHash(model.ResolvedURL(srv.URL), hash.AlgoSHA256, &buf)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FetchAndHash() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && buf.String() != "ok" {
+				t.Errorf("body = %q, want %q", buf.String(), "ok")
+			}
+		})
+	}
+}
@@ -1,41 +1,221 @@
 package download
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
+	"net"
 	"net/http"
+	neturl "net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/hrko/dltofu/internal/hash" // 自身のモジュールパス
+	"github.com/hrko/dltofu/internal/metrics"
 	"github.com/hrko/dltofu/internal/model"
+	"github.com/hrko/dltofu/internal/progress"
 )
 
+// DefaultTimeout はリクエストを送信してからレスポンスヘッダを受信するまでのタイムアウトのデフォルト値
 const DefaultTimeout = 60 * time.Second
 
+// DefaultConcurrencyPerHost は同一ホストへの同時ダウンロード数のデフォルト値
+const DefaultConcurrencyPerHost = 4
+
+// DefaultRetryBackoff はリトライ間隔の初期値 (リトライごとに倍になる)
+const DefaultRetryBackoff = 1 * time.Second
+
+// maxRetryBackoff はリトライ間隔の上限
+const maxRetryBackoff = 30 * time.Second
+
+// ErrNotModified は条件付きリクエストに対してサーバーが 304 Not Modified を返したことを示す
+var ErrNotModified = errors.New("not modified")
+
+// Validators は条件付きリクエストに使うレスポンスの ETag と Last-Modified ヘッダの値
+type Validators struct {
+	ETag         string
+	LastModified string
+}
+
+// IsZero はどちらの値もない場合に true を返す
+func (v Validators) IsZero() bool {
+	return v.ETag == "" && v.LastModified == ""
+}
+
+// requestHeader は v に対応する If-None-Match/If-Modified-Since ヘッダを返す
+func (v Validators) requestHeader() http.Header {
+	header := http.Header{}
+	if v.ETag != "" {
+		header.Set("If-None-Match", v.ETag)
+	}
+	if v.LastModified != "" {
+		header.Set("If-Modified-Since", v.LastModified)
+	}
+	return header
+}
+
+// validatorsFrom はレスポンスヘッダから Validators を取得する (header が nil の場合は空)
+func validatorsFrom(header http.Header) Validators {
+	return Validators{ETag: header.Get("ETag"), LastModified: header.Get("Last-Modified")}
+}
+
 // Downloader はファイルダウンロード機能を提供
 type Downloader struct {
-	client *http.Client
+	client   *http.Client
+	pipeline pipeline                                  // レスポンスボディの読み込み処理
+	metrics  *metrics.Metrics                          // 統計情報の集計先 (nil の場合は集計しない)
+	hosts    *hostLimiter                              // ホストごとの同時接続数の制限 (nil の場合は制限しない)
+	retries  int                                       // 失敗時のリトライ回数 (0 の場合はリトライしない)
+	backoff  time.Duration                             // リトライ間隔の初期値
+	header   http.Header                               // 全てのリクエストに設定するヘッダ (WithHeader で指定)
+	mirrors  map[model.ResolvedURL][]model.ResolvedURL // URL ごとの代替URL (WithMirrors で指定)
+	cache    *fetchCache                               // 複数回取得するURLの内容 (nil の場合はキャッシュしない)
+	resolver *URLResolver                              // 取得するURLの変換 (nil の場合は変換しない)
+	// contentTypes は URL ごとに期待するレスポンスの Content-Type (WithContentType で指定)
+	contentTypes map[model.ResolvedURL]string
+	// stallTimeout はレスポンスボディの受信が止まってから中断するまでの時間 (0 の場合は中断しない)
+	stallTimeout time.Duration
+	// ctx はリクエストとリトライの待機に使うコンテキスト (nil の場合は context.Background)
+	ctx    context.Context
 	logger *slog.Logger
 }
 
-// NewDownloader は Downloader を作成
-func NewDownloader(timeout time.Duration, logger *slog.Logger) *Downloader {
+// Option は NewDownloader に渡すオプション
+type Option func(d *Downloader)
+
+// WithMetrics はダウンロードしたバイト数やリクエスト数を m に集計する
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(d *Downloader) {
+		d.metrics = m
+		d.pipeline.set(stageMetrics, func(r io.Reader, t *transfer) io.Reader {
+			return &countingReader{r: r, add: m.AddBytes}
+		})
+	}
+}
+
+// WithProgress は各ダウンロードの進捗を r に通知する
+func WithProgress(r progress.Reporter) Option {
+	return func(d *Downloader) {
+		d.pipeline.set(stageProgress, func(rd io.Reader, t *transfer) io.Reader {
+			return &progressReader{r: rd, tracker: r.Start(string(t.url), t.size)}
+		})
+	}
+}
+
+// WithContext はリクエストとリトライの待機を ctx に従わせる。
+// ctx がキャンセルされるか、リトライの待ち時間が ctx の期限を超える場合はリトライせずに失敗する。
+func WithContext(ctx context.Context) Option {
+	return func(d *Downloader) {
+		d.ctx = ctx
+	}
+}
+
+// context は d のコンテキストを返す
+func (d *Downloader) context() context.Context {
+	if d.ctx == nil {
+		return context.Background()
+	}
+	return d.ctx
+}
+
+// WithConcurrencyPerHost は同一ホストへの同時ダウンロード数を n に制限する。
+// n が 0 以下の場合は制限しない。
+func WithConcurrencyPerHost(n int) Option {
+	return func(d *Downloader) {
+		if n > 0 {
+			d.hosts = newHostLimiter(n)
+		}
+	}
+}
+
+// WithRetry はネットワークエラーや 5xx/429 レスポンスの場合に最大 retries 回リトライする。
+// リトライ間隔は backoff から始まり、リトライごとに倍になる (Retry-After ヘッダがあればそれに従う)。
+// 受信が途中で失敗した場合、サーバーが Range リクエストに対応していれば同じ回数まで続きから再開する。
+func WithRetry(retries int, backoff time.Duration) Option {
+	return func(d *Downloader) {
+		d.retries = max(retries, 0)
+		d.backoff = backoff
+		if d.retries > 0 {
+			d.pipeline.set(stageResume, resumeLayer(d.retries, d.logger))
+		} else {
+			d.pipeline.set(stageResume, nil)
+		}
+	}
+}
+
+// NewDownloader は Downloader を作成する。
+// timeout はリクエストを送信してからレスポンスヘッダを受信するまでのタイムアウト (0 の場合は DefaultTimeout)。
+// 大きなファイルの転送が途中で打ち切られないよう、転送全体の時間は制限せず、
+// 接続と TLS ハンドシェイクには http.DefaultTransport と同じタイムアウトを、ボディの受信には WithStallTimeout の設定を使う。
+func NewDownloader(timeout time.Duration, logger *slog.Logger, opts ...Option) *Downloader {
 	if logger == nil {
 		logger = slog.Default()
 	}
 	if timeout == 0 {
 		timeout = DefaultTimeout
 	}
-	return &Downloader{
+	// プロキシは HTTP_PROXY/HTTPS_PROXY/NO_PROXY に従う (WithProxy で上書きできる)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = timeout
+	transport.RegisterProtocol("file", fileTransport{}) // file:// はローカルファイルとして読む
+	// オブジェクトストレージは認証済みの https のリクエストに変換する (プロキシや TLS の設定も適用される)
+	transport.RegisterProtocol("s3", &s3Transport{next: transport})
+	transport.RegisterProtocol("gs", &gcsTransport{next: transport})
+	d := &Downloader{
 		client: &http.Client{
-			Timeout: timeout,
-			// リダイレクト追従はデフォルトで有効 (最大10回)
+			Transport: transport,
+			// リダイレクトは最大10回まで追従する。file:// などの http(s) 以外へのリダイレクトは拒否する
+			CheckRedirect: checkRedirect,
 		},
-		logger: logger,
+		stallTimeout: DefaultStallTimeout,
+		logger:       logger,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// HTTPClient はダウンロードに使う http.Client を返す。
+// プロキシや TLS、レスポンスヘッダのタイムアウトなどの設定が適用されているため、ダウンロード以外の API の問い合わせなどに使う。
+func (d *Downloader) HTTPClient() *http.Client {
+	return d.client
+}
+
+// WithHeader は全てのリクエストに header を設定する Downloader を返す。
+// 統計情報や同時接続数の制限などは d と共有される。認証ヘッダなどファイルごとに異なるヘッダを使う場合に利用する。
+func (d *Downloader) WithHeader(header http.Header) *Downloader {
+	if len(header) == 0 {
+		return d
+	}
+	clone := *d
+	clone.header = header.Clone()
+	return &clone
+}
+
+// WithMirrors は url の取得に失敗した場合に mirrors を順に試す Downloader を返す。
+// 統計情報や同時接続数の制限などは d と共有される。
+// 代替URLから取得した内容も url の内容として扱われるため、ハッシュ値の検証は url の期待値に対して行われる。
+// WithHeader のヘッダは代替URLへのリクエストにも設定されるが、url と異なるオリジンの代替URLには認証情報のヘッダを送らない。
+func (d *Downloader) WithMirrors(url model.ResolvedURL, mirrors []model.ResolvedURL) *Downloader {
+	if len(mirrors) == 0 {
+		return d
+	}
+	clone := *d
+	clone.mirrors = maps.Clone(d.mirrors)
+	if clone.mirrors == nil {
+		clone.mirrors = make(map[model.ResolvedURL][]model.ResolvedURL)
 	}
+	clone.mirrors[url] = slices.Clone(mirrors)
+	return &clone
 }
 
 // FetchToFileWithHashCheck は指定されたURLからファイルをダウンロードし、
@@ -44,19 +224,45 @@ func (d *Downloader) FetchToFileWithHashCheck(url model.ResolvedURL, destPath st
 	if expectedHash == nil {
 		return fmt.Errorf("expected hash is nil")
 	}
+	_, err := d.fetchToFile(destPath, url, expectedHash, func(w io.Writer) (*hash.Hash, error) {
+		h, _, err := d.fetchAndHash([]model.ResolvedURL{url}, expectedHash.Algorithm, w, nil)
+		return h, err
+	})
+	return err
+}
 
+// FetchPartsToFileWithHashCheck は分割されたファイルの各パートを urls の順にダウンロードし、
+// 連結した内容を指定されたパスに保存すると同時に、連結後の内容のハッシュ値を計算して検証する。
+func (d *Downloader) FetchPartsToFileWithHashCheck(urls []model.ResolvedURL, destPath string, expectedHash *hash.Hash) error {
+	if expectedHash == nil {
+		return fmt.Errorf("expected hash is nil")
+	}
+	if len(urls) == 0 {
+		return fmt.Errorf("no part URLs specified")
+	}
+	_, err := d.fetchToFile(destPath, urls[0], expectedHash, func(w io.Writer) (*hash.Hash, error) {
+		h, _, err := d.fetchAndHash(urls, expectedHash.Algorithm, w, nil)
+		return h, err
+	})
+	return err
+}
+
+// fetchToFile は FetchToFileWithHashCheck などの本体で、fetch が書き込む内容を一時ファイルに保存してから destPath にリネームする。
+// fetch はダウンロードした内容を w に書き込み、そのハッシュ値を返す。url はログ出力用の代表URL。
+// expectedHash が nil の場合は検証せずに保存する。保存した内容のハッシュ値を返す。
+func (d *Downloader) fetchToFile(destPath string, url model.ResolvedURL, expectedHash *hash.Hash, fetch func(w io.Writer) (*hash.Hash, error)) (*hash.Hash, error) {
 	d.logger.Debug("Starting download", "url", url, "destination", destPath)
 
 	// ディレクトリが存在しない場合は作成
 	destDir := filepath.Dir(destPath)
 	if err := os.MkdirAll(destDir, 0755); err != nil {
-		return fmt.Errorf("failed to create destination directory %s: %w", destDir, err)
+		return nil, fmt.Errorf("failed to create destination directory %s: %w", destDir, err)
 	}
 
 	// 一時ファイルにダウンロード
 	tmpFile, err := os.CreateTemp(destDir, filepath.Base(destPath)+".*.tmp")
 	if err != nil {
-		return fmt.Errorf("failed to create temporary file in %s: %w", destDir, err)
+		return nil, fmt.Errorf("failed to create temporary file in %s: %w", destDir, err)
 	}
 	tmpFilePath := tmpFile.Name()
 	d.logger.Debug("Created temporary file", "path", tmpFilePath)
@@ -71,87 +277,495 @@ func (d *Downloader) FetchToFileWithHashCheck(url model.ResolvedURL, destPath st
 	}()
 
 	// ダウンロードとハッシュ計算/ファイル書き込み
-	actualHash, err := d.FetchAndHash(url, expectedHash.Algorithm, tmpFile)
+	actualHash, err := fetch(tmpFile)
 	if err != nil {
-		return fmt.Errorf("failed to download and calculate hash: %w", err)
+		if errors.Is(err, ErrNotModified) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to download and calculate hash: %w", err)
 	}
-	if !actualHash.Equal(expectedHash) {
-		return fmt.Errorf("hash mismatch for %s: expected %s, got %s", url, expectedHash, actualHash)
+	if expectedHash != nil {
+		if !actualHash.Equal(expectedHash) {
+			return nil, fmt.Errorf("hash mismatch for %s: expected %s, got %s", url, expectedHash, actualHash)
+		}
+		d.logger.Debug("Hash verified successfully", "url", url, "hash", actualHash)
 	}
-	d.logger.Debug("Hash verified successfully", "url", url, "hash", actualHash)
 
 	// 一時ファイルを最終的なパスにリネーム (アトミック操作)
 	// tmpFile を閉じる必要がある
 	if err := tmpFile.Close(); err != nil {
-		return fmt.Errorf("failed to close temporary file %s: %w", tmpFilePath, err)
+		return nil, fmt.Errorf("failed to close temporary file %s: %w", tmpFilePath, err)
 	}
 	d.logger.Debug("Renaming temporary file", "from", tmpFilePath, "to", destPath)
 	err = os.Rename(tmpFilePath, destPath)
 	if err != nil {
 		// Rename が失敗した場合、一時ファイルは残っている可能性があるが、defer での削除に任せる
-		return fmt.Errorf("failed to rename temporary file %s to %s: %w", tmpFilePath, destPath, err)
+		return nil, fmt.Errorf("failed to rename temporary file %s to %s: %w", tmpFilePath, destPath, err)
 	}
 
 	d.logger.Info("File downloaded successfully", "url", url, "destination", destPath)
-	return nil
+	return actualHash, nil
+}
+
+// FetchIfModified は validators (前回のレスポンスの ETag/Last-Modified) を If-None-Match/If-Modified-Since ヘッダとして送信し、
+// 条件付きでダウンロードした内容を w に書き込むと同時に algorithm のハッシュ値を計算する。
+// 返り値はハッシュ値と、次回の条件付きリクエストに使うレスポンスの Validators。
+// サーバーが 304 を返した場合は w に何も書き込まずに ErrNotModified を返す。validators が空の場合は条件なしのダウンロードになる。
+// lock のようにハッシュ値とバイト数だけが必要な場合は、w にバイト数を数える io.Writer を渡せばディスクに書き込まずに済む。
+func (d *Downloader) FetchIfModified(url model.ResolvedURL, algorithm hash.HashAlgorithm, w io.Writer, validators Validators) (*hash.Hash, Validators, error) {
+	var header http.Header
+	if !validators.IsZero() {
+		d.logger.Debug("Sending conditional request", "url", url, "etag", validators.ETag, "last_modified", validators.LastModified)
+		header = validators.requestHeader()
+	}
+	h, respHeader, err := d.fetchAndHash([]model.ResolvedURL{url}, algorithm, w, header)
+	if err != nil {
+		return nil, Validators{}, err
+	}
+	return h, validatorsFrom(respHeader), nil
+}
+
+// FetchToFileIfModified は FetchIfModified で条件付きでダウンロードし、destPath に保存する。
+// 返り値は保存した内容のハッシュ値と、次回の条件付きリクエストに使うレスポンスの Validators。
+// expectedHash が nil でなければ保存する内容を検証し、nil の場合は検証せずに algorithm でハッシュ値を計算する。
+// サーバーが 304 を返した場合は destPath に触れずに ErrNotModified を返す。このとき destPath に既存ファイルがあり expectedHash が
+// 指定されていれば既存ファイルのハッシュ値を照合し、一致しない場合は条件なしで再ダウンロードする。
+func (d *Downloader) FetchToFileIfModified(url model.ResolvedURL, destPath string, algorithm hash.HashAlgorithm, expectedHash *hash.Hash, validators Validators) (*hash.Hash, Validators, error) {
+	var respValidators Validators
+	h, err := d.fetchToFile(destPath, url, expectedHash, func(w io.Writer) (*hash.Hash, error) {
+		var h *hash.Hash
+		var err error
+		h, respValidators, err = d.FetchIfModified(url, algorithm, w, validators)
+		return h, err
+	})
+	if err == nil {
+		return h, respValidators, nil
+	}
+	if !errors.Is(err, ErrNotModified) || expectedHash == nil || validators.IsZero() {
+		return nil, Validators{}, err
+	}
+
+	// 304 の場合でも既存ファイルが期待通りかハッシュで確認する
+	f, err := os.Open(destPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, Validators{}, fmt.Errorf("failed to open existing file %s: %w", destPath, err)
+		}
+		d.logger.Warn("Server reported not modified but the file does not exist. Re-downloading.", "url", url, "path", destPath)
+		return d.FetchToFileIfModified(url, destPath, algorithm, expectedHash, Validators{})
+	}
+	actualHash, err := hash.CalculateStream(f, expectedHash.Algorithm)
+	f.Close()
+	if err != nil {
+		return nil, Validators{}, fmt.Errorf("failed to calculate hash of existing file %s: %w", destPath, err)
+	}
+	if !actualHash.Equal(expectedHash) {
+		d.logger.Warn("Server reported not modified but existing file hash does not match. Re-downloading.", "url", url, "path", destPath, "expected", expectedHash, "actual", actualHash)
+		return d.FetchToFileIfModified(url, destPath, algorithm, expectedHash, Validators{})
+	}
+
+	d.logger.Info("File not modified, keeping existing file", "url", url, "destination", destPath)
+	return nil, validators, ErrNotModified
 }
 
 // FetchAndHash は指定されたURLからファイルをダウンロードし、io.Writer に書き込む。
 // ダウンロードと同時に、algorithm で指定されたアルゴリズムを使用してハッシュ値を計算する。
 func (d *Downloader) FetchAndHash(url model.ResolvedURL, algorithm hash.HashAlgorithm, writer io.Writer) (*hash.Hash, error) {
-	d.logger.Debug("Starting download and hash calculation", "url", url, "algorithm", algorithm)
+	h, _, err := d.fetchAndHash([]model.ResolvedURL{url}, algorithm, writer, nil)
+	return h, err
+}
 
-	resp, err := d.open(url)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open %s: %w", url, err)
+// FetchPartsAndHash は分割されたファイルの各パートを urls の順にダウンロードし、
+// 連結したストリームを io.Writer に書き込むと同時に、連結後の内容のハッシュ値を計算する。
+func (d *Downloader) FetchPartsAndHash(urls []model.ResolvedURL, algorithm hash.HashAlgorithm, writer io.Writer) (*hash.Hash, error) {
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("no part URLs specified")
+	}
+	h, _, err := d.fetchAndHash(urls, algorithm, writer, nil)
+	return h, err
+}
+
+// fetchAndHash は FetchAndHash の本体。header はリクエストヘッダに追加される。
+// urls が複数の場合は各パートを順に開いて連結したストリームとして扱う。
+// writer が nil の場合はハッシュ計算のみ行う。WithCache の対象の URL はキャッシュした内容を使う。
+// respHeader はレスポンスヘッダ (分割ダウンロードの場合は nil)。
+func (d *Downloader) fetchAndHash(urls []model.ResolvedURL, algorithm hash.HashAlgorithm, writer io.Writer, header http.Header) (h *hash.Hash, respHeader http.Header, err error) {
+	if d.cache != nil && len(urls) == 1 && header == nil {
+		if h, respHeader, ok, err := d.cache.fetchAndHash(d, urls[0], algorithm, writer); ok {
+			return h, respHeader, err
+		}
+	}
+	return d.fetchAndHashDirect(urls, algorithm, writer, header)
+}
+
+// fetchAndHashDirect はキャッシュを使わずにダウンロードしてハッシュ値を計算する。
+// respHeader はレスポンスヘッダ (分割ダウンロードの場合は nil)。
+func (d *Downloader) fetchAndHashDirect(urls []model.ResolvedURL, algorithm hash.HashAlgorithm, writer io.Writer, header http.Header) (h *hash.Hash, respHeader http.Header, err error) {
+	url := urls[0] // ログ出力用の代表URL
+	d.logger.Debug("Starting download and hash calculation", "url", url, "parts", len(urls), "algorithm", algorithm)
+
+	var body io.ReadCloser
+	t := &transfer{ctx: d.context(), url: url, size: -1}
+	if len(urls) == 1 {
+		resp, err := d.open(url, header)
+		if err != nil {
+			if errors.Is(err, ErrNotModified) {
+				return nil, nil, err
+			}
+			return nil, nil, fmt.Errorf("failed to open %s: %w", url, err)
+		}
+		body = resp.Body
+		t.size = resp.ContentLength
+		respHeader = resp.Header
+		if resumable(resp) {
+			t.reopen = d.reopenFunc(url, header, ifRange(resp.Header), t)
+		}
+	} else {
+		// 各パートは読み込みが進んだ時点で順に開く
+		body = &partsReader{d: d, urls: urls, header: header}
 	}
-	defer resp.Close()
+	t.body = body
+	defer func() { t.body.Close() }() // 再開した場合は取得し直したボディを閉じる
 
-	hash, err := hash.CalculateStreamTee(resp, writer, algorithm)
+	sniffer := &htmlSniffer{r: body}
+	hash, err := d.pipeline.run(sniffer, t, writer, algorithm)
 	if err != nil {
-		return nil, fmt.Errorf("failed to calculate hash for %s: %w", url, err)
+		return nil, nil, fmt.Errorf("failed to calculate hash for %s: %w", url, err)
+	}
+	// Content-Type が text/html の場合は open で警告済み
+	if !d.expectsHTML(url) && mediaType(respHeader.Get("Content-Type")) != "text/html" && sniffer.looksLikeHTML() {
+		d.logger.Warn("Downloaded content looks like an HTML page; the URL may point to an error or login page (set expect_content_type: text/html if this is intended)", "url", url, "size", sniffer.size)
 	}
 
 	d.logger.Debug("Downloaded and hashed successfully", "url", url, "hash", hash)
-	return hash, nil
+	return hash, respHeader, nil
 }
 
 // Hash は指定されたURLからファイルをダウンロードし、
 // 指定されたアルゴリズムでハッシュ値を計算して返す。
 // ただし、ファイルは保存せず、io.Writer に書き込むこともない。
 func (d *Downloader) Hash(url model.ResolvedURL, algorithm hash.HashAlgorithm) (*hash.Hash, error) {
-	d.logger.Debug("Starting hash calculation", "url", url, "algorithm", algorithm)
+	h, _, err := d.fetchAndHash([]model.ResolvedURL{url}, algorithm, nil, nil)
+	return h, err
+}
 
-	resp, err := d.open(url)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open %s: %w", url, err)
+// HashParts は分割されたファイルの各パートを urls の順にダウンロードし、
+// 連結後の内容のハッシュ値を計算して返す。ファイルは保存しない。
+func (d *Downloader) HashParts(urls []model.ResolvedURL, algorithm hash.HashAlgorithm) (*hash.Hash, error) {
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("no part URLs specified")
+	}
+	h, _, err := d.fetchAndHash(urls, algorithm, nil, nil)
+	return h, err
+}
+
+// partsReader は複数のURLのレスポンスボディを順に連結して読み込む io.ReadCloser
+type partsReader struct {
+	d      *Downloader
+	urls   []model.ResolvedURL
+	header http.Header
+	next   int            // 次に開くパートのインデックス
+	cur    *http.Response // 現在読み込み中のパート
+}
+
+func (p *partsReader) Read(b []byte) (int, error) {
+	for {
+		if p.cur == nil {
+			if p.next >= len(p.urls) {
+				return 0, io.EOF
+			}
+			url := p.urls[p.next]
+			p.d.logger.Debug("Opening part", "url", url, "index", p.next)
+			resp, err := p.d.open(url, p.header)
+			if err != nil {
+				return 0, fmt.Errorf("failed to open part %s: %w", url, err)
+			}
+			p.cur = resp
+			p.next++
+		}
+		n, err := p.cur.Body.Read(b)
+		if err == io.EOF {
+			p.cur.Body.Close()
+			p.cur = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue // 次のパートへ
+		}
+		return n, err
+	}
+}
+
+func (p *partsReader) Close() error {
+	if p.cur != nil {
+		err := p.cur.Body.Close()
+		p.cur = nil
+		return err
+	}
+	return nil
+}
+
+// open は指定されたURLからHTTP GETリクエストを作成し、レスポンスを返す。
+// 呼び出し元はレスポンスボディを閉じる必要がある。
+// header が指定された場合はリクエストヘッダに追加する。
+// 条件付きリクエストに対してサーバーが 304 を返した場合は ErrNotModified を返す。
+// WithRetry が指定されている場合、ネットワークエラーや 5xx/429 レスポンスはリトライする (404 などはリトライしない)。
+// WithMirrors で代替URLが指定されている場合、url の取得に (リトライ後も) 失敗すると代替URLを順に試す。
+// WithContentType で指定した Content-Type と一致しないレスポンスも失敗として扱う。
+func (d *Downloader) open(url model.ResolvedURL, header http.Header) (*http.Response, error) {
+	resp, err := d.openURL(url, header)
+	if err == nil {
+		err = d.checkContentType(url, resp)
+	}
+	mirrors := d.mirrors[url]
+	if err == nil || errors.Is(err, ErrNotModified) || len(mirrors) == 0 {
+		return resp, err
+	}
+
+	d.logger.Warn("Download failed, trying mirrors", "url", url, "mirrors", len(mirrors), "error", err)
+	for _, mirror := range mirrors {
+		resp, mirrorErr := d.forMirror(url, mirror).openURL(mirror, header)
+		if mirrorErr == nil {
+			mirrorErr = d.checkContentType(url, resp)
+		}
+		if mirrorErr == nil {
+			d.logger.Info("Downloading from mirror", "url", url, "mirror", mirror)
+			return resp, nil
+		}
+		if errors.Is(mirrorErr, ErrNotModified) {
+			return nil, mirrorErr
+		}
+		d.logger.Warn("Mirror failed", "url", url, "mirror", mirror, "error", mirrorErr)
 	}
-	defer resp.Close()
+	return nil, fmt.Errorf("%w (all %d mirrors also failed)", err, len(mirrors))
+}
+
+// credentialHeaders は url と異なるオリジンの代替URLに送らないヘッダ (net/http がリダイレクト時に除くものと同じ)。
+// 名前に credentialHeaderWords を含むヘッダ (X-Api-Key, Private-Token など) も除く。
+var credentialHeaders = []string{"Authorization", "Proxy-Authorization", "Www-Authenticate", "Cookie", "Cookie2"}
 
-	hash, err := hash.CalculateStream(resp, algorithm)
+// credentialHeaderWords は認証情報のヘッダとみなす名前に含まれる語 (小文字)
+var credentialHeaderWords = []string{"auth", "token", "key", "secret", "session", "password"}
+
+// forMirror は mirror へのリクエストに使う Downloader を返す。
+// mirror が url と異なるオリジン (スキーム、ホスト、ポート) の場合は WithHeader のヘッダから認証情報を除く。
+func (d *Downloader) forMirror(url, mirror model.ResolvedURL) *Downloader {
+	if len(d.header) == 0 || sameOrigin(url, mirror) {
+		return d
+	}
+	header := d.header.Clone()
+	for name := range header {
+		if isCredentialHeader(name) {
+			d.logger.Debug("Not sending credential header to mirror on another host", "header", name, "mirror", mirror)
+			header.Del(name)
+		}
+	}
+	clone := *d
+	clone.header = header
+	return &clone
+}
+
+// ForRelated は url に付随するファイル (署名など) の related を取得する Downloader を返す。
+// related が url と異なるオリジンの場合は、代替URLと同様に WithHeader のヘッダから認証情報を除く。
+func (d *Downloader) ForRelated(url, related model.ResolvedURL) *Downloader {
+	return d.forMirror(url, related)
+}
+
+// sameOrigin は a と b のスキーム、ホスト、ポートが同じ場合に true を返す (解析できない場合は false)
+func sameOrigin(a, b model.ResolvedURL) bool {
+	ua, err := neturl.Parse(string(a))
+	if err != nil {
+		return false
+	}
+	ub, err := neturl.Parse(string(b))
 	if err != nil {
-		return nil, fmt.Errorf("failed to calculate hash for %s: %w", url, err)
+		return false
 	}
+	return strings.EqualFold(ua.Scheme, ub.Scheme) && strings.EqualFold(ua.Host, ub.Host)
+}
 
-	d.logger.Debug("Hash calculated successfully", "url", url, "hash", hash)
-	return hash, nil
+// isCredentialHeader は name が認証情報を含む可能性のあるヘッダの場合に true を返す
+func isCredentialHeader(name string) bool {
+	for _, h := range credentialHeaders {
+		if strings.EqualFold(name, h) {
+			return true
+		}
+	}
+	lower := strings.ToLower(name)
+	for _, word := range credentialHeaderWords {
+		if strings.Contains(lower, word) {
+			return true
+		}
+	}
+	return false
 }
 
-// open は指定されたURLからHTTP GETリクエストを作成し、レスポンスボディを返す。
-func (d *Downloader) open(url model.ResolvedURL) (io.ReadCloser, error) {
-	req, err := http.NewRequest("GET", string(url), nil)
+// openURL は url に対して1回 (リトライを含む) リクエストを送信する
+func (d *Downloader) openURL(url model.ResolvedURL, header http.Header) (*http.Response, error) {
+	fetchURL := url
+	if d.resolver != nil {
+		resolved, err := d.resolver.Resolve(d.context(), url)
+		if err != nil {
+			return nil, err
+		}
+		d.logger.Debug("Resolved URL with url_resolver", "url", url)
+		fetchURL = resolved
+	}
+	req, err := http.NewRequestWithContext(d.context(), "GET", string(fetchURL), nil)
 	if err != nil {
+		if fetchURL != url {
+			return nil, fmt.Errorf("failed to create request for %s", url) // 変換後のURLはエラーメッセージに含めない
+		}
 		return nil, fmt.Errorf("failed to create request for %s: %w", url, err)
 	}
+	for key, values := range d.header {
+		req.Header[key] = append([]string(nil), values...)
+	}
+	for key, values := range header {
+		for _, v := range values {
+			req.Header.Add(key, v)
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := d.do(req, url)
+		if err == nil {
+			return resp, nil
+		}
+		var re *retryableError
+		if !errors.As(err, &re) || attempt >= d.retries {
+			if attempt > 0 {
+				return nil, fmt.Errorf("%w (gave up after %d retries)", err, attempt)
+			}
+			return nil, err
+		}
+		wait := d.retryWait(attempt, re.retryAfter)
+		ctx := req.Context()
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return nil, fmt.Errorf("%w (not retrying: waiting %s would exceed the deadline)", err, wait)
+		}
+		d.logger.Warn("Download failed, retrying", "url", url, "retry", attempt+1, "max_retries", d.retries, "wait", wait, "error", err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("%w (retry canceled: %w)", err, ctx.Err())
+		}
+	}
+}
+
+// retryableError はリトライによって成功する可能性があるエラー
+type retryableError struct {
+	err        error
+	retryAfter time.Duration // Retry-After ヘッダで指定された待ち時間 (指定がない場合は 0)
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// isTransientNetworkError は client.Do のエラーがリトライで解消しうる一時的なネットワークエラーかを返す。
+// リダイレクトの拒否 (file:// や s3:// へのリダイレクト)、証明書の検証失敗、未対応のスキームなどは
+// 何度リクエストしても同じ結果になるため含めない。
+func isTransientNetworkError(err error) bool {
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) { // レスポンスの途中 (または受信前) で接続が切れた
+		return true
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNABORTED) {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// retryWait は attempt 回目の失敗後にリトライするまでの待ち時間を返す。
+// Retry-After ヘッダの値も maxRetryBackoff を上限とする (極端に長い値でプロセスが止まらないようにする)。
+func (d *Downloader) retryWait(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return min(retryAfter, maxRetryBackoff)
+	}
+	wait := d.backoff << attempt
+	if wait <= 0 || wait > maxRetryBackoff { // シフトによるオーバーフローも上限で抑える
+		wait = maxRetryBackoff
+	}
+	return wait
+}
+
+// do はリクエストを1回送信する。url はエラーメッセージに使う論理的なURL (url_resolver による変換前のURL)。
+// 一時的なネットワークエラーや 5xx/429 レスポンスの場合は retryableError を返す。
+func (d *Downloader) do(req *http.Request, url model.ResolvedURL) (*http.Response, error) {
 
-	resp, err := d.client.Do(req)
+	// ホストごとの同時接続数の制限 (枠はレスポンスボディを閉じるまで保持する)
+	release := func() {}
+	if d.hosts != nil {
+		var err error
+		release, err = d.hosts.acquire(req.Context(), req.URL.Host)
+		if err != nil {
+			return nil, fmt.Errorf("failed to acquire connection slot for %s: %w", req.URL.Host, err)
+		}
+	}
+
+	if d.metrics != nil {
+		d.metrics.AddRequest(req.URL.Host)
+	}
+	// ボディの受信が止まった場合にリクエストを中断できるよう、リクエストごとにコンテキストを作る
+	ctx, cancel := context.WithCancel(req.Context())
+	done := func() {
+		cancel()
+		release()
+	}
+	resp, err := d.client.Do(req.WithContext(ctx))
 	if err != nil {
-		return nil, fmt.Errorf("failed to download from %s: %w", url, err)
+		done()
+		var urlErr *neturl.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = string(url) // 変換後のURLは認証情報を含みうるため出力しない
+		}
+		err = fmt.Errorf("failed to download from %s: %w", url, err)
+		if isTransientNetworkError(err) {
+			return nil, &retryableError{err: err}
+		}
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		done()
+		return nil, ErrNotModified
 	}
-	if resp.StatusCode != http.StatusOK {
+	// Range リクエスト (resume.go) には 206 も成功として返す
+	if resp.StatusCode != http.StatusOK && !(resp.StatusCode == http.StatusPartialContent && req.Header.Get("Range") != "") {
 		resp.Body.Close()
-		return nil, fmt.Errorf("failed to download from %s: received status code %d", url, resp.StatusCode)
+		done()
+		err := fmt.Errorf("failed to download from %s: received status code %d", url, resp.StatusCode)
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			return nil, &retryableError{err: err, retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+		}
+		return nil, err
+	}
+
+	body := resp.Body
+	if d.stallTimeout > 0 {
+		body = newStallReader(body, d.stallTimeout, cancel)
 	}
+	resp.Body = &releaseOnClose{ReadCloser: body, release: done}
+	return resp, nil
+}
 
-	return resp.Body, nil
+// parseRetryAfter は Retry-After ヘッダの値 (秒数または HTTP 日付) を待ち時間に変換する。
+// 解釈できない場合は 0 を返す。
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
 }
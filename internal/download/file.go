@@ -0,0 +1,63 @@
+package download
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// fileTransport は file:// の URL をローカルファイルの読み込みとして扱う http.RoundTripper。
+// エアギャップ環境のミラーやテスト用のローカルのファイルを http(s) と同じ経路 (ハッシュ検証、展開など) で扱うために使う。
+// 存在しないファイルは 404、ディレクトリなどの通常ファイル以外は 403 として返す。
+type fileTransport struct{}
+
+func (fileTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != "" && req.URL.Host != "localhost" {
+		return nil, fmt.Errorf("file URL %s must not have a host other than localhost", req.URL)
+	}
+	path := filePath(req.URL.Path)
+
+	resp := &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Body:       http.NoBody,
+		Request:    req,
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			resp.Status, resp.StatusCode = "404 Not Found", http.StatusNotFound
+			return resp, nil
+		}
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	if !info.Mode().IsRegular() {
+		file.Close()
+		resp.Status, resp.StatusCode = "403 Forbidden", http.StatusForbidden
+		return resp, nil
+	}
+	resp.ContentLength = info.Size()
+	resp.Body = file
+	return resp, nil
+}
+
+// filePath は file:// の URL のパスをローカルのパスに変換する。
+// Windows ではドライブレター付きのパス (file:///C:/path) の先頭の "/" を取り除く。
+func filePath(urlPath string) string {
+	if runtime.GOOS == "windows" && len(urlPath) >= 3 && urlPath[0] == '/' && urlPath[2] == ':' {
+		urlPath = urlPath[1:]
+	}
+	return filepath.FromSlash(urlPath)
+}
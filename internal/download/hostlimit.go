@@ -0,0 +1,52 @@
+package download
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// hostLimiter はホストごとの同時接続数を制限する
+type hostLimiter struct {
+	limit int64
+	mu    sync.Mutex
+	sems  map[string]*semaphore.Weighted // key: host
+}
+
+func newHostLimiter(limit int) *hostLimiter {
+	return &hostLimiter{
+		limit: int64(limit),
+		sems:  make(map[string]*semaphore.Weighted),
+	}
+}
+
+// acquire は host の枠を1つ確保し、解放用の関数を返す
+func (h *hostLimiter) acquire(ctx context.Context, host string) (func(), error) {
+	h.mu.Lock()
+	sem, ok := h.sems[host]
+	if !ok {
+		sem = semaphore.NewWeighted(h.limit)
+		h.sems[host] = sem
+	}
+	h.mu.Unlock()
+
+	if err := sem.Acquire(ctx, 1); err != nil {
+		return nil, err
+	}
+	return func() { sem.Release(1) }, nil
+}
+
+// releaseOnClose は Close 時に release を一度だけ呼び出す io.ReadCloser
+type releaseOnClose struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (r *releaseOnClose) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.release)
+	return err
+}
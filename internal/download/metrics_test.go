@@ -0,0 +1,76 @@
+package download
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/hrko/dltofu/internal/hash"
+	"github.com/hrko/dltofu/internal/metrics"
+	"github.com/hrko/dltofu/internal/model"
+)
+
+func TestMetricsRecordedForDownload(t *testing.T) {
+	content := bytes.Repeat([]byte("x"), 4096)
+	tests := []struct {
+		name          string
+		fetches       int  // 同じ URL を取得する回数
+		cache         bool // WithCache で再利用する
+		status        int  // サーバーが返すステータス (0 なら 200)
+		wantBytes     int64
+		wantRequests  int
+		wantCacheHits int64
+		wantCacheMiss int64
+	}{
+		{name: "single fetch", fetches: 1, wantBytes: 4096, wantRequests: 1},
+		{name: "repeated fetch", fetches: 3, wantBytes: 3 * 4096, wantRequests: 3},
+		{name: "cached fetch", fetches: 3, cache: true, wantBytes: 4096, wantRequests: 1, wantCacheHits: 2, wantCacheMiss: 1},
+		{name: "failed fetch", fetches: 1, status: http.StatusNotFound, wantBytes: 0, wantRequests: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+					return
+				}
+				time.Sleep(10 * time.Millisecond) // 計測時間が 0 にならないようにする
+				w.Write(content)
+			}))
+			defer srv.Close()
+			fileURL := model.ResolvedURL(srv.URL + "/file")
+
+			m := metrics.New()
+			opts := []Option{WithMetrics(m)}
+			if tt.cache {
+				opts = append(opts, WithCache(map[model.ResolvedURL]int{fileURL: tt.fetches}, nil))
+			}
+			d := NewDownloader(0, nil, opts...)
+			for range tt.fetches {
+				var buf bytes.Buffer
+				_, err := d.FetchAndHash(fileURL, hash.AlgoSHA256, &buf)
+				if (err != nil) != (tt.status != 0) {
+					t.Fatalf("FetchAndHash() error = %v", err)
+				}
+			}
+
+			s := m.Summary()
+			if s.BytesDownloaded != tt.wantBytes {
+				t.Errorf("BytesDownloaded = %d, want %d", s.BytesDownloaded, tt.wantBytes)
+			}
+			host := (&url.URL{Host: srv.Listener.Addr().String()}).Host
+			if s.Requests != tt.wantRequests || s.RequestsPerHost[host] != tt.wantRequests {
+				t.Errorf("Requests = %d (per host %v), want %d for %s", s.Requests, s.RequestsPerHost, tt.wantRequests, host)
+			}
+			if s.CacheHits != tt.wantCacheHits || s.CacheMisses != tt.wantCacheMiss {
+				t.Errorf("cache hits/misses = %d/%d, want %d/%d", s.CacheHits, s.CacheMisses, tt.wantCacheHits, tt.wantCacheMiss)
+			}
+			if s.DurationSeconds <= 0 {
+				t.Errorf("DurationSeconds = %v, want positive", s.DurationSeconds)
+			}
+		})
+	}
+}
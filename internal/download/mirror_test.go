@@ -0,0 +1,99 @@
+package download
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hrko/dltofu/internal/hash"
+	"github.com/hrko/dltofu/internal/model"
+)
+
+func TestMirrorCredentialHeaders(t *testing.T) {
+	header := http.Header{
+		"Authorization": {"Bearer secret"},
+		"Cookie":        {"session=secret"},
+		"X-Api-Key":     {"secret"},
+		"Private-Token": {"secret"},
+		"Accept":        {"application/octet-stream"},
+	}
+	tests := []struct {
+		name        string
+		sameOrigin  bool
+		wantHeaders []string // 代替URLへのリクエストに含まれるべきヘッダ
+		wantDropped []string // 代替URLへのリクエストに含まれないべきヘッダ
+	}{
+		{
+			name:        "same origin keeps credentials",
+			sameOrigin:  true,
+			wantHeaders: []string{"Authorization", "Cookie", "X-Api-Key", "Private-Token", "Accept"},
+		},
+		{
+			name:        "other origin drops credentials",
+			wantHeaders: []string{"Accept"},
+			wantDropped: []string{"Authorization", "Cookie", "X-Api-Key", "Private-Token"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got http.Header
+			mirrorHandler := func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Clone()
+				io.WriteString(w, "content")
+			}
+			primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/mirror" {
+					mirrorHandler(w, r)
+					return
+				}
+				http.Error(w, "gone", http.StatusNotFound)
+			}))
+			defer primary.Close()
+			other := httptest.NewServer(http.HandlerFunc(mirrorHandler))
+			defer other.Close()
+
+			mirror := model.ResolvedURL(other.URL + "/mirror")
+			if tt.sameOrigin {
+				mirror = model.ResolvedURL(primary.URL + "/mirror")
+			}
+			url := model.ResolvedURL(primary.URL + "/file")
+			d := NewDownloader(10*time.Second, nil).WithHeader(header).WithMirrors(url, []model.ResolvedURL{mirror})
+			if _, err := d.FetchAndHash(url, hash.AlgoSHA256, io.Discard); err != nil {
+				t.Fatalf("FetchAndHash() error = %v", err)
+			}
+			for _, name := range tt.wantHeaders {
+				if got.Get(name) == "" {
+					t.Errorf("mirror request lacks %s", name)
+				}
+			}
+			for _, name := range tt.wantDropped {
+				if v := got.Get(name); v != "" {
+					t.Errorf("mirror request has %s: %s", name, v)
+				}
+			}
+		})
+	}
+}
+
+func TestIsCredentialHeader(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: "Authorization", want: true},
+		{name: "proxy-authorization", want: true},
+		{name: "Cookie", want: true},
+		{name: "X-Api-Key", want: true},
+		{name: "Private-Token", want: true},
+		{name: "X-Client-Secret", want: true},
+		{name: "Accept", want: false},
+		{name: "User-Agent", want: false},
+	}
+	for _, tt := range tests {
+		if got := isCredentialHeader(tt.name); got != tt.want {
+			t.Errorf("isCredentialHeader(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
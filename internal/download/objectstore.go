@@ -0,0 +1,117 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// s3PresignExpiry は s3:// のリクエストを変換する署名付きURLの有効期限
+const s3PresignExpiry = 15 * time.Minute
+
+// gcsScope は gs:// の読み込みに使う OAuth2 のスコープ
+const gcsScope = "https://www.googleapis.com/auth/devstorage.read_only"
+
+// s3Transport は s3://bucket/key のリクエストを、実行環境の AWS 認証情報
+// (環境変数、共有設定ファイル、インスタンスプロファイルなど) で署名した https のURLへのリクエストに変換して next に渡す。
+// リージョンは AWS_REGION などの設定に従い、未設定の場合は us-east-1 とする。
+// AWS_ENDPOINT_URL_S3 などでエンドポイントが指定されている場合 (S3 互換ストレージ) はパス形式のURLを使う。
+type s3Transport struct {
+	next http.RoundTripper
+
+	once    sync.Once
+	presign *s3.PresignClient
+	err     error
+}
+
+func (t *s3Transport) client(ctx context.Context) (*s3.PresignClient, error) {
+	t.once.Do(func() {
+		cfg, err := awsconfig.LoadDefaultConfig(ctx)
+		if err != nil {
+			t.err = fmt.Errorf("failed to load AWS configuration: %w", err)
+			return
+		}
+		if cfg.Region == "" {
+			cfg.Region = "us-east-1"
+		}
+		t.presign = s3.NewPresignClient(s3.NewFromConfig(cfg, func(o *s3.Options) {
+			o.UsePathStyle = o.BaseEndpoint != nil
+		}))
+	})
+	return t.presign, t.err
+}
+
+func (t *s3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	bucket, key := req.URL.Host, strings.TrimPrefix(req.URL.Path, "/")
+	client, err := t.client(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	signed, err := client.PresignGetObject(req.Context(), &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(s3PresignExpiry))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign request for %s: %w", req.URL, err)
+	}
+	signedURL, err := url.Parse(signed.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signed URL for %s: %w", req.URL, err)
+	}
+	out := req.Clone(req.Context())
+	out.URL, out.Host = signedURL, ""
+	for key, values := range signed.SignedHeader {
+		if !strings.EqualFold(key, "Host") {
+			out.Header[key] = values
+		}
+	}
+	return t.next.RoundTrip(out)
+}
+
+// gcsTransport は gs://bucket/object のリクエストを、Application Default Credentials
+// (GOOGLE_APPLICATION_CREDENTIALS、gcloud の認証情報、メタデータサーバーなど) のアクセストークンを付けた
+// https://storage.googleapis.com/bucket/object へのリクエストに変換して next に渡す。
+type gcsTransport struct {
+	next http.RoundTripper
+
+	once   sync.Once
+	tokens oauth2.TokenSource
+	err    error
+}
+
+func (t *gcsTransport) tokenSource(ctx context.Context) (oauth2.TokenSource, error) {
+	t.once.Do(func() {
+		// トークンの更新に使う HTTP クライアントは最初のリクエストのコンテキストに依存させない
+		t.tokens, t.err = google.DefaultTokenSource(context.WithoutCancel(ctx), gcsScope)
+		if t.err != nil {
+			t.err = fmt.Errorf("failed to find Google Cloud credentials: %w", t.err)
+		}
+	})
+	return t.tokens, t.err
+}
+
+func (t *gcsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tokens, err := t.tokenSource(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	token, err := tokens.Token()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Google Cloud access token: %w", err)
+	}
+	out := req.Clone(req.Context())
+	out.URL = &url.URL{Scheme: "https", Host: "storage.googleapis.com", Path: "/" + req.URL.Host + req.URL.Path}
+	out.Host = ""
+	token.SetAuthHeader(out)
+	return t.next.RoundTrip(out)
+}
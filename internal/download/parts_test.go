@@ -0,0 +1,107 @@
+package download
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/hrko/dltofu/internal/hash"
+	"github.com/hrko/dltofu/internal/model"
+)
+
+func TestFetchParts(t *testing.T) {
+	content := bytes.Repeat([]byte("split archive content "), 1000)
+	wantHash, err := hash.CalculateStream(bytes.NewReader(content), hash.AlgoSHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		splits  []int // 各パートの終了位置 (最後のパートは常に末尾まで)
+		missing int   // 404 を返すパートの番号 (1 始まり、0 なら全て返す)
+		wantErr bool
+	}{
+		{name: "single part"},
+		{name: "two parts", splits: []int{len(content) / 2}},
+		{name: "uneven parts", splits: []int{1, 100, 10000}},
+		{name: "empty part", splits: []int{500, 500}},
+		{name: "missing part", splits: []int{500, 1000}, missing: 2, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts := make(map[string][]byte)
+			var urls []model.ResolvedURL
+			start := 0
+			var mu sync.Mutex
+			var requested []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				requested = append(requested, r.URL.Path)
+				mu.Unlock()
+				part, ok := parts[r.URL.Path]
+				if !ok {
+					http.NotFound(w, r)
+					return
+				}
+				w.Write(part)
+			}))
+			defer srv.Close()
+			for i, end := range append(slices.Clone(tt.splits), len(content)) {
+				p := fmt.Sprintf("/file.tar.gz.%03d", i+1)
+				if i+1 != tt.missing {
+					parts[p] = content[start:end]
+				}
+				urls = append(urls, model.ResolvedURL(srv.URL+p))
+				start = end
+			}
+
+			d := NewDownloader(0, nil)
+			var buf bytes.Buffer
+			got, err := d.FetchPartsAndHash(urls, hash.AlgoSHA256, &buf)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FetchPartsAndHash() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !got.Equal(wantHash) || !bytes.Equal(buf.Bytes(), content) {
+				t.Errorf("FetchPartsAndHash() = %v (%d bytes), want %v (%d bytes)", got, buf.Len(), wantHash, len(content))
+			}
+			var wantRequested []string
+			for _, u := range urls {
+				wantRequested = append(wantRequested, string(u)[len(srv.URL):])
+			}
+			if !slices.Equal(requested, wantRequested) {
+				t.Errorf("requested parts = %v, want %v in order", requested, wantRequested)
+			}
+
+			if got, err := d.HashParts(urls, hash.AlgoSHA256); err != nil || !got.Equal(wantHash) {
+				t.Errorf("HashParts() = %v, %v; want %v", got, err, wantHash)
+			}
+
+			dest := filepath.Join(t.TempDir(), "file.tar.gz")
+			if err := d.FetchPartsToFileWithHashCheck(urls, dest, wantHash); err != nil {
+				t.Fatalf("FetchPartsToFileWithHashCheck() error = %v", err)
+			}
+			if data, err := os.ReadFile(dest); err != nil || !bytes.Equal(data, content) {
+				t.Errorf("saved file has %d bytes (error: %v), want the reassembled %d bytes", len(data), err, len(content))
+			}
+
+			wrongDest := filepath.Join(t.TempDir(), "file.tar.gz")
+			wrongHash := hash.NewHash(hash.AlgoSHA256, make([]byte, 32))
+			if err := d.FetchPartsToFileWithHashCheck(urls, wrongDest, wrongHash); err == nil {
+				t.Error("FetchPartsToFileWithHashCheck() with a wrong hash succeeded")
+			}
+			if _, err := os.Stat(wrongDest); !os.IsNotExist(err) {
+				t.Errorf("a file failing the hash check was saved (error: %v)", err)
+			}
+		})
+	}
+}
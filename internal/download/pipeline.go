@@ -0,0 +1,131 @@
+package download
+
+import (
+	"context"
+	"io"
+
+	"github.com/hrko/dltofu/internal/hash"
+	"github.com/hrko/dltofu/internal/model"
+	"github.com/hrko/dltofu/internal/progress"
+)
+
+// stage はパイプライン内でのレイヤーの適用位置を表す。
+// 値が小さいほどレスポンスボディ (base reader) に近い位置で適用される。
+type stage int
+
+const (
+	stageResume    stage = iota // Range リクエストによる再開
+	stageRateLimit              // 帯域制限
+	stageProgress               // 進捗カウント
+	stageMetrics                // 統計情報の収集
+	numStages
+)
+
+// transfer はパイプラインの各レイヤーに渡される転送ごとの情報
+type transfer struct {
+	ctx  context.Context // 転送のコンテキスト (キャンセルされたらレイヤー内の待機も中断する)
+	url  model.ResolvedURL
+	size int64 // Content-Length (不明な場合は -1)
+	// body は現在読み込み中のレスポンスボディ (転送の終了時に閉じる)
+	body io.Closer
+	// reopen は offset バイト目から取得し直したボディを返す (Range リクエストで再開できない場合は nil)
+	reopen func(offset int64) (io.Reader, error)
+}
+
+// context は転送のコンテキストを返す (設定されていない場合は context.Background())
+func (t *transfer) context() context.Context {
+	if t.ctx == nil {
+		return context.Background()
+	}
+	return t.ctx
+}
+
+// layer は下位の io.Reader をラップして機能を追加するパイプラインの一段
+type layer func(r io.Reader, t *transfer) io.Reader
+
+// pipeline はレスポンスボディにレイヤーを決まった順序で重ね、最後にハッシュ計算と書き込みを行う。
+// 適用順序は base -> resume -> rateLimit -> progress -> metrics -> hasher (+writer) で固定されており、
+// 各機能は互いの存在を意識せずに自分の stage にレイヤーを登録するだけで組み合わせられる。
+type pipeline struct {
+	layers [numStages]layer
+}
+
+// set は指定された stage にレイヤーを登録する (既存のレイヤーは置き換えられる)
+func (p *pipeline) set(s stage, l layer) {
+	p.layers[s] = l
+}
+
+// finisher は転送の終了時 (成功・失敗を問わない) に後処理が必要なレイヤーの io.Reader
+type finisher interface {
+	finish()
+}
+
+// wrap は登録済みのレイヤーを stage の順に r に適用する。
+// finisher を実装するレイヤーは finishers に返し、呼び出し元が転送の終了時に呼び出す。
+func (p *pipeline) wrap(r io.Reader, t *transfer) (wrapped io.Reader, finishers []finisher) {
+	for _, l := range p.layers {
+		if l != nil {
+			r = l(r, t)
+			if f, ok := r.(finisher); ok {
+				finishers = append(finishers, f)
+			}
+		}
+	}
+	return r, finishers
+}
+
+// run は r をパイプラインに流してハッシュ値を計算する。
+// writer が nil の場合はハッシュ計算のみ行う。
+// 書き込みの失敗などで r を最後まで読まずに終了した場合も、各レイヤーの後処理 (プログレスバーの終了など) を行う。
+func (p *pipeline) run(r io.Reader, t *transfer, writer io.Writer, algorithm hash.HashAlgorithm) (*hash.Hash, error) {
+	r, finishers := p.wrap(r, t)
+	defer func() {
+		for _, f := range finishers {
+			f.finish()
+		}
+	}()
+	if writer == nil {
+		return hash.CalculateStream(r, algorithm)
+	}
+	return hash.CalculateStreamTee(r, writer, algorithm)
+}
+
+// countingReader は読み込んだバイト数を add に通知する io.Reader
+type countingReader struct {
+	r   io.Reader
+	add func(n int64)
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 {
+		c.add(int64(n))
+	}
+	return n, err
+}
+
+// progressReader は読み込んだバイト数を tracker に通知し、読み込みの終了 (EOF またはエラー) で Done を呼ぶ io.Reader
+type progressReader struct {
+	r       io.Reader
+	tracker progress.Tracker
+	done    bool // Done を呼び出し済みか
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.tracker.Add(int64(n))
+	}
+	if err != nil {
+		p.finish()
+	}
+	return n, err
+}
+
+// finish は進捗の表示を終了させる。読み込みが途中で終わった場合も pipeline.run から呼び出される。
+func (p *progressReader) finish() {
+	if !p.done {
+		p.done = true
+		p.tracker.Done()
+	}
+}
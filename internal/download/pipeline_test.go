@@ -0,0 +1,60 @@
+package download
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/hrko/dltofu/internal/hash"
+)
+
+// failingWriter は常に失敗する io.Writer
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+// failingReader は data を返した後に err を返す io.Reader
+type failingReader struct {
+	data io.Reader
+	err  error
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	n, err := f.data.Read(p)
+	if err == io.EOF {
+		return n, f.err
+	}
+	return n, err
+}
+
+func TestPipelineFinishesProgress(t *testing.T) {
+	content := strings.Repeat("x", 100000)
+	tests := []struct {
+		name    string
+		body    func() io.Reader
+		writer  io.Writer
+		wantErr bool
+	}{
+		{name: "success", body: func() io.Reader { return strings.NewReader(content) }, writer: &bytes.Buffer{}},
+		{name: "hash only", body: func() io.Reader { return strings.NewReader(content) }},
+		{name: "writer fails", body: func() io.Reader { return strings.NewReader(content) }, writer: failingWriter{}, wantErr: true},
+		{name: "body fails", body: func() io.Reader {
+			return &failingReader{data: strings.NewReader(content), err: errors.New("connection reset")}
+		}, writer: &bytes.Buffer{}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reporter := &countingReporter{}
+			d := NewDownloader(0, nil, WithProgress(reporter))
+			_, err := d.pipeline.run(tt.body(), &transfer{url: "https://example.com/file", size: int64(len(content))}, tt.writer, hash.AlgoSHA256)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if reporter.done != 1 {
+				t.Errorf("Tracker.Done called %d times, want 1", reporter.done)
+			}
+		})
+	}
+}
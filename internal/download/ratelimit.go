@@ -0,0 +1,87 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimitBurst は帯域制限時に1回の Read で読み込む最大バイト数
+const rateLimitBurst = 64 * 1024
+
+// WithMaxBandwidth は全てのダウンロードの合計帯域を bytesPerSec バイト/秒に制限する。
+// 制限は同じ Downloader による並列ダウンロード全体で共有される。
+// bytesPerSec が 0 以下の場合は制限しない。
+func WithMaxBandwidth(bytesPerSec int64) Option {
+	return func(d *Downloader) {
+		if bytesPerSec <= 0 {
+			return
+		}
+		limiter := rate.NewLimiter(rate.Limit(bytesPerSec), int(min(bytesPerSec, rateLimitBurst)))
+		d.pipeline.set(stageRateLimit, func(r io.Reader, t *transfer) io.Reader {
+			return &rateLimitedReader{r: r, limiter: limiter, ctx: t.context()}
+		})
+	}
+}
+
+// rateLimitedReader は limiter のトークンを消費しながら読み込む io.Reader。
+// トークンの待機は ctx がキャンセルされると中断する。
+type rateLimitedReader struct {
+	r       io.Reader
+	limiter *rate.Limiter
+	ctx     context.Context
+}
+
+func (l *rateLimitedReader) Read(p []byte) (int, error) {
+	// 一度に待つトークン数がバーストを超えないよう読み込み量を制限する
+	if burst := l.limiter.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+	n, err := l.r.Read(p)
+	if n > 0 {
+		if werr := l.limiter.WaitN(l.ctx, n); werr != nil && err == nil {
+			err = fmt.Errorf("rate limiter: %w", werr)
+		}
+	}
+	return n, err
+}
+
+// byteSizeUnits はサイズ表記の接尾辞と倍率 (長いものから順に判定する)
+var byteSizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30},
+	{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000},
+	{"K", 1000}, {"M", 1000 * 1000}, {"G", 1000 * 1000 * 1000},
+	{"B", 1},
+}
+
+// ParseByteSize は "10MB" や "512KiB" のようなサイズ表記をバイト数に変換する。
+// 接尾辞がない場合はバイト数として扱う。KB/MB/GB は 1000 倍、KiB/MiB/GiB は 1024 倍の単位。
+// 1バイト未満の端数は切り上げる ("0.5" は 1 バイト)。
+func ParseByteSize(s string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, unit := range byteSizeUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 || math.IsNaN(n) {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+	size := math.Ceil(n * float64(multiplier))
+	if size >= math.MaxInt64 {
+		return 0, fmt.Errorf("size is too large: %q", s)
+	}
+	return int64(size), nil
+}
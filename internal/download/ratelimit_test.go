@@ -0,0 +1,91 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/hrko/dltofu/internal/hash"
+	"github.com/hrko/dltofu/internal/model"
+)
+
+func TestRateLimitedReaderCanceled(t *testing.T) {
+	// 1 バイト/秒の制限では2バイト目以降の読み込みごとに約1秒待つ
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	time.AfterFunc(50*time.Millisecond, cancel)
+	r := &rateLimitedReader{r: strings.NewReader("abc"), limiter: rate.NewLimiter(1, 1), ctx: ctx}
+	start := time.Now()
+	_, err := io.ReadAll(r)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("ReadAll() error = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("ReadAll() took %s, want the wait to stop on cancellation", elapsed)
+	}
+}
+
+func TestMaxBandwidthRespectsContext(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte("x"), 100))
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	time.AfterFunc(50*time.Millisecond, cancel)
+	d := NewDownloader(0, nil, WithMaxBandwidth(1), WithContext(ctx))
+	start := time.Now()
+	var buf bytes.Buffer
+	if _, err := d.FetchAndHash(model.ResolvedURL(srv.URL), hash.AlgoSHA256, &buf); err == nil {
+		t.Fatal("FetchAndHash() succeeded, want an error after cancellation")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("FetchAndHash() took %s, want the rate limiter wait to stop on cancellation", elapsed)
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "0", want: 0},
+		{in: "1", want: 1},
+		{in: "512", want: 512},
+		{in: "500KB", want: 500 * 1000},
+		{in: "10mb", want: 10 * 1000 * 1000},
+		{in: "1GiB", want: 1 << 30},
+		{in: "512 KiB", want: 512 << 10},
+		{in: "1.5K", want: 1500},
+		{in: "0.5", want: 1},
+		{in: "0.001KB", want: 1},
+		{in: "1.2B", want: 2},
+		{in: "-1", wantErr: true},
+		{in: "", wantErr: true},
+		{in: "fast", wantErr: true},
+		{in: "NaN", wantErr: true},
+		{in: "Inf", wantErr: true},
+		{in: "1e30GB", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseByteSize(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseByteSize(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseByteSize(%q) = %d, want %d", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package download
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// maxRedirects はリダイレクトを追従する最大回数 (net/http のデフォルトと同じ)
+const maxRedirects = 10
+
+// checkRedirect は http.Client.CheckRedirect に設定するリダイレクトの検証。
+// リダイレクト先は http と https のみ許可する。file:// などを許可すると、悪意のあるサーバーが
+// Location: file:///etc/shadow のようなリダイレクトでローカルのファイルを読ませ、ダウンロード先に書き出させることができる。
+// 同様に s3:// や gs:// へのリダイレクトを許可すると、実行環境のクラウドの認証情報で
+// 任意のバケットのオブジェクトを読ませることができてしまう。
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return errors.New("stopped after 10 redirects")
+	}
+	switch req.URL.Scheme {
+	case "http", "https":
+		return nil
+	default:
+		return fmt.Errorf("refusing to follow redirect from %s to %s URL", via[0].URL.Redacted(), req.URL.Scheme)
+	}
+}
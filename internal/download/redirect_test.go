@@ -0,0 +1,107 @@
+package download
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hrko/dltofu/internal/hash"
+	"github.com/hrko/dltofu/internal/model"
+)
+
+func TestCheckRedirect(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		via     int
+		wantErr bool
+	}{
+		{name: "http", target: "http://example.com/a", via: 1},
+		{name: "https", target: "https://example.com/a", via: 1},
+		{name: "file", target: "file:///etc/passwd", via: 1, wantErr: true},
+		{name: "s3", target: "s3://bucket/key", via: 1, wantErr: true},
+		{name: "gs", target: "gs://bucket/object", via: 1, wantErr: true},
+		{name: "too many redirects", target: "https://example.com/a", via: maxRedirects, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newRequest(t, tt.target)
+			via := make([]*http.Request, tt.via)
+			for i := range via {
+				via[i] = newRequest(t, "https://example.com/start")
+			}
+			err := checkRedirect(req, via)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkRedirect(%s) error = %v, wantErr %v", tt.target, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRedirectToFileIsRejected(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "secret")
+	content := []byte("secret content")
+	if err := os.WriteFile(secret, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	fileURL := (&url.URL{Scheme: "file", Path: filepath.ToSlash(secret)}).String()
+	if !strings.HasPrefix(fileURL, "file:///") {
+		fileURL = "file:///" + strings.TrimPrefix(fileURL, "file://")
+	}
+	srv := httptest.NewServer(http.RedirectHandler(fileURL, http.StatusFound))
+	defer srv.Close()
+
+	expected, err := hash.CalculateStream(bytes.NewReader(content), hash.AlgoSHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(dir, "dest")
+	d := NewDownloader(0, nil)
+	if err := d.FetchToFileWithHashCheck(model.ResolvedURL(srv.URL+"/file"), dest, expected); err == nil {
+		t.Fatal("FetchToFileWithHashCheck succeeded, want error for redirect to file://")
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Errorf("destination exists after rejected redirect (stat error: %v)", err)
+	}
+}
+
+func TestRedirectToObjectStoreIsRejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		location string
+	}{
+		{name: "s3", location: "s3://private-bucket/secret"},
+		{name: "gs", location: "gs://private-bucket/secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.RedirectHandler(tt.location, http.StatusFound))
+			defer srv.Close()
+
+			d := NewDownloader(0, nil)
+			_, err := d.FetchAndHash(model.ResolvedURL(srv.URL+"/file"), hash.AlgoSHA256, io.Discard)
+			if err == nil {
+				t.Fatalf("FetchAndHash succeeded, want error for redirect to %s", tt.location)
+			}
+			if !strings.Contains(err.Error(), "refusing to follow redirect") {
+				t.Errorf("FetchAndHash error = %v, want redirect refusal", err)
+			}
+		})
+	}
+}
+
+func newRequest(t *testing.T, rawURL string) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
@@ -0,0 +1,97 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/hrko/dltofu/internal/model"
+)
+
+// urlResolverTimeout は url_resolver のコマンド1回の実行時間の上限
+const urlResolverTimeout = 1 * time.Minute
+
+// URLResolver は外部コマンドを実行して、論理的なURL (設定ファイルや Lock ファイルのURL) を
+// 実際に取得するURL (署名付きURLなど) に変換する。
+//
+// コマンドはシェルを介さずに直接実行され、各引数は {{.URL}} で論理的なURLを参照できるテンプレートとして展開される
+// (URL は環境変数 DLTOFU_URL にも設定される)。コマンドは標準出力に変換後のURLを1行で出力する。
+// コマンドは dltofu と同じ権限と環境変数で実行されるため、url_resolver を含む設定ファイルは
+// スクリプトと同様に信頼できるものだけを使う必要がある。
+// 変換後のURLは認証情報を含みうるため、ログやエラーメッセージには出力せず、Lock ファイルにも記録しない。
+// 取得した内容は論理的なURLのハッシュ値で検証されるため、コマンドが別の内容を指すURLを返しても検証で失敗する。
+type URLResolver struct {
+	command string
+	args    []*template.Template
+}
+
+// urlResolverData は url_resolver の引数のテンプレートに渡されるデータ
+type urlResolverData struct {
+	URL string
+}
+
+// NewURLResolver は command (コマンドと引数) を実行する URLResolver を作成する。
+// 引数のテンプレートの構文が正しくない場合はエラーを返す。
+func NewURLResolver(command []string) (*URLResolver, error) {
+	if len(command) == 0 || command[0] == "" {
+		return nil, fmt.Errorf("url_resolver command is empty")
+	}
+	r := &URLResolver{command: command[0]}
+	for i, arg := range command[1:] {
+		tmpl, err := template.New("url_resolver").Option("missingkey=error").Parse(arg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse url_resolver argument %d: %w", i+1, err)
+		}
+		r.args = append(r.args, tmpl)
+	}
+	return r, nil
+}
+
+// Resolve はコマンドを実行して url を取得するURLに変換する
+func (r *URLResolver) Resolve(ctx context.Context, url model.ResolvedURL) (model.ResolvedURL, error) {
+	data := urlResolverData{URL: string(url)}
+	args := make([]string, 0, len(r.args))
+	for i, tmpl := range r.args {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return "", fmt.Errorf("failed to expand url_resolver argument %d for %s: %w", i+1, url, err)
+		}
+		args = append(args, buf.String())
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, urlResolverTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, r.command, args...)
+	cmd.Env = append(os.Environ(), "DLTOFU_URL="+string(url))
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("url_resolver failed for %s: %w: %s", url, err, msg)
+		}
+		return "", fmt.Errorf("url_resolver failed for %s: %w", url, err)
+	}
+
+	resolved := model.ResolvedURL(strings.TrimSpace(stdout.String()))
+	if resolved == "" || strings.ContainsAny(string(resolved), "\r\n") {
+		return "", fmt.Errorf("url_resolver for %s must print exactly one URL", url)
+	}
+	if err := resolved.Validate(); err != nil {
+		// 変換後のURLは認証情報を含みうるためエラーメッセージに含めない
+		return "", fmt.Errorf("url_resolver for %s printed an invalid URL", url)
+	}
+	return resolved, nil
+}
+
+// WithURLResolver は各URLへのリクエストの直前に r で取得するURLを変換する (代替URLやリトライを含む)。
+// Lock ファイルのキー、ハッシュ値の検証、ログには変換前のURLを使う。
+func WithURLResolver(r *URLResolver) Option {
+	return func(d *Downloader) {
+		d.resolver = r
+	}
+}
@@ -0,0 +1,102 @@
+package download
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/hrko/dltofu/internal/model"
+)
+
+// resumeLayer は受信が途中で失敗したレスポンスボディを Range リクエストで続きから取得し直すレイヤー。
+// 再開は1回の転送につき最大 maxResumes 回まで行う。
+func resumeLayer(maxResumes int, logger *slog.Logger) layer {
+	return func(r io.Reader, t *transfer) io.Reader {
+		if t.reopen == nil {
+			return r
+		}
+		return &resumeReader{r: r, t: t, remaining: maxResumes, logger: logger}
+	}
+}
+
+// resumeReader は読み込みエラーの時点のオフセットから t.reopen で取得し直して読み込みを続ける io.Reader
+type resumeReader struct {
+	r         io.Reader
+	t         *transfer
+	offset    int64 // これまでに読み込んだバイト数
+	remaining int   // 残りの再開回数
+	logger    *slog.Logger
+}
+
+func (rr *resumeReader) Read(p []byte) (int, error) {
+	for {
+		n, err := rr.r.Read(p)
+		rr.offset += int64(n)
+		if err == nil || err == io.EOF || rr.remaining <= 0 {
+			return n, err
+		}
+		if n > 0 {
+			// 読み込めた分を先に返し、次の Read でエラーを再び受け取ってから再開する
+			rr.r = &errAfterReader{err: err}
+			return n, nil
+		}
+		rr.remaining--
+		rr.logger.Warn("Download interrupted, resuming", "url", rr.t.url, "offset", rr.offset, "error", err)
+		body, rerr := rr.t.reopen(rr.offset)
+		if rerr != nil {
+			return 0, fmt.Errorf("%w (resume failed: %v)", err, rerr)
+		}
+		rr.r = body
+	}
+}
+
+// errAfterReader は常に err を返す io.Reader
+type errAfterReader struct{ err error }
+
+func (e *errAfterReader) Read([]byte) (int, error) { return 0, e.err }
+
+// resumable は resp のボディを Range リクエストで続きから取得できる場合に true を返す。
+// 取得し直した内容が同じであることを If-Range で保証するため、強い ETag か Last-Modified が必要。
+func resumable(resp *http.Response) bool {
+	return resp.Header.Get("Accept-Ranges") == "bytes" && ifRange(resp.Header) != ""
+}
+
+// ifRange は If-Range ヘッダに使う値 (強い ETag、なければ Last-Modified) を返す
+func ifRange(header http.Header) string {
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return header.Get("Last-Modified")
+}
+
+// reopenFunc は url を offset バイト目から取得し直す transfer.reopen を返す。
+// ホストごとの同時接続数の枠を解放するため、読み込み中のボディ (t.body) は取得し直す前に閉じる。
+// 取得し直したボディは t.body に設定され、fetchAndHashDirect が最後に閉じる。
+func (d *Downloader) reopenFunc(url model.ResolvedURL, header http.Header, validator string, t *transfer) func(offset int64) (io.Reader, error) {
+	return func(offset int64) (io.Reader, error) {
+		t.body.Close()
+		h := header.Clone()
+		if h == nil {
+			h = make(http.Header)
+		}
+		h.Del("If-None-Match")
+		h.Del("If-Modified-Since")
+		h.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		h.Set("If-Range", validator)
+		resp, err := d.open(url, h)
+		if err != nil {
+			return nil, err
+		}
+		t.body = resp.Body
+		if resp.StatusCode != http.StatusPartialContent {
+			return nil, errors.New("server did not honor the range request (content may have changed)")
+		}
+		if cr := resp.Header.Get("Content-Range"); !strings.HasPrefix(cr, fmt.Sprintf("bytes %d-", offset)) {
+			return nil, fmt.Errorf("unexpected Content-Range %q for offset %d", cr, offset)
+		}
+		return resp.Body, nil
+	}
+}
@@ -0,0 +1,149 @@
+package download
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hrko/dltofu/internal/hash"
+	"github.com/hrko/dltofu/internal/model"
+	"github.com/hrko/dltofu/internal/progress"
+)
+
+// countingReporter は通知されたバイト数と Done の回数を数える progress.Reporter
+type countingReporter struct {
+	mu    sync.Mutex
+	bytes int64
+	done  int
+}
+
+func (c *countingReporter) Start(name string, total int64) progress.Tracker { return c }
+
+func (c *countingReporter) Add(n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.bytes += n
+}
+
+func (c *countingReporter) Done() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.done++
+}
+
+func TestResumeInterruptedDownload(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 8192) // 128 KiB
+	half := len(content) / 2
+	contentHash, err := hash.CalculateStream(bytes.NewReader(content), hash.AlgoSHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		etag          string // 空なら ETag を返さない
+		lastModified  bool
+		noRanges      bool   // Accept-Ranges を返さない
+		rangeETag     string // Range リクエストに返す ETag (空なら etag と同じ)
+		rangeAborts   int    // Range リクエストのうち途中で切断する回数
+		retries       int
+		options       []Option
+		wantErr       bool
+		wantRequests  int32
+		wantLastRange string
+	}{
+		{name: "resume with strong etag", etag: `"v1"`, retries: 1, wantRequests: 2, wantLastRange: "bytes=65536-"},
+		{name: "resume with last-modified", lastModified: true, retries: 1, wantRequests: 2, wantLastRange: "bytes=65536-"},
+		{name: "resume twice", etag: `"v1"`, rangeAborts: 1, retries: 2, wantRequests: 3, wantLastRange: "bytes=98304-"},
+		{name: "resume with rate limit and progress", etag: `"v1"`, retries: 1, wantRequests: 2, wantLastRange: "bytes=65536-",
+			options: []Option{WithMaxBandwidth(64 << 20), WithConcurrencyPerHost(1)}},
+		{name: "resumes exhausted", etag: `"v1"`, rangeAborts: 1, retries: 1, wantErr: true, wantRequests: 2},
+		{name: "no retries", etag: `"v1"`, retries: 0, wantErr: true, wantRequests: 1},
+		{name: "weak etag is not resumable", etag: `W/"v1"`, retries: 1, wantErr: true, wantRequests: 1},
+		{name: "no accept-ranges", etag: `"v1"`, noRanges: true, retries: 1, wantErr: true, wantRequests: 1},
+		{name: "content changed", etag: `"v1"`, rangeETag: `"v2"`, retries: 1, wantErr: true, wantRequests: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests, rangeAborts atomic.Int32
+			var lastRange atomic.Value
+			lastRange.Store("")
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				etag := tt.etag
+				if r.Header.Get("Range") != "" {
+					lastRange.Store(r.Header.Get("Range"))
+					if tt.rangeETag != "" {
+						etag = tt.rangeETag
+					}
+				}
+				if etag != "" {
+					w.Header().Set("ETag", etag)
+				}
+				if tt.noRanges {
+					w.Header().Set("Accept-Ranges", "none")
+				}
+				if tt.lastModified {
+					w.Header().Set("Last-Modified", modTime.Format(http.TimeFormat))
+				}
+				if r.Header.Get("Range") == "" || int(rangeAborts.Add(1)) <= tt.rangeAborts {
+					// 残りの半分だけ送って接続を切る
+					if rng := r.Header.Get("Range"); rng != "" {
+						start, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rng, "bytes="), "-"))
+						if err != nil {
+							t.Errorf("unexpected Range header %q", rng)
+							return
+						}
+						w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(content)-1, len(content)))
+						w.Header().Set("Content-Length", strconv.Itoa(len(content)-start))
+						w.WriteHeader(http.StatusPartialContent)
+						w.Write(content[start : start+(len(content)-start)/2])
+					} else {
+						if !tt.noRanges {
+							w.Header().Set("Accept-Ranges", "bytes")
+						}
+						w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+						w.WriteHeader(http.StatusOK)
+						w.Write(content[:half])
+					}
+					w.(http.Flusher).Flush()
+					panic(http.ErrAbortHandler)
+				}
+				http.ServeContent(w, r, "", modTime, bytes.NewReader(content))
+			}))
+			defer srv.Close()
+
+			reporter := &countingReporter{}
+			opts := append([]Option{WithRetry(tt.retries, time.Millisecond), WithProgress(reporter)}, tt.options...)
+			d := NewDownloader(0, nil, opts...)
+			var buf bytes.Buffer
+			got, err := d.FetchAndHash(model.ResolvedURL(srv.URL), hash.AlgoSHA256, &buf)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FetchAndHash() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if n := requests.Load(); n != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", n, tt.wantRequests)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !got.Equal(contentHash) || !bytes.Equal(buf.Bytes(), content) {
+				t.Errorf("FetchAndHash() = %v (%d bytes), want %v (%d bytes)", got, buf.Len(), contentHash, len(content))
+			}
+			if r := lastRange.Load().(string); r != tt.wantLastRange {
+				t.Errorf("last Range header = %q, want %q", r, tt.wantLastRange)
+			}
+			if reporter.bytes != int64(len(content)) || reporter.done != 1 {
+				t.Errorf("progress = %d bytes, %d done; want %d bytes, 1 done", reporter.bytes, reporter.done, len(content))
+			}
+		})
+	}
+}
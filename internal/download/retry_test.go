@@ -0,0 +1,234 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hrko/dltofu/internal/hash"
+	"github.com/hrko/dltofu/internal/model"
+)
+
+func TestRetryWait(t *testing.T) {
+	d := &Downloader{backoff: time.Second}
+	tests := []struct {
+		name       string
+		attempt    int
+		retryAfter time.Duration
+		want       time.Duration
+	}{
+		{name: "first backoff", attempt: 0, want: time.Second},
+		{name: "doubled backoff", attempt: 2, want: 4 * time.Second},
+		{name: "backoff capped", attempt: 10, want: maxRetryBackoff},
+		{name: "backoff overflow", attempt: 100, want: maxRetryBackoff},
+		{name: "retry-after honored", attempt: 0, retryAfter: 5 * time.Second, want: 5 * time.Second},
+		{name: "retry-after capped", attempt: 0, retryAfter: time.Hour, want: maxRetryBackoff},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := d.retryWait(tt.attempt, tt.retryAfter); got != tt.want {
+				t.Errorf("retryWait(%d, %s) = %s, want %s", tt.attempt, tt.retryAfter, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryRespectsContext(t *testing.T) {
+	tests := []struct {
+		name         string
+		retryAfter   string
+		ctx          func() (context.Context, context.CancelFunc)
+		wantErr      bool
+		wantRequests int32
+	}{
+		{
+			name:         "retries without deadline",
+			retryAfter:   "0",
+			ctx:          func() (context.Context, context.CancelFunc) { return context.WithCancel(context.Background()) },
+			wantRequests: 2,
+		},
+		{
+			name:       "retry-after beyond deadline",
+			retryAfter: "3600",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 2*time.Second)
+			},
+			wantErr:      true,
+			wantRequests: 1,
+		},
+		{
+			name:       "canceled while waiting",
+			retryAfter: "1",
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(50*time.Millisecond, cancel)
+				return ctx, cancel
+			},
+			wantErr:      true,
+			wantRequests: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if requests.Add(1) == 1 {
+					w.Header().Set("Retry-After", tt.retryAfter)
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.Write([]byte("ok"))
+			}))
+			defer srv.Close()
+
+			ctx, cancel := tt.ctx()
+			defer cancel()
+			d := NewDownloader(0, nil, WithRetry(3, time.Millisecond), WithContext(ctx))
+			start := time.Now()
+			var buf bytes.Buffer
+			_, err := d.FetchAndHash(model.ResolvedURL(srv.URL), hash.AlgoSHA256, &buf)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FetchAndHash() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("FetchAndHash() took %s, want it to give up without waiting", elapsed)
+			}
+			if n := requests.Load(); n != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", n, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestIsTransientNetworkError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "unexpected EOF", err: &url.Error{Op: "Get", URL: "https://example.com", Err: io.ErrUnexpectedEOF}, want: true},
+		{name: "connection reset", err: &url.Error{Op: "Get", URL: "https://example.com", Err: &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}}, want: true},
+		{name: "connection refused", err: &url.Error{Op: "Get", URL: "https://example.com", Err: &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}}, want: true},
+		{name: "timeout", err: &url.Error{Op: "Get", URL: "https://example.com", Err: &net.OpError{Op: "dial", Err: os.ErrDeadlineExceeded}}, want: true},
+		{name: "temporary DNS failure", err: &url.Error{Op: "Get", URL: "https://example.com", Err: &net.DNSError{Err: "server misbehaving", Name: "example.com", IsTemporary: true}}, want: true},
+		{name: "unknown host", err: &url.Error{Op: "Get", URL: "https://example.com", Err: &net.DNSError{Err: "no such host", Name: "example.com", IsNotFound: true}}},
+		{name: "redirect rejected", err: &url.Error{Op: "Get", URL: "https://example.com", Err: errors.New("refusing to follow redirect from https://example.com to file URL")}},
+		{name: "certificate", err: &url.Error{Op: "Get", URL: "https://example.com", Err: &tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}}}},
+		{name: "unsupported scheme", err: &url.Error{Op: "Get", URL: "ftp://example.com", Err: errors.New(`unsupported protocol scheme "ftp"`)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransientNetworkError(fmt.Errorf("failed to download: %w", tt.err)); got != tt.want {
+				t.Errorf("isTransientNetworkError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryOnlyTransientErrors(t *testing.T) {
+	// dropOnce は最初のリクエストだけレスポンスを返さずに接続を切る
+	dropOnce := func(requests *atomic.Int32) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if requests.Load() == 1 {
+				conn, _, err := w.(http.Hijacker).Hijack()
+				if err == nil {
+					conn.Close()
+				}
+				return
+			}
+			w.Write([]byte("ok"))
+		})
+	}
+	tests := []struct {
+		name         string
+		tls          bool
+		handler      func(requests *atomic.Int32) http.Handler
+		wantErr      bool
+		wantRequests int32
+	}{
+		{
+			name:         "connection dropped",
+			handler:      dropOnce,
+			wantRequests: 2,
+		},
+		{
+			name: "redirect to file",
+			handler: func(*atomic.Int32) http.Handler {
+				return http.RedirectHandler("file:///etc/passwd", http.StatusFound)
+			},
+			wantErr:      true,
+			wantRequests: 1,
+		},
+		{
+			name: "redirect to object store",
+			handler: func(*atomic.Int32) http.Handler {
+				return http.RedirectHandler("s3://bucket/key", http.StatusFound)
+			},
+			wantErr:      true,
+			wantRequests: 1,
+		},
+		{
+			name: "untrusted certificate",
+			tls:  true,
+			handler: func(*atomic.Int32) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) })
+			},
+			wantErr:      true,
+			wantRequests: 0, // TLS ハンドシェイクで失敗するためハンドラーまで届かない
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			handler := tt.handler(&requests)
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				handler.ServeHTTP(w, r)
+			}))
+			var connections atomic.Int32
+			srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+				if state == http.StateNew {
+					connections.Add(1)
+				}
+			}
+			if tt.tls {
+				srv.Config.ErrorLog = log.New(io.Discard, "", 0) // ハンドシェイクの失敗をログに出さない
+				srv.StartTLS()
+			} else {
+				srv.Start()
+			}
+			defer srv.Close()
+
+			d := NewDownloader(0, nil, WithRetry(3, time.Millisecond))
+			var buf bytes.Buffer
+			_, err := d.FetchAndHash(model.ResolvedURL(srv.URL), hash.AlgoSHA256, &buf)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FetchAndHash() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && strings.Contains(err.Error(), "gave up after") {
+				t.Errorf("FetchAndHash() error = %v, want it to fail without retrying", err)
+			}
+			if n := requests.Load(); n != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", n, tt.wantRequests)
+			}
+			if n := connections.Load(); n > max(tt.wantRequests, 1) {
+				t.Errorf("connections = %d, want the failure not to be retried", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultStallTimeout はデータを1バイトも受信できない状態が続いた場合にダウンロードを中断するまでの時間のデフォルト値
+const DefaultStallTimeout = 2 * time.Minute
+
+// WithStallTimeout はレスポンスボディの受信が timeout の間止まった場合にダウンロードを中断する。
+// 転送全体の時間は制限しないため、遅い回線でも受信が続いている限り大きなファイルをダウンロードできる。
+// timeout が 0 以下の場合は中断しない。
+func WithStallTimeout(timeout time.Duration) Option {
+	return func(d *Downloader) {
+		d.stallTimeout = max(timeout, 0)
+	}
+}
+
+// stallReader はデータを受信するたびにタイマーをリセットし、timeout の間受信がない場合は cancel でリクエストを中断する io.ReadCloser
+type stallReader struct {
+	io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	stalled atomic.Bool
+}
+
+// newStallReader は body の受信の停止を監視する。監視はレスポンスヘッダの受信直後から始まる。
+func newStallReader(body io.ReadCloser, timeout time.Duration, cancel context.CancelFunc) *stallReader {
+	r := &stallReader{ReadCloser: body, timeout: timeout}
+	r.timer = time.AfterFunc(timeout, func() {
+		r.stalled.Store(true)
+		cancel()
+	})
+	return r
+}
+
+func (r *stallReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.timer.Reset(r.timeout)
+	}
+	if err != nil && err != io.EOF && r.stalled.Load() {
+		return n, fmt.Errorf("download stalled: no data received for %s", r.timeout)
+	}
+	return n, err
+}
+
+func (r *stallReader) Close() error {
+	r.timer.Stop()
+	return r.ReadCloser.Close()
+}
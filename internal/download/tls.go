@@ -0,0 +1,70 @@
+package download
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// TLSOptions は HTTPS 接続の TLS 設定
+type TLSOptions struct {
+	CACertFile         string // 追加で信頼する CA 証明書 (PEM 形式、複数可) のファイル。システムの CA に加えて使う
+	ClientCertFile     string // クライアント証明書 (PEM 形式) のファイル
+	ClientKeyFile      string // クライアント証明書の秘密鍵 (PEM 形式) のファイル
+	InsecureSkipVerify bool   // サーバー証明書を検証しない (開発環境用)
+}
+
+// IsZero は TLS 設定が何も指定されていない場合に true を返す
+func (o TLSOptions) IsZero() bool {
+	return o == TLSOptions{}
+}
+
+// LoadTLSConfig は opts の証明書を読み込み、tls.Config を返す
+func LoadTLSConfig(opts TLSOptions) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+	}
+
+	if opts.CACertFile != "" {
+		pem, err := os.ReadFile(opts.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate %s: %w", opts.CACertFile, err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			// システムの CA を読み込めない環境では指定された CA のみを信頼する
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in CA certificate %s", opts.CACertFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if (opts.ClientCertFile == "") != (opts.ClientKeyFile == "") {
+		return nil, fmt.Errorf("client certificate and client key must be specified together")
+	}
+	if opts.ClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.ClientCertFile, opts.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate %s: %w", opts.ClientCertFile, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// WithTLSConfig は HTTPS 接続に tlsConfig を使う。tlsConfig が nil の場合は何もしない。
+func WithTLSConfig(tlsConfig *tls.Config) Option {
+	return func(d *Downloader) {
+		if tlsConfig == nil {
+			return
+		}
+		if tlsConfig.InsecureSkipVerify {
+			d.logger.Warn("TLS certificate verification is DISABLED; connections are vulnerable to man-in-the-middle attacks. Do not use this outside development environments")
+		}
+		d.transport().TLSClientConfig = tlsConfig
+	}
+}
@@ -0,0 +1,85 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultServerURL は GitHub のリリースアセットをダウンロードするサーバーのURL
+const DefaultServerURL = "https://github.com"
+
+// APIBaseURL は GitHub API のURL
+var APIBaseURL = "https://api.github.com"
+
+// requestTimeout は GitHub API へのリクエストのタイムアウト
+const requestTimeout = 30 * time.Second
+
+// ReleaseAssetURL はリリースアセットのダウンロードURLを返す。
+// tag と asset はテンプレートのまま渡してよい (URL テンプレートとして後で展開される)。
+func ReleaseAssetURL(repo, tag, asset string) string {
+	return DefaultServerURL + "/" + repo + "/releases/download/" + tag + "/" + asset
+}
+
+// ValidateRepo は repo が "owner/name" 形式であることを検証する
+func ValidateRepo(repo string) error {
+	owner, name, ok := strings.Cut(repo, "/")
+	if !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+		return fmt.Errorf("invalid GitHub repository '%s', expected 'owner/name'", repo)
+	}
+	return nil
+}
+
+// ReleaseTag は url が repo のリリースアセットのダウンロードURL (ReleaseAssetURL の形式) であればそのタグ名を返す
+func ReleaseTag(repo, url string) (string, bool) {
+	rest, ok := strings.CutPrefix(url, DefaultServerURL+"/"+repo+"/releases/download/")
+	if !ok {
+		return "", false
+	}
+	tag, asset, ok := strings.Cut(rest, "/")
+	if !ok || tag == "" || asset == "" {
+		return "", false
+	}
+	return tag, true
+}
+
+// LatestReleaseTag は GitHub API で repo の最新リリース (プレリリースとドラフトを除く) のタグ名を取得する。
+// client にはダウンロードと同じプロキシや TLS の設定を適用したものを渡す。
+// 環境変数 GITHUB_TOKEN が設定されている場合は認証に使う (レート制限の緩和のため)。
+func LatestReleaseTag(client *http.Client, repo string) (string, error) {
+	url := APIBaseURL + "/repos/" + repo + "/releases/latest"
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request for %s: %w", url, err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to query latest release of %s: %w", repo, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to query latest release of %s: received status code %d", repo, resp.StatusCode)
+	}
+
+	var release struct {
+		TagName string `json:"tag_name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return "", fmt.Errorf("failed to decode latest release of %s: %w", repo, err)
+	}
+	if release.TagName == "" {
+		return "", fmt.Errorf("latest release of %s has no tag", repo)
+	}
+	return release.TagName, nil
+}
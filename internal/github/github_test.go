@@ -0,0 +1,84 @@
+package github
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReleaseTag(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		wantTag string
+		wantOK  bool
+	}{
+		{name: "asset", url: "https://github.com/owner/tool/releases/download/v1.2.3/tool.tar.gz", wantTag: "v1.2.3", wantOK: true},
+		{name: "asset in subdirectory", url: "https://github.com/owner/tool/releases/download/v1.2.3/dir/tool.tar.gz", wantTag: "v1.2.3", wantOK: true},
+		{name: "other repository", url: "https://github.com/owner/other/releases/download/v1.2.3/tool.tar.gz"},
+		{name: "repository prefix", url: "https://github.com/owner/tool2/releases/download/v1.2.3/tool.tar.gz"},
+		{name: "no asset", url: "https://github.com/owner/tool/releases/download/v1.2.3/"},
+		{name: "not a release", url: "https://example.com/tool.tar.gz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tag, ok := ReleaseTag("owner/tool", tt.url)
+			if tag != tt.wantTag || ok != tt.wantOK {
+				t.Errorf("ReleaseTag() = (%q, %v), want (%q, %v)", tag, ok, tt.wantTag, tt.wantOK)
+			}
+		})
+	}
+}
+
+// countingTransport はリクエスト数を数える http.RoundTripper
+type countingTransport struct {
+	next  http.RoundTripper
+	count int
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.count++
+	return t.next.RoundTrip(req)
+}
+
+func TestLatestReleaseTag(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantTag string
+		wantErr bool
+	}{
+		{name: "ok", status: http.StatusOK, body: `{"tag_name": "v1.2.3"}`, wantTag: "v1.2.3"},
+		{name: "not found", status: http.StatusNotFound, body: `{}`, wantErr: true},
+		{name: "no tag", status: http.StatusOK, body: `{}`, wantErr: true},
+		{name: "invalid JSON", status: http.StatusOK, body: `{`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/repos/owner/tool/releases/latest" {
+					t.Errorf("unexpected request path %s", r.URL.Path)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+			defer func(orig string) { APIBaseURL = orig }(APIBaseURL)
+			APIBaseURL = srv.URL
+
+			// 渡した client (ダウンロードと同じ設定のもの) で問い合わせること
+			transport := &countingTransport{next: http.DefaultTransport}
+			tag, err := LatestReleaseTag(&http.Client{Transport: transport}, "owner/tool")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LatestReleaseTag() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tag != tt.wantTag {
+				t.Errorf("LatestReleaseTag() = %q, want %q", tag, tt.wantTag)
+			}
+			if transport.count != 1 {
+				t.Errorf("requests through the given client = %d, want 1", transport.count)
+			}
+		})
+	}
+}
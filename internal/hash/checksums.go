@@ -0,0 +1,123 @@
+package hash
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// bsdChecksumLine は BSD 形式 (sha256sum --tag など) の行 "SHA256 (name) = hex"
+var bsdChecksumLine = regexp.MustCompile(`^([A-Za-z0-9-]+) ?\((.+)\) ?= ?([0-9a-fA-F]+)$`)
+
+// checksum はチェックサムファイルの1行分のハッシュ値
+type checksum struct {
+	tag   string // BSD 形式のアルゴリズム名 (GNU 形式の場合は空)
+	value []byte
+}
+
+// Checksums はリリースと一緒に公開されるチェックサムファイル (SHA256SUMS など) の内容
+type Checksums struct {
+	entries map[string]checksum // key: ファイル名 (先頭の "./" は除く)
+}
+
+// ParseChecksums はチェックサムファイルを読み込む。
+// GNU 形式 ("<hex>  <name>"、バイナリモードの "<hex> *<name>") と BSD 形式 ("SHA256 (<name>) = <hex>") の行を解釈し、
+// 空行、コメント、PGP のクリア署名のヘッダなど解釈できない行は無視する。
+func ParseChecksums(r io.Reader) (*Checksums, error) {
+	c := &Checksums{entries: make(map[string]checksum)}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, sum, ok := parseChecksumLine(line)
+		if !ok {
+			continue
+		}
+		c.entries[strings.TrimPrefix(name, "./")] = sum
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read checksums: %w", err)
+	}
+	if len(c.entries) == 0 {
+		return nil, fmt.Errorf("no checksum lines found")
+	}
+	return c, nil
+}
+
+// parseChecksumLine は1行を GNU 形式または BSD 形式として解釈する
+func parseChecksumLine(line string) (name string, sum checksum, ok bool) {
+	if m := bsdChecksumLine.FindStringSubmatch(line); m != nil {
+		value, err := hex.DecodeString(m[3])
+		if err != nil {
+			return "", checksum{}, false
+		}
+		return m[2], checksum{tag: m[1], value: value}, true
+	}
+
+	// GNU 形式。ファイル名に改行やバックスラッシュを含む場合は行頭に "\" が付き、名前がエスケープされる
+	escaped := strings.HasPrefix(line, "\\")
+	line = strings.TrimPrefix(line, "\\")
+	hexValue, rest, found := strings.Cut(line, " ")
+	if !found || rest == "" {
+		return "", checksum{}, false
+	}
+	value, err := hex.DecodeString(hexValue)
+	if err != nil {
+		return "", checksum{}, false
+	}
+	// 区切りの2文字目はテキストモードなら " "、バイナリモードなら "*"
+	name = strings.TrimPrefix(strings.TrimPrefix(rest, " "), "*")
+	if escaped {
+		name = strings.NewReplacer(`\\`, `\`, `\n`, "\n").Replace(name)
+	}
+	if name == "" {
+		return "", checksum{}, false
+	}
+	return name, checksum{value: value}, true
+}
+
+// Lookup はファイル名 filename のハッシュ値を algorithm のハッシュ値として返す。
+// 完全に一致する名前がない場合は、ディレクトリを除いた名前が一致する唯一の行を使う。
+// BSD 形式でアルゴリズム名が異なる場合や、ハッシュ値の長さが algorithm と一致しない場合はエラーを返す。
+func (c *Checksums) Lookup(filename string, algorithm HashAlgorithm) (*Hash, error) {
+	sum, ok := c.entries[filename]
+	if !ok {
+		var matches []string
+		for name := range c.entries {
+			if path.Base(name) == filename {
+				matches = append(matches, name)
+			}
+		}
+		switch len(matches) {
+		case 0:
+			return nil, fmt.Errorf("no checksum found for %s", filename)
+		case 1:
+			sum = c.entries[matches[0]]
+		default:
+			return nil, fmt.Errorf("multiple checksums found for %s: %s", filename, strings.Join(matches, ", "))
+		}
+	}
+
+	if sum.tag != "" && normalizeChecksumTag(sum.tag) != string(algorithm) {
+		return nil, fmt.Errorf("checksum for %s is %s, but hash algorithm is %s", filename, sum.tag, algorithm)
+	}
+	hasher, err := GetHasher(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if len(sum.value) != hasher.Size() {
+		return nil, fmt.Errorf("checksum for %s is not a %s hash (%d bytes, expected %d)", filename, algorithm, len(sum.value), hasher.Size())
+	}
+	return NewHash(algorithm, sum.value), nil
+}
+
+// normalizeChecksumTag は BSD 形式のアルゴリズム名 (SHA256, SHA-256, BLAKE2b など) を HashAlgorithm の表記に揃える
+func normalizeChecksumTag(tag string) string {
+	return strings.ToLower(strings.ReplaceAll(tag, "-", ""))
+}
@@ -0,0 +1,124 @@
+package hash
+
+import (
+	"fmt"
+	"hash"
+	"io"
+)
+
+// ChunkHashes はファイルを固定長のチャンクに分割して計算したハッシュ値の一覧。
+// 巨大なファイルの破損箇所をチャンク単位で特定するために使う。
+type ChunkHashes struct {
+	ChunkSize int64   `json:"chunk_size"`
+	Root      *Hash   `json:"root"`   // 各チャンクのハッシュ値を連結した内容のハッシュ値
+	Chunks    []*Hash `json:"chunks"` // 先頭からの各チャンクのハッシュ値 (最後のチャンクは短い場合がある)
+}
+
+// Copy は ChunkHashes のコピーを作成する
+func (c *ChunkHashes) Copy() *ChunkHashes {
+	chunks := make([]*Hash, len(c.Chunks))
+	for i, h := range c.Chunks {
+		chunks[i] = h.Copy()
+	}
+	return &ChunkHashes{ChunkSize: c.ChunkSize, Root: c.Root.Copy(), Chunks: chunks}
+}
+
+// Equal はチャンクサイズと全チャンクのハッシュ値が一致する場合に true を返す
+func (c *ChunkHashes) Equal(other *ChunkHashes) bool {
+	return c.ChunkSize == other.ChunkSize && c.Root.Equal(other.Root) && len(c.Chunks) == len(other.Chunks)
+}
+
+// Diverged は expected と比較してハッシュ値が異なるチャンクのインデックスを返す。
+// チャンク数が異なる場合、片方にしか存在しないチャンクも異なるものとして扱う。
+func (c *ChunkHashes) Diverged(expected *ChunkHashes) []int {
+	var diverged []int
+	n := max(len(c.Chunks), len(expected.Chunks))
+	for i := range n {
+		if i >= len(c.Chunks) || i >= len(expected.Chunks) || !c.Chunks[i].Equal(expected.Chunks[i]) {
+			diverged = append(diverged, i)
+		}
+	}
+	return diverged
+}
+
+// ChunkHasher は書き込まれた内容をチャンクごとにハッシュする io.Writer。
+// ダウンロード中のストリームに対して io.MultiWriter などで組み合わせて使う。
+type ChunkHasher struct {
+	algorithm HashAlgorithm
+	chunkSize int64
+	current   hash.Hash
+	written   int64 // 現在のチャンクに書き込まれたバイト数
+	chunks    []*Hash
+	onChunk   func(index int, h *Hash)
+}
+
+// NewChunkHasher は ChunkHasher を作成する。
+// onChunk が nil でなければ、チャンクのハッシュ値が確定するたびに呼び出される (途中経過の報告用)。
+func NewChunkHasher(algorithm HashAlgorithm, chunkSize int64, onChunk func(index int, h *Hash)) (*ChunkHasher, error) {
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("invalid chunk size: %d", chunkSize)
+	}
+	current, err := GetHasher(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return &ChunkHasher{algorithm: algorithm, chunkSize: chunkSize, current: current, onChunk: onChunk}, nil
+}
+
+// Write は io.Writer を実装する
+func (c *ChunkHasher) Write(p []byte) (int, error) {
+	total := len(p)
+	for len(p) > 0 {
+		n := min(int64(len(p)), c.chunkSize-c.written)
+		c.current.Write(p[:n]) // hash.Hash の Write はエラーを返さない
+		c.written += n
+		p = p[n:]
+		if c.written == c.chunkSize {
+			c.finishChunk()
+		}
+	}
+	return total, nil
+}
+
+// finishChunk は現在のチャンクのハッシュ値を確定し、次のチャンクの計算を開始する
+func (c *ChunkHasher) finishChunk() {
+	h := &Hash{Algorithm: c.algorithm, HashValue: c.current.Sum(nil)}
+	c.chunks = append(c.chunks, h)
+	if c.onChunk != nil {
+		c.onChunk(len(c.chunks)-1, h)
+	}
+	c.current.Reset()
+	c.written = 0
+}
+
+// Sum は書き込まれた内容全体のチャンクハッシュを返す。呼び出し後に Write してはならない。
+func (c *ChunkHasher) Sum() (*ChunkHashes, error) {
+	// 端数のチャンク、または空の内容の場合は唯一のチャンクを確定する
+	if c.written > 0 || len(c.chunks) == 0 {
+		c.finishChunk()
+	}
+	root, err := GetHasher(c.algorithm)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range c.chunks {
+		root.Write(h.HashValue)
+	}
+	return &ChunkHashes{
+		ChunkSize: c.chunkSize,
+		Root:      &Hash{Algorithm: c.algorithm, HashValue: root.Sum(nil)},
+		Chunks:    c.chunks,
+	}, nil
+}
+
+// CalculateChunks は io.Reader から読み込んでチャンクハッシュを計算する
+func CalculateChunks(r io.Reader, algorithm HashAlgorithm, chunkSize int64) (*ChunkHashes, error) {
+	hasher, err := NewChunkHasher(algorithm, chunkSize, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(hasher, r); err != nil {
+		return nil, fmt.Errorf("failed to calculate chunk hashes: %w", err)
+	}
+	return hasher.Sum()
+}
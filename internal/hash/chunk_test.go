@@ -0,0 +1,139 @@
+package hash
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+)
+
+func TestCalculateChunks(t *testing.T) {
+	const chunkSize = 4
+	tests := []struct {
+		name       string
+		content    string
+		wantChunks []string // 各チャンクの内容
+	}{
+		{name: "exact multiple", content: "aaaabbbbcccc", wantChunks: []string{"aaaa", "bbbb", "cccc"}},
+		{name: "short last chunk", content: "aaaabbbbcc", wantChunks: []string{"aaaa", "bbbb", "cc"}},
+		{name: "smaller than a chunk", content: "ab", wantChunks: []string{"ab"}},
+		{name: "empty", content: "", wantChunks: []string{""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CalculateChunks(bytes.NewReader([]byte(tt.content)), AlgoSHA256, chunkSize)
+			if err != nil {
+				t.Fatalf("CalculateChunks() error = %v", err)
+			}
+			if got.ChunkSize != chunkSize || len(got.Chunks) != len(tt.wantChunks) {
+				t.Fatalf("CalculateChunks() = %d chunks of %d bytes, want %d chunks of %d bytes", len(got.Chunks), got.ChunkSize, len(tt.wantChunks), chunkSize)
+			}
+			var concatenated []byte
+			for i, chunk := range tt.wantChunks {
+				want, err := CalculateStream(bytes.NewReader([]byte(chunk)), AlgoSHA256)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !got.Chunks[i].Equal(want) {
+					t.Errorf("chunk %d = %v, want %v", i, got.Chunks[i], want)
+				}
+				concatenated = append(concatenated, want.HashValue...)
+			}
+			wantRoot, err := CalculateStream(bytes.NewReader(concatenated), AlgoSHA256)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Root.Equal(wantRoot) {
+				t.Errorf("root = %v, want %v", got.Root, wantRoot)
+			}
+		})
+	}
+}
+
+func TestChunkHasherWriteSizes(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10)
+	want, err := CalculateChunks(bytes.NewReader(content), AlgoSHA256, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// チャンクの境界と書き込みの境界が一致しなくても同じ結果になる
+	for _, writeSize := range []int{1, 3, 16, 17, 100} {
+		var indexes []int
+		hasher, err := NewChunkHasher(AlgoSHA256, 16, func(index int, h *Hash) { indexes = append(indexes, index) })
+		if err != nil {
+			t.Fatal(err)
+		}
+		for p := content; len(p) > 0; {
+			n := min(writeSize, len(p))
+			hasher.Write(p[:n])
+			p = p[n:]
+		}
+		got, err := hasher.Sum()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(want) || len(got.Diverged(want)) != 0 {
+			t.Errorf("write size %d: Sum() = %v, want %v", writeSize, got.Root, want.Root)
+		}
+		if wantIndexes := []int{0, 1, 2, 3, 4, 5, 6}; !slices.Equal(indexes, wantIndexes) {
+			t.Errorf("write size %d: onChunk indexes = %v, want %v", writeSize, indexes, wantIndexes)
+		}
+	}
+}
+
+func TestChunkHashesDiverged(t *testing.T) {
+	const chunkSize = 8
+	original := bytes.Repeat([]byte("abcdefgh"), 4) // 4 チャンク
+	expected, err := CalculateChunks(bytes.NewReader(original), AlgoSHA256, chunkSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// modify は original を変更した内容を返す
+	modify := func(f func(b []byte) []byte) []byte { return f(bytes.Clone(original)) }
+	tests := []struct {
+		name    string
+		content []byte
+		want    []int
+	}{
+		{name: "identical", content: original},
+		{name: "first byte corrupted", content: modify(func(b []byte) []byte { b[0] ^= 0xff; return b }), want: []int{0}},
+		{name: "single chunk corrupted", content: modify(func(b []byte) []byte { b[2*chunkSize+3] ^= 0xff; return b }), want: []int{2}},
+		{name: "last byte corrupted", content: modify(func(b []byte) []byte { b[len(b)-1] ^= 0xff; return b }), want: []int{3}},
+		{name: "two chunks corrupted", content: modify(func(b []byte) []byte { b[0] ^= 0xff; b[3*chunkSize] ^= 0xff; return b }), want: []int{0, 3}},
+		{name: "truncated", content: original[:2*chunkSize+1], want: []int{2, 3}},
+		{name: "appended", content: append(bytes.Clone(original), 'x'), want: []int{4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CalculateChunks(bytes.NewReader(tt.content), AlgoSHA256, chunkSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diverged := got.Diverged(expected); !slices.Equal(diverged, tt.want) {
+				t.Errorf("Diverged() = %v, want %v", diverged, tt.want)
+			}
+			if got.Equal(expected) != (len(tt.want) == 0) {
+				t.Errorf("Equal() = %v, want %v", got.Equal(expected), len(tt.want) == 0)
+			}
+		})
+	}
+}
+
+func TestNewChunkHasherInvalid(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm HashAlgorithm
+		chunkSize int64
+	}{
+		{name: "zero chunk size", algorithm: AlgoSHA256, chunkSize: 0},
+		{name: "negative chunk size", algorithm: AlgoSHA256, chunkSize: -1},
+		{name: "unknown algorithm", algorithm: "md4", chunkSize: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewChunkHasher(tt.algorithm, tt.chunkSize, nil); err == nil {
+				t.Errorf("NewChunkHasher(%q, %d) error = nil, want error", tt.algorithm, tt.chunkSize)
+			}
+		})
+	}
+}
@@ -9,11 +9,18 @@ import (
 	"io"
 	"slices"
 	"strings"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/blake2s"
+	"lukechampine.com/blake3"
 )
 
 const (
-	AlgoSHA256 HashAlgorithm = "sha256"
-	AlgoSHA512 HashAlgorithm = "sha512"
+	AlgoSHA256  HashAlgorithm = "sha256"
+	AlgoSHA512  HashAlgorithm = "sha512"
+	AlgoBLAKE3  HashAlgorithm = "blake3"
+	AlgoBLAKE2b HashAlgorithm = "blake2b" // BLAKE2b-512
+	AlgoBLAKE2s HashAlgorithm = "blake2s" // BLAKE2s-256
 )
 
 type HashAlgorithm string
@@ -100,6 +107,12 @@ func GetHasher(algorithm HashAlgorithm) (hash.Hash, error) {
 		return sha256.New(), nil
 	case AlgoSHA512:
 		return sha512.New(), nil
+	case AlgoBLAKE3:
+		return blake3.New(32, nil), nil // 256 bit ダイジェスト (BLAKE3 の標準出力長)
+	case AlgoBLAKE2b:
+		return blake2b.New512(nil)
+	case AlgoBLAKE2s:
+		return blake2s.New256(nil)
 	default:
 		return nil, fmt.Errorf("unsupported hash algorithm: %s", algorithm)
 	}
@@ -0,0 +1,102 @@
+package hash
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// knownVectors は各アルゴリズムの公開されたテストベクタ
+var knownVectors = []struct {
+	algorithm HashAlgorithm
+	input     string
+	want      string // 16進数のハッシュ値
+}{
+	{algorithm: AlgoSHA256, input: "abc", want: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+	{algorithm: AlgoSHA512, input: "abc", want: "ddaf35a193617abacc417349ae20413112e6fa4e89a97ea20a9eeee64b55d39a2192992a274fc1a836ba3c23a3feebbd454d4423643ce80e2a9ac94fa54ca49f"},
+	{algorithm: AlgoBLAKE3, input: "", want: "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262"},
+	{algorithm: AlgoBLAKE3, input: "abc", want: "6437b3ac38465133ffb63b75273a8db548c558465d79db03fd359c6cd5bd9d85"},
+	{algorithm: AlgoBLAKE2b, input: "", want: "786a02f742015903c6c6fd852552d272912f4740e15847618a86e217f71f5419d25e1031afee585313896444934eb04b903a685b1448b755d56f701afe9be2ce"},
+	{algorithm: AlgoBLAKE2b, input: "abc", want: "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923"},
+	{algorithm: AlgoBLAKE2s, input: "", want: "69217a3079908094e11121d042354a7c1f55b6482ca1a51e1b250dfd1ed0eef9"},
+	{algorithm: AlgoBLAKE2s, input: "abc", want: "508c5e8c327c14e2e1a72ba34eeb452f37458b209ed63a294d999b4c86675982"},
+}
+
+func TestCalculateStreamKnownVectors(t *testing.T) {
+	for _, tt := range knownVectors {
+		t.Run(string(tt.algorithm)+"/"+tt.input, func(t *testing.T) {
+			got, err := CalculateStream(strings.NewReader(tt.input), tt.algorithm)
+			if err != nil {
+				t.Fatalf("CalculateStream() error = %v", err)
+			}
+			if hex.EncodeToString(got.HashValue) != tt.want || got.Algorithm != tt.algorithm {
+				t.Errorf("CalculateStream() = %v, want %s:%s", got, tt.algorithm, tt.want)
+			}
+
+			var copied bytes.Buffer
+			tee, err := CalculateStreamTee(strings.NewReader(tt.input), &copied, tt.algorithm)
+			if err != nil {
+				t.Fatalf("CalculateStreamTee() error = %v", err)
+			}
+			if !tee.Equal(got) || copied.String() != tt.input {
+				t.Errorf("CalculateStreamTee() = %v (copied %q), want %v (copied %q)", tee, copied.String(), got, tt.input)
+			}
+		})
+	}
+}
+
+func TestHashStringRoundTrip(t *testing.T) {
+	for _, tt := range knownVectors {
+		formatted := string(tt.algorithm) + ":" + tt.want
+		t.Run(formatted, func(t *testing.T) {
+			h, err := NewHashFromString(formatted)
+			if err != nil {
+				t.Fatalf("NewHashFromString(%q) error = %v", formatted, err)
+			}
+			if h.Algorithm != tt.algorithm {
+				t.Errorf("Algorithm = %s, want %s", h.Algorithm, tt.algorithm)
+			}
+			if got := h.String(); got != formatted {
+				t.Errorf("String() = %q, want %q", got, formatted)
+			}
+		})
+	}
+}
+
+func TestParseHashInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"sha256",
+		"sha256:",
+		":abcd",
+		"md5:d41d8cd98f00b204e9800998ecf8427e",
+	}
+	for _, input := range tests {
+		if _, _, err := ParseHash(input); err == nil {
+			t.Errorf("ParseHash(%q) error = nil, want error", input)
+		}
+	}
+}
+
+func TestHashJSONRoundTrip(t *testing.T) {
+	for _, tt := range knownVectors {
+		t.Run(string(tt.algorithm)+"/"+tt.input, func(t *testing.T) {
+			h, err := CalculateStream(strings.NewReader(tt.input), tt.algorithm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := h.MarshalJSON()
+			if err != nil {
+				t.Fatalf("MarshalJSON() error = %v", err)
+			}
+			var decoded Hash
+			if err := decoded.UnmarshalJSON(data); err != nil {
+				t.Fatalf("UnmarshalJSON(%s) error = %v", data, err)
+			}
+			if !decoded.Equal(h) {
+				t.Errorf("UnmarshalJSON(%s) = %v, want %v", data, &decoded, h)
+			}
+		})
+	}
+}
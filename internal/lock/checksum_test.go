@@ -0,0 +1,134 @@
+package lock
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hrko/dltofu/internal/hash"
+	"github.com/hrko/dltofu/internal/model"
+)
+
+func TestLockFileChecksum(t *testing.T) {
+	const url = model.ResolvedURL("https://example.com/tool.tar.gz")
+	h := hash.NewHash(hash.AlgoSHA256, []byte{0x01, 0x02})
+
+	// reindent は内容を変えずに整形だけを変える
+	reindent := func(t *testing.T, data []byte) []byte {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, data, "", "\t"); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	tests := []struct {
+		name     string
+		signed   bool
+		dedup    bool
+		edit     func(t *testing.T, data []byte) []byte // 保存後のファイルの変更 (nil なら変更しない)
+		wantErr  error                                  // errors.Is で比較 (*ChecksumError は mismatch で指定)
+		mismatch bool
+	}{
+		{name: "signed", signed: true},
+		{name: "signed dedup", signed: true, dedup: true},
+		{name: "unsigned", wantErr: ErrChecksumMissing},
+		{name: "reformatted", signed: true, edit: reindent},
+		{
+			name:   "hash edited",
+			signed: true,
+			edit: func(t *testing.T, data []byte) []byte {
+				return bytes.Replace(data, []byte("0102"), []byte("0103"), 1)
+			},
+			mismatch: true,
+		},
+		{
+			name:   "entry added",
+			signed: true,
+			edit: func(t *testing.T, data []byte) []byte {
+				return bytes.Replace(data, []byte(`"files": {`), []byte(`"files": {"extra": {},`), 1)
+			},
+			mismatch: true,
+		},
+		{
+			name:   "checksum removed",
+			signed: true,
+			edit: func(t *testing.T, data []byte) []byte {
+				var fields map[string]json.RawMessage
+				if err := json.Unmarshal(data, &fields); err != nil {
+					t.Fatal(err)
+				}
+				delete(fields, "checksum")
+				out, _ := json.Marshal(fields)
+				return out
+			},
+			wantErr: ErrChecksumMissing,
+		},
+		{
+			name:   "checksum replaced",
+			signed: true,
+			edit: func(t *testing.T, data []byte) []byte {
+				return bytes.Replace(data, []byte(`"checksum": "sha256:`), []byte(`"checksum": "sha256:00`), 1)
+			},
+			mismatch: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), LockFileName)
+			lf := NewLockFile(nil)
+			if err := lf.SetHash("tool", url, h); err != nil {
+				t.Fatal(err)
+			}
+			lf.SetSigned(tt.signed)
+			lf.SetDedup(tt.dedup)
+			if err := lf.Save(path); err != nil {
+				t.Fatal(err)
+			}
+			if tt.edit != nil {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, tt.edit(t, data), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			loaded, err := LoadLockFile(path, nil)
+			if err != nil {
+				t.Fatalf("LoadLockFile() error = %v", err)
+			}
+			err = loaded.VerifyChecksum()
+			var mismatch *ChecksumError
+			switch {
+			case tt.mismatch:
+				if !errors.As(err, &mismatch) {
+					t.Errorf("VerifyChecksum() error = %v, want *ChecksumError", err)
+				}
+			case !errors.Is(err, tt.wantErr):
+				t.Errorf("VerifyChecksum() error = %v, want %v", err, tt.wantErr)
+			}
+
+			// checksum が記録された Lock ファイルは、保存し直しても checksum を記録し直す
+			if tt.signed && tt.wantErr == nil {
+				if err := loaded.Save(path); err != nil {
+					t.Fatal(err)
+				}
+				resaved, err := LoadLockFile(path, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := resaved.VerifyChecksum(); err != nil {
+					t.Errorf("VerifyChecksum() after re-save = %v, want nil", err)
+				}
+			}
+			if data, _ := os.ReadFile(path); tt.signed && tt.wantErr == nil && !strings.Contains(string(data), `"checksum"`) {
+				t.Errorf("re-saved lock file has no checksum:\n%s", data)
+			}
+		})
+	}
+}
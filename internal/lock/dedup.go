@@ -0,0 +1,154 @@
+package lock
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hrko/dltofu/internal/hash"
+	"github.com/hrko/dltofu/internal/model"
+)
+
+// LockFileVersionDedup は同一の (URL, ハッシュ値) を1回だけ記録する正規化形式のバージョン。
+// 複数のファイルIDが同じツールを参照する大規模なリポジトリで Lock ファイルを小さくするために使う。
+const LockFileVersionDedup = 3
+
+// sharedEntry は正規化形式で共有される Entry
+type sharedEntry struct {
+	URL model.ResolvedURL `json:"url"`
+	*Entry
+}
+
+// entryTimes は共有された Entry と記録日時が異なる参照の記録日時
+type entryTimes struct {
+	FirstSeen *time.Time `json:"first_seen,omitempty"`
+	LockedAt  *time.Time `json:"locked_at,omitempty"`
+}
+
+// dedupLockFile は正規化形式の Lock ファイルの JSON 表現
+type dedupLockFile struct {
+	Version      int                                                          `json:"version"`
+	Entries      []sharedEntry                                                `json:"entries"`                // 共有される Entry の一覧
+	Files        map[model.FileID][]int                                       `json:"files"`                  // ファイルIDごとの Entries のインデックス
+	Trees        map[model.FileID]map[model.ResolvedURL]*hash.Hash            `json:"trees,omitempty"`        // 通常形式と同じ
+	Chunks       map[model.FileID]map[model.ResolvedURL]*hash.ChunkHashes     `json:"chunks,omitempty"`       // 通常形式と同じ
+	Members      map[model.FileID]map[model.ResolvedURL]map[string]*hash.Hash `json:"members,omitempty"`      // 通常形式と同じ
+	Descriptions map[model.FileID]string                                      `json:"descriptions,omitempty"` // 通常形式と同じ
+	Validators   map[model.FileID]map[model.ResolvedURL]*Validators           `json:"validators,omitempty"`   // 通常形式と同じ (共有する Entry には含めない)
+	Times        map[model.FileID]map[model.ResolvedURL]*entryTimes           `json:"times,omitempty"`        // 共有された Entry と記録日時が異なる参照の記録日時
+	Checksum     string                                                       `json:"checksum,omitempty"`     // 通常形式と同じ
+}
+
+// dedupLockFileJSON は正規化形式の Lock ファイルの出力用の表現。
+// dedupLockFile と同じ JSON になるが、各マップはキーの昇順に出力される (lockFileJSON を参照)。
+type dedupLockFileJSON struct {
+	Version      int                                                                                           `json:"version"`
+	Entries      []sharedEntry                                                                                 `json:"entries"`
+	Files        sortedObject[model.FileID, []int]                                                             `json:"files"`
+	Trees        sortedObject[model.FileID, sortedObject[model.ResolvedURL, *hash.Hash]]                       `json:"trees,omitempty"`
+	Chunks       sortedObject[model.FileID, sortedObject[model.ResolvedURL, *hash.ChunkHashes]]                `json:"chunks,omitempty"`
+	Members      sortedObject[model.FileID, sortedObject[model.ResolvedURL, sortedObject[string, *hash.Hash]]] `json:"members,omitempty"`
+	Descriptions sortedObject[model.FileID, string]                                                            `json:"descriptions,omitempty"`
+	Validators   sortedObject[model.FileID, sortedObject[model.ResolvedURL, *Validators]]                      `json:"validators,omitempty"`
+	Times        sortedObject[model.FileID, sortedObject[model.ResolvedURL, *entryTimes]]                      `json:"times,omitempty"`
+	Checksum     string                                                                                        `json:"checksum,omitempty"`
+}
+
+// marshalDedup は Lock ファイルを正規化形式の JSON に変換する。
+// 同じ内容のファイルが同じインデックスになるよう、ファイルID と URL の順に走査する。
+// URL とハッシュ値、バイト数が同じエントリは記録日時が異なっても共有し、
+// 最初に現れたエントリと記録日時が異なる参照は times に記録する。
+func (lf *LockFile) marshalDedup() ([]byte, error) {
+	out := dedupLockFileJSON{
+		Version:      LockFileVersionDedup,
+		Files:        make(sortedObject[model.FileID, []int]),
+		Trees:        sortedNested(lf.Trees),
+		Chunks:       sortedNested(lf.Chunks),
+		Members:      sortedMembers(lf.Members),
+		Descriptions: lf.Descriptions,
+		Validators:   sortedNested(lf.Validators),
+		Checksum:     lf.Checksum,
+	}
+	indices := make(map[string]int) // key: URL と Entry の内容 (contentKey)
+
+	fileIDs := make([]model.FileID, 0, len(lf.Files))
+	for fileID := range lf.Files {
+		fileIDs = append(fileIDs, fileID)
+	}
+	sort.Slice(fileIDs, func(i, j int) bool { return fileIDs[i] < fileIDs[j] })
+
+	for _, fileID := range fileIDs {
+		fileLocks := lf.Files[fileID]
+		urls := make([]model.ResolvedURL, 0, len(fileLocks))
+		for url := range fileLocks {
+			urls = append(urls, url)
+		}
+		sort.Slice(urls, func(i, j int) bool { return urls[i] < urls[j] })
+
+		refs := make([]int, 0, len(urls))
+		for _, url := range urls {
+			entry := fileLocks[url].normalized()
+			if entry == nil {
+				return nil, fmt.Errorf("entry for %s [%s] is empty", fileID, url)
+			}
+			key := string(url) + "\x00" + entry.contentKey()
+			index, ok := indices[key]
+			if !ok {
+				index = len(out.Entries)
+				indices[key] = index
+				out.Entries = append(out.Entries, sharedEntry{URL: url, Entry: entry})
+			} else if shared := out.Entries[index].Entry; !timeEqual(shared.FirstSeen, entry.FirstSeen) || !timeEqual(shared.LockedAt, entry.LockedAt) {
+				if out.Times == nil {
+					out.Times = make(sortedObject[model.FileID, sortedObject[model.ResolvedURL, *entryTimes]])
+				}
+				if out.Times[fileID] == nil {
+					out.Times[fileID] = make(sortedObject[model.ResolvedURL, *entryTimes])
+				}
+				out.Times[fileID][url] = &entryTimes{FirstSeen: entry.FirstSeen, LockedAt: entry.LockedAt}
+			}
+			refs = append(refs, index)
+		}
+		out.Files[fileID] = refs
+	}
+
+	return json.MarshalIndent(out, "", "  ")
+}
+
+// unmarshalDedup は正規化形式の JSON を読み込み、ファイルIDごとの形式に展開する。
+// 共有されていた Entry はファイルIDごとに複製し、以降の更新が他のファイルIDに影響しないようにする。
+func (lf *LockFile) unmarshalDedup(data []byte) error {
+	var in dedupLockFile
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+
+	lf.Version = LockFileVersion
+	lf.Files = make(map[model.FileID]map[model.ResolvedURL]*Entry)
+	for fileID, refs := range in.Files {
+		lf.Files[fileID] = make(map[model.ResolvedURL]*Entry)
+		for _, index := range refs {
+			if index < 0 || index >= len(in.Entries) {
+				return fmt.Errorf("file ID %s refers to unknown entry %d", fileID, index)
+			}
+			shared := in.Entries[index]
+			if shared.Entry == nil {
+				return fmt.Errorf("entry %d has no hashes", index)
+			}
+			entry := shared.Entry.Copy()
+			if times := in.Times[fileID][shared.URL]; times != nil {
+				entry.FirstSeen = copyTime(times.FirstSeen)
+				entry.LockedAt = copyTime(times.LockedAt)
+			}
+			lf.Files[fileID][shared.URL] = entry
+		}
+	}
+	lf.Trees = in.Trees
+	lf.Chunks = in.Chunks
+	lf.Members = in.Members
+	lf.Descriptions = in.Descriptions
+	lf.Validators = in.Validators
+	lf.Checksum = in.Checksum
+	lf.dedup = true
+	return nil
+}
@@ -0,0 +1,166 @@
+package lock
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hrko/dltofu/internal/hash"
+	"github.com/hrko/dltofu/internal/model"
+)
+
+func TestMarshalDedupSharesEntriesRegardlessOfValidators(t *testing.T) {
+	const url = model.ResolvedURL("https://example.com/tool.tar.gz")
+	h := hash.NewHash(hash.AlgoSHA256, []byte{0x01, 0x02})
+
+	tests := []struct {
+		name       string
+		validators map[model.FileID]Validators // 空の値は記録しない
+	}{
+		{name: "no validators"},
+		{
+			name: "same validators",
+			validators: map[model.FileID]Validators{
+				"a": {ETag: `"v1"`},
+				"b": {ETag: `"v1"`},
+			},
+		},
+		{
+			name: "different validators",
+			validators: map[model.FileID]Validators{
+				"a": {ETag: `"v1"`},
+				"b": {ETag: `"v2"`, LastModified: "Mon, 02 Jan 2006 15:04:05 GMT"},
+			},
+		},
+		{
+			name: "only one file ID has validators",
+			validators: map[model.FileID]Validators{
+				"b": {LastModified: "Mon, 02 Jan 2006 15:04:05 GMT"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lf := NewLockFile(nil)
+			for _, fileID := range []model.FileID{"a", "b"} {
+				lf.Files[fileID] = map[model.ResolvedURL]*Entry{url: NewEntry(h)}
+				if err := lf.SetValidators(fileID, url, tt.validators[fileID]); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			data, err := lf.marshalDedup()
+			if err != nil {
+				t.Fatalf("marshalDedup() error = %v", err)
+			}
+			var out dedupLockFile
+			if err := json.Unmarshal(data, &out); err != nil {
+				t.Fatal(err)
+			}
+			if len(out.Entries) != 1 {
+				t.Errorf("len(entries) = %d, want 1\n%s", len(out.Entries), data)
+			}
+
+			var loaded LockFile
+			if err := loaded.unmarshalDedup(data); err != nil {
+				t.Fatalf("unmarshalDedup() error = %v", err)
+			}
+			for _, fileID := range []model.FileID{"a", "b"} {
+				if got, want := loaded.GetValidators(fileID, url), tt.validators[fileID]; got != want {
+					t.Errorf("GetValidators(%s) = %+v, want %+v", fileID, got, want)
+				}
+			}
+			if !reflect.DeepEqual(loaded.Files, lf.Files) {
+				t.Errorf("files after round trip = %+v, want %+v", loaded.Files, lf.Files)
+			}
+		})
+	}
+}
+
+func TestSetValidatorsEmptyRemoves(t *testing.T) {
+	const url = model.ResolvedURL("https://example.com/tool")
+	lf := NewLockFile(nil)
+	lf.Files["a"] = map[model.ResolvedURL]*Entry{url: NewEntry(hash.NewHash(hash.AlgoSHA256, []byte{0x01}))}
+
+	if err := lf.SetValidators("a", url, Validators{ETag: `"v1"`}); err != nil {
+		t.Fatal(err)
+	}
+	if err := lf.SetValidators("a", url, Validators{}); err != nil {
+		t.Fatal(err)
+	}
+	if lf.Validators["a"] != nil {
+		t.Errorf("validators = %+v, want removed", lf.Validators)
+	}
+	if err := lf.SetValidators("missing", url, Validators{ETag: `"v1"`}); err == nil {
+		t.Error("SetValidators() for unknown entry succeeded, want error")
+	}
+}
+
+func TestMarshalDedupSharesEntriesByContent(t *testing.T) {
+	const url = model.ResolvedURL("https://example.com/tool.tar.gz")
+	h1 := hash.NewHash(hash.AlgoSHA256, []byte{0x01})
+	h2 := hash.NewHash(hash.AlgoSHA256, []byte{0x02})
+	t1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	entry := func(size int64, first, locked *time.Time, hashes ...*hash.Hash) *Entry {
+		e := NewEntry(hashes...)
+		e.Size, e.FirstSeen, e.LockedAt = size, first, locked
+		return e
+	}
+
+	tests := []struct {
+		name        string
+		a, b        *Entry
+		urlB        model.ResolvedURL // 空なら url
+		wantEntries int
+		wantTimes   bool
+	}{
+		{name: "identical", a: entry(10, &t1, &t1, h1), b: entry(10, &t1, &t1, h1), wantEntries: 1},
+		{name: "different first_seen", a: entry(10, &t1, &t2, h1), b: entry(10, &t2, &t2, h1), wantEntries: 1, wantTimes: true},
+		{name: "locked_at only on one", a: entry(10, &t1, nil, h1), b: entry(10, &t1, &t2, h1), wantEntries: 1, wantTimes: true},
+		{name: "different size", a: entry(10, &t1, &t1, h1), b: entry(11, &t1, &t1, h1), wantEntries: 2},
+		{name: "different hash", a: entry(10, &t1, &t1, h1), b: entry(10, &t1, &t1, h2), wantEntries: 2},
+		{name: "different url", a: entry(10, &t1, &t1, h1), b: entry(10, &t1, &t1, h1), urlB: url + "?mirror", wantEntries: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			urlB := url
+			if tt.urlB != "" {
+				urlB = tt.urlB
+			}
+			lf := NewLockFile(nil)
+			lf.Files["a"] = map[model.ResolvedURL]*Entry{url: tt.a}
+			lf.Files["b"] = map[model.ResolvedURL]*Entry{urlB: tt.b}
+
+			data, err := lf.marshalDedup()
+			if err != nil {
+				t.Fatalf("marshalDedup() error = %v", err)
+			}
+			var out dedupLockFile
+			if err := json.Unmarshal(data, &out); err != nil {
+				t.Fatal(err)
+			}
+			if len(out.Entries) != tt.wantEntries {
+				t.Errorf("len(entries) = %d, want %d\n%s", len(out.Entries), tt.wantEntries, data)
+			}
+			if got := len(out.Times) > 0; got != tt.wantTimes {
+				t.Errorf("times recorded = %v, want %v\n%s", got, tt.wantTimes, data)
+			}
+
+			var loaded LockFile
+			if err := loaded.unmarshalDedup(data); err != nil {
+				t.Fatalf("unmarshalDedup() error = %v", err)
+			}
+			for fileID, want := range map[model.FileID]*Entry{"a": tt.a, "b": tt.b} {
+				for u := range lf.Files[fileID] {
+					got := loaded.Files[fileID][u]
+					if got == nil || got.contentKey() != want.contentKey() ||
+						!timeEqual(got.FirstSeen, want.FirstSeen) || !timeEqual(got.LockedAt, want.LockedAt) {
+						t.Errorf("entry %s [%s] after round trip = %+v, want %+v", fileID, u, got, want)
+					}
+				}
+			}
+		})
+	}
+}
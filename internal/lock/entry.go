@@ -0,0 +1,115 @@
+package lock
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hrko/dltofu/internal/hash"
+)
+
+// Entry は1つの解決済みURLに対する Lock 情報
+type Entry struct {
+	Hashes []*hash.Hash `json:"hashes"`         // アルゴリズムごとに最大1つ (アルゴリズム名でソート済み)
+	Size   int64        `json:"size,omitempty"` // ファイルのバイト数 (0 の場合は未記録。古い Lock ファイルや checksums_url のみで記録した場合など)
+	// 以下は「いつからこのハッシュ値なのか」を調べるための記録で、TOFU の検証には使わない。
+	// これらの項目が導入される前に作成されたエントリでは未記録となる。
+	FirstSeen *time.Time `json:"first_seen,omitempty"` // この URL のエントリが最初に記録された日時
+	LockedAt  *time.Time `json:"locked_at,omitempty"`  // ハッシュ値が最後に追加された日時 (同じ値の再設定では更新しない)
+}
+
+// Validators は次回の lock で内容が変わっていない場合にダウンロードを省略する条件付きリクエストに使うレスポンスヘッダ。
+// サーバーごとに値が変わり得るため Entry とは別に記録し、TOFU の検証や正規化形式の共有には使わない。
+type Validators struct {
+	ETag         string `json:"etag,omitempty"`          // 最後にダウンロードした時のレスポンスの ETag
+	LastModified string `json:"last_modified,omitempty"` // 最後にダウンロードした時のレスポンスの Last-Modified
+}
+
+// NewEntry は指定されたハッシュ値を持つ Entry を作成する
+func NewEntry(hashes ...*hash.Hash) *Entry {
+	e := &Entry{}
+	for _, h := range hashes {
+		_, _ = e.addHash(h) // 新規作成なので不整合は起こり得ない
+	}
+	return e
+}
+
+// Hash は指定されたアルゴリズムのハッシュ値を返す。記録されていない場合は nil を返す。
+func (e *Entry) Hash(algorithm hash.HashAlgorithm) *hash.Hash {
+	for _, h := range e.Hashes {
+		if h.Algorithm == algorithm {
+			return h
+		}
+	}
+	return nil
+}
+
+// Copy は Entry のコピーを作成する
+func (e *Entry) Copy() *Entry {
+	copied := &Entry{Hashes: make([]*hash.Hash, 0, len(e.Hashes)), Size: e.Size, FirstSeen: copyTime(e.FirstSeen), LockedAt: copyTime(e.LockedAt)}
+	for _, h := range e.Hashes {
+		copied.Hashes = append(copied.Hashes, h.Copy())
+	}
+	return copied
+}
+
+// contentKey は Entry の内容 (ハッシュ値とバイト数) を表す文字列を返す。
+// FirstSeen や LockedAt などの記録日時は含めないため、同じ内容のエントリは記録日時が異なっても同じ値になる。
+func (e *Entry) contentKey() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d", e.Size)
+	for _, h := range e.normalized().Hashes {
+		b.WriteString("\x00")
+		b.WriteString(h.String())
+	}
+	return b.String()
+}
+
+// setSize はバイト数を記録する。異なるバイト数が既に記録されている場合はエラーを返す。
+func (e *Entry) setSize(size int64) error {
+	if e.Size != 0 && e.Size != size {
+		return fmt.Errorf("existing size %d bytes, new size %d bytes", e.Size, size)
+	}
+	e.Size = size
+	return nil
+}
+
+// addHash はハッシュ値を追加し、追加した場合は true を返す。同じアルゴリズムの異なる値が既にある場合はエラーを返す。
+func (e *Entry) addHash(newHash *hash.Hash) (bool, error) {
+	if existing := e.Hash(newHash.Algorithm); existing != nil {
+		if !existing.Equal(newHash) {
+			return false, fmt.Errorf("existing '%s', new '%s'", existing, newHash)
+		}
+		return false, nil // 同じ値なので何もしない
+	}
+	e.Hashes = append(e.Hashes, newHash)
+	sort.Slice(e.Hashes, func(i, j int) bool { return e.Hashes[i].Algorithm < e.Hashes[j].Algorithm })
+	return true, nil
+}
+
+// copyTime は日時のコピーを返す (nil の場合は nil)
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	copied := *t
+	return &copied
+}
+
+// timeEqual は2つの日時が同じか返す (どちらも nil の場合も同じとみなす)
+func timeEqual(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// algorithms は記録されているハッシュアルゴリズムをカンマ区切りで返す
+func (e *Entry) algorithms() string {
+	names := make([]string, len(e.Hashes))
+	for i, h := range e.Hashes {
+		names[i] = string(h.Algorithm)
+	}
+	return strings.Join(names, ", ")
+}
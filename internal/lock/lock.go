@@ -1,31 +1,53 @@
 package lock
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"os"
-	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/hrko/dltofu/internal/hash"
 	"github.com/hrko/dltofu/internal/model"
 )
 
+// LockFileName はデフォルトの Lock ファイル名 (--lock-file で変更できる)
 const LockFileName = "dltofu.lock"
-const LockFileVersion = 1
 
-type FileID = model.FileID
-type ResolvedURL = model.ResolvedURL
+// PartialSuffix は lock --write-only-if-complete で一部のファイルが失敗した場合に結果を書き出すファイルの接尾辞
+const PartialSuffix = ".partial"
+
+// LockFileVersion は新しく作成する Lock ファイルのバージョン (ファイルIDごとにエントリを記録する通常の形式)
+const LockFileVersion = 2
+
+// latestLockFileVersion はこのバージョンの dltofu が読み込める最も新しい Lock ファイルのバージョン
+const latestLockFileVersion = LockFileVersionDedup
 
 // LockFile は dltofu.lock ファイルの内容を表す
 type LockFile struct {
-	Version int                                   `json:"version"`
-	Files   map[FileID]map[ResolvedURL]*hash.Hash `json:"files"` // key1: file_id, key2: resolved_url, value: formatted_hash
+	Version      int                                                          `json:"version"`
+	Files        map[model.FileID]map[model.ResolvedURL]*Entry                `json:"files"`                  // key1: file_id, key2: resolved_url
+	Trees        map[model.FileID]map[model.ResolvedURL]*hash.Hash            `json:"trees,omitempty"`        // アーカイブ展開結果の TreeHash (キーは Files と同じ)
+	Chunks       map[model.FileID]map[model.ResolvedURL]*hash.ChunkHashes     `json:"chunks,omitempty"`       // chunk_size 指定時のチャンクハッシュ (キーは Files と同じ)
+	Members      map[model.FileID]map[model.ResolvedURL]map[string]*hash.Hash `json:"members,omitempty"`      // アーカイブ展開結果の各ファイルのハッシュ値 (キーは展開先からの相対パス)
+	Descriptions map[model.FileID]string                                      `json:"descriptions,omitempty"` // ファイルIDごとの説明 (設定の description)。差分のレビュー用で、検証には使わない
+	Validators   map[model.FileID]map[model.ResolvedURL]*Validators           `json:"validators,omitempty"`   // 条件付きリクエスト用の ETag/Last-Modified (キーは Files と同じ)。検証には使わない
+	Checksum     string                                                       `json:"checksum,omitempty"`     // checksum 自身を除いた内容の正規化 JSON のハッシュ値 (SetSigned で有効にした場合のみ記録する)
 
-	path   string       // Lockファイルのパス
-	mu     sync.RWMutex // Files マップへのアクセスを保護
-	logger *slog.Logger
+	path        string       // Lockファイルのパス
+	migrated    bool         // 古いバージョンの形式から変換して読み込んだか
+	dedup       bool         // 正規化形式 (LockFileVersionDedup) で保存するか
+	signed      bool         // 保存時に checksum を記録するか
+	checksumErr error        // 読み込み時の checksum の検証結果 (記録されていない場合は ErrChecksumMissing)
+	mu          sync.RWMutex // Files マップへのアクセスを保護
+	logger      *slog.Logger
 }
 
 // NewLockFile は空の LockFile 構造体を作成する
@@ -35,7 +57,7 @@ func NewLockFile(logger *slog.Logger) *LockFile {
 	}
 	return &LockFile{
 		Version: LockFileVersion,
-		Files:   make(map[FileID]map[ResolvedURL]*hash.Hash),
+		Files:   make(map[model.FileID]map[model.ResolvedURL]*Entry),
 		logger:  logger,
 	}
 }
@@ -44,27 +66,78 @@ func NewLockFile(logger *slog.Logger) *LockFile {
 func (lf *LockFile) Copy() *LockFile {
 	lf.mu.RLock() // 読み取りロック
 	defer lf.mu.RUnlock()
-	copiedFiles := make(map[FileID]map[ResolvedURL]*hash.Hash)
+	copiedFiles := make(map[model.FileID]map[model.ResolvedURL]*Entry)
 	for fileID, fileLocks := range lf.Files {
-		copiedLocks := make(map[ResolvedURL]*hash.Hash)
-		for resolvedURL, hash := range fileLocks {
-			copiedLocks[resolvedURL] = hash.Copy()
+		copiedLocks := make(map[model.ResolvedURL]*Entry)
+		for resolvedURL, entry := range fileLocks {
+			copiedLocks[resolvedURL] = entry.Copy()
 		}
 		copiedFiles[fileID] = copiedLocks
 	}
+	var copiedTrees map[model.FileID]map[model.ResolvedURL]*hash.Hash
+	if lf.Trees != nil {
+		copiedTrees = make(map[model.FileID]map[model.ResolvedURL]*hash.Hash)
+		for fileID, treeLocks := range lf.Trees {
+			copiedLocks := make(map[model.ResolvedURL]*hash.Hash)
+			for resolvedURL, hash := range treeLocks {
+				copiedLocks[resolvedURL] = hash.Copy()
+			}
+			copiedTrees[fileID] = copiedLocks
+		}
+	}
+	var copiedChunks map[model.FileID]map[model.ResolvedURL]*hash.ChunkHashes
+	if lf.Chunks != nil {
+		copiedChunks = make(map[model.FileID]map[model.ResolvedURL]*hash.ChunkHashes)
+		for fileID, chunkLocks := range lf.Chunks {
+			copiedLocks := make(map[model.ResolvedURL]*hash.ChunkHashes)
+			for resolvedURL, chunks := range chunkLocks {
+				copiedLocks[resolvedURL] = chunks.Copy()
+			}
+			copiedChunks[fileID] = copiedLocks
+		}
+	}
+	var copiedMembers map[model.FileID]map[model.ResolvedURL]map[string]*hash.Hash
+	if lf.Members != nil {
+		copiedMembers = make(map[model.FileID]map[model.ResolvedURL]map[string]*hash.Hash)
+		for fileID, memberLocks := range lf.Members {
+			copiedLocks := make(map[model.ResolvedURL]map[string]*hash.Hash)
+			for resolvedURL, members := range memberLocks {
+				copiedLocks[resolvedURL] = copyMembers(members)
+			}
+			copiedMembers[fileID] = copiedLocks
+		}
+	}
+	var copiedValidators map[model.FileID]map[model.ResolvedURL]*Validators
+	if lf.Validators != nil {
+		copiedValidators = make(map[model.FileID]map[model.ResolvedURL]*Validators)
+		for fileID, validatorLocks := range lf.Validators {
+			copiedLocks := make(map[model.ResolvedURL]*Validators)
+			for resolvedURL, v := range validatorLocks {
+				copied := *v
+				copiedLocks[resolvedURL] = &copied
+			}
+			copiedValidators[fileID] = copiedLocks
+		}
+	}
 	return &LockFile{
-		Version: lf.Version,
-		Files:   copiedFiles,
-		logger:  lf.logger,
+		Version:      lf.Version,
+		Files:        copiedFiles,
+		Trees:        copiedTrees,
+		Chunks:       copiedChunks,
+		Members:      copiedMembers,
+		Descriptions: maps.Clone(lf.Descriptions),
+		Validators:   copiedValidators,
+		dedup:        lf.dedup,
+		signed:       lf.signed,
+		logger:       lf.logger,
 	}
 }
 
-// LoadLockFile は指定されたディレクトリから dltofu.lock を読み込む
-func LoadLockFile(dirPath string, logger *slog.Logger) (*LockFile, error) {
+// LoadLockFile は lockPath の Lock ファイルを読み込む
+func LoadLockFile(lockPath string, logger *slog.Logger) (*LockFile, error) {
 	if logger == nil {
 		logger = slog.Default()
 	}
-	lockPath := filepath.Join(dirPath, LockFileName)
 	logger.Debug("Attempting to load lock file", "path", lockPath)
 
 	data, err := os.ReadFile(lockPath)
@@ -78,109 +151,609 @@ func LoadLockFile(dirPath string, logger *slog.Logger) (*LockFile, error) {
 		return nil, fmt.Errorf("failed to read lock file %s: %w", lockPath, err)
 	}
 
-	var lf LockFile
-	err = json.Unmarshal(data, &lf)
-	if err != nil {
+	// まずバージョンだけを読み取り、形式に応じてパースする
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal lock file %s: %w", lockPath, err)
 	}
 
-	if lf.Version != LockFileVersion {
-		return nil, fmt.Errorf("unsupported lock file version: %d (supported: %d)", lf.Version, LockFileVersion)
+	var lf LockFile
+	switch header.Version {
+	case LockFileVersion:
+		err = json.Unmarshal(data, &lf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal lock file %s: %w", lockPath, err)
+		}
+	case LockFileVersionDedup:
+		if err := lf.unmarshalDedup(data); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal lock file %s: %w", lockPath, err)
+		}
+	case 1:
+		logger.Info("Migrating lock file from version 1", "path", lockPath, "version", LockFileVersion)
+		if err := lf.migrateV1(data); err != nil {
+			return nil, fmt.Errorf("failed to migrate lock file %s: %w", lockPath, err)
+		}
+	default:
+		if header.Version > latestLockFileVersion {
+			// 新しいバージョンの dltofu で作成された Lock ファイル
+			return nil, fmt.Errorf("lock file %s has version %d, which is newer than this dltofu supports (up to %d); please upgrade dltofu", lockPath, header.Version, latestLockFileVersion)
+		}
+		// version フィールドがない、または移行できない古い形式
+		return nil, fmt.Errorf("lock file %s has unsupported version %d (supported: 1-%d); remove it and run 'dltofu lock' to regenerate it", lockPath, header.Version, latestLockFileVersion)
 	}
 
 	if lf.Files == nil {
 		// 空のファイルでも files フィールドは存在すべき
-		lf.Files = make(map[FileID]map[ResolvedURL]*hash.Hash)
+		lf.Files = make(map[model.FileID]map[model.ResolvedURL]*Entry)
 	}
 
 	lf.path = lockPath // パスを記憶
 	lf.logger = logger
+	if lf.Checksum == "" {
+		lf.checksumErr = ErrChecksumMissing
+	} else {
+		lf.signed = true // 以降の保存でも checksum を記録する
+		lf.checksumErr = verifyChecksum(data, lf.Checksum)
+		if lf.checksumErr != nil {
+			logger.Warn("Lock file has been modified outside of dltofu", "path", lockPath, "error", lf.checksumErr)
+		}
+	}
 	logger.Info("Lock file loaded successfully", "path", lockPath)
 	return &lf, nil
 }
 
-// Save は現在の LockFile の内容をファイルに書き込む
-func (lf *LockFile) Save(dirPath string) error {
+// migrateV1 はバージョン1形式 (URL ごとに単一のハッシュ値) の Lock ファイルを読み込み、
+// 現在の形式に変換する
+func (lf *LockFile) migrateV1(data []byte) error {
+	var v1 struct {
+		Files map[model.FileID]map[model.ResolvedURL]*hash.Hash `json:"files"`
+		Trees map[model.FileID]map[model.ResolvedURL]*hash.Hash `json:"trees,omitempty"`
+	}
+	if err := json.Unmarshal(data, &v1); err != nil {
+		return err
+	}
+
+	lf.Version = LockFileVersion
+	lf.Files = make(map[model.FileID]map[model.ResolvedURL]*Entry)
+	for fileID, fileLocks := range v1.Files {
+		lf.Files[fileID] = make(map[model.ResolvedURL]*Entry)
+		for resolvedURL, h := range fileLocks {
+			lf.Files[fileID][resolvedURL] = NewEntry(h)
+		}
+	}
+	lf.Trees = v1.Trees
+	lf.migrated = true
+	return nil
+}
+
+// SameContent は lf と other に記録された内容 (ハッシュ値、バイト数、ツリー、チャンク、メンバー、説明) が同じか返す。
+// FirstSeen や LockedAt などの記録日時と Validators は TOFU の検証に使わないため比較しない。
+func (lf *LockFile) SameContent(other *LockFile) bool {
+	lf.mu.RLock()
+	defer lf.mu.RUnlock()
+	other.mu.RLock()
+	defer other.mu.RUnlock()
+	if len(lf.Files) != len(other.Files) {
+		return false
+	}
+	for fileID, fileLocks := range lf.Files {
+		otherLocks, ok := other.Files[fileID]
+		if !ok || len(fileLocks) != len(otherLocks) {
+			return false
+		}
+		for url, entry := range fileLocks {
+			otherEntry, ok := otherLocks[url]
+			if !ok || (entry == nil) != (otherEntry == nil) || (entry != nil && entry.contentKey() != otherEntry.contentKey()) {
+				return false
+			}
+		}
+	}
+	return reflect.DeepEqual(lf.Trees, other.Trees) && reflect.DeepEqual(lf.Chunks, other.Chunks) &&
+		reflect.DeepEqual(lf.Members, other.Members) && reflect.DeepEqual(lf.Descriptions, other.Descriptions)
+}
+
+// SameValidators は lf と other に記録された Validators (ETag/Last-Modified) が同じか返す
+func (lf *LockFile) SameValidators(other *LockFile) bool {
+	lf.mu.RLock()
+	defer lf.mu.RUnlock()
+	other.mu.RLock()
+	defer other.mu.RUnlock()
+	if len(lf.Validators) != len(other.Validators) {
+		return false
+	}
+	for fileID, validatorLocks := range lf.Validators {
+		otherLocks, ok := other.Validators[fileID]
+		if !ok || len(validatorLocks) != len(otherLocks) {
+			return false
+		}
+		for url, v := range validatorLocks {
+			otherV, ok := otherLocks[url]
+			if !ok || (v == nil) != (otherV == nil) || (v != nil && *v != *otherV) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Migrated は古いバージョンの形式から変換して読み込まれた場合に true を返す
+func (lf *LockFile) Migrated() bool {
+	return lf.migrated
+}
+
+// Dedup は正規化形式で保存する設定の場合に true を返す
+func (lf *LockFile) Dedup() bool {
+	return lf.dedup
+}
+
+// SetDedup は正規化形式 (同一の URL とハッシュ値を1回だけ記録する形式) で保存するかを設定する
+func (lf *LockFile) SetDedup(dedup bool) {
+	lf.dedup = dedup
+}
+
+// SetSigned は保存時に Lock ファイル自身のハッシュ値 (checksum) を記録するかを設定する。
+// checksum が記録された Lock ファイルを読み込んだ場合は、以降の保存でも記録する。
+func (lf *LockFile) SetSigned(signed bool) {
+	lf.signed = signed
+}
+
+// Signed は保存時に checksum を記録する設定の場合に true を返す
+func (lf *LockFile) Signed() bool {
+	return lf.signed
+}
+
+// ErrChecksumMissing は Lock ファイルに checksum が記録されていないことを示す
+var ErrChecksumMissing = errors.New("lock file has no checksum (run 'dltofu lock --sign-lock' to add one)")
+
+// ChecksumError は読み込んだ Lock ファイルの内容が記録された checksum と一致しないことを示す
+type ChecksumError struct {
+	Recorded string
+	Actual   string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("lock file checksum mismatch (recorded %s, actual %s); it was edited outside of dltofu", e.Recorded, e.Actual)
+}
+
+// VerifyChecksum は読み込み時の checksum の検証結果を返す。
+// checksum が記録されていない場合は ErrChecksumMissing、内容と一致しない場合は *ChecksumError を返す。
+// LoadLockFile で読み込んでいない (新規作成した) 場合は nil を返す。
+func (lf *LockFile) VerifyChecksum() error {
+	return lf.checksumErr
+}
+
+// computeChecksum は Lock ファイルの JSON から checksum フィールドを除き、
+// トップレベルのキーの順に並べて空白を除いた正規化 JSON の SHA-256 を返す。
+// 整形 (インデントや改行) の違いは無視し、内容の変更のみを検出する。
+func computeChecksum(data []byte) (string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return "", err
+	}
+	delete(fields, "checksum")
+	canonical, err := json.Marshal(fields) // キーはソートされ、各値は空白を除いて出力される
+	if err != nil {
+		return "", err
+	}
+	h, err := hash.CalculateStream(bytes.NewReader(canonical), hash.AlgoSHA256)
+	if err != nil {
+		return "", err
+	}
+	return h.String(), nil
+}
+
+// verifyChecksum は data の内容が recorded の checksum と一致することを検証する
+func verifyChecksum(data []byte, recorded string) error {
+	actual, err := computeChecksum(data)
+	if err != nil {
+		return fmt.Errorf("failed to compute lock file checksum: %w", err)
+	}
+	if actual != recorded {
+		return &ChecksumError{Recorded: recorded, Actual: actual}
+	}
+	return nil
+}
+
+// Save は現在の LockFile の内容をファイルに書き込む。
+// 新規作成の場合 (LoadLockFile で読み込んでいない場合) は lockPath に書き込む。
+func (lf *LockFile) Save(lockPath string) error {
 	lf.mu.Lock() // 書き込み中はロック
 	defer lf.mu.Unlock()
 
 	if lf.path == "" { // 新規作成の場合
-		lf.path = filepath.Join(dirPath, LockFileName)
+		lf.path = lockPath
 	}
+	return lf.writeFile(lf.path)
+}
+
+// SaveAs は現在の LockFile の内容を path に書き込む。Save とは異なり、Lock ファイルのパスは変更しない。
+func (lf *LockFile) SaveAs(path string) error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	return lf.writeFile(path)
+}
 
-	lf.logger.Debug("Saving lock file", "path", lf.path)
-	data, err := json.MarshalIndent(lf, "", "  ") // 整形して出力
+// writeFile は LockFile の内容を path にアトミックに書き込む。呼び出し元は mu を保持していること。
+func (lf *LockFile) writeFile(path string) error {
+	lf.logger.Debug("Saving lock file", "path", path)
+	lf.Checksum = ""
+	data, err := lf.marshal()
+	if err == nil && lf.signed {
+		// checksum を除いた内容から checksum を計算し、記録して出力し直す
+		lf.Checksum, err = computeChecksum(data)
+		if err == nil {
+			data, err = lf.marshal()
+		}
+	}
 	if err != nil {
 		return fmt.Errorf("failed to marshal lock file data: %w", err)
 	}
 
 	// ファイルに書き込む (アトミックな書き込みを考慮すると、一時ファイル経由が良い)
-	tmpPath := lf.path + ".tmp"
+	tmpPath := path + ".tmp"
 	err = os.WriteFile(tmpPath, data, 0644)
 	if err != nil {
 		return fmt.Errorf("failed to write temporary lock file %s: %w", tmpPath, err)
 	}
 
 	// 一時ファイルをリネームしてアトミックに置き換え
-	err = os.Rename(tmpPath, lf.path)
+	err = os.Rename(tmpPath, path)
 	if err != nil {
 		// リネーム失敗した場合、一時ファイルを削除する試み
 		_ = os.Remove(tmpPath)
-		return fmt.Errorf("failed to rename temporary lock file to %s: %w", lf.path, err)
+		return fmt.Errorf("failed to rename temporary lock file to %s: %w", path, err)
 	}
 
-	lf.logger.Info("Lock file saved successfully", "path", lf.path)
+	lf.logger.Info("Lock file saved successfully", "path", path)
 	return nil
 }
 
-// GetHash は指定されたファイルIDと解決済みURLに対応するハッシュ値を取得する
-func (lf *LockFile) GetHash(fileID FileID, resolvedURL ResolvedURL) (*hash.Hash, error) {
+// marshal は保存形式に応じて LockFile を JSON に変換する
+func (lf *LockFile) marshal() ([]byte, error) {
+	if lf.dedup {
+		return lf.marshalDedup()
+	}
+	return json.MarshalIndent(lf, "", "  ") // 整形して出力
+}
+
+// GetHash は指定されたファイルIDと解決済みURLに対応する、指定アルゴリズムのハッシュ値を取得する
+func (lf *LockFile) GetHash(fileID model.FileID, resolvedURL model.ResolvedURL, algorithm hash.HashAlgorithm) (*hash.Hash, error) {
 	lf.mu.RLock() // 読み取りロック
 	defer lf.mu.RUnlock()
 
 	if fileLocks, ok := lf.Files[fileID]; !ok {
 		return nil, fmt.Errorf("file ID %s not found in lock file", fileID)
 	} else {
-		hash, ok := fileLocks[resolvedURL]
+		entry, ok := fileLocks[resolvedURL]
 		if !ok {
 			return nil, fmt.Errorf("hash not found for %s [%s]", fileID, resolvedURL)
 		}
+		hash := entry.Hash(algorithm)
+		if hash == nil {
+			if len(entry.Hashes) > 0 {
+				// 設定の hash_algorithm を変更した後に lock し直していない場合
+				locked := make([]string, 0, len(entry.Hashes))
+				for _, h := range entry.Hashes {
+					locked = append(locked, string(h.Algorithm))
+				}
+				return nil, &AlgorithmMismatchError{FileID: fileID, URL: resolvedURL, Configured: algorithm, Locked: locked}
+			}
+			return nil, fmt.Errorf("%s hash not found for %s [%s]", algorithm, fileID, resolvedURL)
+		}
 		return hash, nil
 	}
 }
 
-// SetHash はハッシュ値を設定する。既存の値があり、新しい値と異なる場合はエラーを返す。
-func (lf *LockFile) SetHash(fileID FileID, resolvedURL ResolvedURL, newHash *hash.Hash) error {
+// GetEntry は指定されたファイルIDと解決済みURLに対応する Entry を取得する
+func (lf *LockFile) GetEntry(fileID model.FileID, resolvedURL model.ResolvedURL) (*Entry, bool) {
+	lf.mu.RLock()
+	defer lf.mu.RUnlock()
+	entry, ok := lf.Files[fileID][resolvedURL]
+	return entry, ok
+}
+
+// URLs は fileID のエントリが記録されている URL をソートして返す
+func (lf *LockFile) URLs(fileID model.FileID) []model.ResolvedURL {
+	lf.mu.RLock()
+	defer lf.mu.RUnlock()
+	return slices.Sorted(maps.Keys(lf.Files[fileID]))
+}
+
+// CopyEntry は指定されたファイルIDと解決済みURLに対応する Entry のコピーを返す。記録されていない場合は nil を返す。
+// 他のゴルーチンが同じエントリを更新している可能性がある場合は GetEntry の代わりに使う。
+func (lf *LockFile) CopyEntry(fileID model.FileID, resolvedURL model.ResolvedURL) *Entry {
+	lf.mu.RLock()
+	defer lf.mu.RUnlock()
+	entry, ok := lf.Files[fileID][resolvedURL]
+	if !ok {
+		return nil
+	}
+	return entry.Copy()
+}
+
+// InconsistencyError は SetHash で記録済みのハッシュ値と異なる値を設定しようとした場合のエラー
+type InconsistencyError struct {
+	FileID   model.FileID
+	URL      model.ResolvedURL
+	Existing *hash.Hash
+	New      *hash.Hash
+}
+
+func (e *InconsistencyError) Error() string {
+	return fmt.Sprintf("hash inconsistency for %s [%s]: existing '%s', new '%s'", e.FileID, e.URL, e.Existing, e.New)
+}
+
+// AlgorithmMismatchError は GetHash で指定されたアルゴリズムのハッシュ値がなく、
+// 他のアルゴリズムのハッシュ値だけが記録されている場合のエラー
+type AlgorithmMismatchError struct {
+	FileID     model.FileID
+	URL        model.ResolvedURL
+	Configured hash.HashAlgorithm
+	Locked     []string // 記録されているアルゴリズム
+}
+
+func (e *AlgorithmMismatchError) Error() string {
+	return fmt.Sprintf("lock file was generated with a different hash algorithm for %s [%s] (locked: %s, configured: %s); re-run 'dltofu lock'",
+		e.FileID, e.URL, strings.Join(e.Locked, ", "), e.Configured)
+}
+
+// SetEntry は指定されたファイルIDと解決済みURLのエントリを entry のコピーで置き換える。
+// 既存の値との整合性は確認しないため、意図的に記録し直す場合 (update コマンド) にのみ使う。
+func (lf *LockFile) SetEntry(fileID model.FileID, resolvedURL model.ResolvedURL, entry *Entry) {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	if lf.Files[fileID] == nil {
+		lf.Files[fileID] = make(map[model.ResolvedURL]*Entry)
+	}
+	lf.Files[fileID][resolvedURL] = entry.Copy()
+}
+
+// SetHash はハッシュ値を設定する。同じアルゴリズムの既存の値があり、新しい値と異なる場合はエラーを返す。
+// 異なるアルゴリズムのハッシュ値は同じエントリに追加される。
+// エントリを新規作成した場合は first_seen と locked_at を、既存のエントリにハッシュ値を追加した場合は locked_at を現在時刻にする。
+func (lf *LockFile) SetHash(fileID model.FileID, resolvedURL model.ResolvedURL, newHash *hash.Hash) error {
 	lf.mu.Lock() // 書き込みロック
 	defer lf.mu.Unlock()
 
 	if lf.Files[fileID] == nil {
-		lf.Files[fileID] = make(map[ResolvedURL]*hash.Hash)
+		lf.Files[fileID] = make(map[model.ResolvedURL]*Entry)
 	}
 
-	existingHash, found := lf.Files[fileID][resolvedURL]
-	if found && !existingHash.Equal(newHash) {
+	now := time.Now().UTC().Truncate(time.Second) // RFC3339 で秒まで記録する
+	entry, found := lf.Files[fileID][resolvedURL]
+	if !found {
+		entry = NewEntry(newHash)
+		entry.FirstSeen, entry.LockedAt = &now, copyTime(&now)
+		lf.Files[fileID][resolvedURL] = entry
+		return nil
+	}
+	added, err := entry.addHash(newHash)
+	if err != nil {
 		// TOFU: 初回以降でハッシュが変わったらエラー
-		return fmt.Errorf("hash inconsistency for %s [%s]: existing '%s', new '%s'",
-			fileID, resolvedURL, existingHash, newHash)
+		return &InconsistencyError{FileID: fileID, URL: resolvedURL, Existing: entry.Hash(newHash.Algorithm), New: newHash}
+	}
+	if added {
+		entry.LockedAt = &now
+	}
+	return nil
+}
+
+// SetSize はファイルのバイト数を記録する。SetHash でエントリを作成した後に呼び出す必要がある。
+// 既に異なるバイト数が記録されている場合はエラーを返す。
+func (lf *LockFile) SetSize(fileID model.FileID, resolvedURL model.ResolvedURL, size int64) error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+
+	entry, found := lf.Files[fileID][resolvedURL]
+	if !found {
+		return fmt.Errorf("no lock entry for %s [%s]", fileID, resolvedURL)
+	}
+	if err := entry.setSize(size); err != nil {
+		return fmt.Errorf("size inconsistency for %s [%s]: %w", fileID, resolvedURL, err)
+	}
+	return nil
+}
+
+// GetValidators は次回の条件付きリクエストに使う ETag と Last-Modified を返す。記録されていない場合は空の値を返す。
+func (lf *LockFile) GetValidators(fileID model.FileID, resolvedURL model.ResolvedURL) Validators {
+	lf.mu.RLock()
+	defer lf.mu.RUnlock()
+	if v := lf.Validators[fileID][resolvedURL]; v != nil {
+		return *v
+	}
+	return Validators{}
+}
+
+// SetValidators は次回の条件付きリクエストに使う ETag と Last-Modified を記録する (両方空の場合は削除する)。
+// SetHash でエントリを作成した後に呼び出す必要がある。
+func (lf *LockFile) SetValidators(fileID model.FileID, resolvedURL model.ResolvedURL, v Validators) error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+
+	if _, found := lf.Files[fileID][resolvedURL]; !found {
+		return fmt.Errorf("no lock entry for %s [%s]", fileID, resolvedURL)
+	}
+	if v == (Validators{}) {
+		if validatorLocks, ok := lf.Validators[fileID]; ok {
+			delete(validatorLocks, resolvedURL)
+			if len(validatorLocks) == 0 {
+				delete(lf.Validators, fileID)
+			}
+		}
+		return nil
+	}
+	if lf.Validators == nil {
+		lf.Validators = make(map[model.FileID]map[model.ResolvedURL]*Validators)
+	}
+	if lf.Validators[fileID] == nil {
+		lf.Validators[fileID] = make(map[model.ResolvedURL]*Validators)
+	}
+	lf.Validators[fileID][resolvedURL] = &v
+	return nil
+}
+
+// GetTreeHash は指定されたファイルIDと解決済みURLに対応する TreeHash を取得する。
+// 記録されていない場合は nil を返す。
+func (lf *LockFile) GetTreeHash(fileID model.FileID, resolvedURL model.ResolvedURL) *hash.Hash {
+	lf.mu.RLock()
+	defer lf.mu.RUnlock()
+	return lf.Trees[fileID][resolvedURL]
+}
+
+// SetTreeHash は TreeHash を設定する。既存の値があり、新しい値と異なる場合 (アルゴリズムが異なる場合を含む) は
+// ファイルのハッシュ値と同様に TOFU の前提が崩れたものとして *InconsistencyError を返し、記録は変更しない。
+func (lf *LockFile) SetTreeHash(fileID model.FileID, resolvedURL model.ResolvedURL, newHash *hash.Hash) error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+
+	if lf.Trees == nil {
+		lf.Trees = make(map[model.FileID]map[model.ResolvedURL]*hash.Hash)
+	}
+	if lf.Trees[fileID] == nil {
+		lf.Trees[fileID] = make(map[model.ResolvedURL]*hash.Hash)
+	}
+
+	existingHash, found := lf.Trees[fileID][resolvedURL]
+	if found && !existingHash.Equal(newHash) {
+		return &InconsistencyError{FileID: fileID, URL: resolvedURL, Existing: existingHash, New: newHash}
 	}
 
-	// 新規またはハッシュが同じ場合は設定/上書き
-	lf.Files[fileID][resolvedURL] = newHash
+	lf.Trees[fileID][resolvedURL] = newHash
+	return nil
+}
+
+// UpgradeTreeHash は記録されている TreeHash が以前の形式で計算した legacy と一致する場合に、
+// 同じ展開結果の現在の形式の値 newHash に置き換えて true を返す。それ以外の場合は何もせず false を返す。
+func (lf *LockFile) UpgradeTreeHash(fileID model.FileID, resolvedURL model.ResolvedURL, legacy, newHash *hash.Hash) bool {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+
+	existingHash, found := lf.Trees[fileID][resolvedURL]
+	if !found || legacy == nil || !existingHash.Equal(legacy) || existingHash.Equal(newHash) {
+		return false
+	}
+	lf.Trees[fileID][resolvedURL] = newHash
+	return true
+}
+
+// GetMemberHashes は指定されたファイルIDと解決済みURLに対応するアーカイブのメンバーごとのハッシュ値を取得する。
+// 記録されていない場合は nil を返す。
+func (lf *LockFile) GetMemberHashes(fileID model.FileID, resolvedURL model.ResolvedURL) map[string]*hash.Hash {
+	lf.mu.RLock()
+	defer lf.mu.RUnlock()
+	return lf.Members[fileID][resolvedURL]
+}
+
+// SetMemberHashes はアーカイブのメンバーごとのハッシュ値を設定する。
+// 同じアルゴリズムの既存の値があり、新しい値と異なるメンバーがある場合はエラーを返す。
+func (lf *LockFile) SetMemberHashes(fileID model.FileID, resolvedURL model.ResolvedURL, newMembers map[string]*hash.Hash) error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+
+	if lf.Members == nil {
+		lf.Members = make(map[model.FileID]map[model.ResolvedURL]map[string]*hash.Hash)
+	}
+	if lf.Members[fileID] == nil {
+		lf.Members[fileID] = make(map[model.ResolvedURL]map[string]*hash.Hash)
+	}
+
+	for relPath, existingHash := range lf.Members[fileID][resolvedURL] {
+		newHash, found := newMembers[relPath]
+		if found && existingHash.Algorithm == newHash.Algorithm && !existingHash.Equal(newHash) {
+			return fmt.Errorf("member hash inconsistency for %s [%s] %s: existing '%s', new '%s'",
+				fileID, resolvedURL, relPath, existingHash, newHash)
+		}
+	}
+
+	lf.Members[fileID][resolvedURL] = newMembers
+	return nil
+}
+
+// copyMembers はメンバーごとのハッシュ値のディープコピーを返す
+func copyMembers(members map[string]*hash.Hash) map[string]*hash.Hash {
+	copied := make(map[string]*hash.Hash, len(members))
+	for relPath, h := range members {
+		copied[relPath] = h.Copy()
+	}
+	return copied
+}
+
+// GetChunkHashes は指定されたファイルIDと解決済みURLに対応するチャンクハッシュを取得する。
+// 記録されていない場合は nil を返す。
+func (lf *LockFile) GetChunkHashes(fileID model.FileID, resolvedURL model.ResolvedURL) *hash.ChunkHashes {
+	lf.mu.RLock()
+	defer lf.mu.RUnlock()
+	return lf.Chunks[fileID][resolvedURL]
+}
+
+// SetChunkHashes はチャンクハッシュを設定する。
+// 同じチャンクサイズとアルゴリズムの既存の値があり、新しい値と異なる場合はエラーを返す。
+func (lf *LockFile) SetChunkHashes(fileID model.FileID, resolvedURL model.ResolvedURL, newChunks *hash.ChunkHashes) error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+
+	if lf.Chunks == nil {
+		lf.Chunks = make(map[model.FileID]map[model.ResolvedURL]*hash.ChunkHashes)
+	}
+	if lf.Chunks[fileID] == nil {
+		lf.Chunks[fileID] = make(map[model.ResolvedURL]*hash.ChunkHashes)
+	}
+
+	existing, found := lf.Chunks[fileID][resolvedURL]
+	if found && existing.ChunkSize == newChunks.ChunkSize && existing.Root.Algorithm == newChunks.Root.Algorithm && !existing.Equal(newChunks) {
+		return fmt.Errorf("chunk hash inconsistency for %s [%s]: existing root '%s', new root '%s'",
+			fileID, resolvedURL, existing.Root, newChunks.Root)
+	}
+
+	lf.Chunks[fileID][resolvedURL] = newChunks
 	return nil
 }
 
 // RemoveEntry は指定されたファイルIDのエントリ全体を削除する
-func (lf *LockFile) RemoveEntry(fileID FileID) {
+func (lf *LockFile) RemoveEntry(fileID model.FileID) {
 	lf.mu.Lock()
 	defer lf.mu.Unlock()
 	delete(lf.Files, fileID)
+	delete(lf.Trees, fileID)
+	delete(lf.Chunks, fileID)
+	delete(lf.Members, fileID)
+	delete(lf.Descriptions, fileID)
+	delete(lf.Validators, fileID)
+}
+
+// SetDescription はファイルIDの説明を記録する。description が空の場合は記録を削除する。
+// 説明はハッシュ値のエントリとは独立して保持され、SetHash などによる更新では変わらない。
+func (lf *LockFile) SetDescription(fileID model.FileID, description string) {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	if description == "" {
+		delete(lf.Descriptions, fileID)
+		return
+	}
+	if lf.Descriptions == nil {
+		lf.Descriptions = make(map[model.FileID]string)
+	}
+	lf.Descriptions[fileID] = description
 }
 
 // RemoveURL は特定のURLエントリを削除する
-func (lf *LockFile) RemoveURL(fileID FileID, resolvedURL ResolvedURL) {
+func (lf *LockFile) RemoveURL(fileID model.FileID, resolvedURL model.ResolvedURL) {
 	lf.mu.Lock()
 	defer lf.mu.Unlock()
+	if treeLocks, ok := lf.Trees[fileID]; ok {
+		delete(treeLocks, resolvedURL)
+	}
+	if chunkLocks, ok := lf.Chunks[fileID]; ok {
+		delete(chunkLocks, resolvedURL)
+	}
+	if memberLocks, ok := lf.Members[fileID]; ok {
+		delete(memberLocks, resolvedURL)
+	}
+	if validatorLocks, ok := lf.Validators[fileID]; ok {
+		delete(validatorLocks, resolvedURL)
+	}
 	if fileLocks, ok := lf.Files[fileID]; ok {
 		delete(fileLocks, resolvedURL)
 		// fileID のマップが空になったら fileID 自体も削除する？ -> しても良いが見やすさのため残す
@@ -192,18 +765,18 @@ func (lf *LockFile) RemoveURL(fileID FileID, resolvedURL ResolvedURL) {
 
 // Prune は設定ファイルに存在するファイルIDとURLのみをLockファイルに残し、他を削除する
 // activeFiles: map[fileID]map[resolvedURL]struct{}
-func (lf *LockFile) Prune(activeFiles map[FileID]map[ResolvedURL]struct{}) {
+func (lf *LockFile) Prune(activeFiles map[model.FileID]map[model.ResolvedURL]struct{}) {
 	lf.mu.Lock()
 	defer lf.mu.Unlock()
 
-	prunedFiles := make(map[FileID]map[ResolvedURL]*hash.Hash)
+	prunedFiles := make(map[model.FileID]map[model.ResolvedURL]*Entry)
 
 	for fileID, activeURLs := range activeFiles {
 		if existingURLs, ok := lf.Files[fileID]; ok {
-			prunedURLs := make(map[ResolvedURL]*hash.Hash)
-			for url, hashVal := range existingURLs {
+			prunedURLs := make(map[model.ResolvedURL]*Entry)
+			for url, entry := range existingURLs {
 				if _, isActive := activeURLs[url]; isActive {
-					prunedURLs[url] = hashVal // アクティブなURLのみ保持
+					prunedURLs[url] = entry // アクティブなURLのみ保持
 				} else {
 					lf.logger.Debug("Pruning inactive URL from lock file", "file_id", fileID, "url", url)
 				}
@@ -216,4 +789,139 @@ func (lf *LockFile) Prune(activeFiles map[FileID]map[ResolvedURL]struct{}) {
 		}
 	}
 	lf.Files = prunedFiles // Prune 後のマップで置き換える
+
+	// TreeHash も同様に Files に残ったエントリのみ保持する
+	if lf.Trees != nil {
+		prunedTrees := make(map[model.FileID]map[model.ResolvedURL]*hash.Hash)
+		for fileID, treeLocks := range lf.Trees {
+			for url, hashVal := range treeLocks {
+				if _, ok := prunedFiles[fileID][url]; !ok {
+					continue
+				}
+				if prunedTrees[fileID] == nil {
+					prunedTrees[fileID] = make(map[model.ResolvedURL]*hash.Hash)
+				}
+				prunedTrees[fileID][url] = hashVal
+			}
+		}
+		lf.Trees = prunedTrees
+	}
+	if lf.Chunks != nil {
+		prunedChunks := make(map[model.FileID]map[model.ResolvedURL]*hash.ChunkHashes)
+		for fileID, chunkLocks := range lf.Chunks {
+			for url, chunks := range chunkLocks {
+				if _, ok := prunedFiles[fileID][url]; !ok {
+					continue
+				}
+				if prunedChunks[fileID] == nil {
+					prunedChunks[fileID] = make(map[model.ResolvedURL]*hash.ChunkHashes)
+				}
+				prunedChunks[fileID][url] = chunks
+			}
+		}
+		lf.Chunks = prunedChunks
+	}
+	if lf.Members != nil {
+		prunedMembers := make(map[model.FileID]map[model.ResolvedURL]map[string]*hash.Hash)
+		for fileID, memberLocks := range lf.Members {
+			for url, members := range memberLocks {
+				if _, ok := prunedFiles[fileID][url]; !ok {
+					continue
+				}
+				if prunedMembers[fileID] == nil {
+					prunedMembers[fileID] = make(map[model.ResolvedURL]map[string]*hash.Hash)
+				}
+				prunedMembers[fileID][url] = members
+			}
+		}
+		lf.Members = prunedMembers
+	}
+	if lf.Validators != nil {
+		prunedValidators := make(map[model.FileID]map[model.ResolvedURL]*Validators)
+		for fileID, validatorLocks := range lf.Validators {
+			for url, v := range validatorLocks {
+				if _, ok := prunedFiles[fileID][url]; !ok {
+					continue
+				}
+				if prunedValidators[fileID] == nil {
+					prunedValidators[fileID] = make(map[model.ResolvedURL]*Validators)
+				}
+				prunedValidators[fileID][url] = v
+			}
+		}
+		lf.Validators = prunedValidators
+	}
+	// 説明も Files に残ったファイルIDのみ保持する
+	for fileID := range lf.Descriptions {
+		if _, ok := prunedFiles[fileID]; !ok {
+			delete(lf.Descriptions, fileID)
+		}
+	}
+}
+
+// URLConflict は複数のファイルIDで同じ URL に互いに矛盾するハッシュ値が記録されていることを示す。
+// 同じ URL の内容は1つなので、設定の誤り (コピー&ペーストのミスなど) の可能性が高い。
+type URLConflict struct {
+	URL     model.ResolvedURL
+	FileIDs []model.FileID // URL を記録しているファイルID (ソート済み)
+	Reason  string
+}
+
+func (c URLConflict) String() string {
+	ids := make([]string, len(c.FileIDs))
+	for i, id := range c.FileIDs {
+		ids[i] = string(id)
+	}
+	return fmt.Sprintf("%s (file IDs: %s): %s", c.URL, strings.Join(ids, ", "), c.Reason)
+}
+
+// URLConflicts は複数のファイルIDに記録された同じ URL のエントリを比較し、
+// 同じアルゴリズムで異なるハッシュ値が記録されている場合や、共通のアルゴリズムがなく比較できない場合を URL の順に返す。
+func (lf *LockFile) URLConflicts() []URLConflict {
+	lf.mu.RLock()
+	defer lf.mu.RUnlock()
+
+	byURL := make(map[model.ResolvedURL][]model.FileID)
+	for fileID, fileLocks := range lf.Files {
+		for url := range fileLocks {
+			byURL[url] = append(byURL[url], fileID)
+		}
+	}
+
+	var conflicts []URLConflict
+	for _, url := range slices.Sorted(maps.Keys(byURL)) {
+		fileIDs := byURL[url]
+		if len(fileIDs) < 2 {
+			continue
+		}
+		slices.Sort(fileIDs)
+		if reason := entriesConflict(url, fileIDs, lf.Files); reason != "" {
+			conflicts = append(conflicts, URLConflict{URL: url, FileIDs: fileIDs, Reason: reason})
+		}
+	}
+	return conflicts
+}
+
+// entriesConflict は fileIDs に記録された url のエントリを2つずつ比較し、最初に見つかった矛盾の説明を返す。矛盾がない場合は空文字列を返す。
+func entriesConflict(url model.ResolvedURL, fileIDs []model.FileID, files map[model.FileID]map[model.ResolvedURL]*Entry) string {
+	for i, a := range fileIDs {
+		for _, b := range fileIDs[i+1:] {
+			entryA, entryB := files[a][url], files[b][url]
+			common := false
+			for _, hashA := range entryA.Hashes {
+				hashB := entryB.Hash(hashA.Algorithm)
+				if hashB == nil {
+					continue
+				}
+				common = true
+				if !hashA.Equal(hashB) {
+					return fmt.Sprintf("%s has %s but %s has %s", a, hashA, b, hashB)
+				}
+			}
+			if !common {
+				return fmt.Sprintf("%s and %s are locked with different hash algorithms (%s vs %s)", a, b, entryA.algorithms(), entryB.algorithms())
+			}
+		}
+	}
+	return ""
 }
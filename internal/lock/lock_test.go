@@ -0,0 +1,255 @@
+package lock
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hrko/dltofu/internal/hash"
+	"github.com/hrko/dltofu/internal/model"
+)
+
+func TestSetTreeHash(t *testing.T) {
+	const url = model.ResolvedURL("https://example.com/tool.tar.gz")
+	locked := hash.NewHash(hash.AlgoSHA256, []byte{0x01})
+	tests := []struct {
+		name     string
+		existing *hash.Hash
+		newHash  *hash.Hash
+		wantErr  bool
+	}{
+		{name: "first use", newHash: locked},
+		{name: "same hash", existing: locked, newHash: hash.NewHash(hash.AlgoSHA256, []byte{0x01})},
+		{name: "different hash", existing: locked, newHash: hash.NewHash(hash.AlgoSHA256, []byte{0x02}), wantErr: true},
+		{name: "different algorithm", existing: locked, newHash: hash.NewHash(hash.AlgoSHA512, []byte{0x01}), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lf := NewLockFile(nil)
+			if tt.existing != nil {
+				if err := lf.SetTreeHash("tool", url, tt.existing); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := lf.SetTreeHash("tool", url, tt.newHash)
+			var mismatch *InconsistencyError
+			if got := errors.As(err, &mismatch); got != tt.wantErr {
+				t.Fatalf("SetTreeHash() error = %v, want InconsistencyError %v", err, tt.wantErr)
+			}
+			want := tt.newHash
+			if tt.wantErr {
+				want = tt.existing // 不一致の場合は記録を変更しない
+			}
+			if got := lf.GetTreeHash("tool", url); !got.Equal(want) {
+				t.Errorf("GetTreeHash() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestUpgradeTreeHash(t *testing.T) {
+	const url = model.ResolvedURL("https://example.com/tool.tar.gz")
+	legacy := hash.NewHash(hash.AlgoSHA256, []byte{0x01})
+	current := hash.NewHash(hash.AlgoSHA256, []byte{0x02})
+	other := hash.NewHash(hash.AlgoSHA256, []byte{0x03})
+	tests := []struct {
+		name     string
+		existing *hash.Hash
+		want     bool
+		wantHash *hash.Hash
+	}{
+		{name: "recorded in legacy format", existing: legacy, want: true, wantHash: current},
+		{name: "already current", existing: current, want: false, wantHash: current},
+		{name: "different content", existing: other, want: false, wantHash: other},
+		{name: "not recorded", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lf := NewLockFile(nil)
+			if tt.existing != nil {
+				if err := lf.SetTreeHash("tool", url, tt.existing); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := lf.UpgradeTreeHash("tool", url, legacy, current); got != tt.want {
+				t.Errorf("UpgradeTreeHash() = %v, want %v", got, tt.want)
+			}
+			if got := lf.GetTreeHash("tool", url); (got == nil) != (tt.wantHash == nil) || (got != nil && !got.Equal(tt.wantHash)) {
+				t.Errorf("GetTreeHash() = %v, want %v", got, tt.wantHash)
+			}
+		})
+	}
+}
+
+func TestSameContent(t *testing.T) {
+	const url = model.ResolvedURL("https://example.com/tool.tar.gz")
+	h1 := hash.NewHash(hash.AlgoSHA256, []byte{0x01})
+	h2 := hash.NewHash(hash.AlgoSHA256, []byte{0x02})
+	t1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := time.Now() // モノトニック時計の値を含む
+
+	base := func() *LockFile {
+		lf := NewLockFile(nil)
+		e := NewEntry(h1)
+		e.Size, e.FirstSeen, e.LockedAt = 10, &t1, &t1
+		lf.Files["tool"] = map[model.ResolvedURL]*Entry{url: e}
+		return lf
+	}
+	tests := []struct {
+		name   string
+		modify func(lf *LockFile)
+		want   bool
+	}{
+		{name: "unchanged", modify: func(lf *LockFile) {}, want: true},
+		{name: "first_seen changed", modify: func(lf *LockFile) { lf.Files["tool"][url].FirstSeen = &t2 }, want: true},
+		{name: "locked_at removed", modify: func(lf *LockFile) { lf.Files["tool"][url].LockedAt = nil }, want: true},
+		{name: "validators added", modify: func(lf *LockFile) { _ = lf.SetValidators("tool", url, Validators{ETag: `"v1"`}) }, want: true},
+		{name: "hash added", modify: func(lf *LockFile) { lf.Files["tool"][url] = NewEntry(h1, hash.NewHash(hash.AlgoSHA512, []byte{0x03})) }, want: false},
+		{name: "hash changed", modify: func(lf *LockFile) { lf.Files["tool"][url].Hashes = []*hash.Hash{h2} }, want: false},
+		{name: "size changed", modify: func(lf *LockFile) { lf.Files["tool"][url].Size = 11 }, want: false},
+		{name: "url added", modify: func(lf *LockFile) { lf.Files["tool"][url+"?v2"] = NewEntry(h1) }, want: false},
+		{name: "file removed", modify: func(lf *LockFile) { delete(lf.Files, "tool") }, want: false},
+		{name: "tree added", modify: func(lf *LockFile) { _ = lf.SetTreeHash("tool", url, h2) }, want: false},
+		{name: "description changed", modify: func(lf *LockFile) { lf.Descriptions = map[model.FileID]string{"tool": "x"} }, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			existing, updated := base(), base()
+			tt.modify(updated)
+			if got := existing.SameContent(updated); got != tt.want {
+				t.Errorf("SameContent() = %v, want %v", got, tt.want)
+			}
+			if got := updated.SameContent(existing); got != tt.want {
+				t.Errorf("SameContent() (reversed) = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSameValidators(t *testing.T) {
+	const url = model.ResolvedURL("https://example.com/tool")
+	base := func() *LockFile {
+		lf := NewLockFile(nil)
+		lf.Files["tool"] = map[model.ResolvedURL]*Entry{url: NewEntry(hash.NewHash(hash.AlgoSHA256, []byte{0x01}))}
+		lf.Files["other"] = map[model.ResolvedURL]*Entry{url: NewEntry(hash.NewHash(hash.AlgoSHA256, []byte{0x01}))}
+		_ = lf.SetValidators("tool", url, Validators{ETag: `"v1"`, LastModified: "Mon, 01 Jan 2024 00:00:00 GMT"})
+		return lf
+	}
+	tests := []struct {
+		name   string
+		modify func(lf *LockFile)
+		want   bool
+	}{
+		{name: "unchanged", modify: func(lf *LockFile) {}, want: true},
+		{name: "hash changed", modify: func(lf *LockFile) { lf.Files["tool"][url] = NewEntry(hash.NewHash(hash.AlgoSHA256, []byte{0x02})) }, want: true},
+		{name: "etag changed", modify: func(lf *LockFile) {
+			_ = lf.SetValidators("tool", url, Validators{ETag: `"v2"`, LastModified: "Mon, 01 Jan 2024 00:00:00 GMT"})
+		}, want: false},
+		{name: "last-modified changed", modify: func(lf *LockFile) { _ = lf.SetValidators("tool", url, Validators{ETag: `"v1"`}) }, want: false},
+		{name: "removed", modify: func(lf *LockFile) { _ = lf.SetValidators("tool", url, Validators{}) }, want: false},
+		{name: "added for another file", modify: func(lf *LockFile) { _ = lf.SetValidators("other", url, Validators{ETag: `"v1"`}) }, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			existing, updated := base(), base()
+			tt.modify(updated)
+			if got := existing.SameValidators(updated); got != tt.want {
+				t.Errorf("SameValidators() = %v, want %v", got, tt.want)
+			}
+			if got := updated.SameValidators(existing); got != tt.want {
+				t.Errorf("SameValidators() (reversed) = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadLockFileVersions(t *testing.T) {
+	const url = model.ResolvedURL("https://example.com/tool")
+	want := hash.NewHash(hash.AlgoSHA256, []byte{0xab, 0xcd})
+	tests := []struct {
+		name         string
+		content      string
+		wantMigrated bool
+		wantErr      []string // エラーメッセージに含まれるべき文字列 (空ならエラーにならない)
+	}{
+		{
+			name:         "version 1 is migrated",
+			content:      `{"version": 1, "files": {"tool": {"https://example.com/tool": "sha256:abcd"}}}`,
+			wantMigrated: true,
+		},
+		{
+			name:    "current version",
+			content: `{"version": 2, "files": {"tool": {"https://example.com/tool": {"hashes": ["sha256:abcd"]}}}}`,
+		},
+		{
+			name:    "newer version",
+			content: `{"version": 99, "files": {}}`,
+			wantErr: []string{"version 99", "newer than this dltofu supports (up to 3)", "upgrade dltofu"},
+		},
+		{
+			name:    "next version",
+			content: `{"version": 4, "files": {}}`,
+			wantErr: []string{"version 4", "upgrade dltofu"},
+		},
+		{
+			name:    "missing version",
+			content: `{"files": {}}`,
+			wantErr: []string{"unsupported version 0", "supported: 1-3", "run 'dltofu lock' to regenerate"},
+		},
+		{
+			name:    "negative version",
+			content: `{"version": -1, "files": {}}`,
+			wantErr: []string{"unsupported version -1", "regenerate"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(t.TempDir(), LockFileName)
+			if err := os.WriteFile(p, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			lf, err := LoadLockFile(p, nil)
+			if len(tt.wantErr) > 0 {
+				if err == nil {
+					t.Fatal("LoadLockFile() succeeded, want an error")
+				}
+				for _, s := range tt.wantErr {
+					if !strings.Contains(err.Error(), s) {
+						t.Errorf("LoadLockFile() error = %v, want it to contain %q", err, s)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadLockFile() error = %v", err)
+			}
+			if lf.Migrated() != tt.wantMigrated {
+				t.Errorf("Migrated() = %v, want %v", lf.Migrated(), tt.wantMigrated)
+			}
+			if lf.Version != LockFileVersion {
+				t.Errorf("Version = %d, want %d", lf.Version, LockFileVersion)
+			}
+			got, err := lf.GetHash("tool", url, hash.AlgoSHA256)
+			if err != nil || !got.Equal(want) {
+				t.Errorf("GetHash() = %v, %v, want %v", got, err, want)
+			}
+			if !tt.wantMigrated {
+				return
+			}
+			// 保存すると現在の形式で書き出され、次回からは移行されない
+			if err := lf.Save(p); err != nil {
+				t.Fatal(err)
+			}
+			saved, err := LoadLockFile(p, nil)
+			if err != nil {
+				t.Fatalf("LoadLockFile() after Save error = %v", err)
+			}
+			if saved.Migrated() || !saved.SameContent(lf) {
+				t.Errorf("re-loaded lock file: Migrated() = %v, SameContent() = %v", saved.Migrated(), saved.SameContent(lf))
+			}
+		})
+	}
+}
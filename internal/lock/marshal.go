@@ -0,0 +1,119 @@
+package lock
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/hrko/dltofu/internal/hash"
+	"github.com/hrko/dltofu/internal/model"
+)
+
+// sortedObject はキーの昇順に出力される JSON オブジェクト。
+// encoding/json も map のキーをソートして出力するが、Lock ファイルの差分が最小になるよう出力順をここで明示的に固定する。
+type sortedObject[K ~string, V any] map[K]V
+
+func (m sortedObject[K, V]) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range slices.Sorted(maps.Keys(m)) {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		keyJSON, err := json.Marshal(string(key))
+		if err != nil {
+			return nil, err
+		}
+		valueJSON, err := json.Marshal(m[key])
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal value for key %s: %w", key, err)
+		}
+		buf.Write(keyJSON)
+		buf.WriteByte(':')
+		buf.Write(valueJSON)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// lockFileJSON は通常形式の Lock ファイルの JSON 表現。フィールドは宣言順、各マップはキーの昇順に出力される。
+type lockFileJSON struct {
+	Version      int                                                                                           `json:"version"`
+	Files        sortedObject[model.FileID, sortedObject[model.ResolvedURL, *Entry]]                           `json:"files"`
+	Trees        sortedObject[model.FileID, sortedObject[model.ResolvedURL, *hash.Hash]]                       `json:"trees,omitempty"`
+	Chunks       sortedObject[model.FileID, sortedObject[model.ResolvedURL, *hash.ChunkHashes]]                `json:"chunks,omitempty"`
+	Members      sortedObject[model.FileID, sortedObject[model.ResolvedURL, sortedObject[string, *hash.Hash]]] `json:"members,omitempty"`
+	Descriptions sortedObject[model.FileID, string]                                                            `json:"descriptions,omitempty"`
+	Validators   sortedObject[model.FileID, sortedObject[model.ResolvedURL, *Validators]]                      `json:"validators,omitempty"`
+	Checksum     string                                                                                        `json:"checksum,omitempty"`
+}
+
+// MarshalJSON は Lock ファイルを決定的な順序 (ファイルID、URL、展開先のパスの昇順、ハッシュ値はアルゴリズム名の順) で JSON に変換する。
+// 同じ内容からは常に同じバイト列が得られるため、Lock ファイルの差分は実際の変更のみとなる。
+func (lf *LockFile) MarshalJSON() ([]byte, error) {
+	out := lockFileJSON{
+		Version:      lf.Version,
+		Files:        make(sortedObject[model.FileID, sortedObject[model.ResolvedURL, *Entry]], len(lf.Files)),
+		Trees:        sortedNested(lf.Trees),
+		Chunks:       sortedNested(lf.Chunks),
+		Members:      sortedMembers(lf.Members),
+		Descriptions: lf.Descriptions,
+		Validators:   sortedNested(lf.Validators),
+		Checksum:     lf.Checksum,
+	}
+	for fileID, fileLocks := range lf.Files {
+		entries := make(sortedObject[model.ResolvedURL, *Entry], len(fileLocks))
+		for url, entry := range fileLocks {
+			entries[url] = entry.normalized()
+		}
+		out.Files[fileID] = entries
+	}
+	return json.Marshal(out)
+}
+
+// sortedNested はファイルIDと URL をキーとするマップを、キーの昇順に出力されるマップに変換する。m が nil の場合は nil を返す。
+func sortedNested[V any](m map[model.FileID]map[model.ResolvedURL]V) sortedObject[model.FileID, sortedObject[model.ResolvedURL, V]] {
+	if m == nil {
+		return nil
+	}
+	out := make(sortedObject[model.FileID, sortedObject[model.ResolvedURL, V]], len(m))
+	for fileID, inner := range m {
+		out[fileID] = sortedObject[model.ResolvedURL, V](inner)
+	}
+	return out
+}
+
+// sortedMembers は Members を、展開先のパスを含めてキーの昇順に出力されるマップに変換する
+func sortedMembers(m map[model.FileID]map[model.ResolvedURL]map[string]*hash.Hash) sortedObject[model.FileID, sortedObject[model.ResolvedURL, sortedObject[string, *hash.Hash]]] {
+	if m == nil {
+		return nil
+	}
+	out := make(sortedObject[model.FileID, sortedObject[model.ResolvedURL, sortedObject[string, *hash.Hash]]], len(m))
+	for fileID, memberLocks := range m {
+		inner := make(sortedObject[model.ResolvedURL, sortedObject[string, *hash.Hash]], len(memberLocks))
+		for url, members := range memberLocks {
+			inner[url] = sortedObject[string, *hash.Hash](members)
+		}
+		out[fileID] = inner
+	}
+	return out
+}
+
+// normalized はハッシュ値をアルゴリズム名の順に並べた Entry を返す。
+// 手で編集された Lock ファイルなどで順序が異なる場合のみコピーを作成する。
+func (e *Entry) normalized() *Entry {
+	if e == nil || slices.IsSortedFunc(e.Hashes, compareAlgorithm) {
+		return e
+	}
+	sorted := e.Copy()
+	slices.SortFunc(sorted.Hashes, compareAlgorithm)
+	return sorted
+}
+
+// compareAlgorithm はハッシュ値をアルゴリズム名で比較する
+func compareAlgorithm(a, b *hash.Hash) int {
+	return cmp.Compare(a.Algorithm, b.Algorithm)
+}
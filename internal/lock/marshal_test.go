@@ -0,0 +1,135 @@
+package lock
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/hrko/dltofu/internal/hash"
+	"github.com/hrko/dltofu/internal/model"
+)
+
+func TestMarshalIsDeterministic(t *testing.T) {
+	seen := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	sha256 := hash.NewHash(hash.AlgoSHA256, []byte{0x01})
+	sha512 := hash.NewHash(hash.AlgoSHA512, []byte{0x02})
+	blake3 := hash.NewHash(hash.AlgoBLAKE3, []byte{0x03})
+
+	// record は LockFile に1件の情報を記録する操作
+	type record func(t *testing.T, lf *LockFile)
+	entry := func(fileID model.FileID, url model.ResolvedURL, hashes ...*hash.Hash) record {
+		return func(t *testing.T, lf *LockFile) {
+			// SetHash は現在時刻を記録するため、時刻を固定した Entry を直接設定する
+			lf.SetEntry(fileID, url, &Entry{Hashes: hashes, Size: 10, FirstSeen: &seen, LockedAt: &seen})
+		}
+	}
+	members := func(fileID model.FileID, url model.ResolvedURL, paths ...string) record {
+		return func(t *testing.T, lf *LockFile) {
+			m := make(map[string]*hash.Hash, len(paths))
+			for _, p := range paths {
+				m[p] = sha256
+			}
+			if err := lf.SetMemberHashes(fileID, url, m); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	validators := func(fileID model.FileID, url model.ResolvedURL, etag string) record {
+		return func(t *testing.T, lf *LockFile) {
+			if err := lf.SetValidators(fileID, url, Validators{ETag: etag}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	description := func(fileID model.FileID, text string) record {
+		return func(t *testing.T, lf *LockFile) { lf.SetDescription(fileID, text) }
+	}
+
+	tests := []struct {
+		name    string
+		records []record
+		other   []record // records と同じ内容を別の順序や表現で記録したもの (nil なら records を逆順に記録する)
+	}{
+		{
+			name: "files and urls",
+			records: []record{
+				entry("zeta", "https://example.com/zeta-linux", sha256),
+				entry("zeta", "https://example.com/zeta-darwin", sha256),
+				entry("alpha", "https://example.com/alpha", sha256, sha512),
+				entry("mid", "https://example.com/mid", blake3),
+			},
+		},
+		{
+			name: "all sections",
+			records: []record{
+				entry("tool", "https://example.com/tool.tar.gz", sha256),
+				entry("lib", "https://example.com/lib.zip", sha256),
+				members("tool", "https://example.com/tool.tar.gz", "bin/tool", "README", "LICENSE"),
+				members("lib", "https://example.com/lib.zip", "lib/b.so", "lib/a.so"),
+				validators("tool", "https://example.com/tool.tar.gz", `"v1"`),
+				validators("lib", "https://example.com/lib.zip", `"v2"`),
+				description("tool", "the tool"),
+				description("lib", "the library"),
+			},
+			// Validators はエントリの記録後にしか設定できないため、種類ごとに逆順にする
+			other: []record{
+				entry("lib", "https://example.com/lib.zip", sha256),
+				entry("tool", "https://example.com/tool.tar.gz", sha256),
+				description("lib", "the library"),
+				description("tool", "the tool"),
+				validators("lib", "https://example.com/lib.zip", `"v2"`),
+				validators("tool", "https://example.com/tool.tar.gz", `"v1"`),
+				members("lib", "https://example.com/lib.zip", "lib/a.so", "lib/b.so"),
+				members("tool", "https://example.com/tool.tar.gz", "LICENSE", "README", "bin/tool"),
+			},
+		},
+		{
+			name:    "hash order",
+			records: []record{entry("tool", "https://example.com/tool", sha256, sha512, blake3)},
+			other:   []record{entry("tool", "https://example.com/tool", blake3, sha512, sha256)},
+		},
+	}
+	for _, tt := range tests {
+		other := tt.other
+		if other == nil {
+			other = slices.Clone(tt.records)
+			slices.Reverse(other)
+		}
+		for _, dedup := range []bool{false, true} {
+			name := tt.name
+			if dedup {
+				name += " dedup"
+			}
+			t.Run(name, func(t *testing.T) {
+				build := func(records []record) *LockFile {
+					lf := NewLockFile(nil)
+					lf.SetDedup(dedup)
+					for _, r := range records {
+						r(t, lf)
+					}
+					return lf
+				}
+				lf := build(tt.records)
+				first, err := lf.marshal()
+				if err != nil {
+					t.Fatalf("marshal() error = %v", err)
+				}
+				second, err := lf.marshal()
+				if err != nil {
+					t.Fatalf("marshal() error = %v", err)
+				}
+				if !bytes.Equal(first, second) {
+					t.Errorf("marshal() is not stable:\n%s\n---\n%s", first, second)
+				}
+				reordered, err := build(other).marshal()
+				if err != nil {
+					t.Fatalf("marshal() error = %v", err)
+				}
+				if !bytes.Equal(first, reordered) {
+					t.Errorf("marshal() depends on insertion order:\n%s\n---\n%s", first, reordered)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics は1回の実行に関する統計情報を集計する。複数のゴルーチンから安全に使用できる。
+type Metrics struct {
+	start       time.Time
+	bytes       atomic.Int64
+	cacheHits   atomic.Int64
+	cacheMisses atomic.Int64
+
+	mu       sync.Mutex
+	requests map[string]int // key: host
+}
+
+// Summary は集計結果
+type Summary struct {
+	BytesDownloaded int64          `json:"bytes_downloaded"`
+	DurationSeconds float64        `json:"duration_seconds"`
+	Requests        int            `json:"requests"`
+	RequestsPerHost map[string]int `json:"requests_per_host"`
+	CacheHits       int64          `json:"cache_hits"`
+	CacheMisses     int64          `json:"cache_misses"`
+}
+
+// New は計測を開始した Metrics を作成する
+func New() *Metrics {
+	return &Metrics{
+		start:    time.Now(),
+		requests: make(map[string]int),
+	}
+}
+
+// AddBytes はダウンロードしたバイト数を加算する
+func (m *Metrics) AddBytes(n int64) {
+	m.bytes.Add(n)
+}
+
+// AddRequest は host へのリクエスト数を加算する
+func (m *Metrics) AddRequest(host string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[host]++
+}
+
+// CacheHit はキャッシュヒット数を加算する
+func (m *Metrics) CacheHit() {
+	m.cacheHits.Add(1)
+}
+
+// CacheMiss はキャッシュミス数を加算する
+func (m *Metrics) CacheMiss() {
+	m.cacheMisses.Add(1)
+}
+
+// Summary は現時点での集計結果を返す
+func (m *Metrics) Summary() Summary {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	perHost := make(map[string]int, len(m.requests))
+	total := 0
+	for host, count := range m.requests {
+		perHost[host] = count
+		total += count
+	}
+	return Summary{
+		BytesDownloaded: m.bytes.Load(),
+		DurationSeconds: time.Since(m.start).Seconds(),
+		Requests:        total,
+		RequestsPerHost: perHost,
+		CacheHits:       m.cacheHits.Load(),
+		CacheMisses:     m.cacheMisses.Load(),
+	}
+}
@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMetricsConcurrent(t *testing.T) {
+	m := New()
+	hosts := []string{"a.example.com", "b.example.com"}
+	var wg sync.WaitGroup
+	for i := range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.AddBytes(10)
+			m.AddRequest(hosts[i%len(hosts)])
+			if i%4 == 0 {
+				m.CacheHit()
+			} else {
+				m.CacheMiss()
+			}
+		}()
+	}
+	wg.Wait()
+	time.Sleep(time.Millisecond)
+
+	s := m.Summary()
+	want := Summary{BytesDownloaded: 1000, Requests: 100, CacheHits: 25, CacheMisses: 75}
+	if s.BytesDownloaded != want.BytesDownloaded || s.Requests != want.Requests || s.CacheHits != want.CacheHits || s.CacheMisses != want.CacheMisses {
+		t.Errorf("Summary() = %+v, want %+v", s, want)
+	}
+	for _, host := range hosts {
+		if s.RequestsPerHost[host] != 50 {
+			t.Errorf("RequestsPerHost[%s] = %d, want 50", host, s.RequestsPerHost[host])
+		}
+	}
+	if s.DurationSeconds < time.Millisecond.Seconds() {
+		t.Errorf("DurationSeconds = %v, want at least 1ms", s.DurationSeconds)
+	}
+
+	// Summary は集計結果のコピーを返す
+	s.RequestsPerHost[hosts[0]] = 0
+	if m.Summary().RequestsPerHost[hosts[0]] != 50 {
+		t.Error("modifying a Summary changed the Metrics")
+	}
+}
@@ -1,6 +1,48 @@
 package model
 
-// TODO: 型定義をここにまとめる
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
 
+// FileID は設定ファイルの files のキー (ファイルの識別子)
 type FileID string
+
+// ResolvedURL はテンプレートを展開した後のダウンロード元のURL。
+// 設定ファイル、Lock ファイル、ダウンローダーの全てでこの型を使う。
 type ResolvedURL string
+
+// SupportedSchemes はダウンロード元のURLとして使えるスキーム
+var SupportedSchemes = []string{"http", "https", "file", "s3", "gs"}
+
+// Validate は URL が対応しているスキームの絶対URLであることを検証する。
+// http(s) はホストを、file はパスを含む必要がある (file:///path/to/file または file://localhost/path/to/file)。
+// s3 (s3://bucket/key) と gs (gs://bucket/object) はバケットとオブジェクト名の両方を含む必要がある。
+// テンプレートの誤りを HTTP リクエストの送信前 (lock の開始時など) に検出するために使う。
+func (u ResolvedURL) Validate() error {
+	parsed, err := url.Parse(string(u))
+	if err != nil {
+		return fmt.Errorf("invalid URL %q: %w", u, err)
+	}
+	switch parsed.Scheme {
+	case "http", "https":
+		if parsed.Host == "" {
+			return fmt.Errorf("URL %q has no host", u)
+		}
+	case "file":
+		if parsed.Host != "" && parsed.Host != "localhost" {
+			return fmt.Errorf("file URL %q must not have a host other than localhost (use file:///path)", u)
+		}
+		if parsed.Path == "" {
+			return fmt.Errorf("file URL %q has no path", u)
+		}
+	case "s3", "gs":
+		if parsed.Host == "" || strings.TrimPrefix(parsed.Path, "/") == "" {
+			return fmt.Errorf("%s URL %q must be of the form %s://bucket/object", parsed.Scheme, u, parsed.Scheme)
+		}
+	default:
+		return fmt.Errorf("unsupported URL scheme %q in %q (supported: %s)", parsed.Scheme, u, strings.Join(SupportedSchemes, ", "))
+	}
+	return nil
+}
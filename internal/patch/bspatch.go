@@ -0,0 +1,133 @@
+package patch
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// bsdiffMagic は bsdiff 4.x 形式のパッチファイルのヘッダ
+const bsdiffMagic = "BSDIFF40"
+
+// headerSize はパッチファイルのヘッダ長 (マジック + 3つの int64)
+const headerSize = 32
+
+// MaxSize はパッチを適用した結果として許容する最大のサイズ。
+// ヘッダの新しいファイルのサイズはパッチの内容から来る信頼できない値のため、これを超える場合はエラーにする。
+const MaxSize = 4 << 30
+
+// copyBufferSize は差分ブロックを読み込む単位
+const copyBufferSize = 32 << 10
+
+// Apply は bsdiff 4.x 形式のパッチ patch を old に適用した結果を w に書き込む。
+// 制御ブロックの各値は old と新しいファイルの範囲内にあることを検証し、範囲外を指す不正なパッチはエラーにする。
+//
+// パッチの形式:
+//
+//	0  8  "BSDIFF40"
+//	8  8  bzip2 圧縮された制御ブロックの長さ
+//	16 8  bzip2 圧縮された差分ブロックの長さ
+//	24 8  新しいファイルのサイズ
+//	32 .. 制御ブロック, 差分ブロック, 追加ブロック (それぞれ bzip2 圧縮)
+func Apply(w io.Writer, old, patch []byte) error {
+	if len(patch) < headerSize || string(patch[:8]) != bsdiffMagic {
+		return fmt.Errorf("invalid bsdiff patch: bad header")
+	}
+	ctrlLen := offtin(patch[8:16])
+	diffLen := offtin(patch[16:24])
+	newSize := offtin(patch[24:32])
+	bodyLen := int64(len(patch)) - headerSize
+	// 加算でオーバーフローしないよう、それぞれ残りの長さと比較する
+	if ctrlLen < 0 || diffLen < 0 || ctrlLen > bodyLen || diffLen > bodyLen-ctrlLen {
+		return fmt.Errorf("invalid bsdiff patch: corrupt header")
+	}
+	if newSize < 0 || newSize > MaxSize {
+		return fmt.Errorf("invalid bsdiff patch: new size %d is out of range (max %d)", newSize, int64(MaxSize))
+	}
+
+	ctrlBlock := bzip2.NewReader(bytes.NewReader(patch[headerSize : headerSize+ctrlLen]))
+	diffBlock := bzip2.NewReader(bytes.NewReader(patch[headerSize+ctrlLen : headerSize+ctrlLen+diffLen]))
+	extraBlock := bzip2.NewReader(bytes.NewReader(patch[headerSize+ctrlLen+diffLen:]))
+
+	oldSize := int64(len(old))
+	var oldPos, newPos int64
+	var ctrlBuf [24]byte
+	buf := make([]byte, copyBufferSize)
+	for newPos < newSize {
+		// 制御ブロックから (差分長, 追加長, old の読み飛ばし量) を読む
+		if _, err := io.ReadFull(ctrlBlock, ctrlBuf[:]); err != nil {
+			return fmt.Errorf("invalid bsdiff patch: failed to read control block: %w", err)
+		}
+		diffSize := offtin(ctrlBuf[0:8])
+		extraSize := offtin(ctrlBuf[8:16])
+		seek := offtin(ctrlBuf[16:24])
+		if diffSize < 0 || diffSize > newSize-newPos || diffSize > oldSize-oldPos {
+			return fmt.Errorf("invalid bsdiff patch: diff length %d is out of range", diffSize)
+		}
+		if extraSize < 0 || extraSize > newSize-newPos-diffSize {
+			return fmt.Errorf("invalid bsdiff patch: extra length %d is out of range", extraSize)
+		}
+		if seek < -(oldPos+diffSize) || seek > oldSize-(oldPos+diffSize) {
+			return fmt.Errorf("invalid bsdiff patch: seek %d is out of range", seek)
+		}
+
+		// 差分ブロックを読み、old の対応するバイトを加算する
+		for rest := diffSize; rest > 0; {
+			chunk := buf[:min(rest, int64(len(buf)))]
+			if _, err := io.ReadFull(diffBlock, chunk); err != nil {
+				return fmt.Errorf("invalid bsdiff patch: failed to read diff block: %w", err)
+			}
+			for i := range chunk {
+				chunk[i] += old[oldPos+int64(i)]
+			}
+			if _, err := w.Write(chunk); err != nil {
+				return fmt.Errorf("failed to write patched data: %w", err)
+			}
+			oldPos += int64(len(chunk))
+			rest -= int64(len(chunk))
+		}
+		newPos += diffSize
+
+		// 追加ブロックをそのままコピーする
+		if _, err := io.CopyN(w, extraBlock, extraSize); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return fmt.Errorf("invalid bsdiff patch: failed to read extra block: %w", err)
+		}
+		newPos += extraSize
+		oldPos += seek
+	}
+	return nil
+}
+
+// ApplyFile は oldPath のファイルに patchPath のパッチを適用した結果を w に書き込む
+func ApplyFile(oldPath, patchPath string, w io.Writer) error {
+	old, err := os.ReadFile(oldPath)
+	if err != nil {
+		return fmt.Errorf("failed to read base file %s: %w", oldPath, err)
+	}
+	patch, err := os.ReadFile(patchPath)
+	if err != nil {
+		return fmt.Errorf("failed to read patch file %s: %w", patchPath, err)
+	}
+	if err := Apply(w, old, patch); err != nil {
+		return fmt.Errorf("failed to apply patch %s to %s: %w", patchPath, oldPath, err)
+	}
+	return nil
+}
+
+// offtin は bsdiff 形式の符号付き 64bit 整数 (リトルエンディアン、最上位ビットが符号) を読む
+func offtin(buf []byte) int64 {
+	y := int64(buf[7] & 0x7f)
+	for i := 6; i >= 0; i-- {
+		y = y<<8 | int64(buf[i])
+	}
+	if buf[7]&0x80 != 0 {
+		y = -y
+	}
+	return y
+}
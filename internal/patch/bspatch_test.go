@@ -0,0 +1,64 @@
+package patch
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testdata のパッチは testdata/old ("hello world\n") を testdata/new ("hello gopher\n") に変換する
+// valid.patch と、そのヘッダや制御ブロックを壊したもの。
+
+func TestApply(t *testing.T) {
+	old := readTestdata(t, "old")
+	want := readTestdata(t, "new")
+	var got bytes.Buffer
+	if err := Apply(&got, old, readTestdata(t, "valid.patch")); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Errorf("Apply() = %q, want %q", got.Bytes(), want)
+	}
+}
+
+func TestApplyMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		patch   []byte
+		wantErr string
+	}{
+		{name: "empty", patch: nil, wantErr: "bad header"},
+		{name: "bad magic", patch: append([]byte("BSDIFF41"), make([]byte, 24)...), wantErr: "bad header"},
+		{name: "new size exceeds limit", patch: readTestdata(t, "huge_size.patch"), wantErr: "new size"},
+		{name: "control block length overflows", patch: readTestdata(t, "ctrl_len_overflow.patch"), wantErr: "corrupt header"},
+		{name: "negative diff length", patch: readTestdata(t, "negative_diff.patch"), wantErr: "diff length"},
+		{name: "diff beyond base", patch: readTestdata(t, "diff_beyond_old.patch"), wantErr: "diff length"},
+		{name: "extra beyond new size", patch: readTestdata(t, "extra_beyond_new.patch"), wantErr: "extra length"},
+		{name: "seek out of range", patch: readTestdata(t, "seek_out_of_range.patch"), wantErr: "seek"},
+		{name: "truncated extra block", patch: readTestdata(t, "truncated_extra.patch"), wantErr: "extra block"},
+	}
+	old := readTestdata(t, "old")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := Apply(&out, old, tt.patch)
+			if err == nil {
+				t.Fatalf("Apply() succeeded, want error containing %q", tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Apply() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
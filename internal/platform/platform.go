@@ -2,7 +2,13 @@ package platform
 
 import (
 	"fmt"
+	"os"
+	"os/exec"
 	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 // マッピング定義
@@ -10,29 +16,41 @@ var goosMap = map[string]string{
 	"darwin":  "macos",
 	"linux":   "linux",
 	"windows": "windows",
+	"freebsd": "freebsd",
+	"openbsd": "openbsd",
+	"netbsd":  "netbsd",
 }
 
 var goarchMap = map[string]string{
-	"amd64": "x86_64",
-	"arm64": "arm64",
+	"amd64":   "x86_64",
+	"arm64":   "arm64",
+	"386":     "i386",
+	"arm":     "arm", // 32-bit ARM (Raspberry Pi など)
+	"riscv64": "riscv64",
+	"ppc64le": "ppc64le",
+	"s390x":   "s390x",
 }
 
 // GetCurrentPlatform は実行環境のプラットフォーム識別子を返す
 func GetCurrentPlatform() (string, error) {
-	os := runtime.GOOS
-	if p, ok := goosMap[os]; ok {
+	goos := runtime.GOOS
+	if p, ok := goosMap[goos]; ok {
 		return p, nil
 	}
-	return "", fmt.Errorf("unsupported GOOS: %s", os)
+	return "", fmt.Errorf("unsupported GOOS: %s", goos)
 }
 
 // GetCurrentArch は実行環境のアーキテクチャ識別子を返す
 func GetCurrentArch() (string, error) {
-	arch := runtime.GOARCH
-	if a, ok := goarchMap[arch]; ok {
+	return archFromGoarch(runtime.GOARCH)
+}
+
+// archFromGoarch は runtime.GOARCH の値に対応するアーキテクチャ識別子を返す
+func archFromGoarch(goarch string) (string, error) {
+	if a, ok := goarchMap[goarch]; ok {
 		return a, nil
 	}
-	return "", fmt.Errorf("unsupported GOARCH: %s", arch)
+	return "", fmt.Errorf("unsupported GOARCH: %s", goarch)
 }
 
 // IsValidPlatform は指定された識別子がサポートされているか返す
@@ -55,21 +73,23 @@ func IsValidArch(a string) bool {
 	return false
 }
 
-// GetAllPlatforms はサポートするプラットフォーム識別子のリストを返す
+// GetAllPlatforms はサポートするプラットフォーム識別子のリストをソートして返す
 func GetAllPlatforms() []string {
 	platforms := make([]string, 0, len(goosMap))
 	for k := range goosMap {
 		platforms = append(platforms, goosMap[k]) // 値を返す
 	}
+	sort.Strings(platforms)
 	return platforms
 }
 
-// GetAllArchs はサポートするアーキテクチャ識別子のリストを返す
+// GetAllArchs はサポートするアーキテクチャ識別子のリストをソートして返す
 func GetAllArchs() []string {
 	archs := make([]string, 0, len(goarchMap))
 	for k := range goarchMap {
 		archs = append(archs, goarchMap[k]) // 値を返す
 	}
+	sort.Strings(archs)
 	return archs
 }
 
@@ -92,3 +112,191 @@ func GetGoarch(archID string) (string, bool) {
 	}
 	return "", false
 }
+
+// GetCurrentPlatformFrom は識別子から runtime.GOOS への対応表 m (設定ファイルの platform_map) を使って、
+// 実行環境のプラットフォーム識別子を返す
+func GetCurrentPlatformFrom(m map[string]string) (string, error) {
+	for id, goos := range m {
+		if goos == runtime.GOOS {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("GOOS %s is not mapped in platform_map", runtime.GOOS)
+}
+
+// GetCurrentArchFrom は識別子から runtime.GOARCH への対応表 m (設定ファイルの arch_map) を使って、
+// 実行環境のアーキテクチャ識別子を返す
+func GetCurrentArchFrom(m map[string]string) (string, error) {
+	for id, goarch := range m {
+		if goarch == runtime.GOARCH {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("GOARCH %s is not mapped in arch_map", runtime.GOARCH)
+}
+
+// armVariants は 32-bit ARM (GOARCH=arm) のバリアント識別子と GOARM のバージョンの対応
+var armVariants = map[string]int{
+	"armv5": 5,
+	"armv6": 6,
+	"armv7": 7,
+}
+
+// IsArmGoarch は GOARCH がバリアントを持つ 32-bit ARM か返す
+func IsArmGoarch(goarch string) bool {
+	return goarch == "arm"
+}
+
+// IsValidArchVariant は指定されたバリアント識別子がサポートされているか返す
+func IsValidArchVariant(v string) bool {
+	_, ok := armVariants[v]
+	return ok
+}
+
+// GetAllArchVariants はサポートするバリアント識別子のリストをソートして返す
+func GetAllArchVariants() []string {
+	variants := make([]string, 0, len(armVariants))
+	for k := range armVariants {
+		variants = append(variants, k)
+	}
+	sort.Strings(variants)
+	return variants
+}
+
+// ArchVariantLevel はバリアントの世代 (GOARM のバージョン) を返す。不明な場合は 0。
+func ArchVariantLevel(v string) int {
+	return armVariants[v]
+}
+
+// GetCurrentArchVariant は実行環境のバリアント識別子 (armv7 など) を返す。
+// 32-bit ARM 以外、または判定できない場合は空文字列を返す。
+// Linux では uname -m (armv6l など)、次に /proc/cpuinfo を使い、取得できない場合はビルド時の GOARM を使う。
+func GetCurrentArchVariant() string {
+	if !IsArmGoarch(runtime.GOARCH) {
+		return ""
+	}
+	if level := unameArchLevel(); level > 0 {
+		return variantForLevel(level)
+	}
+	if level := cpuinfoArchLevel(); level > 0 {
+		return variantForLevel(level)
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "GOARM" {
+				// "7" や "7,softfloat" の形式
+				level, err := strconv.Atoi(strings.SplitN(s.Value, ",", 2)[0])
+				if err == nil {
+					return variantForLevel(level)
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// unameArchLevel は uname -m のマシン名から ARM のアーキテクチャバージョンを読み取る。取得できない場合は 0。
+func unameArchLevel() int {
+	out, err := exec.Command("uname", "-m").Output()
+	if err != nil {
+		return 0
+	}
+	return machineArchLevel(strings.TrimSpace(string(out)))
+}
+
+// machineArchLevel は uname -m のマシン名 (armv6l, armv7l, armv5tel など) からアーキテクチャバージョンを返す。
+// 64-bit カーネル上の 32-bit ユーザーランド (aarch64, armv8l) は 8 とする。ARM でない場合は 0。
+func machineArchLevel(machine string) int {
+	switch machine {
+	case "aarch64", "arm64":
+		return 8
+	}
+	rest, ok := strings.CutPrefix(machine, "armv")
+	if !ok {
+		return 0
+	}
+	return leadingInt(rest)
+}
+
+// cpuinfoArchLevel は /proc/cpuinfo から ARM のアーキテクチャバージョンを読み取る。取得できない場合は 0。
+func cpuinfoArchLevel() int {
+	data, err := os.ReadFile("/proc/cpuinfo")
+	if err != nil {
+		return 0
+	}
+	return parseCpuinfoArchLevel(string(data))
+}
+
+// arm11Parts は ARMv6 の CPU (ARM11 ファミリー) の CPU part の値。
+// カーネルはこれらの CPU でも CPU architecture に 7 を表示するため、CPU part で判別する。
+var arm11Parts = map[string]bool{
+	"0xb02": true, // ARM11 MPCore
+	"0xb36": true, // ARM1136
+	"0xb56": true, // ARM1156
+	"0xb76": true, // ARM1176 (Raspberry Pi 1, Zero)
+}
+
+// parseCpuinfoArchLevel は /proc/cpuinfo の内容から ARM のアーキテクチャバージョンを返す。取得できない場合は 0。
+// CPU architecture は ARM1176 などの ARMv6 の CPU でも 7 となるため、
+// model name (古いカーネルでは Processor) の末尾の "(v6l)" や CPU part の値を優先する。
+func parseCpuinfoArchLevel(cpuinfo string) int {
+	var modelLevel, archLevel int
+	arm11 := false
+	for _, line := range strings.Split(cpuinfo, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "model name", "Processor":
+			// "ARMv6-compatible processor rev 7 (v6l)" の形式
+			if open := strings.LastIndex(value, "(v"); open >= 0 && modelLevel == 0 {
+				modelLevel = leadingInt(value[open+2:])
+			}
+		case "CPU architecture":
+			// "7"、"5TE"、"AArch64" (64-bit カーネル上の 32-bit ユーザーランド) の形式
+			if archLevel == 0 {
+				if archLevel = leadingInt(value); archLevel == 0 && strings.EqualFold(value, "AArch64") {
+					archLevel = 8
+				}
+			}
+		case "CPU part":
+			if arm11Parts[strings.ToLower(value)] {
+				arm11 = true
+			}
+		}
+	}
+	switch {
+	case modelLevel > 0:
+		return modelLevel
+	case arm11:
+		return 6
+	default:
+		return archLevel
+	}
+}
+
+// leadingInt は s の先頭の数字を整数として返す。数字で始まらない場合は 0。
+func leadingInt(s string) int {
+	end := 0
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end++
+	}
+	n, err := strconv.Atoi(s[:end])
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// variantForLevel は ARM のアーキテクチャバージョンを実行できる最も新しいバリアント識別子に変換する
+func variantForLevel(level int) string {
+	best, bestLevel := "", 0
+	for v, l := range armVariants {
+		if l <= level && l > bestLevel {
+			best, bestLevel = v, l
+		}
+	}
+	return best
+}
@@ -0,0 +1,110 @@
+package platform
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+)
+
+func TestArchMapping(t *testing.T) {
+	tests := []struct {
+		goarch  string
+		archID  string // 空ならサポートしない
+		isArm32 bool
+	}{
+		{goarch: "amd64", archID: "x86_64"},
+		{goarch: "arm64", archID: "arm64"},
+		{goarch: "386", archID: "i386"},
+		{goarch: "arm", archID: "arm", isArm32: true},
+		{goarch: "riscv64", archID: "riscv64"},
+		{goarch: "ppc64le", archID: "ppc64le"},
+		{goarch: "s390x", archID: "s390x"},
+		{goarch: "mips"},
+		{goarch: "wasm"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.goarch, func(t *testing.T) {
+			archID, err := archFromGoarch(tt.goarch)
+			if tt.archID == "" {
+				if err == nil {
+					t.Errorf("archFromGoarch(%q) = %q, want error", tt.goarch, archID)
+				}
+				return
+			}
+			if err != nil || archID != tt.archID {
+				t.Fatalf("archFromGoarch(%q) = %q, %v; want %q", tt.goarch, archID, err, tt.archID)
+			}
+			if !IsValidArch(tt.archID) {
+				t.Errorf("IsValidArch(%q) = false, want true", tt.archID)
+			}
+			if !slices.Contains(GetAllArchs(), tt.archID) {
+				t.Errorf("GetAllArchs() = %v, want it to contain %q", GetAllArchs(), tt.archID)
+			}
+			if goarch, ok := GetGoarch(tt.archID); !ok || goarch != tt.goarch {
+				t.Errorf("GetGoarch(%q) = %q, %v; want %q", tt.archID, goarch, ok, tt.goarch)
+			}
+			if got := IsArmGoarch(tt.goarch); got != tt.isArm32 {
+				t.Errorf("IsArmGoarch(%q) = %v, want %v", tt.goarch, got, tt.isArm32)
+			}
+		})
+	}
+
+	// 実行環境のアーキテクチャも同じ対応で識別される
+	want, wantErr := archFromGoarch(runtime.GOARCH)
+	if got, err := GetCurrentArch(); got != want || (err != nil) != (wantErr != nil) {
+		t.Errorf("GetCurrentArch() = %q, %v; want %q, %v", got, err, want, wantErr)
+	}
+}
+
+func TestParseCpuinfoArchLevel(t *testing.T) {
+	tests := []struct {
+		file        string
+		want        int
+		wantVariant string
+	}{
+		{file: "rpi1-arm1176.txt", want: 6, wantVariant: "armv6"}, // CPU architecture は 7 と表示される
+		{file: "rpi-zero-old-kernel.txt", want: 6, wantVariant: "armv6"},
+		{file: "arm1176-no-model.txt", want: 6, wantVariant: "armv6"},
+		{file: "rpi3-armv7.txt", want: 7, wantVariant: "armv7"},
+		{file: "rpi4-aarch64-kernel.txt", want: 8, wantVariant: "armv7"},
+		{file: "kirkwood-armv5.txt", want: 5, wantVariant: "armv5"},
+		{file: "x86_64.txt", want: 0, wantVariant: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", "cpuinfo", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := parseCpuinfoArchLevel(string(data))
+			if got != tt.want {
+				t.Errorf("parseCpuinfoArchLevel() = %d, want %d", got, tt.want)
+			}
+			if v := variantForLevel(got); v != tt.wantVariant {
+				t.Errorf("variantForLevel(%d) = %q, want %q", got, v, tt.wantVariant)
+			}
+		})
+	}
+}
+
+func TestMachineArchLevel(t *testing.T) {
+	tests := []struct {
+		machine string
+		want    int
+	}{
+		{machine: "armv6l", want: 6},
+		{machine: "armv7l", want: 7},
+		{machine: "armv5tel", want: 5},
+		{machine: "armv8l", want: 8},
+		{machine: "aarch64", want: 8},
+		{machine: "x86_64", want: 0},
+		{machine: "", want: 0},
+	}
+	for _, tt := range tests {
+		if got := machineArchLevel(tt.machine); got != tt.want {
+			t.Errorf("machineArchLevel(%q) = %d, want %d", tt.machine, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reporter はダウンロードの進捗の通知先
+type Reporter interface {
+	// Start は name の転送の開始を通知する。total は全体のバイト数 (不明な場合は -1)。
+	Start(name string, total int64) Tracker
+}
+
+// Tracker は1つの転送の進捗を通知する
+type Tracker interface {
+	// Add は n バイト転送したことを通知する
+	Add(n int64)
+	// Done は転送の終了 (成功・失敗を問わない) を通知する。2回目以降の呼び出しは何もしない。
+	Done()
+}
+
+// IsTerminal は f が端末 (キャラクタデバイス) の場合に true を返す
+func IsTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice != 0
+}
+
+// --- 端末向けのプログレスバー ---
+
+// barRedrawInterval はプログレスバーを再描画する最小間隔
+const barRedrawInterval = 100 * time.Millisecond
+
+// barWidth はプログレスバーの幅 (文字数)
+const barWidth = 30
+
+// nameWidth は転送名の表示幅 (超える場合は先頭を省略する)
+const nameWidth = 40
+
+// Bars は並行する転送ごとにプログレスバーを端末の末尾に描画する Reporter。
+// ログ出力も Bars を経由させる (io.Writer として使う) ことで、ログ行とバーが混ざらないようにする。
+type Bars struct {
+	out      io.Writer
+	mu       sync.Mutex
+	bars     []*bar
+	drawn    int // 直前に描画したバーの行数
+	lastDraw time.Time
+}
+
+// NewBars は out (通常は os.Stderr) に描画する Bars を作成する
+func NewBars(out io.Writer) *Bars {
+	return &Bars{out: out}
+}
+
+// Start は Reporter を実装する
+func (b *Bars) Start(name string, total int64) Tracker {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	br := &bar{parent: b, name: name, total: total}
+	b.bars = append(b.bars, br)
+	b.redraw(true)
+	return br
+}
+
+// Finish は残っている全てのバーを消去する。
+// 終了を通知されなかった転送があっても端末にバーが残らないよう、コマンドの終了時 (エラーの場合も) に呼び出す。
+func (b *Bars) Finish() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clear()
+	for _, br := range b.bars {
+		br.done = true
+	}
+	b.bars = nil
+}
+
+// Write はバーを一旦消してから p を書き込み、バーを描画し直す
+func (b *Bars) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clear()
+	n, err := b.out.Write(p)
+	b.draw()
+	return n, err
+}
+
+// redraw はバーを描画し直す。force が false の場合は前回の描画から一定時間経過していなければ何もしない。
+// 呼び出し元は mu を保持していること。
+func (b *Bars) redraw(force bool) {
+	if !force && time.Since(b.lastDraw) < barRedrawInterval {
+		return
+	}
+	b.clear()
+	b.draw()
+}
+
+// clear は描画済みのバーを消去する。呼び出し元は mu を保持していること。
+func (b *Bars) clear() {
+	if b.drawn == 0 {
+		return
+	}
+	fmt.Fprintf(b.out, "\033[%dA\033[J", b.drawn) // カーソルを戻して以降を消去
+	b.drawn = 0
+}
+
+// draw は全てのバーを描画する。呼び出し元は mu を保持していること。
+func (b *Bars) draw() {
+	var sb strings.Builder
+	for _, br := range b.bars {
+		sb.WriteString(br.render())
+		sb.WriteByte('\n')
+	}
+	io.WriteString(b.out, sb.String())
+	b.drawn = len(b.bars)
+	b.lastDraw = time.Now()
+}
+
+// remove は終了したバーを取り除く。呼び出し元は mu を保持していること。
+func (b *Bars) remove(target *bar) {
+	for i, br := range b.bars {
+		if br == target {
+			b.bars = append(b.bars[:i], b.bars[i+1:]...)
+			return
+		}
+	}
+}
+
+// bar は1つの転送のプログレスバー
+type bar struct {
+	parent  *Bars
+	name    string
+	total   int64
+	current int64
+	done    bool
+}
+
+func (br *bar) Add(n int64) {
+	br.parent.mu.Lock()
+	defer br.parent.mu.Unlock()
+	br.current += n
+	br.parent.redraw(false)
+}
+
+func (br *bar) Done() {
+	br.parent.mu.Lock()
+	defer br.parent.mu.Unlock()
+	if br.done {
+		return
+	}
+	br.done = true
+	br.parent.clear()
+	br.parent.remove(br)
+	br.parent.draw()
+}
+
+// render はバーを1行の文字列にする
+func (br *bar) render() string {
+	name := br.name
+	if len(name) > nameWidth {
+		name = "..." + name[len(name)-(nameWidth-3):]
+	}
+	if br.total <= 0 {
+		return fmt.Sprintf("%-*s  %s", nameWidth, name, FormatBytes(br.current))
+	}
+	ratio := min(float64(br.current)/float64(br.total), 1)
+	filled := int(ratio * barWidth)
+	return fmt.Sprintf("%-*s  [%s%s] %3.0f%%  %s / %s", nameWidth, name,
+		strings.Repeat("=", filled), strings.Repeat(" ", barWidth-filled),
+		ratio*100, FormatBytes(br.current), FormatBytes(br.total))
+}
+
+// --- 端末以外向けのログ出力 ---
+
+// DefaultLogInterval は Log が進捗をログに出力する間隔
+const DefaultLogInterval = 5 * time.Second
+
+// Log は転送ごとに一定間隔で進捗をログに出力する Reporter。
+// 端末以外 (CI のログなど) ではプログレスバーの代わりに使う。
+type Log struct {
+	logger   *slog.Logger
+	interval time.Duration
+}
+
+// NewLog は interval ごとに logger へ進捗を出力する Log を作成する
+func NewLog(logger *slog.Logger, interval time.Duration) *Log {
+	return &Log{logger: logger, interval: interval}
+}
+
+// Start は Reporter を実装する
+func (l *Log) Start(name string, total int64) Tracker {
+	return &logTracker{parent: l, name: name, total: total, lastLog: time.Now()}
+}
+
+// logTracker は1つの転送の進捗を一定間隔でログに出力する
+type logTracker struct {
+	parent  *Log
+	mu      sync.Mutex
+	name    string
+	total   int64
+	current int64
+	lastLog time.Time
+}
+
+func (t *logTracker) Add(n int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.current += n
+	if time.Since(t.lastLog) < t.parent.interval {
+		return
+	}
+	t.lastLog = time.Now()
+	if t.total > 0 {
+		t.parent.logger.Info("Download progress", "url", t.name, "downloaded", FormatBytes(t.current), "total", FormatBytes(t.total),
+			"percent", fmt.Sprintf("%.0f%%", float64(t.current)/float64(t.total)*100))
+	} else {
+		t.parent.logger.Info("Download progress", "url", t.name, "downloaded", FormatBytes(t.current))
+	}
+}
+
+func (t *logTracker) Done() {}
+
+// FormatBytes はバイト数を読みやすい単位 (KiB, MiB, ...) の文字列にする
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,63 @@
+package progress
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestBarsFinish(t *testing.T) {
+	tests := []struct {
+		name     string
+		started  int // 開始する転送の数
+		finished int // Done を通知する転送の数
+	}{
+		{name: "no transfers"},
+		{name: "all done", started: 2, finished: 2},
+		{name: "some left running", started: 3, finished: 1},
+		{name: "none done", started: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			b := NewBars(&out)
+			var trackers []Tracker
+			for i := 0; i < tt.started; i++ {
+				trackers = append(trackers, b.Start("file", 100))
+			}
+			for _, tr := range trackers[:tt.finished] {
+				tr.Done()
+			}
+			b.Finish()
+			if len(b.bars) != 0 || b.drawn != 0 {
+				t.Errorf("after Finish: %d bars, %d lines drawn; want none", len(b.bars), b.drawn)
+			}
+
+			// Finish 後の通知やログ出力でバーが再び描画されない
+			out.Reset()
+			for _, tr := range trackers {
+				tr.Add(10)
+				tr.Done()
+			}
+			b.Write([]byte("log line\n"))
+			if got := out.String(); got != "log line\n" {
+				t.Errorf("output after Finish = %q, want only the log line", got)
+			}
+		})
+	}
+}
+
+func TestBarDoneTwice(t *testing.T) {
+	var out bytes.Buffer
+	b := NewBars(&out)
+	tr := b.Start("file", 100)
+	b.Start("other", 100)
+	tr.Done()
+	tr.Done()
+	if len(b.bars) != 1 {
+		t.Errorf("bars after double Done = %d, want 1", len(b.bars))
+	}
+	if !strings.Contains(out.String(), "other") {
+		t.Errorf("remaining bar was not drawn: %q", out.String())
+	}
+}
@@ -0,0 +1,73 @@
+package prompt
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// ErrQuit はユーザーが quit を選択した場合に返されるエラー
+var ErrQuit = errors.New("aborted by user")
+
+// Overwrite は既存のファイルを上書きするかを対話的に確認する。
+// 複数のゴルーチンから安全に使用でき、確認は1つずつ順番に行われる。
+type Overwrite struct {
+	mu   sync.Mutex
+	in   *bufio.Reader
+	out  io.Writer
+	all  bool // all が選択された (以降は確認せずに上書きする)
+	quit bool // quit が選択された、または入力が終了した (以降は全て中断する)
+}
+
+// NewOverwrite は in から回答を読み、out に確認メッセージを書き出す Overwrite を作成する
+func NewOverwrite(in io.Reader, out io.Writer) *Overwrite {
+	return &Overwrite{in: bufio.NewReader(in), out: out}
+}
+
+// Aborted は quit が選択された場合に true を返す
+func (o *Overwrite) Aborted() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.quit
+}
+
+// ConfirmOverwrite は path を上書きするか確認し、上書きする場合に true を返す。
+// quit が選択された場合 (以降の呼び出しを含む) は ErrQuit を返す。
+func (o *Overwrite) ConfirmOverwrite(path string) (bool, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for {
+		switch {
+		case o.quit:
+			return false, ErrQuit
+		case o.all:
+			return true, nil
+		}
+
+		fmt.Fprintf(o.out, "%s already exists. Overwrite? [y]es/[N]o/[a]ll/[q]uit: ", path)
+		line, err := o.in.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			// 入力が得られない場合は中断として扱う
+			fmt.Fprintln(o.out)
+			o.quit = true
+			continue
+		}
+
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "y", "yes":
+			return true, nil
+		case "", "n", "no":
+			return false, nil // デフォルトはスキップ
+		case "a", "all":
+			o.all = true
+		case "q", "quit":
+			o.quit = true
+		default:
+			fmt.Fprintln(o.out, "Please answer y, n, a or q.")
+		}
+	}
+}
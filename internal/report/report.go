@@ -0,0 +1,178 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/hrko/dltofu/internal/hash"
+	"github.com/hrko/dltofu/internal/metrics"
+	"github.com/hrko/dltofu/internal/model"
+)
+
+// ファイルごとの処理結果のステータス
+const (
+	StatusOK       = "ok"
+	StatusSkipped  = "skipped"  // 対象外、既存ファイルがあるなどの理由で処理しなかった
+	StatusFailed   = "failed"   // ダウンロードやハッシュ計算などに失敗した
+	StatusMismatch = "mismatch" // verify でハッシュ値が一致しなかった、lock で記録済みのハッシュ値と異なった
+	StatusMissing  = "missing"  // verify でファイルが存在しなかった、lock --check で Lock ファイルに記録されていなかった
+	StatusOrphaned = "orphaned" // lock --check で設定ファイルから解決されない URL が Lock ファイルに記録されていた
+	StatusPruned   = "pruned"   // lock --prune-only で設定ファイルから解決されない URL を Lock ファイルから削除した
+)
+
+// FileResult は1ファイル (のバリアント) の処理結果
+type FileResult struct {
+	FileID   model.FileID      `json:"file_id"`
+	Platform string            `json:"platform,omitempty"`
+	Arch     string            `json:"arch,omitempty"`
+	Variant  string            `json:"arch_variant,omitempty"`
+	URL      model.ResolvedURL `json:"url,omitempty"`
+	Path     string            `json:"path,omitempty"` // ダウンロード/展開先
+	Hash     *hash.Hash        `json:"hash,omitempty"` // Lock ファイルに記録されたハッシュ値
+	Status   string            `json:"status"`
+	Detail   string            `json:"detail,omitempty"`
+	Error    string            `json:"error,omitempty"`
+}
+
+// Fail は err を記録した失敗の結果を返す
+func (r FileResult) Fail(err error) FileResult {
+	r.Status = StatusFailed
+	r.Error = err.Error()
+	return r
+}
+
+// Skip は理由を記録したスキップの結果を返す
+func (r FileResult) Skip(reason string) FileResult {
+	r.Status = StatusSkipped
+	r.Detail = reason
+	return r
+}
+
+// Failed は結果が成功、スキップ、削除のいずれでもない場合に true を返す
+func (r FileResult) Failed() bool {
+	return r.Status != StatusOK && r.Status != StatusSkipped && r.Status != StatusPruned
+}
+
+// Failure は失敗した処理の概要。並列処理のログに埋もれないよう、コマンドの最後にまとめて報告する。
+type Failure struct {
+	FileID   model.FileID      `json:"file_id"`
+	Platform string            `json:"platform,omitempty"`
+	Arch     string            `json:"arch,omitempty"`
+	Variant  string            `json:"arch_variant,omitempty"`
+	URL      model.ResolvedURL `json:"url,omitempty"`
+	Status   string            `json:"status"`
+	Error    string            `json:"error"` // エラーメッセージ (mismatch などエラーのない失敗では詳細)
+}
+
+// Target はログ用に "fileID (platform/arch)" 形式 (バリアントがある場合は "fileID (platform/arch/variant)") の文字列を返す
+func (f Failure) Target() string {
+	switch {
+	case f.Platform == "":
+		return string(f.FileID)
+	case f.Variant != "":
+		return fmt.Sprintf("%s (%s/%s/%s)", f.FileID, f.Platform, f.Arch, f.Variant)
+	default:
+		return fmt.Sprintf("%s (%s/%s)", f.FileID, f.Platform, f.Arch)
+	}
+}
+
+// Result はコマンドの実行結果。--output json で標準出力に書き出される。
+type Result struct {
+	Command  string           `json:"command"`
+	Success  bool             `json:"success"`
+	Error    string           `json:"error,omitempty"`
+	Files    []FileResult     `json:"files"`
+	Failures []Failure        `json:"failures,omitempty"` // Files のうち失敗したもの
+	Summary  *metrics.Summary `json:"summary,omitempty"`
+}
+
+// Collector はファイルごとの処理結果を集める。複数のゴルーチンから安全に使用できる。
+type Collector struct {
+	mu    sync.Mutex
+	files []FileResult
+}
+
+// Add は処理結果を追加する
+func (c *Collector) Add(r FileResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.files = append(c.files, r)
+}
+
+// Files は集めた処理結果をファイルID、プラットフォーム、アーキテクチャ、バリアントの順にソートして返す
+func (c *Collector) Files() []FileResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	files := make([]FileResult, len(c.files)) // JSON で null ではなく [] を出力する
+	copy(files, c.files)
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].FileID != files[j].FileID {
+			return files[i].FileID < files[j].FileID
+		}
+		if files[i].Platform != files[j].Platform {
+			return files[i].Platform < files[j].Platform
+		}
+		if files[i].Arch != files[j].Arch {
+			return files[i].Arch < files[j].Arch
+		}
+		return files[i].Variant < files[j].Variant
+	})
+	return files
+}
+
+// Failures は集めた処理結果のうち失敗したものを Files と同じ順に返す
+func (c *Collector) Failures() []Failure {
+	var failures []Failure
+	for _, r := range c.Files() {
+		if !r.Failed() {
+			continue
+		}
+		message := r.Error
+		if message == "" {
+			message = r.Detail
+		}
+		failures = append(failures, Failure{
+			FileID:   r.FileID,
+			Platform: r.Platform,
+			Arch:     r.Arch,
+			Variant:  r.Variant,
+			URL:      r.URL,
+			Status:   r.Status,
+			Error:    message,
+		})
+	}
+	return failures
+}
+
+// FailedFileIDs は失敗した処理結果のファイルIDを重複なくソートして返す
+func FailedFileIDs(failures []Failure) []model.FileID {
+	var fileIDs []model.FileID
+	for _, f := range failures {
+		fileIDs = append(fileIDs, f.FileID)
+	}
+	slices.Sort(fileIDs)
+	return slices.Compact(fileIDs)
+}
+
+// Result は集めた処理結果を実行結果にまとめる。err はコマンドが返すエラー (成功した場合は nil)。
+func (c *Collector) Result(command string, err error) Result {
+	result := Result{Command: command, Success: err == nil, Files: c.Files(), Failures: c.Failures()}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// Write は実行結果を JSON として w に書き出す
+func Write(w io.Writer, r Result) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal result: %w", err)
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
@@ -0,0 +1,122 @@
+package report
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hrko/dltofu/internal/model"
+)
+
+// update が指定された場合は golden ファイルを現在の出力で更新する (go test ./internal/report -update)
+var update = flag.Bool("update", false, "update golden files")
+
+// sampleResults は成功、スキップ、各種の失敗を含む処理結果 (追加順はソート順と異なる)
+func sampleResults() []FileResult {
+	base := func(fileID model.FileID, platform, arch, variant string) FileResult {
+		return FileResult{FileID: fileID, Platform: platform, Arch: arch, Variant: variant, URL: model.ResolvedURL("https://example.com/" + string(fileID)), Status: StatusOK}
+	}
+	return []FileResult{
+		base("zeta", "linux", "x86_64", "").Fail(errors.New("received status code 404")),
+		base("alpha", "linux", "x86_64", ""),
+		base("alpha", "linux", "arm", "armv7").Fail(errors.New("connection reset")),
+		base("beta", "", "", "").Skip("destination exists"),
+		{FileID: "gamma", Platform: "macos", Arch: "arm64", Status: StatusMismatch, Detail: "hash changed"},
+		{FileID: "zeta", Platform: "macos", Arch: "arm64", Status: StatusMissing, Error: "not in lock file"},
+		{FileID: "old", Status: StatusPruned},
+	}
+}
+
+func TestCollectorFailures(t *testing.T) {
+	tests := []struct {
+		name       string
+		results    []FileResult
+		want       []Failure
+		wantFileID []model.FileID
+	}{
+		{name: "no results"},
+		{name: "only successes", results: []FileResult{{FileID: "a", Status: StatusOK}, {FileID: "b", Status: StatusSkipped}, {FileID: "c", Status: StatusPruned}}},
+		{
+			name:    "mixed",
+			results: sampleResults(),
+			want: []Failure{
+				{FileID: "alpha", Platform: "linux", Arch: "arm", Variant: "armv7", URL: "https://example.com/alpha", Status: StatusFailed, Error: "connection reset"},
+				{FileID: "gamma", Platform: "macos", Arch: "arm64", Status: StatusMismatch, Error: "hash changed"},
+				{FileID: "zeta", Platform: "linux", Arch: "x86_64", URL: "https://example.com/zeta", Status: StatusFailed, Error: "received status code 404"},
+				{FileID: "zeta", Platform: "macos", Arch: "arm64", Status: StatusMissing, Error: "not in lock file"},
+			},
+			wantFileID: []model.FileID{"alpha", "gamma", "zeta"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c Collector
+			for _, r := range tt.results {
+				c.Add(r)
+			}
+			failures := c.Failures()
+			if !reflect.DeepEqual(failures, tt.want) {
+				t.Errorf("Failures() = %+v, want %+v", failures, tt.want)
+			}
+			if got := FailedFileIDs(failures); !reflect.DeepEqual(got, tt.wantFileID) {
+				t.Errorf("FailedFileIDs() = %v, want %v", got, tt.wantFileID)
+			}
+		})
+	}
+}
+
+func TestFailureTarget(t *testing.T) {
+	tests := []struct {
+		failure Failure
+		want    string
+	}{
+		{failure: Failure{FileID: "tool"}, want: "tool"},
+		{failure: Failure{FileID: "tool", Platform: "linux", Arch: "x86_64"}, want: "tool (linux/x86_64)"},
+		{failure: Failure{FileID: "tool", Platform: "linux", Arch: "arm", Variant: "armv6"}, want: "tool (linux/arm/armv6)"},
+	}
+	for _, tt := range tests {
+		if got := tt.failure.Target(); got != tt.want {
+			t.Errorf("Target() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestWriteGolden(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []FileResult
+		err     error
+	}{
+		{name: "success", results: []FileResult{{FileID: "tool", Status: StatusOK}}},
+		{name: "failures", results: sampleResults(), err: errors.New("3 file(s) failed")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c Collector
+			for _, r := range tt.results {
+				c.Add(r)
+			}
+			var buf bytes.Buffer
+			if err := Write(&buf, c.Result("download", tt.err)); err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join("testdata", tt.name+".golden.json")
+			if *update {
+				if err := os.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), want) {
+				t.Errorf("Write() output differs from %s:\n%s", golden, buf.String())
+			}
+		})
+	}
+}
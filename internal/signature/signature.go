@@ -0,0 +1,90 @@
+package signature
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// armorPrefix は ASCII armor 形式のデータの先頭
+const armorPrefix = "-----BEGIN PGP"
+
+// LoadKeyRing は公開鍵を読み込む。publicKey が ASCII armor 形式の鍵そのものであればそれを使い、
+// そうでなければファイルパスとして読み込む (ASCII armor 形式とバイナリ形式のどちらにも対応する)。
+func LoadKeyRing(publicKey string) (openpgp.EntityList, error) {
+	data := []byte(publicKey)
+	if !IsInlineKey(publicKey) {
+		var err error
+		data, err = os.ReadFile(publicKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read public key %s: %w", publicKey, err)
+		}
+	}
+
+	var keyring openpgp.EntityList
+	var err error
+	if isArmored(data) {
+		keyring, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	} else {
+		keyring, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+	if len(keyring) == 0 {
+		return nil, fmt.Errorf("no public key found")
+	}
+	return keyring, nil
+}
+
+// IsInlineKey は public_key の値がファイルパスではなく鍵そのものである場合に true を返す
+func IsInlineKey(publicKey string) bool {
+	return strings.HasPrefix(strings.TrimSpace(publicKey), armorPrefix)
+}
+
+// VerifyFile は path の内容を分離署名 sig (ASCII armor 形式 .asc またはバイナリ形式 .sig) で検証し、
+// 署名した鍵を "鍵ID (ユーザーID)" 形式で返す
+func VerifyFile(keyring openpgp.EntityList, path string, sig []byte) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+	return Verify(keyring, f, sig)
+}
+
+// Verify は signed の内容を分離署名 sig で検証し、署名した鍵を返す
+func Verify(keyring openpgp.EntityList, signed io.Reader, sig []byte) (string, error) {
+	var signer *openpgp.Entity
+	var err error
+	if isArmored(sig) {
+		signer, err = openpgp.CheckArmoredDetachedSignature(keyring, signed, bytes.NewReader(sig), nil)
+	} else {
+		signer, err = openpgp.CheckDetachedSignature(keyring, signed, bytes.NewReader(sig), nil)
+	}
+	if err != nil {
+		return "", fmt.Errorf("signature verification failed: %w", err)
+	}
+	return describe(signer), nil
+}
+
+// describe は署名した鍵を表す文字列を返す (ユーザーIDが複数ある場合は名前順で最初のもの)
+func describe(e *openpgp.Entity) string {
+	keyID := e.PrimaryKey.KeyIdString()
+	names := slices.Sorted(maps.Keys(e.Identities))
+	if len(names) == 0 {
+		return keyID
+	}
+	return fmt.Sprintf("%s (%s)", keyID, names[0])
+}
+
+// isArmored は data が ASCII armor 形式の場合に true を返す
+func isArmored(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte(armorPrefix))
+}
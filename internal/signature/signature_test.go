@@ -0,0 +1,132 @@
+package signature
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+)
+
+// newTestEntity はテスト用の鍵ペアを生成する
+func newTestEntity(t *testing.T, name string) *openpgp.Entity {
+	t.Helper()
+	e, err := openpgp.NewEntity(name, "", name+"@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// armoredPublicKey は e の公開鍵を ASCII armor 形式で返す
+func armoredPublicKey(t *testing.T, e *openpgp.Entity) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Serialize(w); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestVerifyFile(t *testing.T) {
+	signer := newTestEntity(t, "release")
+	other := newTestEntity(t, "other")
+	content := []byte("release binary\n")
+
+	sign := func(e *openpgp.Entity, armored bool) []byte {
+		var sig bytes.Buffer
+		var err error
+		if armored {
+			err = openpgp.ArmoredDetachSign(&sig, e, bytes.NewReader(content), nil)
+		} else {
+			err = openpgp.DetachSign(&sig, e, bytes.NewReader(content), nil)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig.Bytes()
+	}
+
+	tests := []struct {
+		name     string
+		sig      []byte
+		content  []byte
+		wantErr  bool
+		wantName string
+	}{
+		{name: "armored signature", sig: sign(signer, true), content: content, wantName: "release"},
+		{name: "binary signature", sig: sign(signer, false), content: content, wantName: "release"},
+		{name: "tampered content", sig: sign(signer, true), content: []byte("tampered\n"), wantErr: true},
+		{name: "signed by unknown key", sig: sign(other, false), content: content, wantErr: true},
+		{name: "garbage signature", sig: []byte("not a signature"), content: content, wantErr: true},
+	}
+	keyring, err := LoadKeyRing(armoredPublicKey(t, signer))
+	if err != nil {
+		t.Fatalf("LoadKeyRing() error = %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "file")
+			if err := os.WriteFile(path, tt.content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := VerifyFile(keyring, path, tt.sig)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VerifyFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !strings.Contains(got, tt.wantName) {
+				t.Errorf("VerifyFile() = %q, want signer containing %q", got, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestLoadKeyRing(t *testing.T) {
+	e := newTestEntity(t, "release")
+	armored := armoredPublicKey(t, e)
+	var binary bytes.Buffer
+	if err := e.Serialize(&binary); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	armoredPath := filepath.Join(dir, "key.asc")
+	binaryPath := filepath.Join(dir, "key.gpg")
+	if err := os.WriteFile(armoredPath, []byte(armored), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(binaryPath, binary.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		publicKey string
+		wantErr   bool
+	}{
+		{name: "inline armored key", publicKey: armored},
+		{name: "armored key file", publicKey: armoredPath},
+		{name: "binary key file", publicKey: binaryPath},
+		{name: "missing file", publicKey: filepath.Join(dir, "missing.asc"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyring, err := LoadKeyRing(tt.publicKey)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadKeyRing() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && len(keyring) != 1 {
+				t.Errorf("LoadKeyRing() returned %d keys, want 1", len(keyring))
+			}
+		})
+	}
+}
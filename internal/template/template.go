@@ -3,7 +3,12 @@ package template
 import (
 	"bytes"
 	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
 	"text/template"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/hrko/dltofu/internal/model"
 )
@@ -13,21 +18,139 @@ type TemplateData struct {
 	Version      string
 	Platform     string // 置換後のプラットフォーム文字列 (e.g., linux, darwin, windows)
 	Architecture string // 置換後のアーキテクチャ文字列 (e.g., amd64, arm64, x86_64)
+	Variant      string // 置換後のアーキテクチャのバリアント文字列 (e.g., armv6, armv7)。arch_variants 未指定の場合は空
 }
 
-// ResolveURL はテンプレート文字列とデータを使ってURLを生成する
+// funcMap はテンプレートで使える関数。変換する値はパイプラインで最後の引数として渡す
+// (e.g., {{.Architecture | replace "amd64" "x86_64"}}, {{.Platform | title}})。
+var funcMap = template.FuncMap{
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"title":      title,
+	"replace":    func(from, to, s string) string { return strings.ReplaceAll(s, from, to) },
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+}
+
+// undefinedFieldPattern は TemplateData にない変数を参照した場合の text/template のエラーメッセージ
+var undefinedFieldPattern = regexp.MustCompile(`can't evaluate field (\w+) in type`)
+
+// newTemplate は funcMap を登録したテンプレートを作成する。未定義のキーは空文字列にせずエラーとする。
+func newTemplate(name string) *template.Template {
+	return template.New(name).Funcs(funcMap).Option("missingkey=error")
+}
+
+// execute はテンプレートを data で展開する。
+// 未定義の変数 (e.g., {{.Arch}}) を参照した場合は、その変数名と使える変数を示すエラーを返す。
+func execute(tmpl *template.Template, data TemplateData) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		if m := undefinedFieldPattern.FindStringSubmatch(err.Error()); m != nil {
+			return "", fmt.Errorf("undefined variable .%s (valid: %s)", m[1], strings.Join(validFields(), ", "))
+		}
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// validFields はテンプレートで使える変数 (TemplateData のフィールド) を返す
+func validFields() []string {
+	t := reflect.TypeOf(TemplateData{})
+	fields := make([]string, 0, t.NumField())
+	for i := range t.NumField() {
+		fields = append(fields, "."+t.Field(i).Name)
+	}
+	return fields
+}
+
+// title は先頭の文字を大文字にする (linux -> Linux)
+func title(s string) string {
+	r, size := utf8.DecodeRuneInString(s)
+	if r == utf8.RuneError {
+		return s
+	}
+	return string(unicode.ToUpper(r)) + s[size:]
+}
+
+// Check はテンプレートの構文と、参照している変数が TemplateData にあることを検証する。
+// 設定ファイルの読み込み時に、lock や download の途中ではなく早い段階で誤りを報告するために使う。
+// 展開結果に依存するエラー (URL として正しくないなど) は検出しない。name はエラーメッセージに使う名前。
+func Check(name, templateText string) error {
+	tmpl, err := newTemplate(name).Parse(templateText)
+	if err != nil {
+		return err
+	}
+	// 値に依存しない誤り (未定義の変数など) を検出するため、空のデータで展開してみる
+	if _, err := execute(tmpl, TemplateData{}); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ResolveURL はテンプレート文字列とデータを使ってURLを生成する。
+// 生成したURLが http(s) の絶対URLでない場合はエラーを返す。
 func ResolveURL(urlTemplate string, data TemplateData) (model.ResolvedURL, error) {
-	tmpl, err := template.New("url").Parse(urlTemplate)
+	tmpl, err := newTemplate("url").Parse(urlTemplate)
 	if err != nil {
 		return "", fmt.Errorf("failed to parse URL template: %w", err)
 	}
 
-	var buf bytes.Buffer
-	err = tmpl.Execute(&buf, data)
+	resolved, err := execute(tmpl, data)
 	if err != nil {
-		// 未定義の変数を参照した場合などにエラーになる
 		return "", fmt.Errorf("failed to execute URL template: %w", err)
 	}
+	if err := model.ResolvedURL(resolved).Validate(); err != nil {
+		return "", err
+	}
+	return model.ResolvedURL(resolved), nil
+}
+
+// ResolveDestination は destination のテンプレートを展開する。
+// 絶対パス/相対パスの解決は展開後のパスに対して呼び出し元が行う。
+func ResolveDestination(destTemplate string, data TemplateData) (string, error) {
+	tmpl, err := newTemplate("destination").Parse(destTemplate)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse destination template: %w", err)
+	}
+
+	dest, err := execute(tmpl, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute destination template: %w", err)
+	}
+	if destTemplate != "" && dest == "" {
+		return "", fmt.Errorf("destination template %q resolved to an empty path", destTemplate)
+	}
+	return dest, nil
+}
+
+// ResolveRename は rename のキー/値 (アーカイブ内のパス) のテンプレートを展開する
+func ResolveRename(pathTemplate string, data TemplateData) (string, error) {
+	tmpl, err := newTemplate("rename").Parse(pathTemplate)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse rename template: %w", err)
+	}
+
+	resolved, err := execute(tmpl, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute rename template: %w", err)
+	}
+	if resolved == "" {
+		return "", fmt.Errorf("rename template %q resolved to an empty path", pathTemplate)
+	}
+	return resolved, nil
+}
 
-	return model.ResolvedURL(buf.String()), nil
+// ResolveHeader はHTTPヘッダの値のテンプレートを展開する。
+// 環境変数 (${NAME}) は設定ファイルの読み込み時に展開済み。
+func ResolveHeader(valueTemplate string, data TemplateData) (string, error) {
+	tmpl, err := newTemplate("header").Parse(valueTemplate)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse header template: %w", err)
+	}
+
+	value, err := execute(tmpl, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute header template: %w", err)
+	}
+	return value, nil
 }
@@ -0,0 +1,40 @@
+package template
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResolveDestination(t *testing.T) {
+	data := TemplateData{Version: "1.2.3", Platform: "linux", Architecture: "x86_64", Variant: "armv7"}
+	tests := []struct {
+		name    string
+		dest    string
+		want    string
+		wantErr string // 空ならエラーにならない
+	}{
+		{name: "literal", dest: "bin/tool", want: "bin/tool"},
+		{name: "empty", dest: "", want: ""},
+		{name: "version", dest: "bin/tool-{{.Version}}", want: "bin/tool-1.2.3"},
+		{name: "platform and architecture", dest: "/opt/{{.Platform}}/{{.Architecture}}/tool", want: "/opt/linux/x86_64/tool"},
+		{name: "variant", dest: "bin/{{.Variant}}/tool", want: "bin/armv7/tool"},
+		{name: "function", dest: "bin/{{.Platform | title}}/tool", want: "bin/Linux/tool"},
+		{name: "resolves to empty", dest: "{{if false}}x{{end}}", wantErr: "resolved to an empty path"},
+		{name: "unknown field", dest: "bin/{{.Os}}", wantErr: "failed to execute destination template"},
+		{name: "unclosed action", dest: "bin/{{.Version", wantErr: "failed to parse destination template"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveDestination(tt.dest, data)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("ResolveDestination(%q) error = %v, want it to contain %q", tt.dest, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("ResolveDestination(%q) = %q, %v; want %q", tt.dest, got, err, tt.want)
+			}
+		})
+	}
+}
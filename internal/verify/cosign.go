@@ -0,0 +1,85 @@
+package verify
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sigstore/sigstore-go/pkg/bundle"
+	"github.com/sigstore/sigstore-go/pkg/root"
+	sgverify "github.com/sigstore/sigstore-go/pkg/verify"
+)
+
+// CosignIdentity は cosign のキーレス署名で期待する署名者 (Fulcio 証明書の ID と OIDC 発行者)
+type CosignIdentity struct {
+	Identity string // 証明書の Subject Alternative Name (メールアドレスやワークフローのURL)
+	Issuer   string // OIDC 発行者 (e.g., https://token.actions.githubusercontent.com)
+}
+
+func (i CosignIdentity) String() string {
+	return fmt.Sprintf("%s (issuer %s)", i.Identity, i.Issuer)
+}
+
+// IdentityMismatchError は署名は有効だが、証明書の ID が期待と異なる場合のエラー
+type IdentityMismatchError struct {
+	Expected CosignIdentity
+	Actual   CosignIdentity
+}
+
+func (e *IdentityMismatchError) Error() string {
+	return fmt.Sprintf("certificate identity mismatch: expected %s, got %s", e.Expected, e.Actual)
+}
+
+// VerifyCosignBundle は sigstore バンドル (cosign sign-blob --bundle の出力) で artifactPath の内容を検証し、
+// 署名した証明書の ID を返す。
+// trustedRootPath が空の場合は Sigstore の公開インスタンスの trusted root を TUF で取得する。
+func VerifyCosignBundle(bundleJSON []byte, artifactPath string, expected CosignIdentity, trustedRootPath string) (CosignIdentity, error) {
+	var b bundle.Bundle
+	if err := b.UnmarshalJSON(bundleJSON); err != nil {
+		return CosignIdentity{}, fmt.Errorf("failed to parse sigstore bundle: %w", err)
+	}
+
+	var trustedRoot *root.TrustedRoot
+	var err error
+	if trustedRootPath != "" {
+		trustedRoot, err = root.NewTrustedRootFromPath(trustedRootPath)
+	} else {
+		trustedRoot, err = root.FetchTrustedRoot()
+	}
+	if err != nil {
+		return CosignIdentity{}, fmt.Errorf("failed to load sigstore trusted root: %w", err)
+	}
+
+	// cosign のキーレス署名のデフォルトと同じく、SCT と透明性ログへの記録を要求する
+	verifier, err := sgverify.NewVerifier(trustedRoot,
+		sgverify.WithSignedCertificateTimestamps(1),
+		sgverify.WithTransparencyLog(1),
+		sgverify.WithObserverTimestamps(1),
+	)
+	if err != nil {
+		return CosignIdentity{}, fmt.Errorf("failed to create sigstore verifier: %w", err)
+	}
+
+	artifact, err := os.Open(artifactPath)
+	if err != nil {
+		return CosignIdentity{}, fmt.Errorf("failed to open %s: %w", artifactPath, err)
+	}
+	defer artifact.Close()
+
+	// 期待と実際の ID を両方報告できるよう、ID の照合は署名の検証後に自前で行う
+	result, err := verifier.Verify(&b, sgverify.NewPolicy(sgverify.WithArtifact(artifact), sgverify.WithoutIdentitiesUnsafe()))
+	if err != nil {
+		return CosignIdentity{}, fmt.Errorf("sigstore bundle verification failed: %w", err)
+	}
+	if result.Signature == nil || result.Signature.Certificate == nil {
+		return CosignIdentity{}, fmt.Errorf("sigstore bundle is not signed with a certificate (keyless signature expected)")
+	}
+
+	actual := CosignIdentity{
+		Identity: result.Signature.Certificate.SubjectAlternativeName,
+		Issuer:   result.Signature.Certificate.Issuer,
+	}
+	if actual != expected {
+		return actual, &IdentityMismatchError{Expected: expected, Actual: actual}
+	}
+	return actual, nil
+}
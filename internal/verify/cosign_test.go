@@ -0,0 +1,130 @@
+package verify
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testdata の a.txt と a.txt.sigstore は sigstore/protobuf-specs のテスト用アセット
+// (Sigstore の公開インスタンスで a@tny.town が署名したもの)、trusted_root.json は
+// sigstore/sigstore-go のテストデータにある公開インスタンスの trusted root。
+// trusted root をファイルで指定するため、テストはネットワークに接続しない。
+var testSigner = CosignIdentity{Identity: "a@tny.town", Issuer: "https://github.com/login/oauth"}
+
+func TestVerifyCosignBundle(t *testing.T) {
+	bundleJSON, err := os.ReadFile(filepath.Join("testdata", "a.txt.sigstore"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifact, err := os.ReadFile(filepath.Join("testdata", "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// tamperedSignature は署名の値を別の有効な base64 に差し替えたバンドルを返す
+	tamperedSignature := func(t *testing.T) []byte {
+		t.Helper()
+		var b map[string]any
+		if err := json.Unmarshal(bundleJSON, &b); err != nil {
+			t.Fatal(err)
+		}
+		sig := b["messageSignature"].(map[string]any)
+		sig["signature"] = "MEUCIQDtampered" + sig["signature"].(string)[15:]
+		data, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	tests := []struct {
+		name         string
+		bundle       func(t *testing.T) []byte
+		artifact     []byte
+		expected     CosignIdentity
+		wantErr      string // エラーメッセージに含まれる文字列 (空なら成功)
+		wantMismatch bool   // IdentityMismatchError を期待する
+	}{
+		{
+			name:     "valid signature",
+			artifact: artifact,
+			expected: testSigner,
+		},
+		{
+			name:     "modified artifact",
+			artifact: append([]byte("tampered "), artifact...),
+			expected: testSigner,
+			wantErr:  "verification failed",
+		},
+		{
+			name:     "truncated artifact",
+			artifact: artifact[:len(artifact)-1],
+			expected: testSigner,
+			wantErr:  "verification failed",
+		},
+		{
+			name:     "tampered signature",
+			bundle:   tamperedSignature,
+			artifact: artifact,
+			expected: testSigner,
+			wantErr:  "verification failed",
+		},
+		{
+			name:         "unexpected identity",
+			artifact:     artifact,
+			expected:     CosignIdentity{Identity: "release@example.com", Issuer: testSigner.Issuer},
+			wantErr:      "identity mismatch",
+			wantMismatch: true,
+		},
+		{
+			name:         "unexpected issuer",
+			artifact:     artifact,
+			expected:     CosignIdentity{Identity: testSigner.Identity, Issuer: "https://token.actions.githubusercontent.com"},
+			wantErr:      "identity mismatch",
+			wantMismatch: true,
+		},
+		{
+			name:     "not a bundle",
+			bundle:   func(*testing.T) []byte { return []byte("not a bundle") },
+			artifact: artifact,
+			expected: testSigner,
+			wantErr:  "failed to parse sigstore bundle",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := bundleJSON
+			if tt.bundle != nil {
+				data = tt.bundle(t)
+			}
+			artifactPath := filepath.Join(t.TempDir(), "a.txt")
+			if err := os.WriteFile(artifactPath, tt.artifact, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := VerifyCosignBundle(data, artifactPath, tt.expected, filepath.Join("testdata", "trusted_root.json"))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("VerifyCosignBundle() error = %v", err)
+				}
+				if got != testSigner {
+					t.Errorf("VerifyCosignBundle() = %v, want %v", got, testSigner)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("VerifyCosignBundle() error = %v, want an error containing %q", err, tt.wantErr)
+			}
+			var mismatch *IdentityMismatchError
+			if isMismatch := errors.As(err, &mismatch); isMismatch != tt.wantMismatch {
+				t.Fatalf("VerifyCosignBundle() error = %v, IdentityMismatchError = %v, want %v", err, isMismatch, tt.wantMismatch)
+			}
+			if tt.wantMismatch && (mismatch.Actual != testSigner || mismatch.Expected != tt.expected) {
+				t.Errorf("IdentityMismatchError = %+v, want expected %v and actual %v", mismatch, tt.expected, testSigner)
+			}
+		})
+	}
+}